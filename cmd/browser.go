@@ -0,0 +1,498 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+// browserName is --browser: find files by the named browser's download
+// history rather than by what is newest in a directory.
+var browserName string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&browserName, "browser", "", "Use the download history of chrome, firefox or safari as the source, wherever the files were saved")
+	getnew.RegisterBackend("browser", newBrowserSource)
+}
+
+// browserHistoryLimit is how many of the most recent downloads are read.
+const browserHistoryLimit = 500
+
+// browserDownload is a completed download from a browser's history.
+type browserDownload struct {
+	path     string
+	url      string
+	finished time.Time
+}
+
+// browserSource lists the files a browser has finished downloading, as of
+// when each finished, wherever they were saved. Files that have since been
+// moved or deleted are left out, as are older downloads of the same name.
+type browserSource struct {
+	browser string
+
+	mu        sync.Mutex
+	downloads map[string]browserDownload // by file name
+}
+
+func newBrowserSource(spec string) (source, error) {
+	_, name, _ := strings.Cut(spec, "://")
+	name = strings.ToLower(strings.Trim(name, "/"))
+	switch name {
+	case "chrome", "firefox", "safari":
+		return &browserSource{browser: name}, nil
+	}
+	return nil, fmt.Errorf("invalid source %q: the browser must be chrome, firefox or safari", spec)
+}
+
+func (s *browserSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	downloads, err := readDownloadHistory(ctx, s.browser)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]browserDownload)
+	var infos []fs.FileInfo
+	for _, d := range downloads {
+		name := filepath.Base(d.path)
+		if _, seen := byName[name]; seen {
+			continue
+		}
+		info, err := os.Stat(d.path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		byName[name] = d
+		infos = append(infos, browserFile{info, d.finished})
+	}
+	s.mu.Lock()
+	s.downloads = byName
+	s.mu.Unlock()
+	return infos, nil
+}
+
+func (s *browserSource) download(name string) (browserDownload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.downloads[name]
+	if !ok {
+		return browserDownload{}, &fs.PathError{Op: "stat", Path: s.Location(name), Err: fs.ErrNotExist}
+	}
+	return d, nil
+}
+
+func (s *browserSource) Stat(name string) (fs.FileInfo, error) {
+	d, err := s.download(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return nil, err
+	}
+	return browserFile{info, d.finished}, nil
+}
+
+func (s *browserSource) Open(name string) (io.ReadCloser, error) {
+	d, err := s.download(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(d.path)
+}
+
+// OpenAt lets an interrupted copy of a download resume.
+func (s *browserSource) OpenAt(name string, offset int64) (io.ReadCloser, error) {
+	d, err := s.download(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *browserSource) Remove(name string) error {
+	d, err := s.download(name)
+	if err != nil {
+		return err
+	}
+	return removeOriginal(d.path)
+}
+
+func (s *browserSource) Location(name string) string {
+	if d, err := s.download(name); err == nil {
+		return d.path
+	}
+	if name == "" {
+		return "browser://" + s.browser
+	}
+	return "browser://" + s.browser + "/" + name
+}
+
+// Origin is the URL the browser downloaded name from.
+func (s *browserSource) Origin(name string) string {
+	d, _ := s.download(name)
+	return d.url
+}
+
+// browserFile is a downloaded file dated by when its download finished, which
+// stays the same however the file is touched afterwards.
+type browserFile struct {
+	fs.FileInfo
+	finished time.Time
+}
+
+func (f browserFile) ModTime() time.Time { return f.finished }
+
+// readDownloadHistory returns the completed downloads in a browser's history,
+// newest first, across all of its profiles.
+func readDownloadHistory(ctx context.Context, browser string) ([]browserDownload, error) {
+	var downloads []browserDownload
+	var err error
+	switch browser {
+	case "chrome":
+		downloads, err = readChromeHistory(ctx)
+	case "firefox":
+		downloads, err = readFirefoxHistory(ctx)
+	case "safari":
+		downloads, err = readSafariHistory(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(downloads, func(i, j int) bool {
+		return downloads[i].finished.After(downloads[j].finished)
+	})
+	return downloads, nil
+}
+
+// browserProfiles finds the files matching pattern under a browser's data
+// directory, one per profile.
+func browserProfiles(browser, pattern string) ([]string, error) {
+	var dir string
+	switch runtime.GOOS {
+	case "windows":
+		dir = map[string]string{
+			"chrome":  filepath.Join(os.Getenv("LOCALAPPDATA"), "Google", "Chrome", "User Data"),
+			"firefox": filepath.Join(os.Getenv("APPDATA"), "Mozilla", "Firefox", "Profiles"),
+		}[browser]
+	case "darwin":
+		dir = map[string]string{
+			"chrome":  filepath.Join(homeDir(), "Library", "Application Support", "Google", "Chrome"),
+			"firefox": filepath.Join(homeDir(), "Library", "Application Support", "Firefox", "Profiles"),
+		}[browser]
+	default:
+		dir = map[string]string{
+			"chrome":  filepath.Join(homeDir(), ".config", "google-chrome"),
+			"firefox": filepath.Join(homeDir(), ".mozilla", "firefox"),
+		}[browser]
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, pattern))
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no %s download history found in %s", browser, dir)
+	}
+	return paths, nil
+}
+
+// chromeEpoch is where Chrome's timestamps, in microseconds, count from.
+const chromeEpoch = -11644473600000000 // 1601-01-01 in Unix microseconds
+
+func readChromeHistory(ctx context.Context) ([]browserDownload, error) {
+	profiles, err := browserProfiles("chrome", filepath.Join("*", "History"))
+	if err != nil {
+		return nil, err
+	}
+	// state 1 is a completed download; the first URL in its chain is the
+	// one asked for, before any redirects
+	query := `SELECT target_path, end_time,
+		(SELECT url FROM downloads_url_chains c WHERE c.id = d.id ORDER BY chain_index LIMIT 1)
+		FROM downloads d WHERE state = 1 AND target_path != ''
+		ORDER BY end_time DESC LIMIT ` + strconv.Itoa(browserHistoryLimit)
+	var downloads []browserDownload
+	for _, db := range profiles {
+		rows, err := querySQLite(ctx, "chrome", db, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if len(row) < 3 {
+				continue
+			}
+			end, err := strconv.ParseInt(row[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			downloads = append(downloads, browserDownload{
+				path:     row[0],
+				url:      row[2],
+				finished: time.UnixMicro(end + chromeEpoch),
+			})
+		}
+	}
+	return downloads, nil
+}
+
+func readFirefoxHistory(ctx context.Context) ([]browserDownload, error) {
+	profiles, err := browserProfiles("firefox", filepath.Join("*", "places.sqlite"))
+	if err != nil {
+		return nil, err
+	}
+	// Firefox keeps each download as annotations on the page it came from
+	query := `SELECT p.url, dest.content, meta.content
+		FROM moz_annos dest
+		JOIN moz_anno_attributes a ON a.id = dest.anno_attribute_id AND a.name = 'downloads/destinationFileURI'
+		JOIN moz_places p ON p.id = dest.place_id
+		JOIN moz_annos meta ON meta.place_id = dest.place_id
+		JOIN moz_anno_attributes m ON m.id = meta.anno_attribute_id AND m.name = 'downloads/metaData'
+		ORDER BY dest.dateAdded DESC LIMIT ` + strconv.Itoa(browserHistoryLimit)
+	var downloads []browserDownload
+	for _, db := range profiles {
+		rows, err := querySQLite(ctx, "firefox", db, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if len(row) < 3 {
+				continue
+			}
+			var meta struct {
+				State   int   `json:"state"`
+				Deleted bool  `json:"deleted"`
+				EndTime int64 `json:"endTime"`
+			}
+			if json.Unmarshal([]byte(row[2]), &meta) != nil || meta.State != 1 || meta.Deleted {
+				continue
+			}
+			path := fileURLPath(row[1])
+			if path == "" {
+				continue
+			}
+			downloads = append(downloads, browserDownload{
+				path:     path,
+				url:      row[0],
+				finished: time.UnixMilli(meta.EndTime),
+			})
+		}
+	}
+	return downloads, nil
+}
+
+// fileURLPath turns a file:// URL into a local path.
+func fileURLPath(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	path := u.Path
+	// file:///C:/Users/... on Windows
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+// querySQLite runs query against a copy of a browser's database with the
+// sqlite3 command, as the browser keeps the original locked while it runs.
+// Rows come back split into their columns.
+func querySQLite(ctx context.Context, browser, db, query string) ([][]string, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("reading %s's download history needs the sqlite3 command", browser)
+	}
+	tmpDir, err := os.MkdirTemp("", "getnew-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	cp := filepath.Join(tmpDir, filepath.Base(db))
+	if _, err := copyFile(ctx, db, cp); err != nil {
+		return nil, fmt.Errorf("failed to read %s's download history: %w", browser, err)
+	}
+	// Recent downloads may only be in the write-ahead log so far
+	if _, err := copyFile(ctx, db+"-wal", cp+"-wal"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s's download history: %w", browser, err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sqlite3", "-batch", "-ascii", cp, query)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s's download history from %s: %w: %s", browser, db, err, strings.TrimSpace(stderr.String()))
+	}
+	// -ascii separates columns with 0x1F and rows with 0x1E
+	var rows [][]string
+	for _, line := range strings.Split(string(out), "\x1e") {
+		if line != "" {
+			rows = append(rows, strings.Split(line, "\x1f"))
+		}
+	}
+	return rows, nil
+}
+
+func readSafariHistory(ctx context.Context) ([]browserDownload, error) {
+	if _, err := exec.LookPath("plutil"); err != nil {
+		return nil, fmt.Errorf("reading safari's download history needs plutil, which comes with macOS")
+	}
+	path := filepath.Join(homeDir(), "Library", "Safari", "Downloads.plist")
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "plutil", "-convert", "xml1", "-o", "-", path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read safari's download history from %s (getnew may need Full Disk Access): %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return parseSafariDownloads(out)
+}
+
+// parseSafariDownloads reads the DownloadHistory entries from Safari's
+// Downloads.plist in XML form. Only finished downloads have a finish date.
+func parseSafariDownloads(data []byte) ([]browserDownload, error) {
+	plist, err := decodePlistXML(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse safari's download history: %w", err)
+	}
+	root, _ := plist.(map[string]any)
+	entries, _ := root["DownloadHistory"].([]any)
+	var downloads []browserDownload
+	for _, e := range entries {
+		entry, _ := e.(map[string]any)
+		path, _ := entry["DownloadEntryPath"].(string)
+		finished, ok := entry["DownloadEntryDateFinishedKey"].(time.Time)
+		if path == "" || !ok {
+			continue
+		}
+		origin, _ := entry["DownloadEntryURL"].(string)
+		downloads = append(downloads, browserDownload{path: expandHome(path), url: origin, finished: finished})
+	}
+	return downloads, nil
+}
+
+// decodePlistXML decodes an XML property list into maps, slices, strings,
+// numbers, booleans and times.
+func decodePlistXML(data []byte) (any, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "plist" {
+			continue
+		} else if ok {
+			return decodePlistValue(d, start)
+		}
+	}
+}
+
+func decodePlistValue(d *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]any)
+		for {
+			key, err := nextPlistElement(d)
+			if key == nil || err != nil {
+				return dict, err
+			}
+			var name string
+			if err := d.DecodeElement(&name, key); err != nil {
+				return nil, err
+			}
+			elem, err := nextPlistElement(d)
+			if elem == nil || err != nil {
+				return dict, err
+			}
+			if dict[name], err = decodePlistValue(d, *elem); err != nil {
+				return nil, err
+			}
+		}
+	case "array":
+		var array []any
+		for {
+			elem, err := nextPlistElement(d)
+			if elem == nil || err != nil {
+				return array, err
+			}
+			v, err := decodePlistValue(d, *elem)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, v)
+		}
+	case "true", "false":
+		return start.Name.Local == "true", d.Skip()
+	}
+	var text string
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	case "real":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	case "date":
+		return time.Parse(time.RFC3339, strings.TrimSpace(text))
+	}
+	return text, nil
+}
+
+// nextPlistElement returns the next element inside the current one, or nil
+// at its end.
+func nextPlistElement(d *xml.Decoder) (*xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			return &tok, nil
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+}
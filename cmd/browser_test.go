@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSafariDownloads(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>DownloadHistory</key>
+	<array>
+		<dict>
+			<key>DownloadEntryDateFinishedKey</key>
+			<date>2024-05-01T10:00:00Z</date>
+			<key>DownloadEntryPath</key>
+			<string>/Users/me/Desktop/report.pdf</string>
+			<key>DownloadEntryProgressBytesSoFar</key>
+			<integer>1024</integer>
+			<key>DownloadEntryRemoveWhenDoneKey</key>
+			<false/>
+			<key>DownloadEntryURL</key>
+			<string>https://example.com/report.pdf</string>
+		</dict>
+		<dict>
+			<key>DownloadEntryPath</key>
+			<string>/Users/me/Downloads/unfinished.iso</string>
+		</dict>
+	</array>
+</dict>
+</plist>
+`
+	downloads, err := parseSafariDownloads([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := browserDownload{
+		path:     "/Users/me/Desktop/report.pdf",
+		url:      "https://example.com/report.pdf",
+		finished: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+	}
+	if len(downloads) != 1 || downloads[0].path != want.path || downloads[0].url != want.url || !downloads[0].finished.Equal(want.finished) {
+		t.Errorf("parseSafariDownloads = %+v, want only %+v", downloads, want)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cartList  bool
+	cartClear bool
+)
+
+var addCmd = &cobra.Command{
+	Use:   "add [filter]",
+	Short: "Stage the nth newest file for a later checkout",
+	Long: `add puts the nth newest file from the source directory into the session cart
+without moving it. Run add as many times as needed, then use checkout to move
+everything in the cart to the destination directory in one go.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if err := addToCart(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+var checkoutCmd = &cobra.Command{
+	Use:   "checkout",
+	Short: "Move every file staged with add to the destination directory",
+	Long: `checkout moves all files in the session cart to the destination directory as
+a single batch. Every staged file is checked before anything is moved, then each
+is moved as getnew moves any file, its copy verified before its original is
+removed. If one cannot be moved, it and the files after it stay in the cart.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch {
+		case cartList:
+			err = listCart()
+		case cartClear:
+			err = saveCart(nil)
+		default:
+			err = checkoutCart(cmd.Context())
+		}
+		if err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(addCmd)
+	rootCmd.AddCommand(checkoutCmd)
+	addCmd.Flags().IntVarP(&nthNewest, "nth", "n", 1, "Nth newest file to stage (default is 1, the newest)")
+	checkoutCmd.Flags().BoolVarP(&cartList, "list", "l", false, "List the staged files without moving them")
+	checkoutCmd.Flags().BoolVar(&cartClear, "clear", false, "Empty the cart without moving anything")
+}
+
+type cartEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func cartPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cart.json"), nil
+}
+
+func loadCart() ([]cartEntry, error) {
+	path, err := cartPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cart: %w", err)
+	}
+	var entries []cartEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse cart: %w", err)
+	}
+	return entries, nil
+}
+
+func saveCart(entries []cartEntry) error {
+	path, err := cartPath()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to clear cart: %w", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cart: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cart: %w", err)
+	}
+	return nil
+}
+
+func addToCart(ctx context.Context) error {
+	files, err := newestSettled(ctx, nthLimit())
+	if err != nil {
+		return err
+	}
+	file, err := selectNthNewest(files, nthNewest, fileFilter)
+	if err != nil {
+		return err
+	}
+
+	dir, ok := sourceDir(file.Source)
+	if !ok {
+		return fmt.Errorf("add needs a local source directory, not %s", file.Source.Location(""))
+	}
+	path, err := filepath.Abs(filepath.Join(dir, file.Name()))
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+	entries, err := loadCart()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Path == path {
+			return fmt.Errorf("%s is already in the cart", file.Name())
+		}
+		if filepath.Base(entry.Path) == file.Name() {
+			return getnew.WithKind(fmt.Errorf("a file named %s is already in the cart: %s", file.Name(), entry.Path), getnew.ErrConflict)
+		}
+	}
+	entries = append(entries, cartEntry{Path: path, Size: file.Size(), ModTime: file.ModTime()})
+	if err := saveCart(entries); err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", file.Name())
+	fmt.Fprintf(os.Stderr, "%d file(s) in cart\n", len(entries))
+	return nil
+}
+
+func listCart() error {
+	entries, err := loadCart()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s\n", entry.Path)
+	}
+	return nil
+}
+
+func checkoutCart(ctx context.Context) error {
+	entries, err := loadCart()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("the cart is empty")
+	}
+
+	// Make sure every staged file is still there and unchanged before touching anything
+	files := make([]candidate, len(entries))
+	destPaths := make([]string, len(entries))
+	names := make(map[string]string, len(entries))
+	for i, entry := range entries {
+		info, err := os.Stat(entry.Path)
+		if err != nil {
+			return fmt.Errorf("staged file is no longer available: %w", err)
+		}
+		if info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+			return fmt.Errorf("staged file has changed since it was added: %s", entry.Path)
+		}
+		name := filepath.Base(entry.Path)
+		if other, ok := names[name]; ok {
+			return getnew.WithKind(fmt.Errorf("%s and %s would both be checked out as %s", other, entry.Path, name), getnew.ErrConflict)
+		}
+		names[name] = entry.Path
+		destPath := filepath.Join(fileDestDir(destDir, info.ModTime()), name)
+		if _, err := os.Stat(destPath); err == nil {
+			return getnew.WithKind(fmt.Errorf("destination already exists: %s", destPath), getnew.ErrConflict)
+		}
+		files[i] = candidate{FileInfo: info, Source: localSource{Dir: filepath.Dir(entry.Path)}}
+		destPaths[i] = destPath
+	}
+
+	// Move each as any other move, leaving those not moved in the cart
+	for i, file := range files {
+		if err := moveFromSource(ctx, file, destPaths[i]); err != nil {
+			if saveErr := saveCart(entries[i:]); saveErr != nil {
+				return saveErr
+			}
+			return fmt.Errorf("checkout stopped at %s, %d file(s) left in the cart: %w", file.Name(), len(entries)-i, err)
+		}
+		fmt.Printf("%s\n", file.Name())
+	}
+	return saveCart(nil)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckoutRejectsDuplicateNames(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	root := t.TempDir()
+	var entries []cartEntry
+	for _, dir := range []string{"one", "two"} {
+		path := filepath.Join(root, dir, "report.pdf")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(dir), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, cartEntry{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	if err := saveCart(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	old := destDir
+	destDir = filepath.Join(root, "dest")
+	defer func() { destDir = old }()
+	if err := os.Mkdir(destDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutCart(context.Background()); err == nil {
+		t.Fatal("checkout of two files with the same name succeeded")
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(entry.Path); err != nil {
+			t.Errorf("staged file was touched: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "report.pdf")); err == nil {
+		t.Error("a file was copied before the conflict was detected")
+	}
+}
+
+func TestCheckoutMovesLikeAnyMove(t *testing.T) {
+	useFakeClock(t)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	root := t.TempDir()
+	path := filepath.Join(root, "report.pdf")
+	if err := os.WriteFile(path, []byte("report"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveCart([]cartEntry{{Path: path, Size: info.Size(), ModTime: info.ModTime()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(dest string, dated bool) { destDir, datedDirs = dest, dated }(destDir, datedDirs)
+	destDir, datedDirs = filepath.Join(root, "dest"), true
+	if err := checkoutCart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(fileDestDir(destDir, info.ModTime()), "report.pdf")
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("not moved into the dated folder: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("original still there: %v", err)
+	}
+	if entries, err := loadHistory(); err != nil || len(entries) != 1 || entries[0].Dest != want {
+		t.Errorf("history = %+v, %v", entries, err)
+	}
+	if entries, _ := loadCart(); len(entries) != 0 {
+		t.Errorf("cart still holds %+v", entries)
+	}
+}
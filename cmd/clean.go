@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean --older-than <age> [filter]",
+	Short: "Delete stale files from the source directory",
+	Long: `clean deletes the files in the source directory that were last modified longer
+ago than --older-than, optionally only those matching a filter and the other
+selection flags such as --type and --min-size. With --trash they go to the
+trash instead, and with --dry-run they are only listed.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if err := cleanSources(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cleanCmd)
+	cleanCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the files that would be deleted without deleting them")
+}
+
+func cleanSources(ctx context.Context) error {
+	// Without an age limit this would empty the source
+	if olderThan.IsZero() {
+		return fmt.Errorf("clean needs --older-than, e.g. --older-than 30d")
+	}
+	files, err := collectCandidates(ctx)
+	if err != nil {
+		return err
+	}
+
+	var removed, failed int
+	var bytes int64
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		age := shortAge(clk.Since(file.ModTime()))
+		if dryRun {
+			fmt.Printf("%8s  %6s old  %s\n", humanSize(file.Size()), age, file.Name())
+			removed++
+			bytes += max(file.Size(), 0)
+			continue
+		}
+		remove := file.Source.Remove
+		if dir, ok := sourceDir(file.Source); ok {
+			remove = func(name string) error { return removeOriginal(filepath.Join(dir, name)) }
+		}
+		if err := remove(file.Name()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", file.Name(), err)
+			failed++
+			continue
+		}
+		fmt.Printf("%8s  %6s old  %s\n", humanSize(file.Size()), age, file.Name())
+		removed++
+		bytes += max(file.Size(), 0)
+	}
+
+	verb := "Deleted"
+	switch {
+	case dryRun:
+		verb = "Would delete"
+	case useTrash:
+		verb = "Trashed"
+	}
+	fmt.Fprintf(os.Stderr, "%s %d file(s), %s\n", verb, removed, humanSize(bytes))
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) could not be deleted", failed)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coljac/getnew/pkg/clock"
+)
+
+var testEpoch = time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// useFakeClock swaps in a fake clock for the duration of the test.
+func useFakeClock(t *testing.T) *clock.Fake {
+	t.Helper()
+	fake := clock.NewFake(testEpoch)
+	clk = fake
+	t.Cleanup(func() { clk = clock.Real })
+	return fake
+}
+
+// memSource is an in-memory source. Stat reports later, when set, so tests
+// can make a file change between listing and re-checking it.
+type memSource struct {
+	files map[string]remoteFileInfo
+	later map[string]remoteFileInfo
+}
+
+func (s *memSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	var infos []fs.FileInfo
+	for _, info := range s.files {
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *memSource) Stat(name string) (fs.FileInfo, error) {
+	if info, ok := s.later[name]; ok {
+		return info, nil
+	}
+	if info, ok := s.files[name]; ok {
+		return info, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (s *memSource) Open(name string) (io.ReadCloser, error) {
+	if _, ok := s.files[name]; !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(strings.NewReader(name)), nil
+}
+
+func (s *memSource) Remove(name string) error {
+	if _, ok := s.files[name]; !ok {
+		return errors.New("not found")
+	}
+	delete(s.files, name)
+	return nil
+}
+
+func (s *memSource) Location(name string) string { return "mem://" + name }
+
+func useSource(t *testing.T, s source) {
+	t.Helper()
+	old := sources
+	sources = []source{s}
+	t.Cleanup(func() { sources = old })
+}
+
+func TestSettledCandidatesWaitsForRecentFiles(t *testing.T) {
+	fake := useFakeClock(t)
+	settlePeriod = 5 * time.Second
+	fileFilter = ""
+	mem := &memSource{
+		files: map[string]remoteFileInfo{
+			"old.pdf":     {name: "old.pdf", size: 10, modTime: testEpoch.Add(-time.Hour)},
+			"done.zip":    {name: "done.zip", size: 20, modTime: testEpoch.Add(-2 * time.Second)},
+			"growing.iso": {name: "growing.iso", size: 30, modTime: testEpoch.Add(-time.Second)},
+		},
+		later: map[string]remoteFileInfo{
+			"growing.iso": {name: "growing.iso", size: 60, modTime: testEpoch.Add(3 * time.Second)},
+		},
+	}
+	useSource(t, mem)
+
+	files, err := settledCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, file := range files {
+		got[file.Name()] = true
+	}
+	if !got["old.pdf"] || !got["done.zip"] || got["growing.iso"] || len(got) != 2 {
+		t.Errorf("settled candidates = %v, want old.pdf and done.zip", got)
+	}
+
+	// The newest file was a second old, so the check waits out the other four
+	if waited := fake.Since(testEpoch); waited != 4*time.Second {
+		t.Errorf("waited %v, want 4s", waited)
+	}
+}
+
+func TestSettledCandidatesSkipsWaitForOldFiles(t *testing.T) {
+	fake := useFakeClock(t)
+	settlePeriod = 5 * time.Second
+	fileFilter = ""
+	useSource(t, &memSource{files: map[string]remoteFileInfo{
+		"old.pdf": {name: "old.pdf", size: 10, modTime: testEpoch.Add(-time.Minute)},
+	}})
+
+	if _, err := settledCandidates(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if waited := fake.Since(testEpoch); waited != 0 {
+		t.Errorf("waited %v for files older than the settle period", waited)
+	}
+}
+
+func TestHistorySinceFilter(t *testing.T) {
+	useFakeClock(t)
+	entries := []historyEntry{
+		{Name: "a", Time: testEpoch.Add(-10 * 24 * time.Hour)},
+		{Name: "b", Time: testEpoch.Add(-6 * 24 * time.Hour)},
+		{Name: "c", Time: testEpoch.Add(-time.Hour)},
+	}
+
+	tests := []struct {
+		since string
+		want  []string
+	}{
+		{"", []string{"a", "b", "c"}},
+		{"7d", []string{"b", "c"}},
+		{"2h", []string{"c"}},
+		{"1m", nil},
+	}
+	for _, tt := range tests {
+		historySince = tt.since
+		got, err := historySinceFilter(entries)
+		if err != nil {
+			t.Fatalf("since %q: %v", tt.since, err)
+		}
+		var names []string
+		for _, entry := range got {
+			names = append(names, entry.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("since %q = %v, want %v", tt.since, names, tt.want)
+		}
+	}
+	historySince = ""
+}
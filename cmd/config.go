@@ -0,0 +1,157 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// config mirrors ~/.config/getnew/config.yaml (or $GETNEW_CONFIG).
+type config struct {
+	Directories   []directoryConfig `yaml:"directories"`
+	Rules         []rule            `yaml:"rules"`
+	Hooks         hooksConfig       `yaml:"hooks"`
+	Media         []mediaProfile    `yaml:"media"`
+	Notifications []notifierConfig  `yaml:"notifications"`
+	Archives      archiveConfig     `yaml:"archives"`
+}
+
+// appConfig is loaded once per invocation before any command runs.
+var appConfig = &config{}
+
+// directoryConfig sets defaults that apply while working inside Path.
+type directoryConfig struct {
+	Path   string `yaml:"path"`
+	Source string `yaml:"source"`
+	Dest   string `yaml:"dest"`
+	Filter string `yaml:"filter"`
+}
+
+func configPath() string {
+	if path := os.Getenv("GETNEW_CONFIG"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(homeDir(), ".config")
+	}
+	return filepath.Join(dir, "getnew", "config.yaml")
+}
+
+// loadConfig reads the config file and refuses it if its rules fail their
+// own examples.
+func loadConfig() (*config, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if failures := checkRuleExamples(cfg.Rules); len(failures) > 0 {
+		return nil, fmt.Errorf("rules in %s fail their examples (see getnew rules verify): %s", configPath(), failures[0])
+	}
+	if err := checkPipelines(cfg.Rules); err != nil {
+		return nil, fmt.Errorf("rules in %s: %w", configPath(), err)
+	}
+	return cfg, nil
+}
+
+func readConfig() (*config, error) {
+	cfg := &config{}
+	data, err := os.ReadFile(configPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", configPath(), err)
+	}
+	return cfg, nil
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return filepath.Join(homeDir(), path[1:])
+	}
+	return path
+}
+
+// homeDir is $HOME, or the user profile directory on Windows.
+func homeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return home
+}
+
+// applyDirectoryConfig picks the most specific directories entry containing the
+// working directory and uses it for any destination or filter not given explicitly.
+func applyDirectoryConfig(cmd *cobra.Command, cfg *config) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	var match *directoryConfig
+	var matchPath string
+	for i, dir := range cfg.Directories {
+		path, err := filepath.Abs(expandHome(dir.Path))
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(path, cwd)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if match == nil || len(path) > len(matchPath) {
+			match, matchPath = &cfg.Directories[i], path
+		}
+	}
+	if match == nil {
+		return nil
+	}
+
+	if match.Source != "" && !sourceChosen(cmd) {
+		sourceDirs = nil
+		for _, spec := range splitSourceList(match.Source) {
+			sourceDirs = append(sourceDirs, expandHome(spec))
+		}
+	}
+	if match.Dest != "" && !cmd.Flags().Changed("dest") {
+		destDir = expandHome(match.Dest)
+		if !filepath.IsAbs(destDir) {
+			destDir = filepath.Join(matchPath, destDir)
+		}
+	}
+	if match.Filter != "" {
+		fileFilter = match.Filter
+	}
+	return nil
+}
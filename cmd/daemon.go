@@ -0,0 +1,252 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var daemonPrint bool
+
+const launchdLabel = "space.coljac.getnew"
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon [filter]",
+	Short: "Keep sorting new files in the background, for running as a service",
+	Long: `daemon runs the same engine as watch for as long as it is left running:
+each new file is moved to the destination of the first rule that matches it,
+or to the destination directory. A source that goes away is paused until it
+returns, and desktop notifications are shown when there is a desktop.
+
+Use "getnew daemon install" to start it at login.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if err := watchSourceDir(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+var daemonInstallCmd = &cobra.Command{
+	Use:   "install [filter]",
+	Short: "Install a systemd user unit or launchd agent that runs the daemon at login",
+	Long: `install writes a systemd user unit (Linux) or a launchd agent (macOS) that runs
+"getnew daemon" with the current sources, destination and filter and every
+other option given, from the current directory, then enables and starts it.
+With --print the unit is only printed. --password is refused, as the unit
+could be read by others.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if err := installDaemon(cmd); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.AddCommand(daemonInstallCmd)
+	daemonInstallCmd.Flags().BoolVar(&daemonPrint, "print", false, "Print the unit instead of installing it")
+}
+
+// daemonArgs is the command line the service runs, with the sources and
+// destination made absolute as the service starts elsewhere, and the other
+// options set on cmd passed on as they were given.
+func daemonArgs(cmd *cobra.Command) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find getnew executable: %w", err)
+	}
+	args := []string{exe, "daemon"}
+	for _, src := range sourceDirs {
+		if !strings.Contains(src, "://") {
+			if src, err = filepath.Abs(src); err != nil {
+				return nil, err
+			}
+		}
+		args = append(args, "--source", src)
+	}
+	dest := destDir
+	if !isRemoteDest(dest) {
+		if dest, err = filepath.Abs(dest); err != nil {
+			return nil, err
+		}
+	}
+	args = append(args, "--dest", dest)
+	var refused error
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		switch f.Name {
+		case "source", "dest", "print":
+			return
+		case "password":
+			refused = fmt.Errorf("--password would be written into the service, where others could read it; set GETNEW_ARCHIVE_PASSWORD for it or use a password hook instead")
+			return
+		}
+		values := []string{f.Value.String()}
+		if list, ok := f.Value.(pflag.SliceValue); ok {
+			values = list.GetSlice()
+		}
+		for _, value := range values {
+			args = append(args, "--"+f.Name+"="+value)
+		}
+	})
+	if refused != nil {
+		return nil, refused
+	}
+	if fileFilter != "" {
+		args = append(args, fileFilter)
+	}
+	return args, nil
+}
+
+func installDaemon(cmd *cobra.Command) error {
+	args, err := daemonArgs(cmd)
+	if err != nil {
+		return err
+	}
+	// Options may name files relative to where install was run
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	var path, unit string
+	var enable [][]string
+	switch runtime.GOOS {
+	case "linux":
+		path = filepath.Join(homeDir(), ".config", "systemd", "user", "getnew.service")
+		if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+			path = filepath.Join(dir, "systemd", "user", "getnew.service")
+		}
+		unit = systemdUnit(args, workDir)
+		enable = [][]string{
+			{"systemctl", "--user", "daemon-reload"},
+			{"systemctl", "--user", "enable", "--now", "getnew.service"},
+		}
+	case "darwin":
+		state, err := stateDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(homeDir(), "Library", "LaunchAgents", launchdLabel+".plist")
+		unit = launchdPlist(args, workDir, filepath.Join(state, "daemon.log"))
+		enable = [][]string{
+			{"launchctl", "unload", path},
+			{"launchctl", "load", "-w", path},
+		}
+	default:
+		return fmt.Errorf("daemon install supports systemd and launchd, not %s; run getnew daemon from your startup programs instead", runtime.GOOS)
+	}
+
+	if daemonPrint {
+		fmt.Print(unit)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(unit), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	for i, c := range enable {
+		err := runQuiet(c[0], c[1:]...)
+		// Unloading an agent that was never loaded fails harmlessly
+		if err != nil && !(runtime.GOOS == "darwin" && i == 0) {
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "getnew daemon is running and will start at login")
+	return nil
+}
+
+func systemdUnit(args []string, workDir string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		// systemd expands % specifiers and $ variables in ExecStart
+		arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		quoted[i] = arg
+	}
+	return fmt.Sprintf(`[Unit]
+Description=getnew: sort new downloads
+
+[Service]
+WorkingDirectory=%s
+ExecStart=%s
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`, strings.ReplaceAll(workDir, "%", "%%"), strings.Join(quoted, " "))
+}
+
+func launchdPlist(args []string, workDir, logPath string) string {
+	escape := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + launchdLabel + `</string>
+	<key>ProgramArguments</key>
+	<array>
+`)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", escape(arg))
+	}
+	fmt.Fprintf(&b, `	</array>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, escape(workDir), escape(logPath))
+	return b.String()
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestDaemonArgsCarryOptions(t *testing.T) {
+	defer func(srcs []string, dest, filter string) { sourceDirs, destDir, fileFilter = srcs, dest, filter }(sourceDirs, destDir, fileFilter)
+	sourceDirs, destDir, fileFilter = []string{"/dl"}, "/inbox", "*.pdf"
+	// Flags as install has them, without touching the real ones
+	cmd := &cobra.Command{Use: "install"}
+	cmd.Flags().Duration("settle", 2*time.Second, "")
+	cmd.Flags().Bool("trash", false, "")
+	cmd.Flags().StringSlice("ignore-ext", nil, "")
+	cmd.Flags().String("password", "", "")
+	cmd.Flags().Bool("print", false, "")
+	for name, value := range map[string]string{"settle": "5s", "trash": "true", "ignore-ext": ".tmp,.part", "print": "true"} {
+		if err := cmd.Flags().Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args, err := daemonArgs(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(args[1:], " ")
+	if strings.Contains(got, "--print") {
+		t.Errorf("daemon args %q carry install's own --print", got)
+	}
+	for _, want := range []string{"daemon --source /dl --dest /inbox", "--settle=5s", "--trash=true", "--ignore-ext=.tmp --ignore-ext=.part", "*.pdf"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("daemon args %q lack %q", got, want)
+		}
+	}
+	if unit := systemdUnit([]string{"/bin/getnew", "daemon", "--exec=echo $HOME"}, "/home/me"); !strings.Contains(unit, "$$HOME") || !strings.Contains(unit, "WorkingDirectory=/home/me") {
+		t.Errorf("unit = %s", unit)
+	}
+
+	if err := cmd.Flags().Set("password", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := daemonArgs(cmd); err == nil {
+		t.Error("--password was written into the service")
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var dashboardOnce bool
+
+var dashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Show recent moves, pending files, rule hits and errors on one screen",
+	Long: `dashboard shows what getnew has been doing: the most recent moves, the files
+waiting in the source directories and in unfinished uploads, how often each rule
+has matched, and the latest errors from watch, sort-all and serve. The screen
+redraws as soon as anything changes; press Ctrl-C to leave.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDashboard(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dashboardCmd)
+	dashboardCmd.Flags().BoolVar(&dashboardOnce, "once", false, "Print the dashboard once and exit")
+}
+
+func runDashboard(ctx context.Context) error {
+	if dashboardOnce {
+		return renderDashboard(ctx, os.Stdout)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watcher: %w", err)
+	}
+	defer watcher.Close()
+	state, err := stateDir()
+	if err != nil {
+		return err
+	}
+	watched := []string{state}
+	if dirs, err := localDirs("dashboard"); err == nil {
+		watched = append(watched, dirs...)
+	}
+	if uploads, err := uploadsDir(); err == nil {
+		watched = append(watched, uploads)
+	}
+	for _, dir := range watched {
+		watcher.Add(dir)
+	}
+
+	// Redraw shortly after a burst of changes, and now and then to keep the
+	// ages current
+	ticker := clk.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	var debounce <-chan time.Time
+	for {
+		var screen strings.Builder
+		screen.WriteString("\033[H\033[2J")
+		if err := renderDashboard(ctx, &screen); err != nil {
+			return err
+		}
+		os.Stdout.WriteString(screen.String())
+
+		debounce = nil
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return nil
+				}
+				if debounce == nil {
+					debounce = clk.After(200 * time.Millisecond)
+				}
+			case <-watcher.Errors:
+			case <-debounce:
+				break wait
+			case <-ticker.C():
+				break wait
+			}
+		}
+	}
+}
+
+func renderDashboard(ctx context.Context, w io.Writer) error {
+	history, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	failures, err := loadErrors()
+	if err != nil {
+		return err
+	}
+	now := clk.Now()
+
+	fmt.Fprintf(w, "getnew  %s  %s\n\n", strings.Join(sourceDirs, ", "), now.Local().Format("2006-01-02 15:04:05"))
+
+	fmt.Fprintln(w, "Recent moves")
+	recent := history
+	if len(recent) > 8 {
+		recent = recent[len(recent)-8:]
+	}
+	if len(recent) == 0 {
+		fmt.Fprintln(w, "  none yet")
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		entry := recent[i]
+		fmt.Fprintf(w, "  %8s ago  %-40s -> %s\n", shortAge(now.Sub(entry.Time)), entry.Name, filepath.Dir(entry.Dest))
+	}
+
+	fmt.Fprintln(w, "\nWaiting")
+	waiting := 0
+	if files, err := collectCandidates(ctx); err == nil {
+		for i, file := range files {
+			if i == 5 {
+				fmt.Fprintf(w, "  ... and %d more\n", len(files)-5)
+				break
+			}
+			fmt.Fprintf(w, "  %8s ago  %s\n", shortAge(now.Sub(file.ModTime())), file.Name())
+		}
+		waiting += len(files)
+	} else {
+		fmt.Fprintf(w, "  cannot list sources: %v\n", err)
+	}
+	for _, up := range pendingUploads() {
+		fmt.Fprintf(w, "  upload      %s (%s of %s)\n", up.Name, humanSize(up.Received), humanSize(up.Size))
+		waiting++
+	}
+	if waiting == 0 {
+		fmt.Fprintln(w, "  nothing")
+	}
+
+	if len(appConfig.Rules) > 0 {
+		fmt.Fprintln(w, "\nRule hits")
+		hits := map[*rule]int{}
+		for _, entry := range history {
+			if r := findRule(entry.Name); r != nil {
+				hits[r]++
+			}
+		}
+		for i := range appConfig.Rules {
+			r := &appConfig.Rules[i]
+			name := r.Name
+			if name == "" {
+				name = r.Match + " -> " + r.Dest
+			}
+			fmt.Fprintf(w, "  %6d  %s\n", hits[r], name)
+		}
+	}
+
+	fmt.Fprintln(w, "\nErrors")
+	if len(failures) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	if len(failures) > 5 {
+		failures = failures[len(failures)-5:]
+	}
+	for i := len(failures) - 1; i >= 0; i-- {
+		f := failures[i]
+		fmt.Fprintf(w, "  %8s ago  %-8s %s\n", shortAge(now.Sub(f.Time)), f.Command, f.Error)
+	}
+	return nil
+}
+
+// pendingUploads are the uploads serve has started but not yet completed.
+func pendingUploads() []upload {
+	dir, err := uploadsDir()
+	if err != nil {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	var uploads []upload
+	for _, path := range paths {
+		var up upload
+		if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &up) == nil {
+			uploads = append(uploads, up)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Started.Before(uploads[j].Started) })
+	return uploads
+}
+
+// shortAge is d rounded to its largest unit, such as 5s, 12m, 3h or 2d.
+func shortAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
@@ -0,0 +1,46 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var datedDirs bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&datedDirs, "dated", false, "File into YEAR/MONTH subdirectories of the destination by modification time")
+}
+
+// fileDestDir is the directory a file modified at modTime goes to in dir:
+// {year}, {month} and {day} in dir are filled in from modTime, and --dated
+// adds YEAR/MONTH below it.
+func fileDestDir(dir string, modTime time.Time) string {
+	t := modTime.Local()
+	dir = strings.NewReplacer("{year}", t.Format("2006"), "{month}", t.Format("01"), "{day}", t.Format("02")).Replace(dir)
+	if datedDirs {
+		dir = filepath.Join(dir, t.Format("2006"), t.Format("01"))
+	}
+	return dir
+}
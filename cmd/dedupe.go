@@ -0,0 +1,172 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+var dedupeMode string
+
+// duplicateError is returned instead of moving a file that is already at
+// the destination or elsewhere in the history.
+type duplicateError struct {
+	name, of string
+	removed  bool
+}
+
+func (e *duplicateError) Error() string {
+	action := "skipped"
+	if e.removed {
+		action = "removed"
+	}
+	return fmt.Sprintf("%s is identical to %s; %s it", e.name, e.of, action)
+}
+
+func isDuplicate(err error) bool {
+	var dup *duplicateError
+	return errors.As(err, &dup)
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&dedupeMode, "dedupe", "", "Before moving, look for an identical file in the destination or history and skip the move (skip), or delete the source (remove)")
+	rootCmd.PersistentFlags().Lookup("dedupe").NoOptDefVal = "skip"
+}
+
+// hashCandidate returns the SHA-256 of a candidate's contents.
+func hashCandidate(ctx context.Context, file candidate) (string, error) {
+	r, err := file.Source.Open(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer r.Close()
+	return hashReader(ctx, r)
+}
+
+func hashReader(ctx context.Context, r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := getnew.CopyContext(ctx, h, r); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashPath(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(ctx, f)
+}
+
+// findDuplicate returns the path of a file identical to file, looking among
+// moved files that are still where they were put, then in dir. Only files
+// of the same size are read.
+func findDuplicate(ctx context.Context, file candidate, dir string) (string, error) {
+	sum := ""
+	sameSize := func(size int64) bool { return file.Size() < 0 || size == file.Size() }
+	candidateSum := func() (string, error) {
+		var err error
+		if sum == "" {
+			sum, err = hashCandidate(ctx, file)
+		}
+		return sum, err
+	}
+
+	entries, err := loadHistory()
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.SHA256 == "" || isRemoteDest(e.Dest) || !sameSize(e.Size) {
+			continue
+		}
+		s, err := candidateSum()
+		if err != nil {
+			return "", err
+		}
+		// Confirm the copy is still there and unchanged
+		if e.SHA256 == s {
+			if other, err := hashPath(ctx, e.Dest); err == nil && other == s {
+				return e.Dest, nil
+			}
+		}
+	}
+
+	if isRemoteDest(dir) {
+		return "", nil
+	}
+	found, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil
+	}
+	for _, entry := range found {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !sameSize(info.Size()) {
+			continue
+		}
+		s, err := candidateSum()
+		if err != nil {
+			return "", err
+		}
+		path := filepath.Join(dir, entry.Name())
+		if other, err := hashPath(ctx, path); err == nil && other == s {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// dedupe checks file against dir with --dedupe and, if it is a duplicate,
+// removes it when asked to and returns a duplicateError.
+func dedupe(ctx context.Context, file candidate, dir string) error {
+	if dedupeMode == "" || file.IsDir() || file.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+	dup, err := findDuplicate(ctx, file, dir)
+	if err != nil || dup == "" {
+		return err
+	}
+	if dedupeMode != "remove" || readOnly {
+		return &duplicateError{name: file.Name(), of: dup}
+	}
+	remove := file.Source.Remove
+	if d, ok := sourceDir(file.Source); ok {
+		remove = func(name string) error { return removeOriginal(filepath.Join(d, name)) }
+	}
+	if err := remove(file.Name()); err != nil {
+		return fmt.Errorf("failed to remove duplicate %s: %w", file.Name(), err)
+	}
+	return &duplicateError{name: file.Name(), of: dup, removed: true}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// containerDest is a destination inside a running container, given as
+// docker://container:/path, or inside a Kubernetes pod, given as
+// k8s://namespace/pod:/path (or k8s://pod:/path for kubectl's current
+// namespace).
+type containerDest struct {
+	scheme    string // docker or k8s
+	container string // the container, or for k8s namespace/pod
+	path      string
+}
+
+// splitContainerDest recognises container destinations. ok is true for
+// anything with the docker: or k8s: prefix, so that a mistyped one is
+// reported rather than taken for a local directory.
+func splitContainerDest(dest string) (c containerDest, ok bool) {
+	scheme, rest, ok := strings.Cut(filepath.ToSlash(dest), ":")
+	if !ok || (scheme != "docker" && scheme != "k8s") {
+		return containerDest{}, false
+	}
+	// Joining a file name on to docker://... collapses the //
+	rest = strings.TrimLeft(rest, "/")
+	c.scheme = scheme
+	c.container, c.path, _ = strings.Cut(rest, ":")
+	return c, true
+}
+
+func (c containerDest) String() string {
+	return c.scheme + "://" + c.container + ":" + c.path
+}
+
+// kubectl runs kubectl against the pod, in its namespace if one was given.
+func (c containerDest) kubectl(ctx context.Context, args ...string) *exec.Cmd {
+	namespace, pod, ok := strings.Cut(c.container, "/")
+	if !ok {
+		namespace, pod = "", c.container
+	}
+	kargs := []string{"exec", "-i", pod}
+	if namespace != "" {
+		kargs = append(kargs, "--namespace", namespace)
+	}
+	return exec.CommandContext(ctx, "kubectl", append(append(kargs, "--"), args...)...)
+}
+
+// unpackCommand unpacks a tar archive read from stdin into dir in the
+// container. kubectl cp does the same with tar in the pod.
+func (c containerDest) unpackCommand(ctx context.Context, dir string) *exec.Cmd {
+	if c.scheme == "k8s" {
+		return c.kubectl(ctx, "tar", "-xf", "-", "-C", dir)
+	}
+	return exec.CommandContext(ctx, "docker", "cp", "-", c.container+":"+dir)
+}
+
+// packCommand writes the destination file to stdout as a tar archive.
+func (c containerDest) packCommand(ctx context.Context) *exec.Cmd {
+	if c.scheme == "k8s" {
+		dir, name := path.Split(c.path)
+		if dir == "" {
+			dir = "."
+		}
+		return c.kubectl(ctx, "tar", "-cf", "-", "-C", dir, name)
+	}
+	return exec.CommandContext(ctx, "docker", "cp", c.container+":"+c.path, "-")
+}
+
+// pushToContainer streams the file at localPath into the container as a
+// one-file tar archive, as docker cp - and kubectl cp do, then reads back
+// the size of the copy.
+func pushToContainer(ctx context.Context, c containerDest, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
+
+	dir, name := path.Split(c.path)
+	if dir == "" {
+		// docker cp takes paths from the root, kubectl from the working directory
+		dir = "."
+		if c.scheme == "docker" {
+			dir = "/"
+		}
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    int64(info.Mode().Perm()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	cmd := c.unpackCommand(ctx, dir)
+	cmd.Stdin = pr
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w: %s", info.Name(), c, err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Confirm the copy's size before letting go of the original
+	size, err := containerFileSize(ctx, c)
+	if err != nil {
+		return fmt.Errorf("failed to check copy %s: %w", c, err)
+	}
+	if size != info.Size() {
+		return fmt.Errorf("copy %s is %d bytes, expected %d", c, size, info.Size())
+	}
+	return nil
+}
+
+// containerFileSize reads the size of a file in a container from the
+// header of a tar archive of it, which for docker needs nothing installed
+// in the container. The rest of the file is not read.
+func containerFileSize(ctx context.Context, c containerDest) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := c.packCommand(ctx)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	hdr, err := tar.NewReader(out).Next()
+	// Stop the copy rather than read the rest of the file
+	cancel()
+	cmd.Wait()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return 0, fmt.Errorf("%s", msg)
+		}
+		return 0, err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return 0, fmt.Errorf("not a regular file")
+	}
+	return hdr.Size, nil
+}
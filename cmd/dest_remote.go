@@ -0,0 +1,167 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+// splitRemoteDest recognises scp-style destinations such as user@host:/path,
+// returning the host part and the remote path.
+func splitRemoteDest(path string) (host, remotePath string, ok bool) {
+	if _, ok := splitContainerDest(path); ok {
+		return "", "", false
+	}
+	i := strings.Index(path, ":")
+	// A single letter before the colon is a Windows drive, not a host
+	if i <= 1 || strings.ContainsAny(path[:i], `/\`) || strings.HasPrefix(path[i+1:], "//") {
+		return "", "", false
+	}
+	return path[:i], path[i+1:], true
+}
+
+// isRemoteDest reports whether path is on another machine or in a container.
+func isRemoteDest(path string) bool {
+	_, _, ok := splitRemoteDest(path)
+	if !ok {
+		_, ok = splitContainerDest(path)
+	}
+	return ok
+}
+
+// pushToRemote copies a file from src to a remote destination with rsync, or
+// scp when rsync is not installed, or into a container, and only removes the
+// original once the remote copy has been confirmed to be complete.
+func pushToRemote(ctx context.Context, src source, info fs.FileInfo, destPath string) error {
+	start := clk.Now()
+	origin := fileOrigin(candidate{FileInfo: info, Source: src})
+
+	localPath := ""
+	if dir, ok := sourceDir(src); ok {
+		localPath = filepath.Join(dir, info.Name())
+	} else {
+		// Stage files from other remote sources locally first
+		tmpDir, err := os.MkdirTemp("", "getnew-")
+		if err != nil {
+			return fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		localPath = filepath.Join(tmpDir, info.Name())
+		r, err := src.Open(info.Name())
+		if err != nil {
+			return fmt.Errorf("failed to open source file: %w", err)
+		}
+		_, err = getnew.WriteFile(ctx, localPath, r)
+		if closeErr := r.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to read source file: %w", closeErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if c, ok := splitContainerDest(destPath); ok {
+		if err := pushToContainer(ctx, c, localPath); err != nil {
+			return err
+		}
+		destPath = c.String()
+	} else if err := pushToHost(ctx, localPath, destPath, info.Size()); err != nil {
+		return err
+	}
+	if !readOnly {
+		if err := removeAfterPush(ctx, src, info, localPath); err != nil {
+			return err
+		}
+	}
+
+	recordMove(historyEntry{
+		Source:   src.Location(info.Name()),
+		Dest:     destPath,
+		Size:     info.Size(),
+		Duration: clk.Since(start),
+		Origin:   origin,
+	})
+	return nil
+}
+
+// pushToHost copies the file at localPath to a host:path destination and
+// confirms the size of the copy.
+func pushToHost(ctx context.Context, localPath, destPath string, size int64) error {
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("rsync"); err == nil {
+		cmd = exec.CommandContext(ctx, "rsync", "--times", "--partial", localPath, destPath)
+	} else {
+		cmd = exec.CommandContext(ctx, "scp", "-p", localPath, destPath)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", filepath.Base(localPath), destPath, err)
+	}
+
+	// Confirm the remote size before letting go of the original
+	host, remotePath, _ := splitRemoteDest(destPath)
+	return verifyRemoteCopy(ctx, host, remotePath, size)
+}
+
+// removeAfterPush removes the original of a file pushed from localPath.
+func removeAfterPush(ctx context.Context, src source, info fs.FileInfo, localPath string) error {
+	// Leave the original if a program is still writing it; the remote copy
+	// may be incomplete, but the next run will replace it
+	if err := getnew.WaitUntilClosed(ctx, candidate{FileInfo: info, Source: src}, findOptions()); err != nil {
+		return err
+	}
+	remove := src.Remove
+	if _, ok := sourceDir(src); ok {
+		remove = func(string) error { return removeOriginal(localPath) }
+	}
+	if err := remove(info.Name()); err != nil {
+		return fmt.Errorf("failed to remove original file: %w", err)
+	}
+	return nil
+}
+
+func verifyRemoteCopy(ctx context.Context, host, remotePath string, size int64) error {
+	// The remote shell starts in the home directory, which ~ would mean
+	remotePath = strings.TrimPrefix(remotePath, "~/")
+	out, err := exec.CommandContext(ctx, "ssh", host, "--", "wc -c < "+shellQuote(remotePath)).Output()
+	if err != nil {
+		return fmt.Errorf("failed to check remote copy %s:%s: %w", host, remotePath, err)
+	}
+	remoteSize, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to check remote copy %s:%s: unexpected output %q", host, remotePath, out)
+	}
+	if size >= 0 && remoteSize != size {
+		return fmt.Errorf("remote copy %s:%s is %d bytes, expected %d", host, remotePath, remoteSize, size)
+	}
+	return nil
+}
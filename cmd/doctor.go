@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the setup and report which archive formats can be unpacked",
+	Long: `doctor checks the config file, the source directories and the destination,
+then lists every archive format --unarchive knows with the tool that would
+unpack it here, and the external extractors found on this machine with their
+versions. It exits with an error if anything needs fixing.`,
+	Args: cobra.NoArgs,
+	// doctor reports setup problems instead of stopping at the first one
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		if !runDoctor(cmd) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// runDoctor prints the report and returns false if it found a problem.
+func runDoctor(cmd *cobra.Command) bool {
+	ctx := cmd.Context()
+	healthy := true
+	check := func(what string, err error) {
+		if err != nil {
+			healthy = false
+			fmt.Printf("  problem  %s: %v\n", what, err)
+			return
+		}
+		fmt.Printf("  ok       %s\n", what)
+	}
+
+	fmt.Println("Setup")
+	cfg, err := loadConfig()
+	check("config "+configPath(), err)
+	if err == nil {
+		appConfig = cfg
+	}
+	opts, err := resolveOptions(newInvocation(cmd))
+	check("options", err)
+	if err == nil {
+		for _, spec := range opts.sources {
+			check("source "+spec, checkSource(ctx, spec))
+		}
+		check("destination "+opts.dest, checkDest(opts.dest))
+	}
+	_, err = stateDir()
+	check("state directory", err)
+
+	fmt.Println("\nArchive formats")
+	for _, f := range getnew.Formats(ctx) {
+		if f.Using == "" {
+			fmt.Printf("  %-9s  %s\n", f.Format, f.Missing)
+			continue
+		}
+		fmt.Printf("  %-9s  %s\n", f.Format, f.Using)
+	}
+
+	fmt.Println("\nExtractors")
+	for _, t := range getnew.Tools(ctx) {
+		switch {
+		case t.Path == "":
+			fmt.Printf("  %-7s  not installed\n", t.Name)
+		case t.Problem != "":
+			fmt.Printf("  %-7s  %-8s  %s (not used: %s)\n", t.Name, orDash(t.Version), t.Path, t.Problem)
+		default:
+			fmt.Printf("  %-7s  %-8s  %s  %s\n", t.Name, orDash(t.Version), t.Path, strings.Join(t.Formats, " "))
+		}
+	}
+	return healthy
+}
+
+func checkSource(ctx context.Context, spec string) error {
+	s, err := openSource(spec)
+	if err != nil {
+		return err
+	}
+	_, err = s.List(ctx)
+	return err
+}
+
+// checkDest makes sure a local destination is a directory that can be
+// written to. Remote destinations are only checked when used.
+func checkDest(dest string) error {
+	if isRemoteDest(dest) {
+		return nil
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+	f, err := os.CreateTemp(dest, ".getnew-doctor-")
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
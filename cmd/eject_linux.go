@@ -0,0 +1,77 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ejectVolume unmounts the filesystem dir is on with udisks, which also
+// powers the device off, or with umount if udisks is not installed.
+func ejectVolume(dir string) error {
+	device, mountPoint, err := findMount(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("udisksctl"); err == nil && strings.HasPrefix(device, "/dev/") {
+		if err := runQuiet("udisksctl", "unmount", "--block-device", device); err != nil {
+			return err
+		}
+		// Not every device can be powered off; unmounted is enough to remove it
+		runQuiet("udisksctl", "power-off", "--block-device", device)
+		return nil
+	}
+	return runQuiet("umount", mountPoint)
+}
+
+// findMount returns the device and mount point of the filesystem holding dir.
+func findMount(dir string) (device, mountPoint string, err error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		// Spaces in mount points are escaped as \040
+		mp := strings.ReplaceAll(fields[1], `\040`, " ")
+		if (abs == mp || strings.HasPrefix(abs, strings.TrimSuffix(mp, "/")+"/")) && len(mp) > len(mountPoint) {
+			device, mountPoint = fields[0], mp
+		}
+	}
+	return device, mountPoint, scanner.Err()
+}
@@ -0,0 +1,214 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var (
+	extractOnly    []string
+	unarchiveDepth int
+	testArchive    bool
+	keepArchive    bool
+	// extractFromSource unpacks archives from the source without copying
+	// them to the destination first.
+	extractFromSource bool
+)
+
+// archiveConfig is the archives section of the config file.
+type archiveConfig struct {
+	// Keep keeps archives once unpacked, unless --keep-archive=false.
+	Keep bool `yaml:"keep"`
+}
+
+var peekArchiveCmd = &cobra.Command{
+	Use:   "peek-archive [archive|filter]",
+	Short: "List what is inside an archive without unpacking it",
+	Long: `peek-archive lists the files inside an archive: a path, or the newest archive in
+the source directory matching a filter. With --extract-only, only the files
+that would be unpacked are listed.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		selector := ""
+		if len(args) > 0 {
+			selector = args[0]
+		}
+		if err := peekArchive(cmd.Context(), selector); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(peekArchiveCmd)
+	rootCmd.PersistentFlags().IntVar(&unarchiveDepth, "unarchive-depth", 1, "Also unpack archives found inside the archive, this many levels deep, removing them once unpacked; implies --unarchive")
+	rootCmd.PersistentFlags().StringSliceVar(&extractOnly, "extract-only", nil, "Unpack only the archive entries matching these patterns (e.g. '*.csv' or 'data/*'), keeping the archive; implies --unarchive")
+	rootCmd.PersistentFlags().BoolVar(&keepArchive, "keep-archive", false, "Keep an archive once it is unpacked rather than removing it (default from archives.keep in the config file)")
+	rootCmd.PersistentFlags().BoolVar(&extractFromSource, "extract-from-source", false, "Unpack archives straight from a local source into the destination without copying them first, removing them from the source only once unpacked; implies --unarchive")
+	rootCmd.PersistentFlags().BoolVar(&testArchive, "test-archive", false, "Check that a moved archive is undamaged (zip CRCs, or decoding it in full) before unpacking it or reporting success")
+}
+
+// unpackedFile is an archive unpacked straight from the source, with where
+// its contents went.
+type unpackedFile struct {
+	fs.FileInfo
+	root string
+}
+
+// unpackingFromSource reports whether file is an archive to unpack straight
+// from its source with --extract-from-source. Archives in remote sources are
+// copied and unpacked as usual.
+func unpackingFromSource(file candidate) bool {
+	if !extractFromSource || !file.Mode().IsRegular() || !getnew.IsArchive(file.Name()) {
+		return false
+	}
+	_, ok := sourceDir(file.Source)
+	return ok
+}
+
+// unpackFromSource unpacks an archive in a local source into dir and removes
+// it from the source once everything is unpacked, unless it is to be kept.
+// It returns where the contents went.
+func unpackFromSource(ctx context.Context, file candidate, dir string) (string, error) {
+	srcDir, _ := sourceDir(file.Source)
+	archive := filepath.Join(srcDir, file.Name())
+	source := file.Source.Location(file.Name())
+	unlock, err := lockSource(source)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	// Another run may have moved it between listing and locking
+	if _, err := os.Stat(archive); errors.Is(err, fs.ErrNotExist) {
+		return "", getnew.WithKind(fmt.Errorf("%s has already been moved, perhaps by another getnew", file.Name()), getnew.ErrConflict)
+	}
+	if err := checkGitIgnored(ctx, dir); err != nil {
+		return "", err
+	}
+	if err := runPreHook(ctx, source, dir); err != nil {
+		return "", err
+	}
+	opts := findOptions()
+	if testArchive {
+		if err := getnew.TestArchive(ctx, archive, opts); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	start := clk.Now()
+	origin := fileOrigin(file)
+	root, err := getnew.UnpackInto(ctx, archive, dir, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to unarchive: %w", err)
+	}
+	if len(extractOnly) == 0 && !keepArchive && !readOnly {
+		if err := removeAfterPush(ctx, file.Source, file.FileInfo, archive); err != nil {
+			return "", err
+		}
+	}
+	recordMove(historyEntry{
+		Source:   source,
+		Dest:     filepath.Join(dir, file.Name()),
+		Size:     file.Size(),
+		Duration: clk.Since(start),
+		Origin:   origin,
+	})
+	runPostHook(ctx, source, root)
+	return root, nil
+}
+
+// testMovedArchive checks a file moved to dir with --test-archive, if it is
+// an archive.
+func testMovedArchive(ctx context.Context, dir string, file fs.FileInfo) error {
+	if !testArchive || file.IsDir() || !getnew.IsArchive(file.Name()) {
+		return nil
+	}
+	return getnew.TestArchive(ctx, filepath.Join(dir, file.Name()), findOptions())
+}
+
+// archiveToPeek is the archive at path, or failing that, the newest archive
+// in a local source matching filter.
+func archiveToPeek(ctx context.Context, selector string) (string, error) {
+	if info, err := os.Stat(selector); err == nil && !info.IsDir() {
+		return selector, nil
+	}
+	fileFilter = selector
+	files, err := collectCandidates(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		dir, ok := sourceDir(file.Source)
+		if ok && getnew.IsArchive(file.Name()) {
+			return filepath.Join(dir, file.Name()), nil
+		}
+	}
+	if selector != "" {
+		return "", fmt.Errorf("no archive matching '%s' found in the source directory", selector)
+	}
+	return "", fmt.Errorf("no archives found in the source directory")
+}
+
+func peekArchive(ctx context.Context, selector string) error {
+	path, err := archiveToPeek(ctx, selector)
+	if err != nil {
+		return err
+	}
+	entries, err := getnew.ListArchive(ctx, path, findOptions())
+	if err != nil {
+		return err
+	}
+	if path != selector {
+		fmt.Fprintln(os.Stderr, path)
+	}
+	files, total := 0, int64(-1)
+	for _, e := range entries {
+		if e.Dir {
+			fmt.Printf("%8s  %s\n", "", e.Name)
+			continue
+		}
+		files++
+		// Some tools list names only
+		if e.Size >= 0 {
+			total = max(total, 0) + e.Size
+		}
+		fmt.Printf("%8s  %s\n", humanSize(e.Size), e.Name)
+	}
+	if total >= 0 {
+		fmt.Fprintf(os.Stderr, "%d file(s), %s\n", files, humanSize(total))
+	} else {
+		fmt.Fprintf(os.Stderr, "%d file(s)\n", files)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var filesFrom string
+
+func init() {
+	rootCmd.Flags().StringVar(&filesFrom, "files-from", "", "Move the files named in this file, one per line or NUL-separated ('-' for stdin), e.g. from getnew list | fzf -m")
+}
+
+// listLineRe matches the number and date getnew list puts before a name.
+var listLineRe = regexp.MustCompile(`^\s*\d+  \d{4}-\d\d-\d\d \d\d:\d\d  `)
+
+// moveListedFiles moves the candidates named in --files-from. Names may be
+// bare file names, full paths as printed by list -0, or whole lines of list
+// output.
+func moveListedFiles(ctx context.Context) error {
+	var r io.Reader = os.Stdin
+	if filesFrom != "-" {
+		f, err := os.Open(filesFrom)
+		if err != nil {
+			return fmt.Errorf("failed to open file list: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read file list: %w", err)
+	}
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+
+	candidates, err := settledCandidates(ctx)
+	if err != nil {
+		return err
+	}
+	byName := map[string]candidate{}
+	for _, c := range candidates {
+		byName[c.Name()] = c
+		byName[c.Source.Location(c.Name())] = c
+	}
+
+	var files []candidate
+	var missing []string
+	seen := map[string]bool{}
+	for _, entry := range bytes.Split(data, sep) {
+		name := strings.TrimSuffix(string(entry), "\r")
+		if name == "" {
+			continue
+		}
+		c, ok := byName[name]
+		if !ok {
+			c, ok = byName[listedName(name)]
+		}
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		if key := c.Source.Location(c.Name()); !seen[key] {
+			seen[key] = true
+			files = append(files, c)
+		}
+	}
+	for _, name := range missing {
+		fmt.Fprintf(os.Stderr, "Error: %s: not among the candidate files\n", name)
+	}
+	if len(files) > 0 {
+		err = moveFiles(ctx, files)
+	}
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("%d listed file(s) not found", len(missing))
+	}
+	return err
+}
+
+// listedName extracts the file name from a line of getnew list output.
+func listedName(line string) string {
+	name := listLineRe.ReplaceAllString(line, "")
+	if i := strings.Index(name, "  <- "); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "  ("); i >= 0 && strings.HasSuffix(name, ")") {
+		name = name[:i]
+	}
+	return name
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import "testing"
+
+func TestListedName(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"  3  2025-01-02 15:04  report 2025.pdf", "report 2025.pdf"},
+		{"  1  2025-01-02 15:04  a.zip  (/home/me/Downloads)", "a.zip"},
+	}
+	for _, tt := range tests {
+		if got := listedName(tt.line); got != tt.want {
+			t.Errorf("listedName(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
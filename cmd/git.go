@@ -0,0 +1,159 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// gitAdd stages moved files when the destination is in a git work tree.
+	gitAdd bool
+	// gitIgnored is what --git-add does with a file .gitignore excludes:
+	// warn, refuse or add.
+	gitIgnored string
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&gitAdd, "git-add", false, "When the destination is inside a git repository, stage the moved file with git add")
+	rootCmd.PersistentFlags().StringVar(&gitIgnored, "git-ignored", "warn", "With --git-add, what to do with a file .gitignore excludes: warn (move it but leave it unstaged), refuse (leave it in the source) or add (stage it anyway)")
+}
+
+// gitCommand runs git in dir and returns what it printed, with its error
+// output in the error if it fails.
+func gitCommand(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), err
+}
+
+// inGitWorkTree reports whether dir is inside a git work tree.
+func inGitWorkTree(ctx context.Context, dir string) bool {
+	out, err := gitCommand(ctx, dir, "rev-parse", "--is-inside-work-tree")
+	return err == nil && strings.TrimSpace(out) == "true"
+}
+
+// existingParent is path's nearest ancestor that exists, since the
+// destination directories may only be created by the move.
+func existingParent(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// gitIgnores reports whether .gitignore excludes path in the work tree
+// holding dir. Tracked files are never reported as ignored.
+func gitIgnores(ctx context.Context, dir, path string) (bool, error) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false, err
+	}
+	_, err = gitCommand(ctx, dir, "check-ignore", "-q", "--", filepath.ToSlash(rel))
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exit) && exit.ExitCode() == 1:
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check .gitignore for %s: %w", path, err)
+}
+
+// checkGitIgnored refuses a move to destPath that .gitignore excludes, with
+// --git-add and --git-ignored=refuse.
+func checkGitIgnored(ctx context.Context, destPath string) error {
+	if !gitAdd || gitIgnored != "refuse" || isRemoteDest(destPath) {
+		return nil
+	}
+	dir := existingParent(destPath)
+	if !inGitWorkTree(ctx, dir) {
+		return nil
+	}
+	ignored, err := gitIgnores(ctx, dir, destPath)
+	if err != nil {
+		return err
+	}
+	if ignored {
+		return fmt.Errorf("%s is ignored by git; not moving it (--git-ignored=refuse)", destPath)
+	}
+	return nil
+}
+
+// stageMoved runs git add on what a move left at path with --git-add, if
+// it is in a git work tree. dir is the directory it was moved into; an
+// archive unpacked straight into dir is not staged, as that would stage
+// everything else in dir too.
+func stageMoved(ctx context.Context, dir, path string) error {
+	if !gitAdd || isRemoteDest(path) {
+		return nil
+	}
+	whole := filepath.Clean(path) == filepath.Clean(dir)
+	parent := filepath.Dir(path)
+	if whole {
+		parent = dir
+	}
+	if !inGitWorkTree(ctx, parent) {
+		return nil
+	}
+	if whole {
+		fmt.Fprintf(os.Stderr, "Warning: not staging %s, as the archive was unpacked among other files\n", dir)
+		return nil
+	}
+	args := []string{"add"}
+	if gitIgnored == "add" {
+		args = append(args, "--force")
+	} else {
+		ignored, err := gitIgnores(ctx, parent, path)
+		if err != nil {
+			return err
+		}
+		if ignored {
+			fmt.Fprintf(os.Stderr, "Warning: %s is ignored by git; not staging it\n", path)
+			return nil
+		}
+	}
+	if _, err := gitCommand(ctx, parent, append(args, "--", filepath.Base(path))...); err != nil {
+		return fmt.Errorf("failed to git add %s: %w", path, err)
+	}
+	fmt.Fprintf(progressOut(), "Staged in git: %s\n", path)
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitAdd(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	ctx := context.Background()
+	repo := t.TempDir()
+	if _, err := gitCommand(ctx, repo, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(repo, ".gitignore"), []byte("*.log\nbuild/\n"), 0o644)
+	for _, name := range []string{"notes.txt", "run.log", "other.txt"} {
+		os.WriteFile(filepath.Join(repo, name), []byte(name), 0o644)
+	}
+	staged := func() string {
+		out, _ := gitCommand(ctx, repo, "diff", "--cached", "--name-only")
+		return strings.Join(strings.Fields(out), " ")
+	}
+	defer func(add bool, ignored string) { gitAdd, gitIgnored = add, ignored }(gitAdd, gitIgnored)
+	gitAdd, gitIgnored = true, "warn"
+
+	if err := stageMoved(ctx, repo, filepath.Join(repo, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageMoved(ctx, repo, filepath.Join(repo, "run.log")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageMoved(ctx, repo, repo); err != nil {
+		t.Fatal(err)
+	}
+	if got := staged(); got != "notes.txt" {
+		t.Errorf("staged %q, want only notes.txt", got)
+	}
+
+	if err := checkGitIgnored(ctx, filepath.Join(repo, "build", "out", "app.bin")); err != nil {
+		t.Errorf("warn refused a move: %v", err)
+	}
+	gitIgnored = "refuse"
+	if err := checkGitIgnored(ctx, filepath.Join(repo, "build", "out", "app.bin")); err == nil {
+		t.Error("refuse allowed a move into an ignored directory")
+	}
+	if err := checkGitIgnored(ctx, filepath.Join(repo, "report.pdf")); err != nil {
+		t.Errorf("refuse stopped a move of a file git would track: %v", err)
+	}
+
+	gitIgnored = "add"
+	if err := stageMoved(ctx, repo, filepath.Join(repo, "run.log")); err != nil {
+		t.Fatal(err)
+	}
+	if got := staged(); got != "notes.txt run.log" {
+		t.Errorf("staged %q, want notes.txt run.log", got)
+	}
+
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "a.txt"), nil, 0o644)
+	if err := stageMoved(ctx, outside, filepath.Join(outside, "a.txt")); err != nil {
+		t.Errorf("outside a repository: %v", err)
+	}
+}
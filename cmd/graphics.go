@@ -0,0 +1,165 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+// Terminal cells are taken to be this many pixels, which is near enough
+// for sizing thumbnails.
+const cellWidth, cellHeight = 8, 16
+
+// graphicsProtocol is how the terminal on stdout shows images: kitty (the
+// kitty graphics protocol, also spoken by Ghostty and WezTerm), iterm
+// (iTerm2's inline images) or sixel, or "" if it cannot. GETNEW_GRAPHICS
+// names one for terminals that are not recognised, or none.
+func graphicsProtocol() (string, error) {
+	switch p := os.Getenv("GETNEW_GRAPHICS"); p {
+	case "kitty", "iterm", "sixel":
+		return p, nil
+	case "none":
+		return "", nil
+	case "":
+	default:
+		return "", fmt.Errorf("GETNEW_GRAPHICS must be kitty, iterm, sixel or none, got %q", p)
+	}
+	// Multiplexers swallow the escape sequences unless told to pass them on
+	if !isTerminal(os.Stdout) || os.Getenv("TMUX") != "" || strings.HasPrefix(os.Getenv("TERM"), "screen") {
+		return "", nil
+	}
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case term == "xterm-kitty" || term == "xterm-ghostty" || os.Getenv("KITTY_WINDOW_ID") != "" || program == "ghostty":
+		return "kitty", nil
+	case program == "iTerm.app" || program == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return "iterm", nil
+	case term == "foot" || strings.HasPrefix(term, "foot-") || term == "mlterm" || strings.Contains(term, "sixel"):
+		return "sixel", nil
+	}
+	return "", nil
+}
+
+// showThumbnail draws a thumbnail of an image no larger than cols by rows
+// cells at the cursor, using protocol, and leaves the cursor below it.
+func showThumbnail(w io.Writer, protocol string, file candidate, cols, rows int) error {
+	thumb, err := getnew.Thumbnail(file, cols*cellWidth, rows*cellHeight)
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %w", file.Name(), err)
+	}
+	if protocol == "sixel" {
+		_, err := fmt.Fprintf(w, "%s\n", sixel(thumb))
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, thumb); err != nil {
+		return fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	cells := (thumb.Bounds().Dx() + cellWidth - 1) / cellWidth
+	if protocol == "iterm" {
+		_, err := fmt.Fprintf(w, "\033]1337;File=inline=1;size=%d;width=%d;preserveAspectRatio=1:%s\a\n", buf.Len(), cells, data)
+		return err
+	}
+
+	// Kitty takes the image in chunks of at most 4096 bytes, the first
+	// saying what it is: a PNG to show now, cells wide
+	for first := true; first || data != ""; first = false {
+		chunk := data[:min(len(data), 4096)]
+		data = data[len(chunk):]
+		more := 0
+		if data != "" {
+			more = 1
+		}
+		control := fmt.Sprintf("m=%d", more)
+		if first {
+			control = fmt.Sprintf("a=T,f=100,c=%d,q=2,%s", cells, control)
+		}
+		if _, err := fmt.Fprintf(w, "\033_G%s;%s\033\\", control, chunk); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// sixel encodes img as DEC sixel graphics in the 216 colors of a 6x6x6
+// cube, each band of six rows drawn one color at a time.
+func sixel(img image.Image) []byte {
+	b := img.Bounds()
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "\033Pq\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+	index := func(x, y int) int {
+		r, g, b, _ := img.At(x, y).RGBA()
+		level := func(v uint32) int { return int((v*5 + 0x7fff) / 0xffff) }
+		return level(r)*36 + level(g)*6 + level(b)
+	}
+	for top := b.Min.Y; top < b.Max.Y; top += 6 {
+		// The sixel for each color in each column of the band
+		bands := map[int][]byte{}
+		var order []int
+		for x := b.Min.X; x < b.Max.X; x++ {
+			for bit := 0; bit < 6 && top+bit < b.Max.Y; bit++ {
+				c := index(x, top+bit)
+				if bands[c] == nil {
+					bands[c] = make([]byte, b.Dx())
+					order = append(order, c)
+				}
+				bands[c][x-b.Min.X] |= 1 << bit
+			}
+		}
+		for i, c := range order {
+			if i > 0 {
+				out.WriteByte('$')
+			}
+			fmt.Fprintf(&out, "#%d", c)
+			row := bands[c]
+			for x := 0; x < len(row); {
+				run := 1
+				for x+run < len(row) && row[x+run] == row[x] {
+					run++
+				}
+				if run > 3 {
+					fmt.Fprintf(&out, "!%d%c", run, 63+row[x])
+				} else {
+					out.Write(bytes.Repeat([]byte{63 + row[x]}, run))
+				}
+				x += run
+			}
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\033\\")
+	return out.Bytes()
+}
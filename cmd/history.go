@@ -0,0 +1,506 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	historySince string
+	exportFormat string
+	exportOutput string
+	historyLimit int
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the files getnew has moved",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := showHistory(); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+var historyExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the move history as a CSV or Parquet report",
+	Long: `export writes every recorded move (time, name, size, source, origin,
+destination, duration and tags) as CSV or Parquet, for expense tracking or
+storage audits. The origin is the URL a file was downloaded from, as its web
+mark or its source recorded, and is left empty when that is not known. Use
+--since to limit the report to recent moves.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportHistory(); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyExportCmd)
+	historyCmd.PersistentFlags().StringVar(&historySince, "since", "", "Only include moves after this date or age (e.g. 2024-01-01, 7d)")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "l", 20, "Number of entries to show (0 for all)")
+	historyExportCmd.Flags().StringVarP(&exportFormat, "format", "f", "csv", "Report format: csv or parquet")
+	historyExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File to write the report to (default stdout)")
+}
+
+// historyEntry records one file getnew has moved.
+type historyEntry struct {
+	Time     time.Time     `json:"time"`
+	Name     string        `json:"name"`
+	Size     int64         `json:"size"`
+	Source   string        `json:"source"`
+	Dest     string        `json:"dest"`
+	Duration time.Duration `json:"duration"`
+	SHA256   string        `json:"sha256,omitempty"`
+	Tags     []string      `json:"tags,omitempty"`
+	// Origin is the URL the file was downloaded from, if its web mark said.
+	Origin string `json:"origin,omitempty"`
+}
+
+func historyPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// loadHistory returns all recorded moves, oldest first.
+func loadHistory() ([]historyEntry, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return entries, nil
+}
+
+// lockHistory takes the lock that keeps runs from writing the history at
+// once, waiting for it if need be, and returns a function that releases it.
+// The lock is on a file beside the history, as rewriting the history
+// replaces it.
+func lockHistory() (func(), error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history lock: %w", err)
+	}
+	if err := waitLock(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock history: %w", err)
+	}
+	return func() {
+		unlock(f)
+		f.Close()
+	}, nil
+}
+
+// updateHistory rewrites the history after change has edited its entries,
+// holding the history lock throughout so that moves recorded meanwhile by
+// other runs are not lost.
+func updateHistory(change func(entries []historyEntry) error) error {
+	release, err := lockHistory()
+	if err != nil {
+		return err
+	}
+	defer release()
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	if err := change(entries); err != nil {
+		return err
+	}
+	return saveHistory(entries)
+}
+
+// saveHistory replaces the history with entries. Callers hold the history
+// lock.
+func saveHistory(entries []historyEntry) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func appendHistory(entry historyEntry) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(entry); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return f.Close()
+}
+
+// historyMu keeps moves made in parallel with --jobs from recording at once.
+var historyMu sync.Mutex
+
+// latestMoves maps each download's base name to its latest move. It is read
+// from the history file (at path) once and then only the entries appended
+// since, by this run or others, are read, so recording a move in a
+// long-running watch or daemon neither rereads the whole file nor misses
+// what other runs recorded. A rewritten history is read again in full.
+var latestMoves struct {
+	path   string
+	file   fs.FileInfo
+	read   int64
+	byName map[string]historyEntry
+}
+
+// latestMovesByName returns latestMoves for the current history file,
+// brought up to date. Callers hold the history lock.
+func latestMovesByName() map[string]historyEntry {
+	path, _ := historyPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		latestMoves.path, latestMoves.file, latestMoves.read, latestMoves.byName = path, nil, 0, map[string]historyEntry{}
+		return latestMoves.byName
+	}
+	if latestMoves.byName == nil || latestMoves.path != path || latestMoves.file == nil ||
+		!os.SameFile(info, latestMoves.file) || info.Size() < latestMoves.read {
+		latestMoves.path, latestMoves.read, latestMoves.byName = path, 0, map[string]historyEntry{}
+	}
+	latestMoves.file = info
+	if info.Size() == latestMoves.read {
+		return latestMoves.byName
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return latestMoves.byName
+	}
+	defer f.Close()
+	r := bufio.NewReader(io.NewSectionReader(f, latestMoves.read, info.Size()-latestMoves.read))
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// Leave a line still being written for next time
+			break
+		}
+		latestMoves.read += int64(len(line))
+		var entry historyEntry
+		if json.Unmarshal(line, &entry) == nil {
+			latestMoves.byName[downloadBaseName(entry.Name)] = entry
+		}
+	}
+	return latestMoves.byName
+}
+
+// recordMove adds a completed move to the history, filling in the time and
+// name. Failing to record is not worth failing the move over, so problems are
+// only reported.
+func recordMove(entry historyEntry) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	entry.Time = clk.Now()
+	if entry.Name == "" {
+		entry.Name = filepath.Base(entry.Dest)
+	}
+	// A file streamed to stdout has "-" for its destination
+	if dest, err := filepath.Abs(entry.Dest); err == nil && !isRemoteDest(entry.Dest) && entry.Dest != "-" {
+		entry.Dest = dest
+	}
+
+	release, err := lockHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		release = func() {}
+	}
+	reportRepeatDownload(entry, latestMovesByName())
+	if err := appendHistory(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	release()
+	notify(event{Type: eventMoved, Time: entry.Time, Name: entry.Name, Source: entry.Source, Dest: entry.Dest, Size: entry.Size})
+}
+
+// errorEntry is a failure, in one of the long-running modes or a run that
+// ended with an error, kept so the dashboard can show it after the fact.
+type errorEntry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Name    string    `json:"name,omitempty"`
+	Error   string    `json:"error"`
+}
+
+func errorLogPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "errors.jsonl"), nil
+}
+
+// recordError prints err, sends it as an error notification and appends it
+// to the error log.
+func recordError(command, name string, err error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	notify(event{Type: eventError, Command: command, Name: name, Error: err.Error()})
+	path, pathErr := errorLogPath()
+	if pathErr != nil {
+		return
+	}
+	f, openErr := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if openErr != nil {
+		return
+	}
+	defer f.Close()
+	json.NewEncoder(f).Encode(errorEntry{Time: clk.Now(), Command: command, Name: name, Error: err.Error()})
+}
+
+func loadErrors() ([]errorEntry, error) {
+	path, err := errorLogPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error log: %w", err)
+	}
+	defer f.Close()
+	var entries []errorEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry errorEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Browsers save repeated downloads as "name (1).ext", "name (2).ext", ...
+var downloadCopySuffix = regexp.MustCompile(` \(\d+\)$`)
+
+func downloadBaseName(name string) string {
+	ext := filepath.Ext(name)
+	return downloadCopySuffix.ReplaceAllString(strings.TrimSuffix(name, ext), "") + ext
+}
+
+// reportRepeatDownload tells the user when a file with the same name has been
+// moved before, and whether its contents have changed since. latest holds
+// the latest move of each name, by downloadBaseName.
+func reportRepeatDownload(entry historyEntry, latest map[string]historyEntry) {
+	prev, ok := latest[downloadBaseName(entry.Name)]
+	if !ok {
+		return
+	}
+	// The file moved on from where it was put, as by send or get
+	if source, _ := filepath.Abs(entry.Source); prev.Dest == source {
+		return
+	}
+	when := prev.Time.Local().Format("2006-01-02 15:04")
+	switch {
+	case prev.SHA256 != "" && entry.SHA256 != "" && prev.SHA256 == entry.SHA256:
+		fmt.Fprintf(os.Stderr, "%s: identical re-download of the file moved %s\n", entry.Name, when)
+	case prev.SHA256 == "" && prev.Size == entry.Size:
+		fmt.Fprintf(os.Stderr, "%s: same size as the file moved %s, probably a re-download\n", entry.Name, when)
+	default:
+		fmt.Fprintf(os.Stderr, "%s: new version of the file moved %s (%d -> %d bytes)\n", entry.Name, when, prev.Size, entry.Size)
+	}
+}
+
+// historySinceFilter returns the entries recorded after --since, if given.
+func historySinceFilter(entries []historyEntry) ([]historyEntry, error) {
+	if historySince == "" {
+		return entries, nil
+	}
+	since, err := parseTimeSpec(historySince, clk.Now())
+	if err != nil {
+		return nil, err
+	}
+	var recent []historyEntry
+	for _, entry := range entries {
+		if !entry.Time.Before(since) {
+			recent = append(recent, entry)
+		}
+	}
+	return recent, nil
+}
+
+func showHistory() error {
+	entries, err := loadHistory()
+	if err == nil {
+		entries, err = historySinceFilter(entries)
+	}
+	if err != nil {
+		return err
+	}
+	if historyLimit > 0 && len(entries) > historyLimit {
+		entries = entries[len(entries)-historyLimit:]
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s  %s -> %s\n", entry.Time.Local().Format("2006-01-02 15:04"), entry.Source, entry.Dest)
+	}
+	return nil
+}
+
+func exportHistory() error {
+	entries, err := loadHistory()
+	if err == nil {
+		entries, err = historySinceFilter(entries)
+	}
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if exportOutput != "" {
+		f, err := os.Create(exportOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch exportFormat {
+	case "csv":
+		err = writeHistoryCSV(out, entries)
+	case "parquet":
+		err = writeHistoryParquet(out, entries)
+	default:
+		return fmt.Errorf("unknown report format '%s' (use csv or parquet)", exportFormat)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if f, ok := out.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+func writeHistoryCSV(w io.Writer, entries []historyEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "name", "size", "source", "origin", "dest", "duration_ms", "tags"})
+	for _, entry := range entries {
+		cw.Write([]string{
+			entry.Time.Format(time.RFC3339),
+			entry.Name,
+			strconv.FormatInt(entry.Size, 10),
+			entry.Source,
+			entry.Origin,
+			entry.Dest,
+			strconv.FormatInt(entry.Duration.Milliseconds(), 10),
+			strings.Join(entry.Tags, ","),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeHistoryParquet(w io.Writer, entries []historyEntry) error {
+	columns := []parquetColumn{
+		{name: "time", kind: parquetInt64, converted: parquetTimestampMillis},
+		{name: "name", kind: parquetByteArray, converted: parquetUTF8},
+		{name: "size", kind: parquetInt64, converted: -1},
+		{name: "source", kind: parquetByteArray, converted: parquetUTF8},
+		{name: "origin", kind: parquetByteArray, converted: parquetUTF8},
+		{name: "dest", kind: parquetByteArray, converted: parquetUTF8},
+		{name: "duration_ms", kind: parquetInt64, converted: -1},
+		{name: "tags", kind: parquetByteArray, converted: parquetUTF8},
+	}
+	for _, entry := range entries {
+		columns[0].int64s = append(columns[0].int64s, entry.Time.UnixMilli())
+		columns[1].strings = append(columns[1].strings, entry.Name)
+		columns[2].int64s = append(columns[2].int64s, entry.Size)
+		columns[3].strings = append(columns[3].strings, entry.Source)
+		columns[4].strings = append(columns[4].strings, entry.Origin)
+		columns[5].strings = append(columns[5].strings, entry.Dest)
+		columns[6].int64s = append(columns[6].int64s, entry.Duration.Milliseconds())
+		columns[7].strings = append(columns[7].strings, strings.Join(entry.Tags, ","))
+	}
+	return writeParquet(w, columns, len(entries))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestRecordMoveAppends(t *testing.T) {
+	useFakeClock(t)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	if err := saveHistory([]historyEntry{{Name: "report.pdf", Dest: "/old/report.pdf", Size: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	recordMove(historyEntry{Source: "/dl", Dest: "/new/report (1).pdf", Size: 2})
+	recordMove(historyEntry{Source: "/dl", Dest: "/new/notes.txt", Size: 3})
+
+	entries, err := loadHistory()
+	if err != nil || len(entries) != 3 || entries[2].Name != "notes.txt" || !entries[2].Time.Equal(testEpoch) {
+		t.Fatalf("history = %+v, %v", entries, err)
+	}
+	// Later moves are compared with the latest of each name, reading only
+	// what was appended since, here by another run
+	if err := appendHistory(historyEntry{Name: "notes.txt", Dest: "/other/notes.txt", Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if latest := latestMovesByName(); latest["report.pdf"].Size != 2 || latest["notes.txt"].Size != 4 {
+		t.Errorf("latest moves = %+v", latest)
+	}
+
+	// A rewritten history is read again in full
+	err = updateHistory(func(entries []historyEntry) error {
+		entries[3].Tags = []string{"draft"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := latestMovesByName(); len(latest["notes.txt"].Tags) != 1 {
+		t.Errorf("latest moves after a rewrite = %+v", latest)
+	}
+}
+
+func TestHistoryCSVOrigin(t *testing.T) {
+	var buf bytes.Buffer
+	entries := []historyEntry{
+		{Time: testEpoch, Name: "a.pdf", Source: "/dl", Origin: "https://example.com/a.pdf", Dest: "/docs/a.pdf"},
+		{Time: testEpoch, Name: "b.pdf", Source: "/dl", Dest: "/docs/b.pdf"},
+	}
+	if err := writeHistoryCSV(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("rows = %v, %v", rows, err)
+	}
+	if rows[0][3] != "source" || rows[0][4] != "origin" {
+		t.Errorf("header = %v", rows[0])
+	}
+	if rows[1][3] != "/dl" || rows[1][4] != "https://example.com/a.pdf" || rows[2][4] != "" {
+		t.Errorf("rows = %v", rows[1:])
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// execHook is the --exec command, run on each moved file.
+var execHook string
+
+// hooksConfig holds commands run around every move. In each, {} is replaced
+// by the file's path, or the path is appended if there is no {}.
+type hooksConfig struct {
+	// Pre runs on the source file before it is moved; if it fails, the
+	// file is left alone.
+	Pre string `yaml:"pre"`
+	// Post runs on the destination file once it has been moved.
+	Post string `yaml:"post"`
+	// Password runs on an encrypted archive and prints its password.
+	Password string `yaml:"password"`
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&execHook, "exec", "", "Run a command on each moved file, with {} replaced by its path (overrides the post hook in the config)")
+}
+
+// runPreHook runs the configured pre-move hook on the file at source.
+func runPreHook(ctx context.Context, source, dest string) error {
+	if appConfig.Hooks.Pre == "" {
+		return nil
+	}
+	if err := runHook(ctx, appConfig.Hooks.Pre, source, source, dest); err != nil {
+		return fmt.Errorf("pre-move hook refused %s: %w", source, err)
+	}
+	return nil
+}
+
+// runPostHook runs --exec, or the configured post-move hook, on the moved
+// file. A failing hook is reported but leaves the move in place.
+func runPostHook(ctx context.Context, source, dest string) {
+	hook := execHook
+	if hook == "" {
+		hook = appConfig.Hooks.Post
+	}
+	if hook == "" {
+		return
+	}
+	if err := runHook(ctx, hook, dest, source, dest); err != nil {
+		recordError("hook", dest, fmt.Errorf("hook on %s failed: %w", dest, err))
+	}
+}
+
+// runHook runs command through the shell with path in place of {}. The
+// source and destination are also given in GETNEW_SOURCE and GETNEW_DEST.
+func runHook(ctx context.Context, command, path, source, dest string) error {
+	cmd := hookCommand(ctx, command, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = progressOut()
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "GETNEW_SOURCE="+source, "GETNEW_DEST="+dest)
+	return cmd.Run()
+}
+
+// hookCommand prepares command to run through the shell with path in place
+// of {}.
+func hookCommand(ctx context.Context, command, path string) *exec.Cmd {
+	quoted := shellQuote(path)
+	if runtime.GOOS == "windows" {
+		quoted = `"` + path + `"`
+	}
+	if strings.Contains(command, "{}") {
+		command = strings.ReplaceAll(command, "{}", quoted)
+	} else {
+		command += " " + quoted
+	}
+
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
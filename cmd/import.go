@@ -0,0 +1,145 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// importing is set for the import command, which only considers photos
+	// and videos.
+	importing    bool
+	importFrom   string
+	importLayout string
+	importRename string
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import --from DIR",
+	Short: "Copy new photos and videos from a camera card into dated folders",
+	Long: `import brings in every photo and video from a camera card or phone that an
+earlier import has not, filing each into folders by the date it was taken. The
+card's folder (such as /Volumes/SD/DCIM) is searched along with the folders
+directly inside it, where cameras keep their pictures.
+
+The date comes from the EXIF data of JPEG and raw photos and from the header of
+MP4 and MOV videos, falling back to the file's modification time. --layout
+names the folders below the destination using {year}, {month} and {day}, and
+--rename names the files using {name}, {stem}, {ext}, {date} and {time}, so
+--rename '{date}_{time}{ext}' names each file by when it was taken.
+
+Files are copied, leaving the card as it is, and what has been imported
+already is recognised from the history. Use --read-only=false to remove them
+from the card once copied.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		if !cmd.Flags().Changed("read-only") {
+			readOnly = true
+		}
+		if importLayout != "" {
+			destDir = filepath.Join(destDir, importLayout)
+		}
+		if importRename != "" {
+			renameTemplate = importRename
+		}
+		err := importFiles(ctx)
+		updateStatus(ctx)
+		if err == nil && ejectAfter {
+			err = ejectSources()
+		}
+		if err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVar(&importFrom, "from", "", "The camera card's folder to import from, e.g. /Volumes/SD/DCIM")
+	importCmd.Flags().StringVar(&importLayout, "layout", "{year}/{year}-{month}-{day}", "Folders to file photos and videos into below the destination, by the date they were taken")
+	importCmd.Flags().StringVar(&importRename, "rename", "", "Name imported files from this template, e.g. '{date}_{time}{ext}' for when each was taken")
+	importCmd.Flags().BoolVar(&ejectAfter, "eject", false, "Eject the card once the files have been imported")
+}
+
+// importSources is the card folder dir and the folders directly inside it,
+// such as DCIM/100CANON.
+func importSources(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	sources := []string{dir}
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			sources = append(sources, filepath.Join(dir, e.Name()))
+		}
+	}
+	return sources, nil
+}
+
+// isPhotoOrVideo reports whether import should consider file.
+func isPhotoOrVideo(file candidate) bool {
+	kind := getnew.TypeOf(file)
+	return kind == "image" || kind == "video"
+}
+
+// importFiles copies the photos and videos not imported before, each dated
+// by when it was taken.
+func importFiles(ctx context.Context) error {
+	files, err := newestSettled(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		files = alreadyImported(files)
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "No new photos or videos to import")
+		return nil
+	}
+	for i, file := range files {
+		if taken, ok := getnew.CaptureTime(file); ok {
+			files[i].FileInfo = capturedFile{file.FileInfo, taken}
+		}
+	}
+	return moveFiles(ctx, files)
+}
+
+// capturedFile is a photo or video dated by when it was taken rather than
+// when it was last modified.
+type capturedFile struct {
+	fs.FileInfo
+	taken time.Time
+}
+
+func (f capturedFile) ModTime() time.Time { return f.taken }
@@ -0,0 +1,429 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var indexForeground bool
+
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Keep a persistent listing of large source directories",
+	Long: `index keeps a listing of each local source directory in the state directory.
+While a directory is unchanged its listing is read from the index instead of
+statting every file again, which makes getnew fast on huge network shares.
+
+The index covers the current sources and every source named in the config
+file's directories entries.`,
+}
+
+var indexBuildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Rebuild the index for all sources in the background",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runIndex(cmd, true)
+	},
+}
+
+var indexUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Refresh the index for sources that changed, in the background",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runIndex(cmd, false)
+	},
+}
+
+var indexStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show what is indexed and the progress of any running build",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := showIndexStatus(); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(indexCmd)
+	indexCmd.AddCommand(indexBuildCmd)
+	indexCmd.AddCommand(indexUpdateCmd)
+	indexCmd.AddCommand(indexStatusCmd)
+	indexCmd.PersistentFlags().BoolVarP(&indexForeground, "foreground", "f", false, "Build in the foreground, printing progress")
+}
+
+// sourceIndex is the stored listing of one directory. DirModTime is the
+// directory's own modification time when it was listed, which changes whenever
+// a file is added, removed or renamed.
+type sourceIndex struct {
+	Dir        string        `json:"dir"`
+	DirModTime time.Time     `json:"dir_mod_time"`
+	Updated    time.Time     `json:"updated"`
+	Files      []indexedFile `json:"files"`
+}
+
+type indexedFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Dir     bool      `json:"dir,omitempty"`
+}
+
+// indexProgress is written by a running build so that status can report on it.
+type indexProgress struct {
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+	Done     int       `json:"done"`
+	Total    int       `json:"total"`
+	Current  string    `json:"current,omitempty"`
+	Errors   []string  `json:"errors,omitempty"`
+}
+
+func indexDir() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "index")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create index directory: %w", err)
+	}
+	return dir, nil
+}
+
+func indexPath(dir string) (string, error) {
+	base, err := indexDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(base, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+func loadSourceIndex(dir string) (*sourceIndex, error) {
+	path, err := indexPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	idx := &sourceIndex{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index for %s: %w", dir, err)
+	}
+	return idx, nil
+}
+
+func saveSourceIndex(idx *sourceIndex) error {
+	path, err := indexPath(idx.Dir)
+	if err != nil {
+		return err
+	}
+	return writeJSONFile(path, idx)
+}
+
+// writeJSONFile replaces path atomically, so readers never see a partial file.
+func writeJSONFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(path), err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// buildSourceIndex lists dir and stores the result.
+func buildSourceIndex(ctx context.Context, dir string) (*sourceIndex, error) {
+	before, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source directory: %w", err)
+	}
+	files, err := localSource{Dir: dir}.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idx := &sourceIndex{Dir: dir, DirModTime: before.ModTime(), Updated: clk.Now()}
+	for _, file := range files {
+		idx.Files = append(idx.Files, indexedFile{Name: file.Name(), Size: file.Size(), ModTime: file.ModTime(), Dir: file.IsDir()})
+	}
+	return idx, saveSourceIndex(idx)
+}
+
+// indexProfiles returns the local directories the index covers: the current
+// sources and those named by the config file's directories entries.
+func indexProfiles() []string {
+	specs := append([]string{}, sourceDirs...)
+	for _, dir := range appConfig.Directories {
+		specs = append(specs, splitSourceList(dir.Source)...)
+	}
+
+	var dirs []string
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		s, err := openSource(expandHome(spec))
+		if err != nil {
+			continue
+		}
+		local, ok := s.(localSource)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Skipping %s: only local directories are indexed\n", spec)
+			continue
+		}
+		dir, err := filepath.Abs(local.Dir)
+		if err != nil || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func runIndex(cmd *cobra.Command, rebuild bool) {
+	if !indexForeground {
+		if err := startIndexWorker(); err != nil {
+			exitWithError(cmd, err)
+		}
+		fmt.Fprintln(os.Stderr, "Indexing in the background; see getnew index status")
+		return
+	}
+	if err := indexSources(cmd.Context(), rebuild); err != nil {
+		exitWithError(cmd, err)
+	}
+}
+
+// startIndexWorker runs the same command again with --foreground, detached
+// from this one, logging to the index directory.
+func startIndexWorker() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find getnew executable: %w", err)
+	}
+	dir, err := indexDir()
+	if err != nil {
+		return err
+	}
+	log, err := os.Create(filepath.Join(dir, "build.log"))
+	if err != nil {
+		return fmt.Errorf("failed to create index log: %w", err)
+	}
+	defer log.Close()
+
+	worker := exec.Command(exe, append(os.Args[1:], "--foreground")...)
+	worker.Stdout = log
+	worker.Stderr = log
+	if err := worker.Start(); err != nil {
+		return fmt.Errorf("failed to start indexer: %w", err)
+	}
+	return worker.Process.Release()
+}
+
+func progressPath() (string, error) {
+	dir, err := indexDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "progress.json"), nil
+}
+
+func indexSources(ctx context.Context, rebuild bool) error {
+	// A background build outlives the terminal that started it
+	signal.Ignore(syscall.SIGHUP)
+
+	path, err := progressPath()
+	if err != nil {
+		return err
+	}
+	dirs := indexProfiles()
+	progress := &indexProgress{PID: os.Getpid(), Started: clk.Now(), Total: len(dirs)}
+	for _, dir := range dirs {
+		progress.Current = dir
+		if err := writeJSONFile(path, progress); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", progress.Done+1, progress.Total, dir)
+
+		start := clk.Now()
+		idx, err := updateSourceIndex(ctx, dir, rebuild)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			progress.Errors = append(progress.Errors, fmt.Sprintf("%s: %v", dir, err))
+		} else {
+			fmt.Fprintf(os.Stderr, "       %d files in %s\n", len(idx.Files), clk.Since(start).Round(time.Millisecond))
+		}
+		progress.Done++
+	}
+	progress.Current = ""
+	progress.Finished = clk.Now()
+	return writeJSONFile(path, progress)
+}
+
+// updateSourceIndex rebuilds the index for dir, or with rebuild false only
+// when the directory has changed since it was indexed.
+func updateSourceIndex(ctx context.Context, dir string, rebuild bool) (*sourceIndex, error) {
+	if !rebuild {
+		idx, err := loadSourceIndex(dir)
+		if err != nil {
+			return nil, err
+		}
+		if idx != nil && indexCurrent(idx) {
+			return idx, nil
+		}
+	}
+	return buildSourceIndex(context.Background(), dir)
+}
+
+func indexCurrent(idx *sourceIndex) bool {
+	info, err := os.Stat(idx.Dir)
+	return err == nil && info.ModTime().Equal(idx.DirModTime)
+}
+
+func showIndexStatus() error {
+	path, err := progressPath()
+	if err != nil {
+		return err
+	}
+	var progress indexProgress
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &progress); err != nil {
+			return fmt.Errorf("failed to parse index progress: %w", err)
+		}
+	}
+	switch {
+	case progress.PID == 0:
+		fmt.Println("No index build has run")
+	case progress.Finished.IsZero() && processRunning(progress.PID):
+		fmt.Printf("Building: %d/%d sources done, indexing %s (started %s ago)\n",
+			progress.Done, progress.Total, progress.Current, clk.Since(progress.Started).Round(time.Second))
+	case progress.Finished.IsZero():
+		fmt.Printf("Last build stopped after %d/%d sources\n", progress.Done, progress.Total)
+	default:
+		fmt.Printf("Last build finished %s ago\n", clk.Since(progress.Finished).Round(time.Second))
+	}
+	for _, msg := range progress.Errors {
+		fmt.Printf("  error: %s\n", msg)
+	}
+
+	for _, dir := range indexProfiles() {
+		idx, err := loadSourceIndex(dir)
+		if err != nil {
+			return err
+		}
+		switch {
+		case idx == nil:
+			fmt.Printf("%-10s %s\n", "missing", dir)
+		case !indexCurrent(idx):
+			fmt.Printf("%-10s %s (%d files, indexed %s ago)\n", "stale", dir, len(idx.Files), clk.Since(idx.Updated).Round(time.Second))
+		default:
+			fmt.Printf("%-10s %s (%d files, indexed %s ago)\n", "current", dir, len(idx.Files), clk.Since(idx.Updated).Round(time.Second))
+		}
+	}
+	return nil
+}
+
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// indexedSource is a local source that lists from its index while the
+// directory is unchanged, and refreshes the index when it has changed.
+type indexedSource struct {
+	localSource
+	idx *sourceIndex
+}
+
+func (s *indexedSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	if !indexCurrent(s.idx) {
+		idx, err := buildSourceIndex(ctx, s.idx.Dir)
+		if err != nil {
+			return nil, err
+		}
+		s.idx = idx
+	}
+	infos := make([]fs.FileInfo, 0, len(s.idx.Files))
+	for _, f := range s.idx.Files {
+		infos = append(infos, remoteFileInfo{name: f.Name, size: f.Size, modTime: f.ModTime, dir: f.Dir})
+	}
+	return infos, nil
+}
+
+// Stream lists from the index too, rather than the directory itself.
+func (s *indexedSource) Stream(ctx context.Context, fn func(fs.FileInfo) error) error {
+	infos, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withIndex returns s listing through its index if one has been built.
+func withIndex(s source) source {
+	local, ok := s.(localSource)
+	if !ok {
+		return s
+	}
+	dir, err := filepath.Abs(local.Dir)
+	if err != nil {
+		return s
+	}
+	idx, err := loadSourceIndex(dir)
+	if err != nil || idx == nil {
+		return s
+	}
+	return &indexedSource{localSource: local, idx: idx}
+}
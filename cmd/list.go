@@ -0,0 +1,297 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listLong    bool
+	listLimit   int
+	listPlain   bool
+	listNoColor bool
+	listThumbs  bool
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list [filter]",
+	Short: "List the candidate files, newest first",
+	Long: `list shows the files getnew would choose from, newest first, numbered as
+they would be for --nth.
+
+On a terminal the files are shown as a table with their size, how long ago
+they arrived and an icon for their kind. --plain, or output to a pipe or file,
+gives one line per file with the full date instead, for scripts.
+--thumbnails draws a small preview under each image in the table, in the
+terminals getnew peek can show images in.
+
+With --long each row also shows the content type. It is looked up in the
+background a few rows ahead of the output, so the first rows appear straight
+away even in huge or slow directories.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if err := listCandidates(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().BoolVarP(&listLong, "long", "l", false, "Show size and content type")
+	listCmd.Flags().IntVar(&listLimit, "limit", 0, "Show at most this many files (0 for all)")
+	listCmd.Flags().BoolVar(&listPlain, "plain", false, "Print one line per file with its date, as when the output is not a terminal")
+	listCmd.Flags().BoolVar(&listThumbs, "thumbnails", false, "Draw a thumbnail under each image, in terminals that can show them")
+	listCmd.Flags().BoolVar(&listNoColor, "no-color", false, "Show the table without colors, as --color=never does")
+}
+
+func listCandidates(ctx context.Context) error {
+	files, err := newestCandidates(ctx, listLimit)
+	if err != nil {
+		return err
+	}
+
+	var meta func(int) fileMeta
+	if listLong {
+		meta = lazyMetadata(files, 32, fetchMetadata)
+	}
+	// Plain output is for reading back in, so it is never styled
+	if listNoColor || listPlain {
+		colorMode = "never"
+	}
+	if !listPlain && !print0 && isTerminal(os.Stdout) {
+		protocol := ""
+		if listThumbs {
+			if protocol, err = graphicsProtocol(); err != nil {
+				return err
+			}
+			if protocol == "" {
+				return fmt.Errorf("--thumbnails needs a terminal that can show images, such as kitty or iTerm2; set GETNEW_GRAPHICS if yours can")
+			}
+		}
+		printTable(files, meta, colorOn(os.Stdout), protocol)
+		return nil
+	}
+	for i, file := range files {
+		name, kind := file.Name(), getnew.TypeByName(file.Name())
+		if file.IsDir() {
+			name, kind = name+"/", "dir"
+		}
+		if colorOn(os.Stdout) {
+			name = colorName(name, kind)
+		}
+		if target, ok := linkTarget(file); ok {
+			name += " -> " + target
+		}
+		origin := ""
+		if len(sources) > 1 {
+			origin = "  (" + file.Source.Location("") + ")"
+		}
+		if print0 {
+			fmt.Printf("%s\x00", file.Source.Location(file.Name()))
+			continue
+		}
+		if meta == nil {
+			fmt.Printf("%3d  %s  %s%s\n", i+1, file.ModTime().Format("2006-01-02 15:04"), name, origin)
+			continue
+		}
+		m := meta(i)
+		if m.origin != "" {
+			origin += "  <- " + m.origin
+		}
+		fmt.Printf("%3d  %s  %8s  %-24s  %s%s\n", i+1, file.ModTime().Format("2006-01-02 15:04"), humanSize(m.size), m.mime, name, origin)
+	}
+	return nil
+}
+
+// linkTarget is where file points, if it is a link kept by --preserve-symlinks.
+func linkTarget(file candidate) (string, bool) {
+	dir, ok := sourceDir(file.Source)
+	if !ok || file.Mode()&fs.ModeSymlink == 0 {
+		return "", false
+	}
+	target, err := os.Readlink(filepath.Join(dir, file.Name()))
+	return target, err == nil
+}
+
+// fileMeta is the metadata that is too slow to gather for every file up front.
+type fileMeta struct {
+	size int64
+	mime string
+	// origin is the download URL from the file's web mark, if any.
+	origin string
+}
+
+func fetchMetadata(file candidate) fileMeta {
+	meta := fileMeta{size: file.Size(), mime: "-"}
+	meta.origin = fileOrigin(file)
+	if dir, ok := sourceDir(file.Source); ok {
+		if file.IsDir() {
+			meta.size, _ = getnew.DirSize(context.Background(), filepath.Join(dir, file.Name()))
+		}
+	}
+	if file.IsDir() {
+		meta.mime = "directory"
+		return meta
+	}
+	r, err := file.Source.Open(file.Name())
+	if err != nil {
+		return meta
+	}
+	defer r.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	meta.mime = http.DetectContentType(head[:n])
+	return meta
+}
+
+// lazyMetadata fetches metadata for files in the background, at most window
+// rows ahead of the reader, and returns a function that blocks until the
+// metadata for row i is ready. Rows must be read in order.
+func lazyMetadata(files []candidate, window int, fetch func(candidate) fileMeta) func(int) fileMeta {
+	results := make([]chan fileMeta, len(files))
+	for i := range results {
+		results[i] = make(chan fileMeta, 1)
+	}
+	ahead := make(chan struct{}, window)
+	jobs := make(chan int)
+
+	go func() {
+		for i := range files {
+			ahead <- struct{}{}
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	var workers sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				results[i] <- fetch(files[i])
+			}
+		}()
+	}
+
+	return func(i int) fileMeta {
+		meta := <-results[i]
+		<-ahead
+		return meta
+	}
+}
+
+func humanSize(n int64) string {
+	if n < 0 {
+		return "-"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// typeIcons mark each kind of file in the table.
+var typeIcons = map[string]string{
+	"image":   "🖼 ",
+	"video":   "🎞 ",
+	"audio":   "🎵",
+	"pdf":     "📕",
+	"doc":     "📄",
+	"archive": "📦",
+	"text":    "📝",
+	"dir":     "📁",
+	"":        "  ",
+}
+
+// printTable prints files as a table for reading on a terminal: index, kind,
+// size, age and name, with the content type too if meta is given, and
+// thumbnails of images drawn with protocol if it is set.
+func printTable(files []candidate, meta func(int) fileMeta, color bool, protocol string) {
+	dim, bold := func(s string) string { return s }, func(s string) string { return s }
+	if color {
+		dim = func(s string) string { return sgr("2", s) }
+		bold = func(s string) string { return sgr("1", s) }
+	}
+	header := fmt.Sprintf("%3s  %2s  %8s  %8s  ", "#", "", "SIZE", "AGE")
+	if meta != nil {
+		header += fmt.Sprintf("%-24s  ", "TYPE")
+	}
+	fmt.Println(bold(header + "NAME"))
+	now := clk.Now()
+	for i, file := range files {
+		name, kind := file.Name(), getnew.TypeByName(file.Name())
+		if file.IsDir() {
+			name, kind = name+"/", "dir"
+		}
+		if color {
+			name = colorName(name, kind)
+		}
+		if target, ok := linkTarget(file); ok {
+			name += " -> " + target
+		}
+		origin := ""
+		if len(sources) > 1 {
+			origin = "  (" + file.Source.Location("") + ")"
+		}
+		size, mime := file.Size(), ""
+		if meta != nil {
+			m := meta(i)
+			size, mime = m.size, fmt.Sprintf("%-24s  ", m.mime)
+			if m.origin != "" {
+				origin += "  <- " + m.origin
+			}
+		}
+		age := "just now"
+		if d := now.Sub(file.ModTime()); d >= time.Minute {
+			age = shortAge(d) + " ago"
+		}
+		fmt.Printf("%s  %s  %8s  %s  %s%s%s\n", dim(fmt.Sprintf("%3d", i+1)), typeIcons[kind], humanSize(size), dim(fmt.Sprintf("%8s", age)), mime, name, dim(origin))
+		if protocol != "" && kind == "image" {
+			fmt.Print("     ")
+			// An image that cannot be read still has its row
+			showThumbnail(os.Stdout, protocol, file, 24, 6)
+		}
+	}
+}
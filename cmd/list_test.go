@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs f with os.Stdout sent to a file and returns what it wrote.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	defer func(old *os.File) { os.Stdout = old }(os.Stdout)
+	os.Stdout = out
+	f()
+	data, _ := os.ReadFile(out.Name())
+	return string(data)
+}
+
+func TestListPlainIsNeverStyled(t *testing.T) {
+	useFakeClock(t)
+	fileFilter, listLimit = "", 0
+	useSource(t, &memSource{files: map[string]remoteFileInfo{
+		"photo.jpg": {name: "photo.jpg", size: 8, modTime: testEpoch},
+	}})
+	defer func(old string) { colorMode = old }(colorMode)
+	colorMode = "always"
+	listPlain = true
+	defer func() { listPlain = false }()
+
+	out := captureStdout(t, func() {
+		if err := listCandidates(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	if strings.Contains(out, "\033[") || !strings.Contains(out, "photo.jpg\n") {
+		t.Errorf("--plain printed %q", out)
+	}
+}
+
+func TestTableHasNoEmptyStyles(t *testing.T) {
+	useFakeClock(t)
+	files := []candidate{{FileInfo: remoteFileInfo{name: "notes", modTime: testEpoch}, Source: &memSource{}}}
+	out := captureStdout(t, func() { printTable(files, nil, true, "") })
+	if strings.Contains(out, "m\033[0m") {
+		t.Errorf("table has an empty styled span: %q", out)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	mediaName  string
+	ejectAfter bool
+	readOnly   bool
+	waitMount  time.Duration
+)
+
+// mediaProfile describes removable media, such as a camera card, to import
+// from. It is used with --media NAME.
+type mediaProfile struct {
+	Name   string `yaml:"name"`
+	Source string `yaml:"source"`
+	Dest   string `yaml:"dest"`
+	Filter string `yaml:"filter"`
+	// WaitMount is how long to wait for the media to be mounted; zero
+	// does not wait.
+	WaitMount time.Duration `yaml:"wait_mount"`
+	Eject     bool          `yaml:"eject"`
+	// ReadOnly copies files without removing them from the media.
+	ReadOnly bool `yaml:"read_only"`
+}
+
+// activeMedia is the profile chosen with --media, if any.
+var activeMedia *mediaProfile
+
+func init() {
+	rootCmd.Flags().StringVar(&mediaName, "media", "", "Import every new file from the removable media profile of this name in the config")
+	rootCmd.Flags().BoolVar(&ejectAfter, "eject", false, "Eject the source's volume once the files have been moved")
+	rootCmd.Flags().DurationVar(&waitMount, "wait-mount", 0, "Wait up to this long for the source directory to appear, as when a card is inserted")
+	rootCmd.PersistentFlags().BoolVar(&readOnly, "read-only", false, "Copy files without removing them from the source")
+}
+
+// applyMediaProfile applies the --media profile to anything not given on the
+// command line.
+func applyMediaProfile(cmd *cobra.Command, cfg *config) error {
+	if mediaName == "" {
+		return nil
+	}
+	for i, m := range cfg.Media {
+		if m.Name == mediaName {
+			activeMedia = &cfg.Media[i]
+		}
+	}
+	if activeMedia == nil {
+		return fmt.Errorf("no media profile called %q in %s", mediaName, configPath())
+	}
+	m := activeMedia
+	if m.Source == "" {
+		return fmt.Errorf("media profile %q has no source", m.Name)
+	}
+	if !sourceChosen(cmd) {
+		sourceDirs = []string{expandHome(m.Source)}
+	}
+	if m.Dest != "" && !cmd.Flags().Changed("dest") {
+		destDir = expandHome(m.Dest)
+	}
+	if m.Filter != "" {
+		fileFilter = m.Filter
+	}
+	if !cmd.Flags().Changed("wait-mount") {
+		waitMount = m.WaitMount
+	}
+	if !cmd.Flags().Changed("eject") {
+		ejectAfter = m.Eject
+	}
+	if !cmd.Flags().Changed("read-only") {
+		readOnly = m.ReadOnly
+	}
+	return nil
+}
+
+// waitForMount waits until every local source directory exists, checking
+// once a second, for at most timeout.
+func waitForMount(ctx context.Context, timeout time.Duration) error {
+	dirs, err := localDirs("--wait-mount")
+	if err != nil {
+		return err
+	}
+	missing := func() []string {
+		var gone []string
+		for _, dir := range dirs {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				gone = append(gone, dir)
+			}
+		}
+		return gone
+	}
+	if len(missing()) == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Waiting for %s to be mounted...\n", strings.Join(missing(), ", "))
+	deadline := clk.After(timeout)
+	ticker := clk.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for %s to be mounted", strings.Join(missing(), ", "))
+		case <-ticker.C():
+			if len(missing()) == 0 {
+				// Give the system a moment to finish mounting
+				clk.Sleep(time.Second)
+				return nil
+			}
+		}
+	}
+}
+
+// ejectSources ejects the volume each local source directory is on.
+func ejectSources() error {
+	dirs, err := localDirs("--eject")
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := ejectVolume(dir); err != nil {
+			return fmt.Errorf("failed to eject %s: %w", dir, err)
+		}
+		fmt.Fprintf(os.Stderr, "Ejected %s\n", dir)
+	}
+	return nil
+}
+
+// alreadyImported drops files that an earlier read-only import has copied,
+// recognised by their location and size in the history.
+func alreadyImported(files []candidate) []candidate {
+	entries, err := loadHistory()
+	if err != nil || len(entries) == 0 {
+		return files
+	}
+	seen := make(map[string]int64, len(entries))
+	for _, e := range entries {
+		seen[e.Source] = e.Size
+	}
+	var fresh []candidate
+	for _, file := range files {
+		if size, ok := seen[file.Source.Location(file.Name())]; !ok || size != file.Size() {
+			fresh = append(fresh, file)
+		}
+	}
+	return fresh
+}
+
+// runQuiet runs a system command, putting its output in the error if it fails.
+func runQuiet(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Event types notifiers can subscribe to.
+const (
+	eventMoved = "moved"
+	eventError = "error"
+	// eventRule is sent as well as eventMoved when a rule routes a file, so
+	// notifiers only get it when they ask for it.
+	eventRule = "rule"
+)
+
+var eventTypes = []string{eventMoved, eventError, eventRule}
+
+// event is something getnew did that a notifier may report.
+type event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Dest    string    `json:"dest,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	// Rule is the name of the rule that routed the file, or its pattern.
+	Rule string `json:"rule,omitempty"`
+}
+
+// notifier delivers events somewhere. Events arrive one at a time, or
+// several at once when the notifier's config batches them.
+type notifier interface {
+	Notify(ctx context.Context, events []event) error
+}
+
+// notifierConfig is one entry under "notifications" in the config file:
+//
+//	notifications:
+//	  - kind: command
+//	    command: notify-send getnew
+//	    events: [error]
+//	    batch: 10m
+//
+// Events limits it to those event types (moved and error by default), and Batch
+// collects events and sends them together at most once per period.
+type notifierConfig struct {
+	Kind   string        `yaml:"kind"`
+	Events []string      `yaml:"events"`
+	Batch  time.Duration `yaml:"batch"`
+	// settings is the whole entry, from which each kind reads its own fields.
+	settings yaml.Node
+}
+
+func (c *notifierConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain notifierConfig
+	if err := node.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	c.settings = *node
+	return nil
+}
+
+// notifierKinds opens a notifier of each kind from its config entry.
+var notifierKinds = map[string]func(notifierConfig) (notifier, error){}
+
+func registerNotifier(kind string, open func(notifierConfig) (notifier, error)) {
+	notifierKinds[kind] = open
+}
+
+func init() {
+	registerNotifier("command", func(cfg notifierConfig) (notifier, error) {
+		var n commandNotifier
+		if err := cfg.settings.Decode(&n); err != nil {
+			return nil, err
+		}
+		if n.Command == "" {
+			return nil, fmt.Errorf("no command given")
+		}
+		return n, nil
+	})
+}
+
+// notifyRoute is a configured notifier and the events waiting for it.
+type notifyRoute struct {
+	cfg      notifierConfig
+	notifier notifier
+
+	mu        sync.Mutex
+	pending   []event
+	scheduled bool
+}
+
+var notifyRoutes []*notifyRoute
+
+// setupNotifiers opens the notifiers in the config.
+func setupNotifiers(cfgs []notifierConfig) error {
+	notifyRoutes = nil
+	for i, cfg := range cfgs {
+		open := notifierKinds[cfg.Kind]
+		if open == nil {
+			return fmt.Errorf("notification %d: unknown kind %q", i+1, cfg.Kind)
+		}
+		for _, t := range cfg.Events {
+			if !slices.Contains(eventTypes, t) {
+				return fmt.Errorf("notification %d: unknown event %q (use %s)", i+1, t, strings.Join(eventTypes, ", "))
+			}
+		}
+		n, err := open(cfg)
+		if err != nil {
+			return fmt.Errorf("notification %d (%s): %w", i+1, cfg.Kind, err)
+		}
+		notifyRoutes = append(notifyRoutes, &notifyRoute{cfg: cfg, notifier: n})
+	}
+	return nil
+}
+
+// notify passes e to every notifier that wants it.
+func notify(e event) {
+	if e.Time.IsZero() {
+		e.Time = clk.Now()
+	}
+	for _, r := range notifyRoutes {
+		if len(r.cfg.Events) == 0 && e.Type != eventRule || slices.Contains(r.cfg.Events, e.Type) {
+			r.add(e)
+		}
+	}
+}
+
+func (r *notifyRoute) add(e event) {
+	if r.cfg.Batch <= 0 {
+		r.send([]event{e})
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, e)
+	if !r.scheduled {
+		r.scheduled = true
+		go func() {
+			<-clk.After(r.cfg.Batch)
+			r.flush()
+		}()
+	}
+}
+
+func (r *notifyRoute) flush() {
+	r.mu.Lock()
+	events := r.pending
+	r.pending, r.scheduled = nil, false
+	r.mu.Unlock()
+	if len(events) > 0 {
+		r.send(events)
+	}
+}
+
+// send delivers events, only warning on failure: a notification is never
+// worth failing a move over.
+func (r *notifyRoute) send(events []event) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := r.notifier.Notify(ctx, events); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s notification failed: %v\n", r.cfg.Kind, err)
+	}
+}
+
+// flushNotifications sends any batched events straight away, before exiting.
+func flushNotifications() {
+	for _, r := range notifyRoutes {
+		r.flush()
+	}
+}
+
+// summarize gives a one-line subject and a longer body for events, for
+// notifiers that deliver text.
+func summarize(events []event) (subject, body string) {
+	moved, failed, routed := 0, 0, 0
+	var bytes int64
+	var lines []string
+	for _, e := range events {
+		switch e.Type {
+		case eventMoved:
+			moved++
+			bytes += e.Size
+			lines = append(lines, fmt.Sprintf("%s  moved %s -> %s", e.Time.Format("15:04:05"), e.Name, e.Dest))
+		case eventError:
+			failed++
+			lines = append(lines, fmt.Sprintf("%s  %s failed on %s: %s", e.Time.Format("15:04:05"), e.Command, e.Name, e.Error))
+		case eventRule:
+			routed++
+			lines = append(lines, fmt.Sprintf("%s  rule %s sent %s -> %s", e.Time.Format("15:04:05"), e.Rule, e.Name, e.Dest))
+		}
+	}
+	if len(events) == 1 {
+		e := events[0]
+		switch e.Type {
+		case eventError:
+			return fmt.Sprintf("getnew: %s failed on %s", e.Command, e.Name), lines[0]
+		case eventRule:
+			return fmt.Sprintf("getnew: rule %s sent %s", e.Rule, e.Name), lines[0]
+		}
+		return fmt.Sprintf("getnew: moved %s", e.Name), lines[0]
+	}
+	var parts []string
+	if moved > 0 {
+		parts = append(parts, fmt.Sprintf("%d file(s) moved (%s)", moved, humanSize(bytes)))
+	}
+	if routed > 0 {
+		parts = append(parts, fmt.Sprintf("%d routed by rules", routed))
+	}
+	if failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d error(s)", failed))
+	}
+	return "getnew: " + strings.Join(parts, ", "), strings.Join(lines, "\n")
+}
+
+// commandNotifier runs a command for each notification, with the events as
+// JSON lines on its standard input and the summary in GETNEW_SUBJECT and
+// GETNEW_MESSAGE.
+type commandNotifier struct {
+	Command string `yaml:"command"`
+}
+
+func (n commandNotifier) Notify(ctx context.Context, events []event) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", n.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", n.Command)
+	}
+	var input strings.Builder
+	enc := json.NewEncoder(&input)
+	for _, e := range events {
+		enc.Encode(e)
+	}
+	subject, body := summarize(events)
+	cmd.Stdin = strings.NewReader(input.String())
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "GETNEW_SUBJECT="+subject, "GETNEW_MESSAGE="+body)
+	return cmd.Run()
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var notifyDesktop bool
+
+func init() {
+	registerNotifier("desktop", func(notifierConfig) (notifier, error) {
+		return desktopNotifier{}, nil
+	})
+}
+
+// desktopNotifier pops up a notification with notify-send, osascript or a
+// Windows toast.
+type desktopNotifier struct{}
+
+// windowsToast shows a toast with the built-in WinRT API, reading the text
+// from the environment to avoid quoting it.
+const windowsToast = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$x = $t.GetElementsByTagName('text')
+$x.Item(0).AppendChild($t.CreateTextNode($env:GETNEW_SUBJECT)) > $null
+$x.Item(1).AppendChild($t.CreateTextNode($env:GETNEW_MESSAGE)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('getnew').Show([Windows.UI.Notifications.ToastNotification]::new($t))`
+
+func (desktopNotifier) Notify(ctx context.Context, events []event) error {
+	subject, body := summarize(events)
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(subject))
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	case "windows":
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToast)
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=getnew", subject, body)
+	}
+	cmd.Env = append(os.Environ(), "GETNEW_SUBJECT="+subject, "GETNEW_MESSAGE="+body)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// setupDesktopNotifier adds a desktop notifier for --notify, which watch and
+// daemon turn on by default when there is a desktop to notify, unless the
+// config already has one.
+func setupDesktopNotifier(cmd *cobra.Command) {
+	want := notifyDesktop
+	if (cmd.Name() == "watch" || cmd.Name() == "daemon") && !cmd.Flags().Changed("notify") {
+		want = haveDesktop()
+	}
+	if !want || slices.ContainsFunc(notifyRoutes, func(r *notifyRoute) bool { return r.cfg.Kind == "desktop" }) {
+		return
+	}
+	cfg := notifierConfig{Kind: "desktop"}
+	notifyRoutes = append(notifyRoutes, &notifyRoute{cfg: cfg, notifier: desktopNotifier{}})
+}
+
+func haveDesktop() bool {
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		return true
+	}
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return false
+	}
+	_, err := exec.LookPath("notify-send")
+	return err == nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+func init() {
+	registerNotifier("email", openEmailNotifier)
+}
+
+// emailNotifier sends notifications by SMTP. Subject and Body are
+// text/template templates over notifyData, defaulting to the summary.
+type emailNotifier struct {
+	Host        string   `yaml:"host"`
+	Port        int      `yaml:"port"`
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	PasswordEnv string   `yaml:"password_env"`
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+	// TLS is "starttls" (the default), "tls" for implicit TLS, or "none".
+	TLS     string `yaml:"tls"`
+	Subject string `yaml:"subject"`
+	Body    string `yaml:"body"`
+
+	subject, body *template.Template
+}
+
+// notifyData is what message templates can refer to.
+type notifyData struct {
+	Subject string
+	Summary string
+	Events  []event
+	Moved   int
+	Errors  int
+	Bytes   int64
+}
+
+var templateFuncs = template.FuncMap{"size": humanSize}
+
+func openEmailNotifier(cfg notifierConfig) (notifier, error) {
+	n := &emailNotifier{}
+	if err := cfg.settings.Decode(n); err != nil {
+		return nil, err
+	}
+	if n.Host == "" || n.From == "" || len(n.To) == 0 {
+		return nil, fmt.Errorf("host, from and to are required")
+	}
+	switch n.TLS {
+	case "":
+		n.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unknown tls mode %q (use starttls, tls or none)", n.TLS)
+	}
+	if n.Port == 0 {
+		n.Port = 587
+		if n.TLS == "tls" {
+			n.Port = 465
+		}
+	}
+	if n.PasswordEnv != "" {
+		n.Password = os.Getenv(n.PasswordEnv)
+	}
+	var err error
+	if n.subject, err = template.New("subject").Funcs(templateFuncs).Parse(n.Subject); err != nil {
+		return nil, err
+	}
+	if n.body, err = template.New("body").Funcs(templateFuncs).Parse(n.Body); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *emailNotifier) Notify(ctx context.Context, events []event) error {
+	data := notifyData{Events: events}
+	data.Subject, data.Summary = summarize(events)
+	for _, e := range events {
+		switch e.Type {
+		case eventMoved:
+			data.Moved++
+			data.Bytes += e.Size
+		case eventError:
+			data.Errors++
+		}
+	}
+	subject, body := data.Subject, data.Summary
+	if n.Subject != "" {
+		var b strings.Builder
+		if err := n.subject.Execute(&b, data); err != nil {
+			return fmt.Errorf("failed to render subject: %w", err)
+		}
+		subject = b.String()
+	}
+	if n.Body != "" {
+		var b strings.Builder
+		if err := n.body.Execute(&b, data); err != nil {
+			return fmt.Errorf("failed to render body: %w", err)
+		}
+		body = b.String()
+	}
+
+	return n.send(ctx, n.message(subject, body))
+}
+
+// message builds the mail. File names end up in the subject, so line breaks
+// are taken out of it, lest a name add headers, and it is encoded for
+// anything outside ASCII.
+func (n *emailNotifier) message(subject, body string) []byte {
+	subject = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(subject)
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", clk.Now().Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.NewReplacer("\r\n", "\r\n", "\r", "\r\n", "\n", "\r\n").Replace(body))
+	msg.WriteString("\r\n")
+	return msg.Bytes()
+}
+
+func (n *emailNotifier) send(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	tlsConfig := &tls.Config{ServerName: n.Host}
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if n.TLS == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to talk to %s: %w", addr, err)
+	}
+	defer c.Close()
+	if n.TLS == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS with %s: %w", addr, err)
+		}
+	}
+	if n.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, n.Host)); err != nil {
+			return fmt.Errorf("failed to log in to %s: %w", addr, err)
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	for _, to := range n.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("failed to send mail to %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return c.Quit()
+}
@@ -0,0 +1,272 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerNotifier("mqtt", openMQTTNotifier)
+}
+
+// mqttNotifier publishes each event as JSON to an MQTT broker, speaking just
+// enough of MQTT 3.1.1 to connect, publish and disconnect.
+type mqttNotifier struct {
+	// Broker is mqtt://host[:1883], or mqtts://host[:8883] for TLS.
+	Broker string `yaml:"broker"`
+	// Topic may contain {type} and {rule}, replaced for each event; it is
+	// getnew/{type} by default.
+	Topic       string `yaml:"topic"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	PasswordEnv string `yaml:"password_env"`
+	ClientID    string `yaml:"client_id"`
+	// QoS is 0 (the default) or 1, to wait for the broker to acknowledge
+	// each message.
+	QoS    int  `yaml:"qos"`
+	Retain bool `yaml:"retain"`
+
+	addr string
+	tls  bool
+}
+
+func openMQTTNotifier(cfg notifierConfig) (notifier, error) {
+	n := &mqttNotifier{}
+	if err := cfg.settings.Decode(n); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(n.Broker)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("broker must look like mqtt://host:1883, got %q", n.Broker)
+	}
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl", "tls":
+		n.tls, port = true, "8883"
+	default:
+		return nil, fmt.Errorf("unknown broker scheme %q (use mqtt or mqtts)", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	n.addr = net.JoinHostPort(u.Hostname(), port)
+	if n.QoS != 0 && n.QoS != 1 {
+		return nil, fmt.Errorf("qos must be 0 or 1, got %d", n.QoS)
+	}
+	if n.Topic == "" {
+		n.Topic = "getnew/{type}"
+	}
+	if strings.ContainsAny(n.Topic, "+#") {
+		return nil, fmt.Errorf("topic %q cannot contain the wildcards + or #", n.Topic)
+	}
+	if n.ClientID == "" {
+		host, _ := os.Hostname()
+		n.ClientID = fmt.Sprintf("getnew-%s-%d", host, os.Getpid())
+	}
+	if n.PasswordEnv != "" {
+		n.Password = os.Getenv(n.PasswordEnv)
+	}
+	return n, nil
+}
+
+func (n *mqttNotifier) Notify(ctx context.Context, events []event) error {
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if n.tls {
+		host, _, _ := net.SplitHostPort(n.addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", n.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", n.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", n.addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+	if err := n.connect(conn, r); err != nil {
+		return err
+	}
+	for i, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := n.publish(conn, r, n.topic(e), payload, uint16(i+1)); err != nil {
+			return err
+		}
+	}
+	// DISCONNECT
+	_, err = conn.Write([]byte{0xe0, 0})
+	return err
+}
+
+// topic is the topic for e, with characters MQTT gives meaning to in topic
+// names left out of what replaces the placeholders.
+func (n *mqttNotifier) topic(e event) string {
+	clean := strings.NewReplacer("/", "_", "+", "_", "#", "_")
+	rule := e.Rule
+	if rule == "" {
+		rule = "none"
+	}
+	return strings.NewReplacer("{type}", clean.Replace(e.Type), "{rule}", clean.Replace(rule)).Replace(n.Topic)
+}
+
+// mqttConnectErrors explains the return codes of a refused connection.
+var mqttConnectErrors = map[byte]string{
+	1: "unsupported protocol version",
+	2: "client ID rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+func (n *mqttNotifier) connect(w io.Writer, r *bufio.Reader) error {
+	var body []byte
+	body = mqttString(body, "MQTT")
+	flags := byte(0x02) // clean session
+	if n.Username != "" {
+		flags |= 0x80
+		if n.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, 4, flags, 0, 60) // protocol level 4, 60s keep-alive
+	body = mqttString(body, n.ClientID)
+	if n.Username != "" {
+		body = mqttString(body, n.Username)
+		if n.Password != "" {
+			body = mqttString(body, n.Password)
+		}
+	}
+	if err := writeMQTTPacket(w, 0x10, body); err != nil {
+		return err
+	}
+	kind, ack, err := readMQTTPacket(r)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", n.addr, err)
+	}
+	if kind != 0x20 || len(ack) != 2 {
+		return fmt.Errorf("failed to connect to %s: unexpected reply", n.addr)
+	}
+	if ack[1] != 0 {
+		reason := mqttConnectErrors[ack[1]]
+		if reason == "" {
+			reason = fmt.Sprintf("code %d", ack[1])
+		}
+		return fmt.Errorf("%s refused the connection: %s", n.addr, reason)
+	}
+	return nil
+}
+
+func (n *mqttNotifier) publish(w io.Writer, r *bufio.Reader, topic string, payload []byte, id uint16) error {
+	header := byte(0x30) | byte(n.QoS)<<1
+	if n.Retain {
+		header |= 0x01
+	}
+	body := mqttString(nil, topic)
+	if n.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	if err := writeMQTTPacket(w, header, append(body, payload...)); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	if n.QoS == 0 {
+		return nil
+	}
+	kind, ack, err := readMQTTPacket(r)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	if kind != 0x40 || len(ack) != 2 || binary.BigEndian.Uint16(ack) != id {
+		return fmt.Errorf("failed to publish to %s: unexpected reply", topic)
+	}
+	return nil
+}
+
+// mqttString appends s to b with its length first, as MQTT encodes strings.
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// writeMQTTPacket writes a packet of the given first byte and body.
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	// The remaining length, seven bits at a time
+	for n := len(body); ; {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+// readMQTTPacket reads a packet, returning the packet type from its first
+// byte and its body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("malformed packet")
+		}
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xf0, body, nil
+}
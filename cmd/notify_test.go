@@ -0,0 +1,174 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openNotifier opens a notifier from a config entry written as YAML.
+func openNotifier(t *testing.T, entry string) notifier {
+	t.Helper()
+	var cfg notifierConfig
+	if err := yaml.Unmarshal([]byte(entry), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	n, err := notifierKinds[cfg.Kind](cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+var ruleEvent = event{Type: eventRule, Rule: "papers/2025", Name: "thesis.pdf", Dest: "/papers/thesis.pdf", Size: 42}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got webhookPayload
+	var signature, auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(body)
+		signature = r.Header.Get("X-Getnew-Signature")
+		if signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		json.Unmarshal(body, &got)
+	}))
+	defer ts.Close()
+
+	t.Setenv("HOOK_TOKEN", "abc")
+	n := openNotifier(t, "{kind: webhook, url: '"+ts.URL+"', secret: s3cret, headers: {Authorization: 'Bearer $HOOK_TOKEN'}}")
+	if err := n.Notify(context.Background(), []event{ruleEvent}); err != nil {
+		t.Fatalf("Notify: %v (signature %s)", err, signature)
+	}
+	if len(got.Events) != 1 || got.Events[0].Rule != "papers/2025" || got.Subject != "getnew: rule papers/2025 sent thesis.pdf" {
+		t.Errorf("payload = %+v", got)
+	}
+	if auth != "Bearer abc" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	n = openNotifier(t, "{kind: webhook, url: '"+ts.URL+"', secret: wrong}")
+	if err := n.Notify(context.Background(), []event{ruleEvent}); err == nil {
+		t.Error("Notify succeeded despite a 401")
+	}
+}
+
+func TestMQTTNotifier(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	type message struct {
+		topic   string
+		payload []byte
+	}
+	received := make(chan message, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		if kind, _, err := readMQTTPacket(r); err != nil || kind != 0x10 {
+			return
+		}
+		conn.Write([]byte{0x20, 2, 0, 0})
+		kind, body, err := readMQTTPacket(r)
+		if err != nil || kind != 0x30 {
+			return
+		}
+		n := int(body[0])<<8 | int(body[1])
+		topic, id := string(body[2:2+n]), body[2+n:4+n]
+		conn.Write([]byte{0x40, 2, id[0], id[1]})
+		received <- message{topic, body[4+n:]}
+	}()
+
+	n := openNotifier(t, "{kind: mqtt, broker: 'mqtt://"+ln.Addr().String()+"', topic: 'home/getnew/{rule}', qos: 1, username: me, password: pw}")
+	if err := n.Notify(context.Background(), []event{ruleEvent}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	msg := <-received
+	var e event
+	json.Unmarshal(msg.payload, &e)
+	if msg.topic != "home/getnew/papers_2025" || e.Name != "thesis.pdf" {
+		t.Errorf("published %s: %s", msg.topic, msg.payload)
+	}
+}
+
+// recordingNotifier keeps the events it is sent.
+type recordingNotifier struct{ events *[]event }
+
+func (n recordingNotifier) Notify(_ context.Context, events []event) error {
+	*n.events = append(*n.events, events...)
+	return nil
+}
+
+func TestRuleEventsAreOptIn(t *testing.T) {
+	var all, rules []event
+	notifyRoutes = []*notifyRoute{
+		{notifier: recordingNotifier{&all}},
+		{cfg: notifierConfig{Events: []string{eventRule}}, notifier: recordingNotifier{&rules}},
+	}
+	defer func() { notifyRoutes = nil }()
+	notify(event{Type: eventMoved, Name: "thesis.pdf"})
+	notify(ruleEvent)
+	if len(all) != 1 || all[0].Type != eventMoved || len(rules) != 1 || rules[0].Type != eventRule {
+		t.Errorf("default route got %v, rule route got %v", all, rules)
+	}
+}
+
+func TestEmailSubjectCannotAddHeaders(t *testing.T) {
+	useFakeClock(t)
+	n := &emailNotifier{From: "getnew@example.com", To: []string{"me@example.com"}}
+	msg := string(n.message("getnew: moved x\r\nBcc: evil@example.com\rCc: other@example.com é.pdf", "one\rtwo\n"))
+	headers, body, _ := strings.Cut(msg, "\r\n\r\n")
+	for _, line := range strings.Split(headers, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "Cc:") || strings.ContainsAny(line, "\r\n") {
+			t.Errorf("header line %q was injected", line)
+		}
+	}
+	if !strings.Contains(headers, "\r\nSubject: =?utf-8?q?") {
+		t.Errorf("subject not encoded:\n%s", headers)
+	}
+	if body != "one\r\ntwo\r\n\r\n" {
+		t.Errorf("body = %q", body)
+	}
+}
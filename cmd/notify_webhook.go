@@ -0,0 +1,119 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerNotifier("webhook", openWebhookNotifier)
+}
+
+// webhookNotifier sends the events to a URL as a JSON object holding the
+// summary and the events themselves:
+//
+//	{"subject": "getnew: moved report.pdf", "summary": "...", "events": [...]}
+type webhookNotifier struct {
+	URL string `yaml:"url"`
+	// Method is POST unless set to PUT.
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	// Secret, or the environment variable SecretEnv names, signs each
+	// request: X-Getnew-Signature is "sha256=" and the hex HMAC-SHA256 of
+	// the body.
+	Secret    string `yaml:"secret"`
+	SecretEnv string `yaml:"secret_env"`
+}
+
+// webhookPayload is the body of a webhook request.
+type webhookPayload struct {
+	Subject string  `json:"subject"`
+	Summary string  `json:"summary"`
+	Events  []event `json:"events"`
+}
+
+func openWebhookNotifier(cfg notifierConfig) (notifier, error) {
+	n := &webhookNotifier{}
+	if err := cfg.settings.Decode(n); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(n.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an http or https URL, got %q", n.URL)
+	}
+	switch n.Method = strings.ToUpper(n.Method); n.Method {
+	case "":
+		n.Method = http.MethodPost
+	case http.MethodPost, http.MethodPut:
+	default:
+		return nil, fmt.Errorf("unknown method %q (use POST or PUT)", n.Method)
+	}
+	if n.SecretEnv != "" {
+		n.Secret = os.Getenv(n.SecretEnv)
+	}
+	return n, nil
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, events []event) error {
+	payload := webhookPayload{Events: events}
+	payload.Subject, payload.Summary = summarize(events)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, n.Method, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "getnew")
+	for k, v := range n.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Getnew-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s answered %s: %s", n.URL, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
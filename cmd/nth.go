@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+// nthLast is the end of a --nth range such as 2..4, or 0 when --nth picks
+// a single file.
+var nthLast int
+
+// nthFlag is --nth: N for the Nth newest file, -N for the Nth oldest, or a
+// range of them such as 2..4 or -3..-1, setting nthNewest and nthLast.
+type nthFlag struct{}
+
+func (nthFlag) String() string {
+	if nthLast != 0 {
+		return fmt.Sprintf("%d..%d", nthNewest, nthLast)
+	}
+	return strconv.Itoa(nthNewest)
+}
+
+func (nthFlag) Set(s string) error {
+	first, last, isRange := strings.Cut(s, "..")
+	n, err := strconv.Atoi(first)
+	if err != nil {
+		return fmt.Errorf("want N, -N for the Nth oldest, or a range such as 2..4")
+	}
+	m := 0
+	if isRange {
+		if m, err = strconv.Atoi(last); err != nil || m == 0 {
+			return fmt.Errorf("want N, -N for the Nth oldest, or a range such as 2..4")
+		}
+	}
+	nthNewest, nthLast = n, m
+	return nil
+}
+
+func (nthFlag) Type() string { return "N|-N|A..B" }
+
+// nthLimit is how many of the newest files are needed to find the --nth
+// file or range: all of them when counting from the oldest.
+func nthLimit() int {
+	if nthNewest < 0 || nthLast < 0 {
+		return 0
+	}
+	return max(nthNewest, nthLast)
+}
+
+// nthNeeded is how many files there must be for --nth to select anything.
+func nthNeeded() int {
+	return max(abs(nthNewest), abs(nthLast))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// moveNthRange moves each file of a --nth range, carrying on past failures
+// as --all does.
+func moveNthRange(ctx context.Context) error {
+	files, err := newestSettled(ctx, nthLimit())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		_, err := selectNthNewest(files, 1, fileFilter)
+		return err
+	}
+	picked, err := getnew.NthRange(files, nthNewest, nthLast)
+	if err != nil {
+		return err
+	}
+	return moveFiles(ctx, picked)
+}
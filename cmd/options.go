@@ -0,0 +1,409 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// invocation is what was asked for on the command line: the command, which
+// flags were given explicitly, and the values that need checking.
+type invocation struct {
+	command     string
+	set         map[string]bool
+	nth         int
+	nthLast     int
+	count       int
+	settle      time.Duration
+	wait        time.Duration
+	sources     []string
+	dest        string
+	webMark     string
+	dedupe      string
+	signatures  string
+	gitIgnored  string
+	depth       int
+	jobs        int
+	browser     string
+	screenshots bool
+	importFrom  string
+	meta        []string
+	contains    string
+	containsRE  string
+	newerThan   string
+	olderThan   string
+	minSize     string
+	maxSize     string
+	fileType    string
+	types       []string
+	verifyAbove string
+	coverage    float64
+	color       string
+	getenv      func(string) string
+}
+
+func newInvocation(cmd *cobra.Command) invocation {
+	inv := invocation{
+		command:     cmd.Name(),
+		set:         make(map[string]bool),
+		nth:         nthNewest,
+		nthLast:     nthLast,
+		count:       moveCount,
+		settle:      settlePeriod,
+		wait:        waitTimeout,
+		sources:     sourceDirs,
+		dest:        destDir,
+		webMark:     webMarkMode,
+		dedupe:      dedupeMode,
+		signatures:  signatureMode,
+		gitIgnored:  gitIgnored,
+		depth:       unarchiveDepth,
+		jobs:        moveJobs,
+		browser:     browserName,
+		screenshots: screenshotsOnly,
+		importFrom:  importFrom,
+		meta:        metaSpecs,
+		contains:    containsText,
+		containsRE:  containsRegex,
+		newerThan:   newerThanSpec,
+		olderThan:   olderThanSpec,
+		minSize:     minSizeSpec,
+		maxSize:     maxSizeSpec,
+		fileType:    fileType,
+		verifyAbove: verifyAbove,
+		coverage:    verifyCoverage,
+		color:       colorMode,
+		getenv:      os.Getenv,
+	}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		inv.set[f.Name] = true
+	})
+	for _, kind := range getnew.FileTypes {
+		if on, err := cmd.Flags().GetBool(kind); err == nil && on {
+			inv.types = append(inv.types, kind)
+		}
+	}
+	return inv
+}
+
+// flagConflict is a pair of flags that cannot be used together. An empty
+// command means the pair conflicts everywhere.
+type flagConflict struct {
+	command string
+	a, b    string
+	reason  string
+}
+
+var flagConflicts = []flagConflict{
+	{"checkout", "list", "clear", "--list only shows the cart"},
+	{"get", "list", "nth", "--list shows every tagged file"},
+	{"list", "long", "print0", "--print0 prints bare paths"},
+	{"list", "no-color", "color", "--no-color is --color=never"},
+	{"getnew", "all", "nth", "--all moves every matching file"},
+	{"getnew", "count", "nth", "--count moves the newest files"},
+	{"getnew", "files-from", "all", "--files-from moves the listed files"},
+	{"getnew", "files-from", "count", "--files-from moves the listed files"},
+	{"getnew", "files-from", "nth", "--files-from moves the listed files"},
+	{"getnew", "files-from", "stdout", "--files-from moves the listed files"},
+	{"getnew", "stdout", "all", "--stdout writes a single file"},
+	{"getnew", "stdout", "count", "--stdout writes a single file"},
+	{"getnew", "stdout", "jobs", "--stdout writes a single file"},
+	{"getnew", "stdout", "dest", "--stdout writes to stdout"},
+	{"getnew", "stdout", "unarchive", "--stdout does not keep a copy to unarchive"},
+	{"getnew", "stdout", "extract-only", "--stdout does not keep a copy to unarchive"},
+	{"getnew", "stdout", "test-archive", "--stdout does not keep a copy to test"},
+	{"getnew", "stdout", "git-add", "--stdout does not keep a copy to add"},
+	{"getnew", "stdout", "keep-archive", "--stdout does not keep a copy to unarchive"},
+	{"getnew", "stdout", "extract-from-source", "--stdout does not keep a copy to unarchive"},
+	{"getnew", "stdout", "dirs", "--stdout writes a single file"},
+	{"getnew", "stdout", "tar-dirs", "--stdout writes a single file"},
+	{"getnew", "stdout", "open", "--stdout does not keep a copy to open"},
+	{"getnew", "stdout", "print-path", "--stdout does not keep a copy"},
+	{"getnew", "stdout", "print0", "--stdout writes the contents, not a path"},
+	{"sort-all", "unarchive", "", "sort-all does not unarchive"},
+	{"sort-all", "extract-only", "", "sort-all does not unarchive"},
+	{"sort-all", "unarchive-depth", "", "sort-all does not unarchive"},
+	{"sort-all", "test-archive", "", "sort-all does not unarchive"},
+	{"sort-all", "keep-archive", "", "sort-all does not unarchive"},
+	{"sort-all", "extract-from-source", "", "sort-all does not unarchive"},
+	{"sort-all", "no-rules", "", "sort-all only moves files that rules match"},
+	{"", "follow-symlinks", "preserve-symlinks", "a link is either followed or kept"},
+	{"", "contains", "contains-regex", "give the text or a regular expression"},
+	{"", "browser", "source", "--browser reads the browser's downloads wherever they were saved"},
+	{"", "rule", "no-rules", "--rule uses a rule"},
+	{"", "rule", "dest", "--rule sets the destination"},
+}
+
+// sourceChosen reports whether the command line picked the source, which then
+// beats any source from the config file.
+func sourceChosen(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("source") || cmd.Flags().Changed("browser") || screenshotsOnly || importing
+}
+
+// resolvedOptions are the settings worked out from an invocation.
+type resolvedOptions struct {
+	sources     []string
+	dest        string
+	sampleAbove int64
+	newerThan   time.Time
+	olderThan   time.Time
+	minSize     int64
+	maxSize     int64
+	fileType    string
+	meta        map[string]string
+	content     *regexp.Regexp
+}
+
+// resolveOptions rejects invalid values and flag combinations, then settles
+// the source directory. Precedence for the source is --source, then
+// GETNEW_SOURCE_DIR, then the Downloads folder. Explicit flags and arguments always
+// beat defaults from the config file, which are applied afterwards.
+func resolveOptions(inv invocation) (resolvedOptions, error) {
+	for _, c := range flagConflicts {
+		if c.command != "" && c.command != inv.command {
+			continue
+		}
+		if !inv.set[c.a] || (c.b != "" && !inv.set[c.b]) {
+			continue
+		}
+		if c.b == "" {
+			return resolvedOptions{}, fmt.Errorf("--%s cannot be used here: %s", c.a, c.reason)
+		}
+		return resolvedOptions{}, fmt.Errorf("--%s and --%s cannot be used together: %s", c.a, c.b, c.reason)
+	}
+
+	if inv.count < 0 {
+		return resolvedOptions{}, fmt.Errorf("--count cannot be negative")
+	}
+	if inv.nth == 0 {
+		return resolvedOptions{}, fmt.Errorf("--nth cannot be 0: the newest is 1 and the oldest -1")
+	}
+	if inv.nthLast != 0 && inv.set["stdout"] {
+		return resolvedOptions{}, fmt.Errorf("--stdout writes a single file, not a range")
+	}
+	if inv.set["consume"] && !inv.set["stdout"] {
+		return resolvedOptions{}, fmt.Errorf("--consume only removes files written with --stdout")
+	}
+	if inv.settle < 0 {
+		return resolvedOptions{}, fmt.Errorf("--settle cannot be negative")
+	}
+	if inv.wait < 0 {
+		return resolvedOptions{}, fmt.Errorf("--wait cannot be negative")
+	}
+	if inv.color != "" && inv.color != "auto" && inv.color != "always" && inv.color != "never" {
+		return resolvedOptions{}, fmt.Errorf("--color must be auto, always or never, got %q", inv.color)
+	}
+	if inv.dedupe != "" && inv.dedupe != "skip" && inv.dedupe != "remove" {
+		return resolvedOptions{}, fmt.Errorf("--dedupe must be skip or remove, got %q", inv.dedupe)
+	}
+	if inv.jobs < 1 {
+		return resolvedOptions{}, fmt.Errorf("--jobs must be 1 or more, got %d", inv.jobs)
+	}
+	if inv.depth < 1 {
+		return resolvedOptions{}, fmt.Errorf("--unarchive-depth must be 1 or more, got %d", inv.depth)
+	}
+	if inv.signatures != "" && inv.signatures != "refuse" && inv.signatures != "warn" {
+		return resolvedOptions{}, fmt.Errorf("--verify-signature must be refuse or warn, got %q", inv.signatures)
+	}
+	if inv.gitIgnored != "" && inv.gitIgnored != "warn" && inv.gitIgnored != "refuse" && inv.gitIgnored != "add" {
+		return resolvedOptions{}, fmt.Errorf("--git-ignored must be warn, refuse or add, got %q", inv.gitIgnored)
+	}
+	if inv.webMark != "" && inv.webMark != "keep" && inv.webMark != "strip" && inv.webMark != "unquarantine" {
+		return resolvedOptions{}, fmt.Errorf("--web-mark must be keep, strip or unquarantine, got %q", inv.webMark)
+	}
+	if inv.coverage < 0 || inv.coverage > 100 {
+		return resolvedOptions{}, fmt.Errorf("--verify-coverage must be between 0 and 100, got %v", inv.coverage)
+	}
+	var sampleAbove int64
+	if inv.verifyAbove != "" {
+		var err error
+		if sampleAbove, err = parseSize(inv.verifyAbove); err != nil {
+			return resolvedOptions{}, fmt.Errorf("--verify-sample-above: %w", err)
+		}
+	}
+
+	sources := inv.sources
+	if !inv.set["source"] || len(sources) == 0 {
+		sources = splitSourceList(inv.getenv("GETNEW_SOURCE_DIR"))
+		if len(sources) == 0 {
+			sources = []string{defaultDownloadsDir(inv.getenv)} // Default to ~/Downloads if not set
+		}
+	}
+	if inv.screenshots && !inv.set["source"] && inv.browser == "" {
+		if sources = screenshotDirs(inv.getenv); len(sources) == 0 {
+			return resolvedOptions{}, fmt.Errorf("no screenshot folder found; give it with --source")
+		}
+	}
+	if inv.command == "import" {
+		switch {
+		case inv.importFrom != "" && inv.set["source"]:
+			return resolvedOptions{}, fmt.Errorf("--from and --source cannot be used together: --from is the source")
+		case inv.importFrom != "":
+			var err error
+			if sources, err = importSources(inv.importFrom); err != nil {
+				return resolvedOptions{}, err
+			}
+		case !inv.set["source"]:
+			return resolvedOptions{}, fmt.Errorf("import needs the folder to import from: give it with --from")
+		}
+	}
+	if inv.browser != "" {
+		switch inv.browser = strings.ToLower(inv.browser); inv.browser {
+		case "chrome", "firefox", "safari":
+			sources = []string{"browser://" + inv.browser}
+		default:
+			return resolvedOptions{}, fmt.Errorf("--browser must be chrome, firefox or safari, got %q", inv.browser)
+		}
+	}
+	sources = uniqueStrings(sources)
+	for _, source := range sources {
+		if inv.set["wait"] && strings.Contains(source, "://") {
+			return resolvedOptions{}, fmt.Errorf("--wait needs a local source directory, not %s", source)
+		}
+	}
+	dest := inv.dest
+	if c, ok := splitContainerDest(dest); ok && (c.container == "" || c.path == "") {
+		return resolvedOptions{}, fmt.Errorf("a container destination is docker://CONTAINER:/PATH or k8s://NAMESPACE/POD:/PATH, got %s", dest)
+	}
+	if isRemoteDest(dest) {
+		if inv.set["unarchive"] {
+			return resolvedOptions{}, fmt.Errorf("--unarchive cannot be used with a remote destination")
+		}
+		if inv.set["extract-only"] {
+			return resolvedOptions{}, fmt.Errorf("--extract-only cannot be used with a remote destination")
+		}
+		if inv.set["test-archive"] {
+			return resolvedOptions{}, fmt.Errorf("--test-archive cannot be used with a remote destination")
+		}
+		if inv.set["extract-from-source"] {
+			return resolvedOptions{}, fmt.Errorf("--extract-from-source cannot be used with a remote destination")
+		}
+		if inv.set["git-add"] {
+			return resolvedOptions{}, fmt.Errorf("--git-add cannot be used with a remote destination")
+		}
+		if inv.set["dirs"] || inv.set["tar-dirs"] {
+			return resolvedOptions{}, fmt.Errorf("directories cannot be sent to a remote destination")
+		}
+		if inv.set["open"] {
+			return resolvedOptions{}, fmt.Errorf("--open cannot be used with a remote destination")
+		}
+		if inv.command == "checkout" {
+			return resolvedOptions{}, fmt.Errorf("checkout needs a local destination")
+		}
+	}
+	if host, remotePath, ok := splitRemoteDest(dest); ok && remotePath == "" {
+		dest = host + ":." // the remote home directory, as scp reads it
+	}
+
+	resolved := resolvedOptions{sources: sources, dest: dest, sampleAbove: sampleAbove}
+	if inv.newerThan != "" {
+		t, err := parseTimeSpec(inv.newerThan, clk.Now())
+		if err != nil {
+			return resolvedOptions{}, fmt.Errorf("--newer-than: %w", err)
+		}
+		resolved.newerThan = t
+	}
+	if inv.olderThan != "" {
+		t, err := parseTimeSpec(inv.olderThan, clk.Now())
+		if err != nil {
+			return resolvedOptions{}, fmt.Errorf("--older-than: %w", err)
+		}
+		resolved.olderThan = t
+	}
+	if inv.fileType != "" && !slices.Contains(getnew.FileTypes, inv.fileType) {
+		return resolvedOptions{}, fmt.Errorf("--type must be one of %s, got %q", strings.Join(getnew.FileTypes, ", "), inv.fileType)
+	}
+	resolved.fileType = inv.fileType
+	if inv.contains != "" {
+		resolved.content = regexp.MustCompile("(?i)" + regexp.QuoteMeta(inv.contains))
+	}
+	if inv.containsRE != "" {
+		re, err := regexp.Compile(inv.containsRE)
+		if err != nil {
+			return resolvedOptions{}, fmt.Errorf("--contains-regex: %w", err)
+		}
+		resolved.content = re
+	}
+	for _, spec := range inv.meta {
+		field, pattern, ok := strings.Cut(spec, "=")
+		if !ok || !slices.Contains(getnew.MetadataFields, field) {
+			return resolvedOptions{}, fmt.Errorf("--meta must be FIELD=PATTERN with a field of %s, got %q", strings.Join(getnew.MetadataFields, ", "), spec)
+		}
+		if resolved.meta == nil {
+			resolved.meta = map[string]string{}
+		}
+		resolved.meta[field] = pattern
+	}
+	for _, kind := range inv.types {
+		if resolved.fileType == "" {
+			resolved.fileType = kind
+			continue
+		}
+		if inv.fileType != "" {
+			return resolvedOptions{}, fmt.Errorf("--%s and --type cannot be used together: --%s is short for --type %s", kind, kind, kind)
+		}
+		return resolvedOptions{}, fmt.Errorf("--%s and --%s cannot be used together: a file is only one kind", resolved.fileType, kind)
+	}
+	if inv.minSize != "" {
+		size, err := parseSize(inv.minSize)
+		if err != nil {
+			return resolvedOptions{}, fmt.Errorf("--min-size: %w", err)
+		}
+		resolved.minSize = size
+	}
+	if inv.maxSize != "" {
+		size, err := parseSize(inv.maxSize)
+		if err != nil {
+			return resolvedOptions{}, fmt.Errorf("--max-size: %w", err)
+		}
+		resolved.maxSize = size
+	}
+	if resolved.maxSize > 0 && resolved.minSize > resolved.maxSize {
+		return resolvedOptions{}, fmt.Errorf("--min-size %s is larger than --max-size %s", inv.minSize, inv.maxSize)
+	}
+	if !resolved.newerThan.IsZero() && !resolved.olderThan.IsZero() && !resolved.newerThan.Before(resolved.olderThan) {
+		return resolvedOptions{}, fmt.Errorf("--newer-than %s and --older-than %s leave no time in between", inv.newerThan, inv.olderThan)
+	}
+	return resolved, nil
+}
+
+// uniqueStrings drops repeats, keeping the first of each.
+func uniqueStrings(list []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testInvocation(command string, flags ...string) invocation {
+	inv := invocation{
+		command: command,
+		set:     make(map[string]bool),
+		nth:     1,
+		depth:   1,
+		jobs:    1,
+		settle:  2 * time.Second,
+		dest:    ".",
+		getenv: func(key string) string {
+			if key == "HOME" {
+				return "/home/test"
+			}
+			return ""
+		},
+	}
+	for _, flag := range flags {
+		inv.set[flag] = true
+	}
+	return inv
+}
+
+func TestResolveOptionsConflicts(t *testing.T) {
+	tests := []struct {
+		command string
+		flags   []string
+		wantErr string
+	}{
+		{"getnew", nil, ""},
+		{"getnew", []string{"nth", "unarchive", "dest", "settle"}, ""},
+		{"checkout", []string{"list"}, ""},
+		{"checkout", []string{"clear"}, ""},
+		{"checkout", []string{"list", "clear"}, "--list and --clear cannot be used together"},
+		{"get", []string{"tag", "nth"}, ""},
+		{"get", []string{"tag", "list"}, ""},
+		{"get", []string{"tag", "list", "nth"}, "--list and --nth cannot be used together"},
+		{"sort-all", []string{"dry-run"}, ""},
+		{"sort-all", []string{"unarchive"}, "--unarchive cannot be used here"},
+		{"watch", []string{"unarchive"}, ""},
+		{"watch", []string{"rule", "dest"}, "--rule and --dest cannot be used together"},
+		{"sort-all", []string{"rule", "dry-run"}, ""},
+	}
+	for _, tt := range tests {
+		_, err := resolveOptions(testInvocation(tt.command, tt.flags...))
+		checkErr(t, tt.command+" "+strings.Join(tt.flags, " "), err, tt.wantErr)
+	}
+}
+
+// Every pair of conflicting flags is rejected, and each flag alone is fine
+// unless it is unsupported by the command outright.
+func TestResolveOptionsEveryConflict(t *testing.T) {
+	for _, c := range flagConflicts {
+		if c.b == "" {
+			_, err := resolveOptions(testInvocation(c.command, c.a))
+			checkErr(t, c.command+" --"+c.a, err, "--"+c.a+" cannot be used here")
+			continue
+		}
+		_, err := resolveOptions(testInvocation(c.command, c.a, c.b))
+		checkErr(t, c.command+" --"+c.a+" --"+c.b, err, "cannot be used together")
+		for _, flag := range []string{c.a, c.b} {
+			_, err := resolveOptions(testInvocation(c.command, flag))
+			checkErr(t, c.command+" --"+flag, err, "")
+		}
+	}
+}
+
+func TestResolveOptionsValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*invocation)
+		wantErr string
+	}{
+		{"defaults", func(inv *invocation) {}, ""},
+		{"nth zero", func(inv *invocation) { inv.nth = 0 }, "--nth cannot be 0"},
+		{"nth negative", func(inv *invocation) { inv.nth = -3 }, ""},
+		{"nth range", func(inv *invocation) { inv.nth, inv.nthLast = 2, 4 }, ""},
+		{"nth range to stdout", func(inv *invocation) {
+			inv.nth, inv.nthLast = 2, 4
+			inv.set["stdout"] = true
+		}, "--stdout writes a single file"},
+		{"consume without stdout", func(inv *invocation) { inv.set["consume"] = true }, "--consume only removes files written with --stdout"},
+		{"color always", func(inv *invocation) { inv.color = "always" }, ""},
+		{"color unknown", func(inv *invocation) { inv.color = "yes" }, "--color must be auto, always or never"},
+		{"git ignored unknown", func(inv *invocation) { inv.gitIgnored = "skip" }, "--git-ignored must be warn, refuse or add"},
+		{"settle negative", func(inv *invocation) { inv.settle = -time.Second }, "--settle cannot be negative"},
+		{"settle zero", func(inv *invocation) { inv.settle = 0 }, ""},
+		{"wait negative", func(inv *invocation) { inv.set["wait"] = true; inv.wait = -time.Second }, "--wait cannot be negative"},
+		{"wait local", func(inv *invocation) { inv.set["wait"] = true }, ""},
+		{"remote dest", func(inv *invocation) { inv.dest = "user@host:/srv/in" }, ""},
+		{"remote dest unarchive", func(inv *invocation) {
+			inv.dest = "user@host:/srv/in"
+			inv.set["unarchive"] = true
+		}, "--unarchive cannot be used with a remote destination"},
+		{"container dest", func(inv *invocation) { inv.dest = "docker://dev:/srv/in" }, ""},
+		{"container dest without path", func(inv *invocation) { inv.dest = "docker://dev" }, "docker://CONTAINER:/PATH"},
+		{"pod dest", func(inv *invocation) { inv.dest = "k8s://web/api-0:/srv/in" }, ""},
+		{"pod dest without path", func(inv *invocation) { inv.dest = "k8s://web/api-0" }, "k8s://NAMESPACE/POD:/PATH"},
+		{"container dest unarchive", func(inv *invocation) {
+			inv.dest = "docker://dev:/srv/in"
+			inv.set["unarchive"] = true
+		}, "--unarchive cannot be used with a remote destination"},
+		{"remote dest checkout", func(inv *invocation) {
+			inv.command = "checkout"
+			inv.dest = "host:in"
+		}, "checkout needs a local destination"},
+		{"wait remote", func(inv *invocation) {
+			inv.set["wait"], inv.set["source"] = true, true
+			inv.sources = []string{"sftp://host/out"}
+		}, "--wait needs a local source directory"},
+		{"time window", func(inv *invocation) { inv.newerThan, inv.olderThan = "2d", "1h" }, ""},
+		{"empty time window", func(inv *invocation) { inv.newerThan, inv.olderThan = "1h", "2d" }, "leave no time in between"},
+		{"bad time", func(inv *invocation) { inv.newerThan = "lately" }, "--newer-than: invalid time"},
+		{"size range", func(inv *invocation) { inv.minSize, inv.maxSize = "10M", "1.5G" }, ""},
+		{"empty size range", func(inv *invocation) { inv.minSize, inv.maxSize = "2G", "1G" }, "is larger than --max-size"},
+		{"bad size", func(inv *invocation) { inv.maxSize = "huge" }, "--max-size: invalid size"},
+		{"metadata", func(inv *invocation) { inv.meta = []string{"exif.model=*R5*"} }, ""},
+		{"unknown metadata", func(inv *invocation) { inv.meta = []string{"exif.iso=100"} }, "--meta must be FIELD=PATTERN"},
+		{"contains regex", func(inv *invocation) { inv.containsRE = `invoice #\d+` }, ""},
+		{"bad contains regex", func(inv *invocation) { inv.containsRE = "invoice (" }, "--contains-regex: error parsing"},
+		{"type shorthand", func(inv *invocation) { inv.types = []string{"pdf"} }, ""},
+		{"two type shorthands", func(inv *invocation) { inv.types = []string{"pdf", "image"} }, "--pdf and --image cannot be used together"},
+		{"type and shorthand", func(inv *invocation) { inv.fileType, inv.types = "video", []string{"pdf"} }, "--pdf and --type cannot be used together"},
+	}
+	for _, tt := range tests {
+		inv := testInvocation("getnew")
+		tt.modify(&inv)
+		_, err := resolveOptions(inv)
+		checkErr(t, tt.name, err, tt.wantErr)
+	}
+}
+
+func TestResolveOptionsSourcePrecedence(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		env   string
+		want  []string
+	}{
+		{"default", nil, "", []string{"/home/test/Downloads"}},
+		{"environment", nil, "/env/dir", []string{"/env/dir"}},
+		{"environment list", nil, "/a:/b:/c", []string{"/a", "/b", "/c"}},
+		{"environment urls", nil, "/a:sftp://me@host:2222/out:https://example.com/rel/", []string{"/a", "sftp://me@host:2222/out", "https://example.com/rel/"}},
+		{"flag beats environment", []string{"/flag/dir"}, "/env/dir", []string{"/flag/dir"}},
+		{"flag alone", []string{"/flag/dir"}, "", []string{"/flag/dir"}},
+		{"repeated flag", []string{"/x", "/y"}, "/env/dir", []string{"/x", "/y"}},
+	}
+	for _, tt := range tests {
+		inv := testInvocation("getnew")
+		if tt.flags != nil {
+			inv.set["source"] = true
+			inv.sources = tt.flags
+		}
+		getenv := inv.getenv
+		inv.getenv = func(key string) string {
+			if key == "GETNEW_SOURCE_DIR" {
+				return tt.env
+			}
+			return getenv(key)
+		}
+		opts, err := resolveOptions(inv)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if strings.Join(opts.sources, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: sources = %q, want %q", tt.name, opts.sources, tt.want)
+		}
+	}
+}
+
+func checkErr(t *testing.T, name string, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Errorf("%s: unexpected error: %v", name, err)
+	case want != "" && err == nil:
+		t.Errorf("%s: expected error containing %q", name, want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Errorf("%s: error %q does not contain %q", name, err, want)
+	}
+}
+
+func TestSplitContainerDest(t *testing.T) {
+	tests := []struct {
+		path string
+		want containerDest
+		ok   bool
+	}{
+		{"docker://dev:/srv/in", containerDest{"docker", "dev", "/srv/in"}, true},
+		{"docker:/dev:/srv/in/a.csv", containerDest{"docker", "dev", "/srv/in/a.csv"}, true},
+		{"k8s://web/api-0:/srv/in", containerDest{"k8s", "web/api-0", "/srv/in"}, true},
+		{"k8s://api-0:data", containerDest{"k8s", "api-0", "data"}, true},
+		{"docker://dev", containerDest{"docker", "dev", ""}, true},
+		{"user@host:/srv/in", containerDest{}, false},
+		{"./docker", containerDest{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := splitContainerDest(tt.path)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("splitContainerDest(%q) = %+v, %v", tt.path, got, ok)
+		}
+	}
+}
+
+func TestSplitRemoteDest(t *testing.T) {
+	tests := []struct {
+		path      string
+		host, dir string
+		remote    bool
+	}{
+		{"user@host:/srv/in", "user@host", "/srv/in", true},
+		{"host:in", "host", "in", true},
+		{"host:", "host", "", true},
+		{"./assets", "", "", false},
+		{"/tmp/a:b", "", "", false},
+		{`C:\Users\me`, "", "", false},
+		{"ssh://host/path", "", "", false},
+		{"docker://dev:/srv/in", "", "", false},
+		{"docker:/dev:/srv/in/a.csv", "", "", false},
+		{"k8s://web/api-0:/srv/in", "", "", false},
+	}
+	for _, tt := range tests {
+		host, dir, ok := splitRemoteDest(tt.path)
+		if ok != tt.remote || host != tt.host || dir != tt.dir {
+			t.Errorf("splitRemoteDest(%q) = %q, %q, %v", tt.path, host, dir, ok)
+		}
+	}
+}
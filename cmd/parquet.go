@@ -0,0 +1,218 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// A minimal Parquet writer: one row group, required columns, PLAIN encoding and
+// no compression. That is all a history report needs and saves pulling in a
+// full Parquet implementation.
+
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+type parquetColumn struct {
+	name      string
+	kind      int32
+	converted int32 // -1 for none
+	int64s    []int64
+	strings   []string
+}
+
+func writeParquet(w io.Writer, columns []parquetColumn, numRows int) error {
+	var out bytes.Buffer
+	out.WriteString("PAR1")
+
+	type chunk struct {
+		offset int64
+		size   int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, col := range columns {
+		var values bytes.Buffer
+		if col.kind == parquetInt64 {
+			binary.Write(&values, binary.LittleEndian, col.int64s)
+		} else {
+			for _, s := range col.strings {
+				binary.Write(&values, binary.LittleEndian, uint32(len(s)))
+				values.WriteString(s)
+			}
+		}
+
+		var header thriftWriter
+		header.beginStruct()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(values.Len()))
+		header.i32(3, int32(values.Len()))
+		header.structField(5)
+		header.i32(1, int32(numRows))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE levels, though required columns have none
+		header.i32(4, 3)
+		header.endStruct()
+		header.endStruct()
+
+		chunks[i].offset = int64(out.Len())
+		out.Write(header.Bytes())
+		out.Write(values.Bytes())
+		chunks[i].size = int64(out.Len()) - chunks[i].offset
+	}
+
+	var meta thriftWriter
+	meta.beginStruct()
+	meta.i32(1, 1)
+	meta.listField(2, thriftStruct, len(columns)+1)
+	meta.beginStruct()
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endStruct()
+	for _, col := range columns {
+		meta.beginStruct()
+		meta.i32(1, col.kind)
+		meta.i32(3, 0) // REQUIRED
+		meta.str(4, col.name)
+		if col.converted >= 0 {
+			meta.i32(6, col.converted)
+		}
+		meta.endStruct()
+	}
+	meta.i64(3, int64(numRows))
+
+	meta.listField(4, thriftStruct, 1)
+	meta.beginStruct()
+	meta.listField(1, thriftStruct, len(columns))
+	var total int64
+	for i, col := range columns {
+		meta.beginStruct()
+		meta.i64(2, chunks[i].offset)
+		meta.structField(3)
+		meta.i32(1, col.kind)
+		meta.listField(2, thriftI32, 1)
+		meta.varint(0) // PLAIN
+		meta.listField(3, thriftBinary, 1)
+		meta.varint(uint64(len(col.name)))
+		meta.WriteString(col.name)
+		meta.i32(4, 0) // UNCOMPRESSED
+		meta.i64(5, int64(numRows))
+		meta.i64(6, chunks[i].size)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.endStruct()
+		meta.endStruct()
+		total += chunks[i].size
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(numRows))
+	meta.endStruct()
+
+	meta.str(6, "getnew")
+	meta.endStruct()
+
+	out.Write(meta.Bytes())
+	binary.Write(&out, binary.LittleEndian, uint32(meta.Len()))
+	out.WriteString("PAR1")
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter emits the Thrift compact protocol used by Parquet metadata.
+// Field ids are delta-encoded per struct, so the last id is saved on entering
+// a nested struct and restored on leaving it.
+type thriftWriter struct {
+	bytes.Buffer
+	saved []int16
+	last  int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.WriteByte(kind)
+		t.varint(uint64((int64(id) << 1) ^ (int64(id) >> 63)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(uint64((int64(v) << 1) ^ (int64(v) >> 63)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) listField(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.WriteByte(0xF0 | elem)
+		t.varint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.beginStruct()
+}
+
+func (t *thriftWriter) beginStruct() {
+	t.saved = append(t.saved, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.WriteByte(0)
+	t.last = t.saved[len(t.saved)-1]
+	t.saved = t.saved[:len(t.saved)-1]
+}
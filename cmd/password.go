@@ -0,0 +1,81 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var passwordFlag string
+
+// passwordMu stops parallel moves from prompting for passwords at once.
+var passwordMu sync.Mutex
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&passwordFlag, "password", "", "Password for encrypted archives; it is passed to the extracting tool on its command line, where other local users can see it")
+}
+
+// archivePassword finds the password for an encrypted archive: --password,
+// then $GETNEW_ARCHIVE_PASSWORD, then the password hook, then by asking if
+// there is someone at the terminal. "" means there is none.
+func archivePassword(path string) (string, error) {
+	if passwordFlag != "" {
+		return passwordFlag, nil
+	}
+	if pw := os.Getenv("GETNEW_ARCHIVE_PASSWORD"); pw != "" {
+		return pw, nil
+	}
+	if appConfig.Hooks.Password != "" {
+		cmd := hookCommand(context.Background(), appConfig.Hooks.Password, path)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("password hook failed for %s: %w", filepath.Base(path), err)
+		}
+		pw, _, _ := strings.Cut(string(out), "\n")
+		return strings.TrimSuffix(pw, "\r"), nil
+	}
+	if !stdinIsTerminal() {
+		return "", nil
+	}
+	passwordMu.Lock()
+	defer passwordMu.Unlock()
+	fmt.Fprintf(os.Stderr, "Password for %s: ", filepath.Base(path))
+	pw, err := readPassword()
+	fmt.Fprintln(os.Stderr)
+	return pw, err
+}
+
+// readLine reads a line from stdin without its line ending.
+func readLine() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var peekLines int
+
+var peekCmd = &cobra.Command{
+	Use:   "peek [filter]",
+	Short: "Show what the file getnew would move is, without moving it",
+	Long: `peek describes the file getnew [filter] would move: its size and age, then
+the first lines of a text file, the dimensions of an image, the page count of
+a PDF, the tags of an MP3 or what is inside an archive. Use --nth to look at
+another file.
+
+Images are drawn in terminals that can show them: kitty, Ghostty, WezTerm,
+iTerm2 and those that speak sixel, such as foot. Set GETNEW_GRAPHICS to kitty,
+iterm or sixel for others that do, or to none to turn it off.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if err := peek(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(peekCmd)
+	peekCmd.Flags().IntVarP(&nthNewest, "nth", "n", 1, "Nth newest file to show (-1 for the oldest)")
+	peekCmd.Flags().IntVar(&peekLines, "lines", 10, "How many lines of a text file, or entries of an archive, to show")
+}
+
+func peek(ctx context.Context) error {
+	files, err := newestCandidates(ctx, nthLimit())
+	if err != nil {
+		return err
+	}
+	file, err := selectNthNewest(files, nthNewest, fileFilter)
+	if err != nil {
+		return err
+	}
+
+	kind := getnew.TypeOf(file)
+	name := file.Name()
+	if colorOn(os.Stdout) {
+		name = colorName(name, kind)
+	}
+	fmt.Println(name)
+	if len(sources) > 1 {
+		fmt.Printf("  in %s\n", file.Source.Location(""))
+	}
+	age := "just now"
+	if d := clk.Now().Sub(file.ModTime()); d >= time.Minute {
+		age = shortAge(d) + " ago"
+	}
+	if kind == "" {
+		kind = "file"
+	}
+	fmt.Printf("  %s %s, modified %s (%s)\n", humanSize(file.Size()), kind, file.ModTime().Format("2006-01-02 15:04"), age)
+
+	switch kind {
+	case "text":
+		return peekText(file)
+	case "image":
+		if w, h, ok := getnew.ImageSize(file); ok {
+			fmt.Printf("  %dx%d pixels\n", w, h)
+		}
+		protocol, err := graphicsProtocol()
+		if err != nil {
+			return err
+		}
+		if protocol != "" {
+			fmt.Println()
+			if err := showThumbnail(os.Stdout, protocol, file, 48, 16); err != nil {
+				return err
+			}
+		}
+	case "pdf":
+		if pages, ok := getnew.PDFPages(file); ok {
+			fmt.Printf("  %d page(s)\n", pages)
+		}
+	case "archive":
+		return peekArchiveEntries(ctx, file)
+	}
+	printMetadata(file)
+	return nil
+}
+
+// printMetadata prints the titles and tags a file records about itself.
+func printMetadata(file candidate) {
+	meta := getnew.Metadata(file)
+	for _, field := range getnew.MetadataFields {
+		if meta[field] != "" {
+			fmt.Printf("  %-12s %s\n", field, meta[field])
+		}
+	}
+}
+
+// peekText prints the first lines of a text file, indented.
+func peekText(file candidate) error {
+	r, err := file.Source.Open(file.Name())
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer r.Close()
+	fmt.Println()
+	scanner := bufio.NewScanner(io.LimitReader(r, getnew.ContentReadLimit))
+	scanner.Buffer(nil, 1<<20)
+	for i := 0; i < peekLines && scanner.Scan(); i++ {
+		line := scanner.Bytes()
+		if bytes.IndexByte(line, 0) >= 0 {
+			fmt.Println("  (binary)")
+			return nil
+		}
+		fmt.Println("  " + strings.ReplaceAll(string(line), "\t", "    "))
+	}
+	return nil
+}
+
+// peekArchiveEntries prints the first entries of an archive in a local
+// source, as peek-archive lists them all.
+func peekArchiveEntries(ctx context.Context, file candidate) error {
+	dir, ok := sourceDir(file.Source)
+	if !ok {
+		fmt.Println("  (contents of remote archives are not shown)")
+		return nil
+	}
+	entries, err := getnew.ListArchive(ctx, filepath.Join(dir, file.Name()), findOptions())
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	for i, e := range entries {
+		if i == peekLines {
+			fmt.Printf("  ... and %d more\n", len(entries)-i)
+			break
+		}
+		size := ""
+		if !e.Dir {
+			size = humanSize(e.Size)
+		}
+		fmt.Printf("  %8s  %s\n", size, e.Name)
+	}
+	return nil
+}
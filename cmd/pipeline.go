@@ -0,0 +1,255 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+// A rule's pipeline is a list of steps run on each file it moves, such as
+//
+//	"*.tar.gz -> ~/tools -> extract -> chmod +x bin/* -> run ./install.sh"
+//
+// Each step works on the moved file, or once it is extracted, the directory
+// its contents went to, and in that directory.
+const (
+	// stepExtract unpacks the file, if it is an archive.
+	stepExtract = "extract"
+	// stepChmod sets the mode of files matching patterns, as in
+	// "chmod +x bin/*". {} is the file itself.
+	stepChmod = "chmod"
+	// stepRun runs a shell command, with {} replaced by the file's path.
+	stepRun = "run"
+)
+
+// parseStep splits a pipeline step into its kind and arguments.
+func parseStep(step string) (kind, args string, err error) {
+	kind, args, _ = strings.Cut(strings.TrimSpace(step), " ")
+	args = strings.TrimSpace(args)
+	switch kind {
+	case stepExtract:
+		if args != "" {
+			return "", "", fmt.Errorf("%q: extract takes nothing after it", step)
+		}
+	case stepChmod:
+		mode, patterns, _ := strings.Cut(args, " ")
+		if strings.TrimSpace(patterns) == "" {
+			return "", "", fmt.Errorf("%q: chmod needs a mode and the files to change", step)
+		}
+		if _, err := chmodMode(mode, 0); err != nil {
+			return "", "", fmt.Errorf("%q: %w", step, err)
+		}
+	case stepRun:
+		if args == "" {
+			return "", "", fmt.Errorf("%q: run needs a command", step)
+		}
+	default:
+		return "", "", fmt.Errorf("%q: unknown step (use extract, chmod or run)", step)
+	}
+	return kind, args, nil
+}
+
+// checkPipelines refuses rules with steps that could not run, so a mistake
+// is found when the config is loaded rather than after a move.
+func checkPipelines(rules []rule) error {
+	for i, r := range rules {
+		for _, step := range r.Then {
+			if _, _, err := parseStep(step); err != nil {
+				return fmt.Errorf("%s: %w", ruleLabel(r, i), err)
+			}
+		}
+	}
+	return nil
+}
+
+// runPipeline runs the steps of r on a file moved to path, and returns what
+// the last step left: the directory an extract step unpacked it into, or
+// else path.
+func runPipeline(ctx context.Context, r *rule, path string) (string, error) {
+	label := fmt.Sprintf("rule %q", r.Name)
+	if r.Name == "" {
+		label = "rule for " + r.Match
+	}
+	if len(r.Then) > 0 && isRemoteDest(path) {
+		return path, fmt.Errorf("%s: steps cannot run on a remote destination", label)
+	}
+	for i, step := range r.Then {
+		kind, args, err := parseStep(step)
+		if err == nil {
+			path, err = runStep(ctx, kind, args, path)
+		}
+		if err != nil {
+			return path, fmt.Errorf("%s, step %d (%s): %w", label, i+1, step, err)
+		}
+	}
+	return path, nil
+}
+
+// finishRule runs the pipeline of the rule r, if any, on a file moved into
+// dir at path, stages what it left with --git-add and returns where that is.
+func finishRule(ctx context.Context, r *rule, dir, path string) (string, error) {
+	if r != nil {
+		var err error
+		if path, err = runPipeline(ctx, r, path); err != nil {
+			return path, err
+		}
+	}
+	return path, stageMoved(ctx, dir, path)
+}
+
+func runStep(ctx context.Context, kind, args, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return path, err
+	}
+	dir := path
+	if !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	switch kind {
+	case stepExtract:
+		// Already unpacked, as with -z
+		if info.IsDir() || !getnew.IsArchive(info.Name()) {
+			return path, nil
+		}
+		return getnew.Unpack(ctx, path, findOptions())
+	case stepChmod:
+		mode, patterns, _ := strings.Cut(args, " ")
+		return path, chmodFiles(mode, strings.Fields(patterns), path, dir)
+	}
+	cmd := stepCommand(ctx, args, path)
+	cmd.Dir = dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = progressOut()
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "GETNEW_DEST="+path)
+	return path, cmd.Run()
+}
+
+// stepCommand prepares a run step's command for the shell, with {} replaced
+// by path. Unlike a hook, the path is not added if there is no {}.
+func stepCommand(ctx context.Context, command, path string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", strings.ReplaceAll(command, "{}", `"`+path+`"`))
+	}
+	return exec.CommandContext(ctx, "sh", "-c", strings.ReplaceAll(command, "{}", shellQuote(path)))
+}
+
+// chmodFiles applies mode to the files matching patterns in dir, with {}
+// standing for path. Each pattern must match something.
+func chmodFiles(mode string, patterns []string, path, dir string) error {
+	for _, pattern := range patterns {
+		matches := []string{path}
+		if pattern != "{}" {
+			var err error
+			if matches, err = filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern))); err != nil {
+				return err
+			}
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s matches nothing in %s", pattern, dir)
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				return err
+			}
+			perm, err := chmodMode(mode, info.Mode())
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(m, perm); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// chmodMode applies a mode as chmod takes it, octal (755) or symbolic
+// (+x, u+rw,go-w), to mode.
+func chmodMode(spec string, mode fs.FileMode) (fs.FileMode, error) {
+	if n, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if n > 0o777 {
+			return 0, fmt.Errorf("mode %s is out of range", spec)
+		}
+		return mode&^fs.ModePerm | fs.FileMode(n), nil
+	}
+	errBad := errors.New("mode " + spec + " should be octal, like 755, or like u+x or go-w")
+	perm := mode.Perm()
+	for _, clause := range strings.Split(spec, ",") {
+		i := strings.IndexAny(clause, "+-=")
+		if i < 0 {
+			return 0, errBad
+		}
+		var who, what fs.FileMode
+		for _, c := range clause[:i] {
+			switch c {
+			case 'u':
+				who |= 0o700
+			case 'g':
+				who |= 0o070
+			case 'o':
+				who |= 0o007
+			case 'a':
+				who |= 0o777
+			default:
+				return 0, errBad
+			}
+		}
+		if who == 0 {
+			who = 0o777
+		}
+		for _, c := range clause[i+1:] {
+			switch c {
+			case 'r':
+				what |= 0o444
+			case 'w':
+				what |= 0o222
+			case 'x':
+				what |= 0o111
+			default:
+				return 0, errBad
+			}
+		}
+		switch what &= who; clause[i] {
+		case '+':
+			perm |= what
+		case '-':
+			perm &^= what
+		case '=':
+			perm = perm&^who | what
+		}
+	}
+	return mode&^fs.ModePerm | perm, nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// projectFiles are the names of a project's getnew settings, in the order
+// they are looked for.
+var projectFiles = []string{".getnew", ".getnew.yaml"}
+
+// projectConfig is a .getnew file, which sets defaults for running getnew
+// inside a project. Dest is relative to the file's directory.
+type projectConfig struct {
+	Filter    string `yaml:"filter"`
+	Dest      string `yaml:"dest"`
+	Rename    string `yaml:"rename"`
+	Unarchive *bool  `yaml:"unarchive"`
+}
+
+var (
+	// renameTemplate names moved files, from a project's rename setting.
+	renameTemplate string
+	// projectDir is the directory of the project file in use.
+	projectDir string
+)
+
+var placeholderRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+var placeholders = []string{"{name}", "{stem}", "{ext}", "{date}", "{project}"}
+
+// findProjectConfig looks for a project file in the working directory and
+// each parent up to the root of the git repository it is in, if any.
+func findProjectConfig(cwd string) (string, error) {
+	root := ""
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			root = dir
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		for _, name := range projectFiles {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, nil
+			}
+		}
+		if root == "" || dir == root || filepath.Dir(dir) == dir {
+			return "", nil
+		}
+	}
+}
+
+// applyProjectConfig uses the project file, if there is one, for any
+// destination, filter or unarchive setting not given explicitly. It wins
+// over the directories entries in the config file.
+func applyProjectConfig(cmd *cobra.Command) error {
+	renameTemplate = ""
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	path, err := findProjectConfig(cwd)
+	if path == "" || err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read project settings: %w", err)
+	}
+	var p projectConfig
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, ph := range placeholderRe.FindAllString(p.Rename, -1) {
+		if !slices.Contains(placeholders, ph) {
+			return fmt.Errorf("%s: unknown %s in rename (use %s)", path, ph, strings.Join(placeholders, ", "))
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if p.Dest != "" && !cmd.Flags().Changed("dest") {
+		destDir = expandHome(p.Dest)
+		if !filepath.IsAbs(destDir) {
+			destDir = filepath.Join(dir, destDir)
+		}
+	}
+	if p.Filter != "" {
+		fileFilter = p.Filter
+	}
+	if p.Unarchive != nil && !cmd.Flags().Changed("unarchive") {
+		unarchive = *p.Unarchive
+	}
+	renameTemplate = p.Rename
+	projectDir = dir
+	return nil
+}
+
+// renamed applies the rename template to a file's name.
+func renamed(file candidate) string {
+	name, modTime := file.Name(), file.ModTime()
+	if renameTemplate == "" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	replacements := []string{
+		"{name}", name,
+		"{stem}", strings.TrimSuffix(name, ext),
+		"{ext}", ext,
+		"{date}", modTime.Format("2006-01-02"),
+		"{time}", modTime.Format("15-04-05"),
+		"{project}", filepath.Base(projectDir),
+	}
+	// Only read the file's metadata if the template uses it
+	var meta map[string]string
+	for _, field := range getnew.MetadataFields {
+		if !strings.Contains(renameTemplate, "{"+field+"}") {
+			continue
+		}
+		if meta == nil {
+			meta = getnew.Metadata(file)
+		}
+		value := meta[field]
+		if value == "" {
+			value = "unknown"
+		}
+		// Keep the value from making directories or an invalid name
+		value = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`/\:*?"<>|`, r) {
+				return '-'
+			}
+			return r
+		}, value)
+		replacements = append(replacements, "{"+field+"}", value)
+	}
+	return strings.NewReplacer(replacements...).Replace(renameTemplate)
+}
+
+// renamedFile is a moved file under its new name.
+type renamedFile struct {
+	fs.FileInfo
+	name string
+}
+
+func (f renamedFile) Name() string { return f.name }
@@ -0,0 +1,109 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var putbackCmd = &cobra.Command{
+	Use:   "putback [file|filter]",
+	Short: "Return a moved file to where it came from",
+	Long: `putback looks up a file in getnew's history and moves it back to the place it
+was moved from, wherever the shell is now. Give the file's path, or a filter to
+pick the most recently moved file matching it; with no argument, the most
+recently moved file that is still where getnew put it goes back.
+
+Unlike undoing the last operation, any earlier move can be put back. The file
+is never put back over another one.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		selector := ""
+		if len(args) > 0 {
+			selector = args[0]
+		}
+		if err := putBack(cmd.Context(), selector); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(putbackCmd)
+}
+
+// putBackEntry finds the most recent move matching selector, a path or a
+// filter, whose file is still where it was put.
+func putBackEntry(entries []historyEntry, selector string) (historyEntry, error) {
+	path := ""
+	if selector != "" {
+		if abs, err := filepath.Abs(selector); err == nil {
+			path = abs
+		}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if selector != "" && entry.Dest != path && !matchesFilter(entry.Name, selector) {
+			continue
+		}
+		if _, err := os.Stat(entry.Dest); err != nil {
+			continue
+		}
+		return entry, nil
+	}
+	if selector != "" {
+		return historyEntry{}, fmt.Errorf("no moved file matching '%s' is still where it was put", selector)
+	}
+	return historyEntry{}, fmt.Errorf("no moved file is still where it was put")
+}
+
+func putBack(ctx context.Context, selector string) error {
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	entry, err := putBackEntry(entries, selector)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(entry.Source, "://") {
+		return fmt.Errorf("%s came from %s, which putback cannot write to", entry.Name, entry.Source)
+	}
+	if _, err := os.Lstat(entry.Source); err == nil {
+		return getnew.WithKind(fmt.Errorf("%s already exists", entry.Source), getnew.ErrConflict)
+	}
+	if err := os.MkdirAll(filepath.Dir(entry.Source), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(entry.Source), err)
+	}
+	if err := transferFile(ctx, entry.Dest, entry.Source); err != nil {
+		return err
+	}
+	printResult(styledName(os.Stdout, entry.Name)+" -> "+filepath.Dir(entry.Source), entry.Source)
+	return nil
+}
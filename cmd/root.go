@@ -22,25 +22,111 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"sort"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/coljac/getnew/pkg/clock"
+	"github.com/coljac/getnew/pkg/getnew"
 	"github.com/spf13/cobra"
 )
 
 var (
-	sourceDir  string
+	sourceDirs []string
+	destDir    string
 	nthNewest  int
 	fileFilter string
 	unarchive  bool
+	moveAll    bool
+	moveCount  int
+	moveJobs   int
+	moveDirs   bool
+	tarDirs    bool
+	printPath  bool
+	print0     bool
+
+	autoPlatform bool
+	byVersion    bool
+	fuzzy        bool
+	useTrash     bool
+	checkOpen    time.Duration
+
+	verifyCopies   bool
+	verifyAbove    string
+	verifyCoverage float64
+	sampleAbove    int64
+
+	newerThanSpec, olderThanSpec string
+	newerThan, olderThan         time.Time
+	minSizeSpec, maxSizeSpec     string
+	minSize, maxSize             int64
+	fileType                     string
+	metaSpecs                    []string
+	metaFilters                  map[string]string
+	containsText, containsRegex  string
+	contentPattern               *regexp.Regexp
+
+	settlePeriod time.Duration
+	waitTimeout  time.Duration
+	ignoreExts   []string
+
+	followSymlinks, preserveSymlinks bool
 )
 
+var errWaitTimeout = errors.New("timed out waiting for a matching file")
+
+// Exit statuses, documented in the README, so scripts can tell "nothing to
+// do" apart from failures. Anything else is 1.
+const (
+	exitSourceGone     = 3
+	exitNoCandidates   = 4
+	exitNotEnough      = 5
+	exitConflict       = 6
+	exitExtractFailed  = 7
+	exitCorruptArchive = 8
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, getnew.ErrSourceGone):
+		return exitSourceGone
+	case errors.Is(err, getnew.ErrNoCandidates):
+		return exitNoCandidates
+	case errors.Is(err, getnew.ErrNotEnoughFiles):
+		return exitNotEnough
+	case errors.Is(err, getnew.ErrConflict):
+		return exitConflict
+	case errors.Is(err, getnew.ErrExtractFailed):
+		return exitExtractFailed
+	case errors.Is(err, getnew.ErrCorruptArchive):
+		return exitCorruptArchive
+	}
+	return 1
+}
+
+// exitWithError ends a failed run: it reports err on stderr and as an error
+// notification, sends any notifications still batched and exits with the
+// code for err. os.Exit skips what Execute would otherwise do on the way out.
+func exitWithError(cmd *cobra.Command, err error) {
+	recordError(cmd.Name(), "", err)
+	flushNotifications()
+	os.Exit(exitCode(err))
+}
+
+// clk is the time source for everything that depends on the current time, so
+// tests can substitute a fake one.
+var clk clock.Clock = clock.Real
+
 var rootCmd = &cobra.Command{
 	Use:   "getnew [filter]",
 	Short: "Move the nth newest file from a source directory to the current directory",
@@ -48,154 +134,734 @@ var rootCmd = &cobra.Command{
 and moves it to the current directory. By default, it moves the newest file.
 
 The source directory can be set using the GETNEW_SOURCE_DIR environment variable
-or specified using the --source flag.
+or specified using the --source flag. Repeat --source (or separate directories
+in GETNEW_SOURCE_DIR with ':') to search several directories together. A source
+can also be a directory on another machine, given as sftp://user@host/path or
+ssh://user@host/path, which is reached with your usual ssh setup. Use --dest to
+move somewhere other than the current directory, including user@host:/path to
+send the file to another machine with rsync or scp.
 
-Optionally, provide a filter argument to match files partially.`,
-	Args: cobra.MaximumNArgs(1),
+Optionally, provide a filter argument to match files partially, or a glob
+pattern such as '*.pdf'.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := prepare(cmd); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
 		if len(args) > 0 {
 			fileFilter = args[0]
 		}
-		err, fileinfo := moveNthNewestFile()
+		if cmd.Flags().Changed("wait") {
+			if err := waitForCandidates(ctx, waitTimeout); err != nil {
+				exitWithError(cmd, err)
+			}
+		}
+		if waitMount > 0 {
+			if err := waitForMount(ctx, waitMount); err != nil {
+				exitWithError(cmd, err)
+			}
+		}
+		if filesFrom != "" {
+			if err := moveListedFiles(ctx); err != nil {
+				exitWithError(cmd, err)
+			}
+			return
+		}
+		if toStdout {
+			if err := streamToStdout(ctx); err != nil {
+				exitWithError(cmd, err)
+			}
+			return
+		}
+		if nthLast != 0 {
+			err := moveNthRange(ctx)
+			updateStatus(ctx)
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+			return
+		}
+		if moveAll || cmd.Flags().Changed("count") || activeMedia != nil {
+			err := moveAllFiles(ctx)
+			updateStatus(ctx)
+			if err == nil && ejectAfter {
+				err = ejectSources()
+			}
+			if err != nil {
+				exitWithError(cmd, err)
+			}
+			return
+		}
+		err, fileinfo := moveNthNewestFile(ctx)
+		if isDuplicate(err) {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+			exitWithError(cmd, err)
+		}
+		updateStatus(ctx)
+		if err := finishMove(ctx, fileinfo, false); err != nil {
+			exitWithError(cmd, err)
 		}
-		if unarchive {
-			if err := unarchiveFetchedFile(fileinfo); err != nil {
-				fmt.Fprintf(os.Stderr, "Error unarchiving: %v\n", err)
-				os.Exit(1)
+		if ejectAfter {
+			if err := ejectSources(); err != nil {
+				exitWithError(cmd, err)
 			}
 		}
 	},
 }
 
+// finishMove tests, unarchives and opens a file moved to destDir, as asked.
+func finishMove(ctx context.Context, file fs.FileInfo, batch bool) error {
+	opened, err := settleMove(ctx, file, batch)
+	if err != nil {
+		return err
+	}
+	if printPath {
+		if abs, err := filepath.Abs(opened); err == nil && !isRemoteDest(opened) {
+			opened = abs
+		}
+		printResult(opened, opened)
+	}
+	if openMoved {
+		return openWithDefaultApp(opened)
+	}
+	return nil
+}
+
+// settleMove tests and unarchives a file moved to destDir, runs the forced
+// rule's pipeline and stages it in git, returning where the result ended up.
+// In a batch, files that are not archives are passed over by --unarchive.
+func settleMove(ctx context.Context, file fs.FileInfo, batch bool) (string, error) {
+	dir := fileDestDir(destDir, file.ModTime())
+	opened := filepath.Join(dir, file.Name())
+	if u, ok := file.(unpackedFile); ok {
+		// Unpacked straight from the source, so there is no archive here
+		opened = u.root
+	} else {
+		if err := testMovedArchive(ctx, dir, file); err != nil {
+			return "", err
+		}
+		if unarchive && !file.IsDir() && (!batch || getnew.IsArchive(file.Name())) {
+			root, err := unarchiveFetchedFile(ctx, dir, file)
+			if err != nil {
+				return "", fmt.Errorf("failed to unarchive: %w", err)
+			}
+			// Show where the contents went rather than the archive
+			opened = root
+		}
+	}
+	return finishRule(ctx, forcedRule, dir, opened)
+}
+
+// prepare resolves the options and opens the sources for any command.
+func prepare(cmd *cobra.Command) error {
+	if cmd.Name() == "shot" {
+		screenshotsOnly = true
+	}
+	importing = cmd.Name() == "import"
+	opts, err := resolveOptions(newInvocation(cmd))
+	if err != nil {
+		return err
+	}
+	sourceDirs, destDir, sampleAbove = opts.sources, opts.dest, opts.sampleAbove
+	newerThan, olderThan = opts.newerThan, opts.olderThan
+	minSize, maxSize = opts.minSize, opts.maxSize
+	fileType, metaFilters, contentPattern = opts.fileType, opts.meta, opts.content
+	if appConfig, err = loadConfig(); err != nil {
+		return err
+	}
+	if err := applyDirectoryConfig(cmd, appConfig); err != nil {
+		return err
+	}
+	if err := applyProjectConfig(cmd); err != nil {
+		return err
+	}
+	if err := applyMediaProfile(cmd, appConfig); err != nil {
+		return err
+	}
+	if err := applyRuleOverride(appConfig); err != nil {
+		return err
+	}
+	if appConfig.Archives.Keep && !cmd.Flags().Changed("keep-archive") {
+		keepArchive = true
+	}
+	// Picking entries or nested archives is no use without unpacking
+	if len(extractOnly) > 0 || unarchiveDepth > 1 || extractFromSource {
+		unarchive = true
+	}
+	if err := setupNotifiers(appConfig.Notifications); err != nil {
+		return err
+	}
+	setupDesktopNotifier(cmd)
+	sources, err = openSources(sourceDirs)
+	return err
+}
+
+// completeFilter completes a filter argument with the names of the files
+// currently in the sources, newest first.
+func completeFilter(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || prepare(cmd) != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	fileFilter = ""
+	files, err := collectCandidates(cmd.Context())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, file := range files {
+		if strings.HasPrefix(strings.ToLower(file.Name()), strings.ToLower(toComplete)) {
+			names = append(names, file.Name())
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+}
+
 func Execute() {
-	err := rootCmd.Execute()
+	// Ctrl-C or SIGTERM stops the current copy or extraction and cleans up
+	// after it; a second signal kills getnew outright.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	flushNotifications()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&sourceDir, "source", "s", "", "Source directory (overrides GETNEW_SOURCE_DIR)")
-	rootCmd.Flags().IntVarP(&nthNewest, "nth", "n", 1, "Nth newest file to move (default is 1, the newest)")
-	rootCmd.Flags().BoolVarP(&unarchive, "unarchive", "z", false, "Unarchive the file if it's an archive (zip, gz, tar.gz, 7z)")
+	rootCmd.PersistentFlags().StringArrayVarP(&sourceDirs, "source", "s", nil, "Source directory, can be repeated (overrides GETNEW_SOURCE_DIR)")
+	rootCmd.PersistentFlags().StringVarP(&destDir, "dest", "d", ".", "Destination directory, or user@host:/path to copy to another machine")
+	nthNewest = 1
+	rootCmd.Flags().VarP(nthFlag{}, "nth", "n", "Nth newest file to move (default is 1, the newest); -1 for the oldest, -2 the one after, or a range such as 2..4")
+	rootCmd.PersistentFlags().BoolVarP(&unarchive, "unarchive", "z", false, "Unarchive the file if it's an archive (zip, tar, gz, bz2, xz, 7z, rar, iso, dmg, deb, rpm; see getnew doctor)")
+	rootCmd.PersistentFlags().DurationVar(&settlePeriod, "settle", 2*time.Second, "How long a new file must be unchanged before it is moved (0 to skip the check)")
+	rootCmd.PersistentFlags().BoolVar(&autoPlatform, "auto-platform", false, "Among files that differ only by platform (linux-amd64, darwin-arm64, .deb, .rpm...), pick the one for this machine")
+	rootCmd.PersistentFlags().BoolVar(&fuzzy, "fuzzy", false, "Match the filter fuzzily, like fzf: its letters in order, best matches first (rprt25 finds Quarterly-Report-2025.pdf)")
+	rootCmd.PersistentFlags().BoolVar(&byVersion, "by-version", false, "Order files by the version number in their names (tool-1.10.0 before tool-1.9.2) instead of by age")
+	rootCmd.PersistentFlags().BoolVar(&useTrash, "trash", false, "Send originals and overwritten files to the trash instead of deleting them")
+	rootCmd.PersistentFlags().DurationVar(&checkOpen, "check-open", 0, "Before removing an original, wait up to this long for other programs to close it, and keep it if they do not (e.g. --check-open=30s)")
+	rootCmd.PersistentFlags().Lookup("check-open").NoOptDefVal = "10s"
+	rootCmd.PersistentFlags().BoolVar(&verifyCopies, "verify", false, "Read each copy back and compare it with the original before removing the original")
+	rootCmd.PersistentFlags().StringVar(&verifyAbove, "verify-sample-above", "1G", "With --verify, only sample files larger than this instead of reading them back in full")
+	rootCmd.PersistentFlags().Float64Var(&verifyCoverage, "verify-coverage", 5, "Percentage of a large file that --verify samples")
+	rootCmd.PersistentFlags().StringVar(&newerThanSpec, "newer-than", "", "Only consider files modified after this date or within this age (e.g. 2h, 2025-01-01)")
+	rootCmd.PersistentFlags().StringVar(&olderThanSpec, "older-than", "", "Only consider files modified before this date or longer ago than this age")
+	rootCmd.PersistentFlags().StringVar(&minSizeSpec, "min-size", "", "Only consider files at least this big (e.g. 10M, 1.5G)")
+	rootCmd.PersistentFlags().StringVar(&maxSizeSpec, "max-size", "", "Only consider files at most this big")
+	rootCmd.PersistentFlags().StringVar(&fileType, "type", "", "Only consider files of this kind: "+strings.Join(getnew.FileTypes, ", "))
+	rootCmd.RegisterFlagCompletionFunc("type", cobra.FixedCompletions(getnew.FileTypes, cobra.ShellCompDirectiveNoFileComp))
+	rootCmd.PersistentFlags().StringArrayVar(&metaSpecs, "meta", nil, "Only consider files whose metadata field matches a pattern, e.g. exif.model='*R5*'; can be repeated. Fields: "+strings.Join(getnew.MetadataFields, ", "))
+	rootCmd.PersistentFlags().StringVar(&containsText, "contains", "", "Only consider text files containing this, ignoring case")
+	rootCmd.PersistentFlags().StringVar(&containsRegex, "contains-regex", "", "Only consider text files with contents matching this regular expression")
+	for _, kind := range getnew.FileTypes {
+		rootCmd.PersistentFlags().Bool(kind, false, "Only consider "+kind+" files, short for --type "+kind)
+	}
+	rootCmd.PersistentFlags().BoolVar(&notifyDesktop, "notify", false, "Show a desktop notification for each file moved (on by default for watch)")
+	rootCmd.PersistentFlags().BoolVarP(&print0, "print0", "0", false, "Print the paths of files moved or listed ending in NUL instead of newline, for xargs -0; all other output goes to stderr")
+	rootCmd.PersistentFlags().StringSliceVar(&ignoreExts, "ignore-ext", getnew.PartialDownloadExts, "Extensions of in-progress downloads to ignore")
+	rootCmd.Flags().BoolVarP(&moveAll, "all", "a", false, "Move every matching file, not just the nth newest")
+	rootCmd.Flags().IntVar(&moveCount, "count", 0, "Move at most this many of the newest matching files")
+	rootCmd.Flags().IntVarP(&moveJobs, "jobs", "j", 1, "Move up to this many files at once with --all, --count or --files-from")
+	rootCmd.PersistentFlags().BoolVar(&moveDirs, "dirs", false, "Treat directories in the source as candidates too, moving them with everything in them")
+	rootCmd.PersistentFlags().BoolVar(&tarDirs, "tar-dirs", false, "Pack directories into a .tar.gz as they are moved; implies --dirs")
+	rootCmd.PersistentFlags().BoolVar(&followSymlinks, "follow-symlinks", false, "Copy what symbolic links in the source point to (the default), removing only the link")
+	rootCmd.PersistentFlags().BoolVar(&preserveSymlinks, "preserve-symlinks", false, "Recreate symbolic links in the source at the destination rather than copying what they point to")
+	rootCmd.Flags().BoolVar(&printPath, "print-path", false, "Print only the absolute path of the moved file on stdout (with -z, the directory it was unpacked into)")
+	rootCmd.Flags().DurationVarP(&waitTimeout, "wait", "w", 0, "Wait for a matching file to appear, optionally with a timeout (e.g. --wait=2m)")
+	rootCmd.Flags().Lookup("wait").NoOptDefVal = "0s"
+}
 
-	// Use environment variable if --source flag is not set
-	if sourceDir == "" {
-		sourceDir = os.Getenv("GETNEW_SOURCE_DIR")
-		if sourceDir == "" {
-			sourceDir = filepath.Join(os.Getenv("HOME"), "Downloads") // Default to ~/Downloads if not set
-		}
+func moveNthNewestFile(ctx context.Context) (error, fs.FileInfo) {
+	regularFiles, err := newestSettled(ctx, nthLimit())
+	if err != nil {
+		return err, nil
+	}
+
+	return moveFile(ctx, regularFiles, nthNewest, fileFilter)
+}
+
+// findOptions are the library options for the current invocation.
+func findOptions() getnew.Options {
+	opts := getnew.Options{
+		Sources:         sources,
+		Filter:          fileFilter,
+		Fuzzy:           fuzzy,
+		NewerThan:       newerThan,
+		OlderThan:       olderThan,
+		MinSize:         minSize,
+		MaxSize:         maxSize,
+		Type:            fileType,
+		Metadata:        metaFilters,
+		Content:         contentPattern,
+		IgnoreExts:      ignoreExts,
+		ByVersion:       byVersion,
+		Trash:           useTrash,
+		CheckOpen:       checkOpen,
+		KeepSource:      readOnly,
+		SkipSystemFiles: activeMedia != nil || importing,
+		Verify:          verifyCopies,
+		SampleAbove:     sampleAbove,
+		Coverage:        verifyCoverage / 100,
+		Settle:          settlePeriod,
+		Dirs:            moveDirs || tarDirs,
+		TarDirs:         tarDirs,
+		Symlinks:        symlinkMode(),
+		ExtractOnly:     extractOnly,
+		Depth:           unarchiveDepth,
+		KeepArchive:     keepArchive,
+		Password:        archivePassword,
+		Clock:           clk,
+		Stdout:          progressOut(),
+		Stderr:          os.Stderr,
+	}
+	if autoPlatform {
+		platform := getnew.CurrentPlatform()
+		opts.Platform = &platform
+	}
+	if signatureMode != "" || fromDomain != "" || screenshotsOnly || importing {
+		opts.Exclude = excluded
+	}
+	if verbose {
+		opts.Skipped = reportSkipped
+	}
+	return opts
+}
+
+// excluded leaves out signature and checksum files with --verify-signature,
+// anything but screenshots with --screenshots, and files downloaded from
+// elsewhere with --from.
+func excluded(file candidate) bool {
+	if signatureMode != "" && isCompanion(file) {
+		return true
+	}
+	if screenshotsOnly && !isScreenshot(file) {
+		return true
+	}
+	if importing && !isPhotoOrVideo(file) {
+		return true
+	}
+	return fromDomain != "" && !downloadedFrom(file, fromDomain)
+}
+
+func symlinkMode() getnew.SymlinkMode {
+	if preserveSymlinks {
+		return getnew.SymlinksPreserve
 	}
+	return getnew.SymlinksFollow
+}
+
+// collectCandidates lists the matching files across all sources.
+func collectCandidates(ctx context.Context) ([]candidate, error) {
+	return newestCandidates(ctx, 0)
+}
+
+// newestCandidates is collectCandidates limited to the first limit files
+// (all of them if 0), which saves holding on to every file in a large
+// source directory.
+func newestCandidates(ctx context.Context, limit int) ([]candidate, error) {
+	opts := findOptions()
+	opts.Settle = 0
+	opts.Limit = limit
+	return getnew.Find(ctx, opts)
 }
 
-func moveNthNewestFile() (error, fs.FileInfo) {
-	files, err := os.ReadDir(sourceDir)
+// settledCandidates is collectCandidates without files that are still growing.
+func settledCandidates(ctx context.Context) ([]candidate, error) {
+	return newestSettled(ctx, 0)
+}
+
+// newestSettled is settledCandidates limited as newestCandidates is.
+func newestSettled(ctx context.Context, limit int) ([]candidate, error) {
+	opts := findOptions()
+	opts.Limit = limit
+	return getnew.Find(ctx, opts)
+}
+
+// progressOut is where messages about the move go: stdout, unless
+// --print-path keeps it for the path alone.
+func progressOut() io.Writer {
+	if printPath || print0 || toStdout {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// printResult prints a line of results on stdout, or with --print0 just
+// the path ended with NUL, which is safe whatever characters it contains.
+func printResult(line, path string) {
+	if print0 {
+		fmt.Printf("%s\x00", path)
+		return
+	}
+	fmt.Println(line)
+}
+
+func isIgnoredExt(ext string) bool {
+	return getnew.IsIgnoredExt(ext, ignoreExts)
+}
+
+// waitForCandidates blocks until at least nthNewest matching files exist in the
+// source directory. A zero timeout waits indefinitely.
+func waitForCandidates(ctx context.Context, timeout time.Duration) error {
+	watcher, err := newSourceWatcher("--wait")
 	if err != nil {
-		return fmt.Errorf("failed to read source directory: %w", err), nil
+		return err
 	}
+	defer watcher.Close()
 
-	var regularFiles []os.FileInfo
-	for _, file := range files {
-		if !file.IsDir() {
-			info, err := file.Info()
-			if err != nil {
-				return fmt.Errorf("failed to get file info: %w", err), nil
-			}
-			if fileFilter == "" || strings.Contains(strings.ToLower(info.Name()), strings.ToLower(fileFilter)) {
-				regularFiles = append(regularFiles, info)
-			}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = clk.After(timeout)
+	}
+	announced := false
+	for {
+		files, err := newestCandidates(ctx, nthLimit())
+		if err != nil {
+			return err
+		}
+		if len(files) >= nthNeeded() {
+			return nil
+		}
+		if !announced {
+			fmt.Fprintf(os.Stderr, "Waiting for a matching file in %s...\n", strings.Join(sourceDirs, ", "))
+			announced = true
+		}
+		if err := watchArrivals(ctx, watcher, deadline, func(candidate) bool { return false }); err != nil {
+			return err
 		}
 	}
+}
 
-	return moveFile(sourceDir, regularFiles, nthNewest, fileFilter)
+func matchesFilter(name, filter string) bool {
+	return getnew.MatchesFilter(name, filter)
 }
 
-func moveFile(sourceDir string, regularFiles []os.FileInfo, nthNewest int, fileFilter string) (error, fs.FileInfo) {
-	if len(regularFiles) == 0 {
-		if fileFilter != "" {
-			return fmt.Errorf("no files matching '%s' found in the source directory", fileFilter), nil
+func moveFile(ctx context.Context, regularFiles []candidate, nthNewest int, fileFilter string) (error, fs.FileInfo) {
+	fileToMove, err := selectNthNewest(regularFiles, nthNewest, fileFilter)
+	if err != nil {
+		return err, nil
+	}
+	info, err := moveToDest(ctx, fileToMove)
+	return err, info
+}
+
+// moveAllFiles moves every matching file, newest first, up to --count of
+// them. A failure does not stop the rest; it is reported at the end.
+func moveAllFiles(ctx context.Context) error {
+	limit := moveCount
+	if readOnly {
+		// Some of the newest may turn out to have been imported already
+		limit = 0
+	}
+	files, err := newestSettled(ctx, limit)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		// The originals stay, so only bring in what earlier imports have not
+		files = alreadyImported(files)
+		if len(files) == 0 {
+			fmt.Fprintln(os.Stderr, "No new files to import")
+			return nil
 		}
-		return fmt.Errorf("no files found in the source directory"), nil
 	}
+	if len(files) == 0 {
+		_, err := selectNthNewest(files, 1, fileFilter)
+		return err
+	}
+	if moveCount > 0 && len(files) > moveCount {
+		files = files[:moveCount]
+	}
+	return moveFiles(ctx, files)
+}
 
-	sort.Slice(regularFiles, func(i, j int) bool {
-		return regularFiles[i].ModTime().After(regularFiles[j].ModTime())
-	})
+// moveFiles moves each of files, carrying on past failures and reporting
+// them at the end. With --jobs, several files are moved at once.
+func moveFiles(ctx context.Context, files []candidate) error {
+	var mu sync.Mutex // guards the tallies below
+	moved := map[string]bool{}
+	// unfinished counts files moved but not tested, unarchived or the like
+	failed, unfinished, duplicates := 0, 0, 0
+	var gone error
+
+	moveOne := func(file candidate) {
+		// Claim the name first, so a file of the same name from another
+		// source cannot be moved over it at the same time
+		mu.Lock()
+		if gone != nil {
+			mu.Unlock()
+			return
+		}
+		if moved[file.Name()] {
+			failed++
+			mu.Unlock()
+			fmt.Fprintf(os.Stderr, "Error: %s: a file with this name was already moved from another source\n", file.Name())
+			return
+		}
+		moved[file.Name()] = true
+		mu.Unlock()
 
-	if nthNewest > len(regularFiles) {
-		return fmt.Errorf("requested %dth newest file, but only %d files available", nthNewest, len(regularFiles)), nil
+		info, err := moveToDest(ctx, file)
+		if err == nil {
+			// The file has moved even if what follows fails
+			if err := finishMove(ctx, info, true); err != nil {
+				mu.Lock()
+				unfinished++
+				mu.Unlock()
+				fmt.Fprintf(os.Stderr, "Error: %s was moved, but: %v\n", file.Name(), err)
+			}
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if info == nil {
+			delete(moved, file.Name())
+		}
+		switch {
+		case isDuplicate(err):
+			fmt.Fprintln(os.Stderr, err)
+			duplicates++
+		case errors.Is(err, getnew.ErrSourceGone):
+			gone = err
+		default:
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", file.Name(), err)
+			failed++
+		}
 	}
 
-	fileToMove := regularFiles[nthNewest-1]
-	sourcePath := filepath.Join(sourceDir, fileToMove.Name())
-	destPath := filepath.Join(".", fileToMove.Name())
+	queue := make(chan candidate)
+	var workers sync.WaitGroup
+	for range max(moveJobs, 1) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for file := range queue {
+				moveOne(file)
+			}
+		}()
+	}
+	for _, file := range files {
+		mu.Lock()
+		stop := gone != nil
+		mu.Unlock()
+		if stop || ctx.Err() != nil {
+			break
+		}
+		queue <- file
+	}
+	close(queue)
+	workers.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Moved %d of %d file(s)\n", len(moved), len(files))
+	if gone != nil {
+		return gone
+	}
+	if duplicates > 0 {
+		fmt.Fprintf(os.Stderr, "%d duplicate(s) left out\n", duplicates)
+	}
+	switch {
+	case failed > 0 && unfinished > 0:
+		return fmt.Errorf("%d file(s) could not be moved, and %d moved file(s) were not finished", failed, unfinished)
+	case failed > 0:
+		return fmt.Errorf("%d file(s) could not be moved", failed)
+	case unfinished > 0:
+		return fmt.Errorf("%d moved file(s) were not finished", unfinished)
+	}
+	return nil
+}
 
-	// Open the source file
-	sourceFile, err := os.Open(sourcePath)
-	if err != nil {
-		return fmt.Errorf("failed to open source file: %w", err), nil
+// withinDir reports whether path lies inside dir.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// moveToDest moves one candidate into destDir and reports it.
+// outputMu serialises the lines printed for each file moved.
+var outputMu sync.Mutex
+
+func moveToDest(ctx context.Context, fileToMove candidate) (fs.FileInfo, error) {
+	name := renamed(fileToMove)
+	if fileToMove.IsDir() && tarDirs {
+		name += ".tar.gz"
+	}
+	dir := fileDestDir(destDir, fileToMove.ModTime())
+	destPath := filepath.Join(dir, name)
+	if !withinDir(dir, destPath) {
+		return nil, fmt.Errorf("%s would be moved outside the destination, to %s", fileToMove.Name(), destPath)
 	}
-	defer sourceFile.Close()
 
-	// Create the destination file
-	destFile, err := os.Create(destPath)
+	// An index may hold an out-of-date size for a file changed in place
+	if _, ok := fileToMove.Source.(*indexedSource); ok {
+		info, err := fileToMove.Source.Stat(fileToMove.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", fileToMove.Name(), err)
+		}
+		fileToMove.FileInfo = info
+	}
+	if err := dedupe(ctx, fileToMove, filepath.Dir(destPath)); err != nil {
+		return nil, err
+	}
+	companions, err := checkSignature(ctx, fileToMove)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err), nil
+		return nil, err
 	}
-	defer destFile.Close()
 
-	// Copy the contents from source to destination
-	if _, err := io.Copy(destFile, sourceFile); err != nil {
-		return fmt.Errorf("failed to copy file: %w", err), nil
+	if unpackingFromSource(fileToMove) {
+		root, err := unpackFromSource(ctx, fileToMove, filepath.Dir(destPath))
+		if err != nil {
+			return nil, err
+		}
+		carryCompanions(ctx, companions, filepath.Dir(destPath))
+		outputMu.Lock()
+		if !printPath {
+			printResult(styledName(os.Stdout, fileToMove.Name())+" -> "+root, root)
+		}
+		outputMu.Unlock()
+		return unpackedFile{fileToMove.FileInfo, root}, nil
 	}
+	if err := moveFromSource(ctx, fileToMove, destPath); err != nil {
+		return nil, err
+	}
+	carryCompanions(ctx, companions, filepath.Dir(destPath))
 
-	// Close the files
-	if err := sourceFile.Close(); err != nil {
-		return fmt.Errorf("failed to close source file: %w", err), nil
+	// Keep each file's lines together when moving several at once
+	outputMu.Lock()
+	if !printPath {
+		printResult(styledName(os.Stdout, name), destPath)
+	}
+	if len(sources) > 1 {
+		fmt.Fprintf(os.Stderr, "from %s\n", fileToMove.Source.Location(""))
 	}
-	if err := destFile.Close(); err != nil {
-		return fmt.Errorf("failed to close destination file: %w", err), nil
+	outputMu.Unlock()
+	if name != fileToMove.Name() {
+		return renamedFile{fileToMove.FileInfo, name}, nil
 	}
+	return fileToMove.FileInfo, nil
+}
 
-	// Remove the original file
-	if err := os.Remove(sourcePath); err != nil {
-		return fmt.Errorf("failed to remove original file: %w", err), nil
+func selectNthNewest(regularFiles []candidate, nthNewest int, fileFilter string) (candidate, error) {
+	if len(regularFiles) == 0 {
+		if fileFilter != "" {
+			return candidate{}, getnew.WithKind(fmt.Errorf("no files matching '%s' found in the source directory", fileFilter), getnew.ErrNoCandidates)
+		}
+		return candidate{}, getnew.WithKind(fmt.Errorf("no files found in the source directory"), getnew.ErrNoCandidates)
 	}
 
-	fmt.Printf("%s\n", fileToMove.Name())
-	return nil, fileToMove
+	return getnew.Nth(regularFiles, nthNewest)
 }
 
-func unarchiveFetchedFile(file fs.FileInfo) error {
-	var cmd *exec.Cmd
-	switch filepath.Ext(file.Name()) {
-	case ".zip":
-		cmd = exec.Command("unzip", "-o", file.Name())
-	case ".gz", ".tgz":
-		cmd = exec.Command("tar", "-xzf", file.Name())
-	case ".tar":
-		cmd = exec.Command("tar", "-xf", file.Name())
-	case ".7z":
-		cmd = exec.Command("7z", "x", file.Name())
-	default:
-		return fmt.Errorf("not a recognized archive format: %s", file.Name())
+func transferFile(ctx context.Context, sourcePath, destPath string) error {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
 	}
+	return moveFromSource(ctx, candidate{FileInfo: info, Source: localSource{Dir: filepath.Dir(sourcePath)}}, destPath)
+}
 
-	if cmd != nil {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to unarchive %s: %w", file.Name(), err)
-		}
-		if err := os.Remove(file.Name()); err != nil {
-			return fmt.Errorf("failed to remove original archive file: %w", err)
+// moveFromSource moves a candidate to destPath and records the move.
+// The pre-move hook can veto the move; the post-move hook only reports.
+func moveFromSource(ctx context.Context, file candidate, destPath string) error {
+	source := file.Source.Location(file.Name())
+	unlock, err := lockSource(source)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// Another run may have moved it between listing and locking
+	if _, err := file.Source.Stat(file.Name()); errors.Is(err, fs.ErrNotExist) {
+		return getnew.WithKind(fmt.Errorf("%s has already been moved, perhaps by another getnew", file.Name()), getnew.ErrConflict)
+	}
+	if err := checkGitIgnored(ctx, destPath); err != nil {
+		return err
+	}
+	if err := runPreHook(ctx, source, destPath); err != nil {
+		return err
+	}
+	if isRemoteDest(destPath) {
+		if err := pushToRemote(ctx, file.Source, file.FileInfo, destPath); err != nil {
+			return err
 		}
-		fmt.Printf("Unarchived and removed: %s\n", file.Name())
+		runPostHook(ctx, source, destPath)
 		return nil
 	}
+	// Read the mark now, as the original is gone after the move
+	var mark webMark
+	var marked bool
+	if dir, ok := sourceDir(file.Source); ok {
+		mark, marked = readWebMark(filepath.Join(dir, file.Name()))
+	}
+	result, err := getnew.Move(ctx, file, destPath, findOptions())
+	if err != nil {
+		return err
+	}
+	carryWebMark(result.Dest, mark, marked)
+	if s, ok := file.Source.(originSource); ok && mark.url == "" {
+		mark.url = s.Origin(file.Name())
+	}
+	recordMove(historyEntry{
+		Source:   result.Source,
+		Dest:     result.Dest,
+		Size:     result.Size,
+		Duration: result.Duration,
+		SHA256:   result.SHA256,
+		Origin:   mark.url,
+	})
+	runPostHook(ctx, source, result.Dest)
+	return nil
+}
+
+// removeOriginal deletes a local file that has been copied elsewhere, or
+// trashes it with --trash.
+func removeOriginal(path string) error {
+	if useTrash {
+		return getnew.Trash(path)
+	}
+	return os.Remove(path)
+}
+
+// copyFile copies sourcePath to destPath and returns the SHA-256 of the contents.
+func copyFile(ctx context.Context, sourcePath, destPath string) (string, error) {
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer sourceFile.Close()
+	return getnew.WriteFile(ctx, destPath, sourceFile)
+}
+
+// unarchiveFetchedFile unpacks a file moved to dir and returns where its
+// contents went.
+func unarchiveFetchedFile(ctx context.Context, dir string, file fs.FileInfo) (string, error) {
+	if isRemoteDest(dir) {
+		return "", fmt.Errorf("cannot unarchive on a remote destination: %s", dir)
+	}
 
-	return fmt.Errorf("no recognized archive file found")
+	archive := filepath.Join(dir, file.Name())
+	root, err := getnew.Unpack(ctx, archive, findOptions())
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case len(extractOnly) > 0:
+		fmt.Fprintf(progressOut(), "Extracted %s from: %s\n", strings.Join(extractOnly, ", "), file.Name())
+	case keepArchive:
+		fmt.Fprintf(progressOut(), "Unarchived %s into: %s\n", archive, root)
+	default:
+		fmt.Fprintf(progressOut(), "Unarchived and removed: %s\n", file.Name())
+	}
+	return root, nil
 }
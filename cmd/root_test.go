@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveFilesUnarchivesOnlyArchives(t *testing.T) {
+	useFakeClock(t)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	src := t.TempDir()
+	defer func(dest string, z bool) { destDir, unarchive = dest, z }(destDir, unarchive)
+	destDir, unarchive = t.TempDir(), true
+
+	var files []candidate
+	for name, data := range map[string]string{"notes.txt": "notes", "broken.zip": "not a zip"} {
+		path := filepath.Join(src, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, candidate{FileInfo: info, Source: localSource{Dir: src}})
+	}
+
+	err := moveFiles(context.Background(), files)
+	if err == nil || err.Error() != "1 moved file(s) were not finished" {
+		t.Errorf("moveFiles = %v, want only the broken archive reported, as moved but not finished", err)
+	}
+	for _, name := range []string{"notes.txt", "broken.zip"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); err != nil {
+			t.Errorf("%s not moved: %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(src); len(entries) != 0 {
+		t.Errorf("left in the source: %v", entries)
+	}
+}
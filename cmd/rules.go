@@ -0,0 +1,299 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	dryRun   bool
+	ruleName string
+	noRules  bool
+	// forcedRule is the rule named by --rule, used for every file.
+	forcedRule *rule
+)
+
+var sortAllCmd = &cobra.Command{
+	Use:   "sort-all [filter]",
+	Short: "Move every file in the source directory to the destination its rule names",
+	Long: `sort-all goes through the source directory and moves each file that matches
+one of the rules in the config file to that rule's destination. Files that no
+rule matches are left where they are. The first matching rule wins.
+
+Rules are listed under "rules" in the config file, either as "pattern -> dest"
+strings or as mappings:
+
+  rules:
+    - "*.pdf -> ~/Documents/papers"
+    - name: isos
+      match: "*.iso"
+      dest: ~/isos`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if err := sortAll(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sortAllCmd)
+	rootCmd.AddCommand(rulesCmd)
+	rulesCmd.AddCommand(rulesVerifyCmd)
+	sortAllCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show where files would go without moving them")
+	rootCmd.PersistentFlags().StringVar(&ruleName, "rule", "", "Send files to the destination of this named rule, whatever their names")
+	rootCmd.PersistentFlags().BoolVar(&noRules, "no-rules", false, "Ignore the rules in the config file")
+	rootCmd.RegisterFlagCompletionFunc("rule", completeRuleName)
+}
+
+func completeRuleName(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, r := range cfg.Rules {
+		if r.Name != "" {
+			names = append(names, r.Name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// applyRuleOverride looks up the rule named by --rule, whose destination
+// then replaces the default one.
+func applyRuleOverride(cfg *config) error {
+	forcedRule = nil
+	if ruleName == "" {
+		return nil
+	}
+	for i, r := range cfg.Rules {
+		if r.Name == ruleName {
+			forcedRule = &cfg.Rules[i]
+			destDir = expandHome(r.Dest)
+			return nil
+		}
+	}
+	return fmt.Errorf("no rule named %q in %s", ruleName, configPath())
+}
+
+// rule routes files whose names match Match into Dest.
+type rule struct {
+	Name  string `yaml:"name"`
+	Match string `yaml:"match"`
+	Dest  string `yaml:"dest"`
+	// Examples are file names this rule must route, and Not names it must
+	// not; see checkRuleExamples.
+	Examples []string `yaml:"examples"`
+	Not      []string `yaml:"not"`
+	// Then is a pipeline of steps run on each file once it is moved; see
+	// runPipeline.
+	Then []string `yaml:"then"`
+}
+
+// UnmarshalYAML accepts the short "pattern -> dest" form, optionally followed
+// by "-> step" for each step of a pipeline, as well as a mapping.
+func (r *rule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		parts := strings.Split(node.Value, "->")
+		if len(parts) < 2 {
+			return fmt.Errorf("line %d: rule %q should look like 'pattern -> dest'", node.Line, node.Value)
+		}
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		r.Match, r.Dest, r.Then = parts[0], parts[1], parts[2:]
+		return nil
+	}
+	type plain rule
+	return node.Decode((*plain)(r))
+}
+
+// findRule returns the first configured rule matching name, or nil. --rule
+// and --no-rules override the configured rules.
+func findRule(name string) *rule {
+	switch {
+	case noRules:
+		return nil
+	case forcedRule != nil:
+		return forcedRule
+	}
+	if i := firstRule(appConfig.Rules, name); i >= 0 {
+		return &appConfig.Rules[i]
+	}
+	return nil
+}
+
+// notifyRule tells notifiers that ask for rule events that r routed file to
+// dest.
+func notifyRule(r *rule, file candidate, dest string) {
+	name := r.Name
+	if name == "" {
+		name = r.Match
+	}
+	notify(event{Type: eventRule, Rule: name, Name: file.Name(), Source: file.Source.Location(file.Name()), Dest: dest, Size: file.Size()})
+}
+
+// firstRule returns the index of the first rule matching name, or -1.
+func firstRule(rules []rule, name string) int {
+	for i, r := range rules {
+		if r.Match != "" && matchesFilter(name, r.Match) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ruleLabel names the ith rule in messages.
+func ruleLabel(r rule, i int) string {
+	if r.Name != "" {
+		return fmt.Sprintf("rule %q", r.Name)
+	}
+	return fmt.Sprintf("rule %d (%s)", i+1, r.Match)
+}
+
+// checkRuleExamples tries every rule's examples against the whole rule list,
+// so an earlier rule that catches a later rule's example is caught too. It
+// returns a description of each broken expectation.
+func checkRuleExamples(rules []rule) []string {
+	var failures []string
+	for i, r := range rules {
+		for _, name := range r.Examples {
+			switch got := firstRule(rules, name); {
+			case got < 0:
+				failures = append(failures, fmt.Sprintf("%s: %s matches no rule", ruleLabel(r, i), name))
+			case got != i:
+				failures = append(failures, fmt.Sprintf("%s: %s goes to %s instead", ruleLabel(r, i), name, ruleLabel(rules[got], got)))
+			}
+		}
+		for _, name := range r.Not {
+			if firstRule(rules, name) == i {
+				failures = append(failures, fmt.Sprintf("%s: %s should not match it but does", ruleLabel(r, i), name))
+			}
+		}
+	}
+	return failures
+}
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Work with the rules in the config file",
+}
+
+var rulesVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check every rule against the example file names it lists",
+	Long: `verify checks the examples embedded in the rules. Each rule can list file
+names it must route ("examples") and names it must leave alone ("not"):
+
+  rules:
+    - name: papers
+      match: "*.pdf"
+      dest: ~/papers
+      examples: [thesis.pdf, "Report 2024.PDF"]
+      not: [thesis.pdf.part]
+
+The whole rule list is tried, so an example caught by an earlier rule fails.
+getnew refuses to load a config whose examples fail; verify lists them all.`,
+	Args: cobra.NoArgs,
+	// Run even when the config fails its own checks, to report why
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := verifyRules(); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func verifyRules() error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	checked := 0
+	for _, r := range cfg.Rules {
+		checked += len(r.Examples) + len(r.Not)
+	}
+	failures := checkRuleExamples(cfg.Rules)
+	for _, f := range failures {
+		fmt.Println("FAIL  " + f)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d rule example(s) failed", len(failures), checked)
+	}
+	fmt.Printf("%d rule example(s) passed\n", checked)
+	return nil
+}
+
+func sortAll(ctx context.Context) error {
+	if len(appConfig.Rules) == 0 {
+		return fmt.Errorf("no rules defined in %s", configPath())
+	}
+	files, err := settledCandidates(ctx)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r := findRule(file.Name())
+		if r == nil {
+			continue
+		}
+		dest := fileDestDir(expandHome(r.Dest), file.ModTime())
+		printResult(styledName(os.Stdout, file.Name())+" -> "+dest, filepath.Join(dest, file.Name()))
+		if dryRun {
+			for _, step := range r.Then {
+				fmt.Printf("  then %s\n", step)
+			}
+			continue
+		}
+		err := moveFromSource(ctx, file, filepath.Join(dest, file.Name()))
+		if err == nil {
+			notifyRule(r, file, filepath.Join(dest, file.Name()))
+			_, err = finishRule(ctx, r, dest, filepath.Join(dest, file.Name()))
+		}
+		if err != nil {
+			recordError("sort-all", file.Name(), err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) could not be moved", failed)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCheckRuleExamples(t *testing.T) {
+	rules := []rule{
+		{Name: "drafts", Match: "draft*", Dest: "~/drafts", Examples: []string{"draft-1.pdf"}},
+		{Name: "papers", Match: "*.pdf", Dest: "~/papers", Examples: []string{"thesis.pdf", "draft-2.pdf", "notes.txt"}, Not: []string{"thesis.pdf.part", "paper.pdf"}},
+	}
+	failures := checkRuleExamples(rules)
+	want := []string{
+		`rule "papers": draft-2.pdf goes to rule "drafts" instead`,
+		`rule "papers": notes.txt matches no rule`,
+		`rule "papers": paper.pdf should not match it but does`,
+	}
+	if strings.Join(failures, "\n") != strings.Join(want, "\n") {
+		t.Errorf("checkRuleExamples =\n%s\nwant\n%s", strings.Join(failures, "\n"), strings.Join(want, "\n"))
+	}
+
+	rules[1].Examples, rules[1].Not = []string{"thesis.pdf"}, []string{"thesis.pdf.part"}
+	if failures := checkRuleExamples(rules); len(failures) != 0 {
+		t.Errorf("passing examples reported %v", failures)
+	}
+}
+
+func TestRulePipeline(t *testing.T) {
+	var cfg config
+	if err := yaml.Unmarshal([]byte(`rules: ["*.tar.gz -> ~/tools -> extract -> chmod +x bin/* -> run touch installed"]`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	r := cfg.Rules[0]
+	if r.Match != "*.tar.gz" || r.Dest != "~/tools" || strings.Join(r.Then, "|") != "extract|chmod +x bin/*|run touch installed" {
+		t.Fatalf("parsed %+v", r)
+	}
+	if err := checkPipelines(cfg.Rules); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"unpack", "chmod +x", "chmod 999 x", "chmod u+z x", "run", "extract now"} {
+		if checkPipelines([]rule{{Match: "*", Then: []string{bad}}}) == nil {
+			t.Errorf("step %q accepted", bad)
+		}
+	}
+
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "tool/bin/tool", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hi"))
+	tw.Close()
+	gz.Close()
+	archive := filepath.Join(dir, "tool.tar.gz")
+	os.WriteFile(archive, buf.Bytes(), 0o644)
+
+	root, err := runPipeline(context.Background(), &r, archive)
+	if err != nil || root != filepath.Join(dir, "tool") {
+		t.Fatalf("runPipeline = %q, %v", root, err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "bin", "tool")); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("bin/tool mode = %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "installed")); err != nil {
+		t.Errorf("run step did not run in the unpacked directory: %v", err)
+	}
+}
+
+func TestChmodMode(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		from fs.FileMode
+		want fs.FileMode
+	}{
+		{"755", 0o600, 0o755},
+		{"+x", 0o644, 0o755},
+		{"u+x", 0o644, 0o744},
+		{"go-w", 0o666, 0o644},
+		{"u=rw,o=", 0o757, 0o650},
+	} {
+		if got, err := chmodMode(tt.spec, tt.from); err != nil || got != tt.want {
+			t.Errorf("chmodMode(%s, %o) = %o, %v, want %o", tt.spec, tt.from, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+// screenshotsOnly is --screenshots, or the shot command: look in the
+// system's screenshot folder and only at screenshots.
+var screenshotsOnly bool
+
+var shotRename string
+
+var shotCmd = &cobra.Command{
+	Use:   "shot [filter]",
+	Short: "Move the newest screenshot to the current directory",
+	Long: `shot looks in the folder this system saves screenshots to (the one set in
+the Screenshot app on macOS, Pictures/Screenshots on Windows and GNOME) and moves
+the newest screenshot, optionally renaming it with --rename. It is the same as
+getnew --screenshots.
+
+The rename template can use {name}, {stem}, {ext}, {date} and {time}.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if shotRename != "" {
+			renameTemplate = shotRename
+		}
+		err, fileinfo := moveNthNewestFile(ctx)
+		if isDuplicate(err) {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		if err != nil {
+			exitWithError(cmd, err)
+		}
+		updateStatus(ctx)
+		if err := finishMove(ctx, fileinfo, false); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shotCmd)
+	rootCmd.PersistentFlags().BoolVar(&screenshotsOnly, "screenshots", false, "Only consider screenshots, looking in the system's screenshot folder unless --source is given")
+	shotCmd.Flags().IntVarP(&nthNewest, "nth", "n", 1, "Nth newest screenshot to move")
+	shotCmd.Flags().StringVar(&shotRename, "rename", "", "Name the moved screenshot from this template, e.g. 'bug-{date}{ext}'")
+}
+
+// isScreenshot reports whether file is named the way screenshot tools name
+// theirs: "Screenshot 2025-01-02 at 10.11.12.png" on macOS ("Screen Shot" before
+// Mojave), "Screenshot from 2025-01-02 10-11-12.png" on GNOME,
+// "Screenshot_20250102_101112.png" from KDE's Spectacle and
+// "Screenshot 2025-01-02 101112.png" or "Screenshot (3).png" on Windows.
+func isScreenshot(file candidate) bool {
+	name := strings.ToLower(file.Name())
+	if !strings.HasPrefix(name, "screenshot") && !strings.HasPrefix(name, "screen shot") {
+		return false
+	}
+	return getnew.TypeOf(file) == "image"
+}
+
+// existingDirs keeps those of dirs that exist.
+func existingDirs(dirs []string) []string {
+	var found []string
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			found = append(found, dir)
+		}
+	}
+	return found
+}
@@ -0,0 +1,64 @@
+//go:build !darwin && !windows
+
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// screenshotDirs is Pictures/Screenshots, where GNOME saves since version
+// 42, and Pictures itself, where KDE's Spectacle and older GNOME save.
+func screenshotDirs(getenv func(string) string) []string {
+	pictures := xdgPicturesDir(getenv)
+	return existingDirs([]string{filepath.Join(pictures, "Screenshots"), pictures})
+}
+
+// xdgPicturesDir reads XDG_PICTURES_DIR from user-dirs.dirs, which desktops
+// translate and users can move, falling back to ~/Pictures.
+func xdgPicturesDir(getenv func(string) string) string {
+	home := getenv("HOME")
+	config := getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		config = filepath.Join(home, ".config")
+	}
+	if f, err := os.Open(filepath.Join(config, "user-dirs.dirs")); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "XDG_PICTURES_DIR=")
+			if !ok {
+				continue
+			}
+			value = strings.ReplaceAll(strings.Trim(value, `"`), "$HOME", home)
+			if value != "" && value != home {
+				return value
+			}
+		}
+	}
+	return filepath.Join(home, "Pictures")
+}
@@ -0,0 +1,47 @@
+//go:build windows
+
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// screenshotDirs is the Screenshots known folder, where Win+PrtScn and the
+// Snipping Tool save, along with OneDrive's, which it replaces when
+// OneDrive backs up screenshots.
+func screenshotDirs(getenv func(string) string) []string {
+	var dirs []string
+	if dir, err := windows.KnownFolderPath(windows.FOLDERID_Screenshots, 0); err == nil && dir != "" {
+		dirs = append(dirs, dir)
+	} else {
+		dirs = append(dirs, filepath.Join(getenv("USERPROFILE"), "Pictures", "Screenshots"))
+	}
+	if onedrive := getenv("OneDrive"); onedrive != "" {
+		dirs = append(dirs, filepath.Join(onedrive, "Pictures", "Screenshots"))
+	}
+	return existingDirs(dirs)
+}
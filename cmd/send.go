@@ -0,0 +1,131 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var sendCmd = &cobra.Command{
+	Use:   "send <file> [rule|profile|dir]",
+	Short: "Move a file from here back into the source directory, or elsewhere",
+	Long: `send is the reverse of getnew: it moves a file from the current directory into
+the source directory. Name a rule or a media profile to send it to that rule's
+or profile's destination instead, or give any directory.
+
+The move goes into the history like any other, so putback can undo it. An
+existing file of the same name is never overwritten.`,
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: completeSend,
+	Run: func(cmd *cobra.Command, args []string) {
+		target := ""
+		if len(args) > 1 {
+			target = args[1]
+		}
+		if err := sendFile(cmd.Context(), args[0], target); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sendCmd)
+}
+
+func completeSend(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 1 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+	var names []string
+	for _, r := range cfg.Rules {
+		if r.Name != "" {
+			names = append(names, r.Name)
+		}
+	}
+	for _, m := range cfg.Media {
+		names = append(names, m.Name)
+	}
+	return names, cobra.ShellCompDirectiveFilterDirs
+}
+
+// sendTarget resolves the directory named by send's second argument: a
+// rule, a media profile or a directory, defaulting to the first source.
+func sendTarget(target string) (string, error) {
+	if target == "" {
+		if len(sources) == 0 {
+			return "", fmt.Errorf("no source directory to send to")
+		}
+		dir, ok := sourceDir(sources[0])
+		if !ok {
+			return "", fmt.Errorf("send needs a local source directory, not %s", sources[0].Location(""))
+		}
+		return dir, nil
+	}
+	for _, r := range appConfig.Rules {
+		if r.Name == target {
+			return expandHome(r.Dest), nil
+		}
+	}
+	for _, m := range appConfig.Media {
+		if m.Name == target && m.Dest != "" {
+			return expandHome(m.Dest), nil
+		}
+	}
+	info, err := os.Stat(target)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%s is not a rule, media profile or directory", target)
+	}
+	return target, nil
+}
+
+func sendFile(ctx context.Context, path, target string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	dir, err := sendTarget(target)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(fileDestDir(dir, info.ModTime()), info.Name())
+	if _, err := os.Lstat(dest); err == nil {
+		return getnew.WithKind(fmt.Errorf("%s already exists", dest), getnew.ErrConflict)
+	}
+	if err := transferFile(ctx, path, dest); err != nil {
+		return err
+	}
+	printResult(styledName(os.Stdout, info.Name())+" -> "+filepath.Dir(dest), dest)
+	return nil
+}
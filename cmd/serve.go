@@ -0,0 +1,624 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen string
+	serveToken  string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run an HTTP API that accepts files and drives moves from other machines",
+	Long: `serve listens for HTTP requests so other machines can push files into getnew.
+An upload is sent in chunks, each checked against its SHA-256, and the whole
+file is checked again before it is accepted. Accepted files are handled as if
+they had just appeared in a watched source directory: rules route them, they
+are recorded in the history and, with --unarchive, unpacked.
+
+Scripts can also list the candidates in the sources (GET /candidates), move
+one of them (POST /move) and read the history (GET /history), with the same
+options the command line was started with.
+
+Set a bearer token with --token or GETNEW_SERVE_TOKEN before listening on
+anything other than localhost. Without one, only requests addressed to a
+local host name and not sent from another site's web page are served.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := serve(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1:8765", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "Bearer token clients must send (default $GETNEW_SERVE_TOKEN)")
+}
+
+func serve(ctx context.Context) error {
+	if serveToken == "" {
+		serveToken = os.Getenv("GETNEW_SERVE_TOKEN")
+	}
+	dir, err := uploadsDir()
+	if err != nil {
+		return err
+	}
+	if host, _, err := net.SplitHostPort(serveListen); err == nil && serveToken == "" && !isLoopback(host) {
+		return fmt.Errorf("refusing to listen on %s without --token", serveListen)
+	}
+
+	server := &http.Server{Addr: serveListen, Handler: newServeHandler(ctx, dir, serveToken)}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", serveListen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func uploadsDir() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "uploads")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+	return dir, nil
+}
+
+// upload is an upload in progress. The data is written to dir/<id>/<name>
+// and its description kept next to it in dir/<id>.json, so uploads can be
+// resumed after a restart.
+type upload struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Received int64     `json:"received"`
+	Started  time.Time `json:"started"`
+}
+
+type uploadServer struct {
+	ctx   context.Context
+	dir   string
+	token string
+
+	mu   sync.Mutex      // held while upload records are read or changed
+	busy map[string]bool // uploads with a chunk or completion under way
+	// accept hands a completed file to the rules; tests replace it
+	accept func(ctx context.Context, file candidate) error
+
+	moveMu sync.Mutex // moves share the command line's state, so one at a time
+	// find lists the candidates and move moves one, returning where it
+	// ended up; tests replace them
+	find func(ctx context.Context) ([]candidate, error)
+	move func(ctx context.Context, file candidate) (string, error)
+}
+
+func newServeHandler(ctx context.Context, dir, token string) http.Handler {
+	s := &uploadServer{ctx: ctx, dir: dir, token: token, accept: moveArrivedFile,
+		find: settledCandidates, move: serveMove}
+	return s.authorize(serveMux(s))
+}
+
+// serveMove moves a file as the command line would, unarchiving it and
+// running the forced rule's pipeline if asked.
+func serveMove(ctx context.Context, file candidate) (string, error) {
+	info, err := moveToDest(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	return settleMove(ctx, info, false)
+}
+
+func serveMux(s *uploadServer) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /uploads", s.create)
+	mux.HandleFunc("GET /uploads/{id}", s.status)
+	mux.HandleFunc("PUT /uploads/{id}", s.chunk)
+	mux.HandleFunc("POST /uploads/{id}/complete", s.complete)
+	mux.HandleFunc("DELETE /uploads/{id}", s.cancel)
+	mux.HandleFunc("GET /candidates", s.candidates)
+	mux.HandleFunc("POST /move", s.moveOne)
+	mux.HandleFunc("GET /history", s.history)
+	return mux
+}
+
+// authorize checks the token. Without one, only requests addressed to a
+// loopback name are served, which stops DNS rebinding, and a browser's
+// Origin must match the host, which stops other sites posting to the API.
+func (s *uploadServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				httpError(w, http.StatusForbidden, "cross-origin requests are not allowed")
+				return
+			}
+		}
+		if s.token == "" {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			if !isLoopback(strings.Trim(host, "[]")) {
+				httpError(w, http.StatusForbidden, "host %q is not local; set --token to serve it", r.Host)
+				return
+			}
+		} else {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				httpError(w, http.StatusUnauthorized, "missing or wrong token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isJSON reports whether a request declares a JSON body. Requiring it means
+// a browser cannot send one from another site without a preflight.
+func isJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		httpError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+	return true
+}
+
+func httpError(w http.ResponseWriter, code int, format string, args ...any) {
+	writeJSON(w, code, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// create starts an upload from {"name", "size", "sha256"}.
+func (s *uploadServer) create(w http.ResponseWriter, r *http.Request) {
+	if !isJSON(w, r) {
+		return
+	}
+	var req upload
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	name := filepath.Base(req.Name)
+	if name != req.Name || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		httpError(w, http.StatusBadRequest, "invalid file name %q", req.Name)
+		return
+	}
+	req.SHA256 = strings.ToLower(req.SHA256)
+	if req.Size < 0 || !sha256Hex.MatchString(req.SHA256) {
+		httpError(w, http.StatusBadRequest, "size and a hex sha256 are required")
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		httpError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	up := &upload{ID: hex.EncodeToString(id), Name: name, Size: req.Size, SHA256: req.SHA256, Started: clk.Now()}
+	if err := os.MkdirAll(filepath.Join(s.dir, up.ID), 0o755); err != nil {
+		httpError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	f, err := os.Create(s.dataPath(up))
+	if err == nil {
+		err = f.Close()
+	}
+	if err == nil {
+		err = s.save(up)
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, up)
+}
+
+func (s *uploadServer) dataPath(up *upload) string {
+	return filepath.Join(s.dir, up.ID, up.Name)
+}
+
+func (s *uploadServer) save(up *upload) error {
+	return writeJSONFile(filepath.Join(s.dir, up.ID+".json"), up)
+}
+
+func (s *uploadServer) load(w http.ResponseWriter, r *http.Request) *upload {
+	id := r.PathValue("id")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		httpError(w, http.StatusNotFound, "no such upload")
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if err != nil {
+		httpError(w, http.StatusNotFound, "no such upload")
+		return nil
+	}
+	up := &upload{}
+	if err := json.Unmarshal(data, up); err != nil {
+		httpError(w, http.StatusInternalServerError, "corrupt upload record: %v", err)
+		return nil
+	}
+	return up
+}
+
+// claim loads an upload and marks it busy, so its file can be written or
+// checked without holding mu. Only one request works on an upload at a time.
+func (s *uploadServer) claim(w http.ResponseWriter, r *http.Request) *upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	up := s.load(w, r)
+	if up == nil {
+		return nil
+	}
+	if s.busy[up.ID] {
+		httpError(w, http.StatusConflict, "upload %s is busy", up.ID)
+		return nil
+	}
+	if s.busy == nil {
+		s.busy = make(map[string]bool)
+	}
+	s.busy[up.ID] = true
+	return up
+}
+
+func (s *uploadServer) release(up *upload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.busy, up.ID)
+}
+
+// status reports how much has been received, so a client can resume.
+func (s *uploadServer) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if up := s.load(w, r); up != nil {
+		writeJSON(w, http.StatusOK, up)
+	}
+}
+
+var contentRange = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+
+// chunk appends the request body at the offset given by Content-Range. The
+// chunk must start where the previous one ended and, if X-Chunk-SHA256 is
+// set, match it.
+func (s *uploadServer) chunk(w http.ResponseWriter, r *http.Request) {
+	up := s.claim(w, r)
+	if up == nil {
+		return
+	}
+	defer s.release(up)
+
+	m := contentRange.FindStringSubmatch(r.Header.Get("Content-Range"))
+	if m == nil {
+		httpError(w, http.StatusBadRequest, "Content-Range: bytes start-end/total is required")
+		return
+	}
+	start, _ := strconv.ParseInt(m[1], 10, 64)
+	end, _ := strconv.ParseInt(m[2], 10, 64)
+	total, _ := strconv.ParseInt(m[3], 10, 64)
+	if total != up.Size || end < start || end >= total {
+		httpError(w, http.StatusRequestedRangeNotSatisfiable, "range %s does not fit a %d byte upload", m[0], up.Size)
+		return
+	}
+	if start != up.Received {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", up.Received-1))
+		httpError(w, http.StatusConflict, "expected a chunk starting at %d", up.Received)
+		return
+	}
+
+	// Stream the chunk into place, hashing it on the way; it only counts as
+	// received once it has been checked
+	f, err := os.OpenFile(s.dataPath(up), os.O_WRONLY, 0)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to store chunk: %v", err)
+		return
+	}
+	defer f.Close()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(io.NewOffsetWriter(f, start), hash), io.LimitReader(r.Body, end-start+2))
+	if err != nil {
+		f.Truncate(start)
+		httpError(w, http.StatusBadRequest, "failed to read chunk: %v", err)
+		return
+	}
+	if n != end-start+1 {
+		f.Truncate(start)
+		httpError(w, http.StatusBadRequest, "chunk is at least %d bytes, Content-Range says %d", n, end-start+1)
+		return
+	}
+	if want := strings.ToLower(r.Header.Get("X-Chunk-SHA256")); want != "" && hex.EncodeToString(hash.Sum(nil)) != want {
+		f.Truncate(start)
+		httpError(w, http.StatusUnprocessableEntity, "chunk checksum mismatch")
+		return
+	}
+	if err := f.Close(); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to store chunk: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	up.Received = end + 1
+	err = s.save(up)
+	s.mu.Unlock()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to store chunk: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, up)
+}
+
+// complete checks the whole file against the checksum given when the upload
+// was created and hands it to the rules.
+func (s *uploadServer) complete(w http.ResponseWriter, r *http.Request) {
+	up := s.claim(w, r)
+	if up == nil {
+		return
+	}
+	defer s.release(up)
+	if up.Received != up.Size {
+		httpError(w, http.StatusConflict, "only %d of %d bytes received", up.Received, up.Size)
+		return
+	}
+
+	path := s.dataPath(up)
+	f, err := os.Open(path)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	f.Close()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != up.SHA256 {
+		s.remove(up)
+		httpError(w, http.StatusUnprocessableEntity, "checksum mismatch, upload discarded")
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		err = s.accept(s.ctx, candidate{FileInfo: info, Source: localSource{Dir: filepath.Dir(path)}})
+	}
+	if err != nil {
+		recordError("serve", up.Name, err)
+		httpError(w, http.StatusInternalServerError, "failed to accept %s: %v", up.Name, err)
+		return
+	}
+	s.remove(up)
+	writeJSON(w, http.StatusOK, up)
+}
+
+func (s *uploadServer) cancel(w http.ResponseWriter, r *http.Request) {
+	if up := s.claim(w, r); up != nil {
+		s.remove(up)
+		s.release(up)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *uploadServer) remove(up *upload) {
+	os.RemoveAll(filepath.Join(s.dir, up.ID))
+	os.Remove(filepath.Join(s.dir, up.ID+".json"))
+}
+
+// candidateInfo describes a candidate in the API's responses.
+type candidateInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Dir      bool      `json:"dir,omitempty"`
+	Source   string    `json:"source"`
+}
+
+// matching lists the candidates whose names match filter, newest first.
+func (s *uploadServer) matching(filter string) ([]candidate, error) {
+	files, err := s.find(s.ctx)
+	if err != nil || filter == "" {
+		return files, err
+	}
+	var matched []candidate
+	for _, file := range files {
+		if matchesFilter(file.Name(), filter) {
+			matched = append(matched, file)
+		}
+	}
+	return matched, nil
+}
+
+// candidates lists the files a move could pick, optionally narrowed by
+// ?filter= and cut to ?limit=.
+func (s *uploadServer) candidates(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	files, err := s.matching(r.URL.Query().Get("filter"))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if limit > 0 && len(files) > limit {
+		files = files[:limit]
+	}
+	list := []candidateInfo{}
+	for _, file := range files {
+		list = append(list, candidateInfo{Name: file.Name(), Size: file.Size(), Modified: file.ModTime(),
+			Dir: file.IsDir(), Source: file.Source.Location("")})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// moveRequest picks the file to move as the command line does: the nth
+// newest (or with a negative nth, oldest) file matching filter.
+type moveRequest struct {
+	Filter string `json:"filter"`
+	Nth    int    `json:"nth"`
+}
+
+// moveOne moves the file picked by {"filter", "nth"} and reports where it went.
+func (s *uploadServer) moveOne(w http.ResponseWriter, r *http.Request) {
+	if !isJSON(w, r) {
+		return
+	}
+	var req moveRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httpError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	if req.Nth == 0 {
+		req.Nth = 1
+	}
+
+	s.moveMu.Lock()
+	defer s.moveMu.Unlock()
+	files, err := s.matching(req.Filter)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	file, err := selectNthNewest(files, req.Nth, req.Filter)
+	if err != nil {
+		httpError(w, moveStatus(err), "%v", err)
+		return
+	}
+	dest, err := s.move(s.ctx, file)
+	if err != nil {
+		recordError("serve", file.Name(), err)
+		httpError(w, moveStatus(err), "failed to move %s: %v", file.Name(), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"name": file.Name(), "source": file.Source.Location(file.Name()), "dest": dest})
+}
+
+// moveStatus is the HTTP status for a failed move.
+func moveStatus(err error) int {
+	switch {
+	case errors.Is(err, getnew.ErrNoCandidates), errors.Is(err, getnew.ErrNotEnoughFiles):
+		return http.StatusNotFound
+	case isDuplicate(err), errors.Is(err, getnew.ErrConflict), errors.Is(err, getnew.ErrSourceGone):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// history returns the recorded moves, oldest first, optionally only those
+// after ?since= and only the last ?limit=.
+func (s *uploadServer) history(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	var since time.Time
+	if spec := r.URL.Query().Get("since"); spec != "" {
+		if since, err = parseTimeSpec(spec, clk.Now()); err != nil {
+			httpError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+	entries, err := loadHistory()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	recent := []historyEntry{}
+	for _, entry := range entries {
+		if !entry.Time.Before(since) {
+			recent = append(recent, entry)
+		}
+	}
+	if limit > 0 && len(recent) > limit {
+		recent = recent[len(recent)-limit:]
+	}
+	writeJSON(w, http.StatusOK, recent)
+}
+
+func queryLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid limit %q", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,254 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sha256Of(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestServeUpload(t *testing.T) {
+	var accepted []byte
+	srv := &uploadServer{ctx: context.Background(), dir: t.TempDir(), token: "secret"}
+	srv.accept = func(ctx context.Context, file candidate) error {
+		if !srv.mu.TryLock() {
+			t.Error("file accepted while holding the upload lock")
+		} else {
+			srv.mu.Unlock()
+		}
+		data, err := os.ReadFile(filepath.Join(file.Source.Location(""), file.Name()))
+		accepted = data
+		return err
+	}
+	ts := httptest.NewServer(srv.authorize(serveMux(srv)))
+	defer ts.Close()
+
+	content := []byte("hello, uploaded world")
+	do := func(method, path string, body []byte, headers ...string) (*http.Response, upload) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var up upload
+		json.NewDecoder(resp.Body).Decode(&up)
+		return resp, up
+	}
+
+	create, _ := json.Marshal(upload{Name: "notes.txt", Size: int64(len(content)), SHA256: sha256Of(content)})
+	resp, up := do("POST", "/uploads", create, "Content-Type", "application/json")
+	if resp.StatusCode != http.StatusCreated || up.ID == "" {
+		t.Fatalf("create = %s", resp.Status)
+	}
+
+	chunk := func(start, end int, sum string) *http.Response {
+		resp, _ := do("PUT", "/uploads/"+up.ID, content[start:end+1],
+			"Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)), "X-Chunk-SHA256", sum)
+		return resp
+	}
+	if resp := chunk(0, 9, sha256Of(content[:10])); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first chunk = %s", resp.Status)
+	}
+	if resp := chunk(15, 20, sha256Of(content[15:])); resp.StatusCode != http.StatusConflict {
+		t.Errorf("out-of-order chunk = %s, want 409", resp.Status)
+	}
+	if resp := chunk(10, 20, sha256Of([]byte("wrong"))); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("corrupt chunk = %s, want 422", resp.Status)
+	}
+	if resp, _ := do("PUT", "/uploads/"+up.ID, content[10:],
+		"Content-Range", fmt.Sprintf("bytes 10-14/%d", len(content))); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("oversized chunk = %s, want 400", resp.Status)
+	}
+	if resp, _ := do("POST", "/uploads/"+up.ID+"/complete", nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("early complete = %s, want 409", resp.Status)
+	}
+	if resp := chunk(10, 20, sha256Of(content[10:])); resp.StatusCode != http.StatusOK {
+		t.Fatalf("second chunk = %s", resp.Status)
+	}
+	if resp, up := do("GET", "/uploads/"+up.ID, nil); resp.StatusCode != http.StatusOK || up.Received != int64(len(content)) {
+		t.Errorf("status = %s, received %d", resp.Status, up.Received)
+	}
+	if resp, _ := do("POST", "/uploads/"+up.ID+"/complete", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("complete = %s", resp.Status)
+	}
+	if !bytes.Equal(accepted, content) {
+		t.Errorf("accepted %q, want %q", accepted, content)
+	}
+	if resp, _ := do("GET", "/uploads/"+up.ID, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("finished upload still present: %s", resp.Status)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/uploads/"+up.ID, nil)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request without token = %v, %v", resp.Status, err)
+	}
+}
+
+func TestServeAPI(t *testing.T) {
+	useFakeClock(t)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	if err := saveHistory([]historyEntry{
+		{Name: "old.txt", Time: testEpoch.Add(-48 * time.Hour)},
+		{Name: "new.txt", Time: testEpoch.Add(-time.Hour)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	src := t.TempDir()
+	var files []candidate
+	for i, name := range []string{"report.pdf", "notes.txt", "draft.txt"} {
+		path := filepath.Join(src, name)
+		os.WriteFile(path, []byte(name), 0o644)
+		os.Chtimes(path, testEpoch, testEpoch.Add(-time.Duration(i)*time.Minute))
+		info, _ := os.Stat(path)
+		files = append(files, candidate{FileInfo: info, Source: localSource{Dir: src}})
+	}
+	var moved []string
+	srv := &uploadServer{ctx: context.Background(), dir: t.TempDir(), token: "secret",
+		find: func(context.Context) ([]candidate, error) { return files, nil },
+		move: func(ctx context.Context, file candidate) (string, error) {
+			moved = append(moved, file.Name())
+			return "/dest/" + file.Name(), nil
+		}}
+	ts := httptest.NewServer(srv.authorize(serveMux(srv)))
+	defer ts.Close()
+
+	do := func(method, path, body string, v any) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+
+	var list []candidateInfo
+	if code := do("GET", "/candidates?filter=txt", "", &list); code != http.StatusOK || len(list) != 2 || list[0].Name != "notes.txt" {
+		t.Errorf("candidates = %d, %+v", code, list)
+	}
+	if code := do("GET", "/candidates?limit=1", "", &list); code != http.StatusOK || len(list) != 1 {
+		t.Errorf("limited candidates = %d, %+v", code, list)
+	}
+
+	var result map[string]string
+	if code := do("POST", "/move", `{"filter":"txt","nth":2}`, &result); code != http.StatusOK || result["dest"] != "/dest/draft.txt" {
+		t.Errorf("move = %d, %v", code, result)
+	}
+	if code := do("POST", "/move", `{"filter":"zip"}`, nil); code != http.StatusNotFound {
+		t.Errorf("move with no match = %d, want 404", code)
+	}
+	if code := do("POST", "/move", `{"nth":5}`, nil); code != http.StatusNotFound {
+		t.Errorf("move past the end = %d, want 404", code)
+	}
+	if len(moved) != 1 || moved[0] != "draft.txt" {
+		t.Errorf("moved %v", moved)
+	}
+
+	var entries []historyEntry
+	if code := do("GET", "/history?since=1d", "", &entries); code != http.StatusOK || len(entries) != 1 || entries[0].Name != "new.txt" {
+		t.Errorf("history = %d, %+v", code, entries)
+	}
+	if code := do("GET", "/history?limit=x", "", nil); code != http.StatusBadRequest {
+		t.Errorf("bad limit = %d, want 400", code)
+	}
+}
+
+func TestServeRejectsCrossSite(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0o644)
+	info, _ := os.Stat(filepath.Join(src, "a.txt"))
+	var moved int
+	srv := &uploadServer{ctx: context.Background(), dir: t.TempDir(),
+		find: func(context.Context) ([]candidate, error) {
+			return []candidate{{FileInfo: info, Source: localSource{Dir: src}}}, nil
+		},
+		move: func(ctx context.Context, file candidate) (string, error) {
+			moved++
+			return "/dest/" + file.Name(), nil
+		}}
+	ts := httptest.NewServer(srv.authorize(serveMux(srv)))
+	defer ts.Close()
+
+	post := func(host string, headers ...string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+"/move", strings.NewReader(`{}`))
+		if host != "" {
+			req.Host = host
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post("", "Content-Type", "text/plain"); code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain move = %d, want 415", code)
+	}
+	if code := post("", "Content-Type", "application/json", "Origin", "https://evil.example"); code != http.StatusForbidden {
+		t.Errorf("cross-origin move = %d, want 403", code)
+	}
+	if code := post("evil.example:8765", "Content-Type", "application/json"); code != http.StatusForbidden {
+		t.Errorf("rebound host move = %d, want 403", code)
+	}
+	if moved != 0 {
+		t.Fatalf("%d file(s) moved by rejected requests", moved)
+	}
+	if code := post("localhost:8765", "Content-Type", "application/json; charset=utf-8"); code != http.StatusOK || moved != 1 {
+		t.Errorf("local move = %d, moved %d", code, moved)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var signatureMode string
+
+// checksumFiles are the shared checksum lists looked for next to a download.
+var checksumFiles = []string{"SHA256SUMS", "sha256sums.txt"}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&signatureMode, "verify-signature", "", "Check a .sig, .asc, .sha256 or SHA256SUMS file next to each download before moving it, and refuse (refuse) or only warn (warn) on a mismatch")
+	rootCmd.PersistentFlags().Lookup("verify-signature").NoOptDefVal = "refuse"
+}
+
+// checkSignature verifies file against any companion signature or checksum
+// file beside it with --verify-signature, and returns the companions that
+// should travel with it. Files from remote sources are not checked.
+func checkSignature(ctx context.Context, file candidate) ([]string, error) {
+	dir, ok := sourceDir(file.Source)
+	if signatureMode == "" || !ok || file.IsDir() {
+		return nil, nil
+	}
+	path := filepath.Join(dir, file.Name())
+
+	var companions []string
+	var problem error
+	checked := false
+	for _, ext := range []string{".sig", ".asc"} {
+		sig := path + ext
+		if _, err := os.Stat(sig); err != nil {
+			continue
+		}
+		companions = append(companions, sig)
+		checked = true
+		if err := gpgVerify(ctx, sig, path); err != nil && problem == nil {
+			problem = err
+		}
+	}
+
+	sum, err := expectedChecksum(path)
+	if err != nil {
+		return nil, err
+	}
+	if sum.want != "" {
+		companions = append(companions, sum.from)
+		checked = true
+		got, err := hashCandidate(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		if got != sum.want && problem == nil {
+			problem = fmt.Errorf("SHA-256 of %s does not match %s", file.Name(), filepath.Base(sum.from))
+		}
+	}
+
+	switch {
+	case problem != nil && signatureMode == "warn":
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", problem)
+	case problem != nil:
+		return nil, fmt.Errorf("not moved: %w", problem)
+	case checked:
+		fmt.Fprintf(os.Stderr, "Verified: %s\n", file.Name())
+	}
+	return companions, nil
+}
+
+// isCompanion reports whether file is a signature or checksum file, which
+// --verify-signature leaves out of the candidates as they move along with
+// the downloads they belong to.
+func isCompanion(file candidate) bool {
+	dir, ok := sourceDir(file.Source)
+	if !ok {
+		return false
+	}
+	if slices.Contains(checksumFiles, file.Name()) {
+		return true
+	}
+	switch ext := filepath.Ext(file.Name()); ext {
+	case ".sig", ".asc", ".sha256":
+		_, err := os.Stat(filepath.Join(dir, strings.TrimSuffix(file.Name(), ext)))
+		return err == nil
+	}
+	return false
+}
+
+func gpgVerify(ctx context.Context, sig, path string) error {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return fmt.Errorf("gpg is needed to check %s", filepath.Base(sig))
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gpg", "--batch", "--verify", sig, path)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("bad signature %s: %s", filepath.Base(sig), gpgReason(stderr.String()))
+	}
+	return nil
+}
+
+// gpgReason picks the line explaining a failure out of gpg's output, skipping
+// the lines describing the signature.
+func gpgReason(out string) string {
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "gpg:"))
+		if line != "" && !strings.HasPrefix(line, "Signature made") && !strings.HasPrefix(line, "using ") {
+			return line
+		}
+	}
+	return "gpg failed"
+}
+
+// checksum is the expected SHA-256 of a file and the file it came from.
+type checksum struct {
+	want, from string
+}
+
+// expectedChecksum looks for path's SHA-256 in path.sha256 or in a shared
+// checksum list in the same directory.
+func expectedChecksum(path string) (checksum, error) {
+	name := filepath.Base(path)
+	own := path + ".sha256"
+	if want, err := readChecksum(own, name, true); err != nil || want != "" {
+		return checksum{want: want, from: own}, err
+	}
+	for _, list := range checksumFiles {
+		from := filepath.Join(filepath.Dir(path), list)
+		if want, err := readChecksum(from, name, false); err != nil || want != "" {
+			return checksum{want: want, from: from}, err
+		}
+	}
+	return checksum{}, nil
+}
+
+// readChecksum finds name in a checksum file in the format written by
+// sha256sum, or the BSD format of shasum --tag. A bare hash is accepted when
+// the file is for name alone.
+func readChecksum(path, name string, alone bool) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if rest, ok := strings.CutPrefix(line, "SHA256 ("); ok {
+			if file, sum, ok := strings.Cut(rest, ") = "); ok && file == name {
+				return strings.ToLower(sum), nil
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1 && alone:
+			return strings.ToLower(fields[0]), nil
+		case len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name:
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", nil
+}
+
+// carryCompanions moves a download's signature and checksum files into dir
+// after it, skipping any already moved. Shared checksum lists are copied, as
+// other downloads may still need them.
+func carryCompanions(ctx context.Context, companions []string, dir string) {
+	for _, path := range companions {
+		dest := filepath.Join(dir, filepath.Base(path))
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		var err error
+		if slices.Contains(checksumFiles, filepath.Base(path)) {
+			if _, statErr := os.Stat(dest); isRemoteDest(dir) || statErr == nil {
+				continue
+			}
+			_, err = copyFile(ctx, path, dest)
+		} else {
+			err = transferFile(ctx, path, dest)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to move %s: %v\n", filepath.Base(path), err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		spec string
+		want int64
+	}{
+		{"500", 500},
+		{"10K", 10 << 10},
+		{"10kb", 10 << 10},
+		{"1.5M", 3 << 19},
+		{"2G", 2 << 30},
+		{"1T", 1 << 40},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.spec)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.spec, got, err, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "big", "-1M", "1X", "M"} {
+		if _, err := parseSize(spec); err == nil {
+			t.Errorf("parseSize(%q) succeeded, want error", spec)
+		}
+	}
+}
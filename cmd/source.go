@@ -0,0 +1,323 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+// source is a place candidate files are listed, read and removed from.
+type source = getnew.SourceBackend
+
+// sources are the sources selected by --source or GETNEW_SOURCE_DIR, which
+// are searched together.
+var sources []source
+
+// candidate is a listed file together with the source it came from.
+type candidate = getnew.Candidate
+
+func openSources(specs []string) ([]source, error) {
+	var opened []source
+	for _, spec := range specs {
+		s, err := openSource(spec)
+		if err != nil {
+			return nil, err
+		}
+		opened = append(opened, withIndex(s))
+	}
+	return opened, nil
+}
+
+func init() {
+	getnew.RegisterBackend("sftp", newSFTPSource)
+	getnew.RegisterBackend("ssh", newSSHSource)
+	getnew.RegisterBackend("http", newHTTPSource)
+	getnew.RegisterBackend("https", newHTTPSource)
+}
+
+func openSource(spec string) (source, error) {
+	return getnew.OpenSource(spec)
+}
+
+// localDirs returns the directories of the sources, for features that need to
+// watch or address the files directly and so only work with local sources.
+func localDirs(feature string) ([]string, error) {
+	var dirs []string
+	for _, s := range sources {
+		dir, ok := sourceDir(s)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a local source directory, not %s", feature, s.Location(""))
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// sourceDir returns the directory of a local source, indexed or not.
+func sourceDir(s source) (string, bool) {
+	switch s := s.(type) {
+	case localSource:
+		return s.Dir, true
+	case *indexedSource:
+		return s.Dir, true
+	}
+	return "", false
+}
+
+// splitSourceList splits a list of sources joined with the path list
+// separator, keeping URLs such as sftp://host:22/dir in one piece.
+func splitSourceList(list string) []string {
+	parts := filepath.SplitList(list)
+	var specs []string
+	for i := 0; i < len(parts); i++ {
+		spec := parts[i]
+		if i+1 < len(parts) && isURLScheme(spec) && strings.HasPrefix(parts[i+1], "//") {
+			i++
+			spec += ":" + parts[i]
+			// A port number continues the same URL
+			if i+1 < len(parts) && parts[i+1] != "" && parts[i+1][0] >= '0' && parts[i+1][0] <= '9' && !strings.Contains(spec[strings.Index(spec, "//")+2:], "/") {
+				i++
+				spec += ":" + parts[i]
+			}
+		}
+		if spec != "" {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+func isURLScheme(s string) bool {
+	switch s {
+	case "sftp", "ssh", "http", "https", "browser", "imap", "imaps", "slack", "gdrive":
+		return true
+	}
+	return false
+}
+
+type localSource = getnew.LocalSource
+
+// sshSource reaches a directory on another machine by running commands over
+// ssh, so the user's agent, config and known_hosts all apply as usual. The
+// remote side needs a POSIX shell and GNU find; sftpSource needs neither.
+type sshSource struct {
+	scheme string
+	target string // [user@]host
+	port   string
+	dir    string
+}
+
+func newSSHSource(spec string) (source, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %q: %w", spec, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid source %q: missing host", spec)
+	}
+	s := &sshSource{scheme: u.Scheme, target: u.Hostname(), port: u.Port(), dir: u.Path}
+	if u.User != nil {
+		s.target = u.User.Username() + "@" + s.target
+	}
+	// sftp://host/~/out means a path relative to the remote home directory
+	if s.dir == "/~" || strings.HasPrefix(s.dir, "/~/") {
+		s.dir = "." + strings.TrimPrefix(s.dir, "/~")
+	}
+	if s.dir == "" {
+		s.dir = "."
+	}
+	return s, nil
+}
+
+func (s *sshSource) command(ctx context.Context, remote string) *exec.Cmd {
+	args := []string{}
+	if s.port != "" {
+		args = append(args, "-p", s.port)
+	}
+	args = append(args, s.target, "--", remote)
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+const sshFindFormat = `'%y %s %T@ %f\n'`
+
+func (s *sshSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	out, err := s.command(ctx, "find "+shellQuote(s.dir)+" -mindepth 1 -maxdepth 1 -printf "+sshFindFormat).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.Location(""), err)
+	}
+	return parseFindOutput(out)
+}
+
+func (s *sshSource) Stat(name string) (fs.FileInfo, error) {
+	out, err := s.command(context.Background(), "find "+shellQuote(path.Join(s.dir, name))+" -maxdepth 0 -printf "+sshFindFormat).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", s.Location(name), err)
+	}
+	infos, err := parseFindOutput(out)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) != 1 {
+		return nil, fmt.Errorf("failed to stat %s: %w", s.Location(name), fs.ErrNotExist)
+	}
+	return infos[0], nil
+}
+
+func (s *sshSource) Open(name string) (io.ReadCloser, error) {
+	cmd := s.command(context.Background(), "cat -- "+shellQuote(path.Join(s.dir, name)))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run ssh: %w", err)
+	}
+	return &commandReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// OpenAt opens name with its first offset bytes skipped, to resume a copy.
+func (s *sshSource) OpenAt(name string, offset int64) (io.ReadCloser, error) {
+	cmd := s.command(context.Background(), fmt.Sprintf("tail -c +%d -- %s", offset+1, shellQuote(path.Join(s.dir, name))))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run ssh: %w", err)
+	}
+	return &commandReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (s *sshSource) Remove(name string) error {
+	if err := s.command(context.Background(), "rm -- "+shellQuote(path.Join(s.dir, name))).Run(); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", s.Location(name), err)
+	}
+	return nil
+}
+
+func (s *sshSource) Location(name string) string {
+	host := s.target
+	if s.port != "" {
+		host += ":" + s.port
+	}
+	dir := s.dir
+	if !strings.HasPrefix(dir, "/") {
+		dir = "/~/" + strings.TrimPrefix(dir, "./")
+	}
+	return s.scheme + "://" + host + path.Join(dir, name)
+}
+
+// commandReader reads a command's output; Close reports whether it succeeded.
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *commandReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// remoteFileInfo is file metadata reported by a remote listing.
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i remoteFileInfo) Name() string       { return i.name }
+func (i remoteFileInfo) Size() int64        { return i.size }
+func (i remoteFileInfo) ModTime() time.Time { return i.modTime }
+func (i remoteFileInfo) IsDir() bool        { return i.dir }
+func (i remoteFileInfo) Sys() any           { return nil }
+func (i remoteFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// safeRemoteName reports whether a name from a remote listing can be used
+// as it is for a file in the destination, holding no path separator and not
+// naming a directory.
+func safeRemoteName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// remoteFileName makes the name a service gives a file safe to use in the
+// destination, replacing path separators.
+func remoteFileName(name string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+	if name == "." || name == ".." {
+		return "_" + name
+	}
+	return name
+}
+
+// parseFindOutput reads lines of "type size mtime name" as printed by sshFindFormat.
+func parseFindOutput(out []byte) ([]fs.FileInfo, error) {
+	var infos []fs.FileInfo
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected listing line %q", scanner.Text())
+		}
+		secs, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected listing line %q", scanner.Text())
+		}
+		infos = append(infos, remoteFileInfo{
+			name:    fields[3],
+			size:    size,
+			modTime: time.Unix(0, int64(secs*float64(time.Second))),
+			dir:     fields[0] == "d",
+		})
+	}
+	return infos, scanner.Err()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
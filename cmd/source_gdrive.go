@@ -0,0 +1,398 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+func init() {
+	getnew.RegisterBackend("gdrive", newGDriveSource)
+}
+
+// Where Google's OAuth and Drive APIs are; tests point them elsewhere.
+var (
+	googleOAuth = "https://oauth2.googleapis.com/"
+	gdriveAPI   = "https://www.googleapis.com/drive/v3/"
+)
+
+const gdriveScope = "https://www.googleapis.com/auth/drive.readonly"
+
+// gdriveSource lists the files in a Google Drive folder, given as
+// gdrive://folder-id (the last part of the folder's URL, or root for My
+// Drive). It signs in with the OAuth device flow, using the client in
+// GETNEW_GDRIVE_CLIENT_ID and GETNEW_GDRIVE_CLIENT_SECRET, and keeps the
+// token in the state directory. Access is read-only, so files are
+// downloaded, not deleted from Drive.
+type gdriveSource struct {
+	folder       string
+	clientID     string
+	clientSecret string
+
+	mu    sync.Mutex
+	token *gdriveToken
+	files map[string]gdriveFile
+}
+
+// gdriveFile is a file as the Drive API describes it.
+type gdriveFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MimeType     string    `json:"mimeType"`
+	Size         int64     `json:"size,string"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+	WebViewLink  string    `json:"webViewLink"`
+}
+
+// gdriveToken is what the device flow signs in with, as kept between runs.
+type gdriveToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+func newGDriveSource(spec string) (source, error) {
+	folder := strings.Trim(spec[strings.Index(spec, "://")+3:], "/")
+	if folder == "" || strings.ContainsAny(folder, "/?") {
+		return nil, fmt.Errorf("invalid source %q: give it as gdrive://folder-id", spec)
+	}
+	s := &gdriveSource{
+		folder:       folder,
+		clientID:     os.Getenv("GETNEW_GDRIVE_CLIENT_ID"),
+		clientSecret: os.Getenv("GETNEW_GDRIVE_CLIENT_SECRET"),
+	}
+	if s.clientID == "" || s.clientSecret == "" {
+		return nil, fmt.Errorf("%s needs an OAuth client in GETNEW_GDRIVE_CLIENT_ID and GETNEW_GDRIVE_CLIENT_SECRET", spec)
+	}
+	return s, nil
+}
+
+func gdriveTokenPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gdrive-token.json"), nil
+}
+
+// accessToken returns a token that is good for a while yet, refreshing it
+// or signing in afresh as needed.
+func (s *gdriveSource) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == nil {
+		if path, err := gdriveTokenPath(); err == nil {
+			if data, err := os.ReadFile(path); err == nil {
+				var token gdriveToken
+				if json.Unmarshal(data, &token) == nil {
+					s.token = &token
+				}
+			}
+		}
+	}
+	if s.token != nil && clk.Now().Add(time.Minute).Before(s.token.Expiry) {
+		return s.token.AccessToken, nil
+	}
+
+	var token *gdriveToken
+	var err error
+	if s.token != nil && s.token.RefreshToken != "" {
+		token, err = s.refresh(ctx, s.token.RefreshToken)
+	}
+	if token == nil {
+		// Never signed in, or the refresh token was revoked
+		if token, err = s.signIn(ctx); err != nil {
+			return "", err
+		}
+	}
+	s.token = token
+	path, err := gdriveTokenPath()
+	if err == nil {
+		data, _ := json.Marshal(token)
+		err = os.WriteFile(path, data, 0o600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save the Google Drive token: %v\n", err)
+	}
+	return token.AccessToken, nil
+}
+
+// tokenReply is the token endpoint's answer, successful or not.
+type tokenReply struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// postForm posts form to one of Google's OAuth endpoints and decodes the
+// reply, which for an error carries error and error_description.
+func postForm(ctx context.Context, endpoint string, form url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleOAuth+endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "getnew")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+func (s *gdriveSource) refresh(ctx context.Context, refreshToken string) (*gdriveToken, error) {
+	var reply tokenReply
+	err := postForm(ctx, "token", url.Values{
+		"client_id":     {s.clientID},
+		"client_secret": {s.clientSecret},
+		"refresh_token": {refreshToken},
+		"grant_type":    {"refresh_token"},
+	}, &reply)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh the Google Drive token: %w", err)
+	}
+	if reply.Error != "" || reply.AccessToken == "" {
+		// Most likely revoked; signing in again will do
+		return nil, nil
+	}
+	return &gdriveToken{
+		AccessToken:  reply.AccessToken,
+		RefreshToken: cmp.Or(reply.RefreshToken, refreshToken),
+		Expiry:       clk.Now().Add(time.Duration(reply.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// signIn runs the OAuth device flow: the user approves getnew in a
+// browser, on this machine or any other, while getnew polls for the token.
+func (s *gdriveSource) signIn(ctx context.Context) (*gdriveToken, error) {
+	var code struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+		Error           string `json:"error"`
+		Description     string `json:"error_description"`
+	}
+	err := postForm(ctx, "device/code", url.Values{"client_id": {s.clientID}, "scope": {gdriveScope}}, &code)
+	if err == nil && code.Error != "" {
+		err = fmt.Errorf("%s: %s", code.Error, code.Description)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start signing in to Google Drive: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "To let getnew read Google Drive, visit %s and enter the code %s\n", code.VerificationURL, code.UserCode)
+
+	interval := time.Duration(max(code.Interval, 1)) * time.Second
+	deadline := clk.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for clk.Now().Before(deadline) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		clk.Sleep(interval)
+		var reply tokenReply
+		err := postForm(ctx, "token", url.Values{
+			"client_id":     {s.clientID},
+			"client_secret": {s.clientSecret},
+			"device_code":   {code.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &reply)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign in to Google Drive: %w", err)
+		}
+		switch reply.Error {
+		case "":
+			return &gdriveToken{
+				AccessToken:  reply.AccessToken,
+				RefreshToken: reply.RefreshToken,
+				Expiry:       clk.Now().Add(time.Duration(reply.ExpiresIn) * time.Second),
+			}, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("failed to sign in to Google Drive: %s", cmp.Or(reply.Description, reply.Error))
+		}
+	}
+	return nil, fmt.Errorf("failed to sign in to Google Drive: the code expired before it was entered")
+}
+
+// get makes an authorised request to the Drive API.
+func (s *gdriveSource) get(ctx context.Context, target string) (*http.Response, error) {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "getnew")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *gdriveSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	q := fmt.Sprintf("'%s' in parents and trashed = false", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s.folder))
+	var files []gdriveFile
+	pageToken := ""
+	for {
+		params := url.Values{
+			"q":                         {q},
+			"orderBy":                   {"modifiedTime desc"},
+			"fields":                    {"nextPageToken,files(id,name,mimeType,size,modifiedTime,webViewLink)"},
+			"pageSize":                  {"1000"},
+			"supportsAllDrives":         {"true"},
+			"includeItemsFromAllDrives": {"true"},
+		}
+		if pageToken != "" {
+			params.Set("pageToken", pageToken)
+		}
+		resp, err := s.get(ctx, gdriveAPI+"files?"+params.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", s.Location(""), err)
+		}
+		var page struct {
+			NextPageToken string       `json:"nextPageToken"`
+			Files         []gdriveFile `json:"files"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", s.Location(""), err)
+		}
+		files = append(files, page.Files...)
+		if pageToken = page.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+
+	// Drive allows several files of one name; the newest keeps it and
+	// older ones are told apart by their IDs
+	slices.SortStableFunc(files, func(a, b gdriveFile) int { return b.ModifiedTime.Compare(a.ModifiedTime) })
+	byName := make(map[string]gdriveFile)
+	var infos []fs.FileInfo
+	for _, f := range files {
+		// Folders, and Google Docs and the like, have nothing to download
+		if strings.HasPrefix(f.MimeType, "application/vnd.google-apps.") {
+			continue
+		}
+		name := remoteFileName(f.Name)
+		if _, clash := byName[name]; clash {
+			name = f.ID + "-" + name
+		}
+		byName[name] = f
+		infos = append(infos, remoteFileInfo{name: name, size: f.Size, modTime: f.ModifiedTime})
+	}
+	s.mu.Lock()
+	s.files = byName
+	s.mu.Unlock()
+	return infos, nil
+}
+
+// file finds name among the files listed last, listing them first if need be.
+func (s *gdriveSource) file(name string) (gdriveFile, error) {
+	s.mu.Lock()
+	listed := s.files != nil
+	s.mu.Unlock()
+	if !listed {
+		if _, err := s.List(context.Background()); err != nil {
+			return gdriveFile{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[name]
+	if !ok {
+		return gdriveFile{}, fmt.Errorf("%s: %w", s.Location(name), fs.ErrNotExist)
+	}
+	return f, nil
+}
+
+func (s *gdriveSource) Stat(name string) (fs.FileInfo, error) {
+	f, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+	return remoteFileInfo{name: name, size: f.Size, modTime: f.ModifiedTime}, nil
+}
+
+func (s *gdriveSource) Open(name string) (io.ReadCloser, error) {
+	f, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.get(context.Background(), gdriveAPI+"files/"+url.PathEscape(f.ID)+"?alt=media&supportsAllDrives=true")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", s.Location(name), err)
+	}
+	return resp.Body, nil
+}
+
+// Remove leaves the file in Drive: getnew only has read access.
+func (s *gdriveSource) Remove(name string) error {
+	return nil
+}
+
+func (s *gdriveSource) Location(name string) string {
+	loc := "gdrive://" + s.folder
+	if name == "" {
+		return loc
+	}
+	return loc + "/" + name
+}
+
+// Origin is the link to the file in Drive.
+func (s *gdriveSource) Origin(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files[name].WebViewLink
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGDriveSource(t *testing.T) {
+	fake := useFakeClock(t)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	polls, refreshes := 0, 0
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/oauth/device/code":
+			fmt.Fprint(w, `{"device_code":"dev-1","user_code":"ABCD-EFGH","verification_url":"https://www.google.com/device","expires_in":1800,"interval":5}`)
+		case "/oauth/token":
+			switch r.Form.Get("grant_type") {
+			case "refresh_token":
+				refreshes++
+				fmt.Fprint(w, `{"access_token":"at-2","expires_in":3600}`)
+			default:
+				if polls++; polls < 3 {
+					w.WriteHeader(http.StatusPreconditionRequired)
+					fmt.Fprint(w, `{"error":"authorization_pending"}`)
+					return
+				}
+				fmt.Fprint(w, `{"access_token":"at-1","refresh_token":"rt-1","expires_in":3600}`)
+			}
+		case "/drive/files":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer at-") {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if q := r.Form.Get("q"); q != "'F0LDER' in parents and trashed = false" {
+				t.Errorf("q = %s", q)
+			}
+			fmt.Fprint(w, `{"files":[
+				{"id":"a","name":"export.csv","mimeType":"text/csv","size":"3","modifiedTime":"2026-10-01T10:00:00Z"},
+				{"id":"b","name":"export.csv","mimeType":"text/csv","size":"5","modifiedTime":"2026-10-14T10:00:00Z","webViewLink":"https://drive.google.com/file/d/b/view"},
+				{"id":"c","name":"Notes","mimeType":"application/vnd.google-apps.document","modifiedTime":"2026-10-15T10:00:00Z"}]}`)
+		case "/drive/files/a":
+			fmt.Fprint(w, "old")
+		case "/drive/files/b":
+			fmt.Fprint(w, "newer")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	defer func(oauth, api string) { googleOAuth, gdriveAPI = oauth, api }(googleOAuth, gdriveAPI)
+	googleOAuth, gdriveAPI = ts.URL+"/oauth/", ts.URL+"/drive/"
+
+	if _, err := newGDriveSource("gdrive://F0LDER"); err == nil {
+		t.Error("opened without an OAuth client")
+	}
+	t.Setenv("GETNEW_GDRIVE_CLIENT_ID", "client")
+	t.Setenv("GETNEW_GDRIVE_CLIENT_SECRET", "secret")
+	src, err := newGDriveSource("gdrive://F0LDER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := src.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls != 3 {
+		t.Errorf("polled %d times, want 3", polls)
+	}
+	got := map[string]string{}
+	for _, info := range infos {
+		r, err := src.Open(info.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		got[info.Name()] = string(data)
+	}
+	if want := map[string]string{"export.csv": "newer", "a-export.csv": "old"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if origin := src.(originSource).Origin("export.csv"); origin != "https://drive.google.com/file/d/b/view" {
+		t.Errorf("origin = %q", origin)
+	}
+
+	// A later run reuses the saved token, refreshing it once it expires
+	path, _ := gdriveTokenPath()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("saved token: %v, %v", info, err)
+	}
+	fake.Advance(2 * time.Hour)
+	again, _ := newGDriveSource("gdrive://F0LDER")
+	if _, err := again.List(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if polls != 3 || refreshes != 1 {
+		t.Errorf("second run polled %d times and refreshed %d, want 3 and 1", polls, refreshes)
+	}
+}
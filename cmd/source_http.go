@@ -0,0 +1,273 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpSource reads a web server's directory listing: an Apache or nginx style
+// autoindex page, or a JSON index such as nginx's autoindex_format json.
+// Files are downloaded rather than moved, as there is nothing to remove.
+type httpSource struct {
+	base *url.URL
+}
+
+func newHTTPSource(spec string) (source, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %q: %w", spec, err)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return &httpSource{base: u}, nil
+}
+
+func (s *httpSource) fileURL(name string) string {
+	return s.base.ResolveReference(&url.URL{Path: name}).String()
+}
+
+func (s *httpSource) get(ctx context.Context, method, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "getnew")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, target, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *httpSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	resp, err := s.get(ctx, http.MethodGet, s.base.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read listing: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read listing: %w", err)
+	}
+
+	var infos []remoteFileInfo
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		infos, err = parseJSONListing(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse listing: %w", err)
+		}
+	} else {
+		infos = parseHTMLListing(string(body))
+	}
+
+	// Fill in missing dates from Last-Modified, and if the server gives none
+	// at all, fall back to name order so the "newest" is the last name
+	dated := false
+	for i := range infos {
+		if infos[i].modTime.IsZero() && !infos[i].dir {
+			if info, err := s.Stat(infos[i].name); err == nil {
+				infos[i].modTime = info.ModTime()
+				if infos[i].size < 0 {
+					infos[i].size = info.Size()
+				}
+			}
+		}
+		dated = dated || !infos[i].modTime.IsZero()
+	}
+	if !dated {
+		sort.Slice(infos, func(i, j int) bool { return infos[i].name < infos[j].name })
+		for i := range infos {
+			infos[i].modTime = time.Unix(int64(i), 0)
+		}
+	}
+
+	result := make([]fs.FileInfo, len(infos))
+	for i, info := range infos {
+		result[i] = info
+	}
+	return result, nil
+}
+
+func (s *httpSource) Stat(name string) (fs.FileInfo, error) {
+	resp, err := s.get(context.Background(), http.MethodHead, s.fileURL(name))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	info := remoteFileInfo{name: name, size: resp.ContentLength}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.modTime = modified
+	}
+	return info, nil
+}
+
+func (s *httpSource) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.get(context.Background(), http.MethodGet, s.fileURL(name))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// OpenAt asks for name from byte offset on, for servers that take ranges.
+func (s *httpSource) OpenAt(name string, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.fileURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "getnew")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s from byte %d: %s", s.fileURL(name), offset, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *httpSource) Remove(name string) error {
+	return nil
+}
+
+func (s *httpSource) Location(name string) string {
+	return s.fileURL(name)
+}
+
+var (
+	listingLink  = regexp.MustCompile(`(?i)<a\s[^>]*href="([^"]+)"[^>]*>`)
+	listingSize  = regexp.MustCompile(`\s(\d+)\s*$`)
+	listingDates = []struct {
+		pattern *regexp.Regexp
+		layout  string
+	}{
+		{regexp.MustCompile(`\d{2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}`), "02-Jan-2006 15:04"},
+		{regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}`), "2006-01-02 15:04:05"},
+		{regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}`), "2006-01-02 15:04"},
+	}
+	listingTags = regexp.MustCompile(`<[^>]*>`)
+)
+
+// parseHTMLListing picks the entries out of an autoindex page. The date and
+// size, when shown, follow each link on the same row.
+func parseHTMLListing(page string) []remoteFileInfo {
+	var infos []remoteFileInfo
+	seen := make(map[string]bool)
+	links := listingLink.FindAllStringSubmatchIndex(page, -1)
+	for i, link := range links {
+		href := page[link[2]:link[3]]
+		if strings.ContainsAny(href, "?#:") || strings.HasPrefix(href, "/") || strings.HasPrefix(href, "..") {
+			continue
+		}
+		name, err := url.PathUnescape(href)
+		if err != nil {
+			continue
+		}
+		dir := strings.HasSuffix(name, "/")
+		name = strings.TrimSuffix(name, "/")
+		if !safeRemoteName(name) || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		// The rest of the row, up to the next link or line break
+		end := len(page)
+		if i+1 < len(links) {
+			end = links[i+1][0]
+		}
+		row := page[link[1]:end]
+		if nl := strings.Index(row, "\n"); nl >= 0 {
+			row = row[:nl]
+		}
+		row = listingTags.ReplaceAllString(row, " ")
+
+		info := remoteFileInfo{name: name, size: -1, dir: dir}
+		for _, date := range listingDates {
+			if match := date.pattern.FindString(row); match != "" {
+				if t, err := time.ParseInLocation(date.layout, match, time.UTC); err == nil {
+					info.modTime = t
+					break
+				}
+			}
+		}
+		if match := listingSize.FindStringSubmatch(row); match != nil {
+			info.size, _ = strconv.ParseInt(match[1], 10, 64)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// parseJSONListing reads a JSON array of entries with name, size and a
+// modification time under one of the usual keys.
+func parseJSONListing(body []byte) ([]remoteFileInfo, error) {
+	var entries []map[string]any
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, err
+	}
+	var infos []remoteFileInfo
+	for _, entry := range entries {
+		name, _ := entry["name"].(string)
+		dir := entry["type"] == "directory" || strings.HasSuffix(name, "/")
+		name = strings.TrimSuffix(name, "/")
+		if !safeRemoteName(name) {
+			continue
+		}
+		info := remoteFileInfo{name: name, size: -1, dir: dir}
+		if size, ok := entry["size"].(float64); ok {
+			info.size = int64(size)
+		}
+		for _, key := range []string{"mtime", "modified", "last_modified", "lastModified"} {
+			value, ok := entry[key].(string)
+			if !ok {
+				continue
+			}
+			if t, err := http.ParseTime(value); err == nil {
+				info.modTime = t
+			} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+				info.modTime = t
+			}
+			break
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
@@ -0,0 +1,37 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import "testing"
+
+func TestParseListingsRejectPaths(t *testing.T) {
+	infos, err := parseJSONListing([]byte(`[{"name":"report.pdf"},{"name":"../escaped.txt"},{"name":"..\\up.txt"},{"name":".."},{"name":"logs/","type":"directory"}]`))
+	if err != nil || len(infos) != 2 || infos[0].name != "report.pdf" || infos[1].name != "logs" || !infos[1].dir {
+		t.Errorf("JSON listing = %+v, %v", infos, err)
+	}
+	page := `<a href="report.pdf">report.pdf</a>
+<a href="..%2Fescaped.txt">escaped</a>
+<a href="%5Cup.txt">up</a>`
+	if infos := parseHTMLListing(page); len(infos) != 1 || infos[0].name != "report.pdf" {
+		t.Errorf("HTML listing = %+v", infos)
+	}
+}
@@ -0,0 +1,630 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+func init() {
+	getnew.RegisterBackend("imap", newIMAPSource)
+	getnew.RegisterBackend("imaps", newIMAPSource)
+}
+
+// imapSource lists the attachments of the newest messages in a mailbox, so
+// a file that was emailed can be fetched like a download. Messages are only
+// read, never changed, so Remove does nothing. The query picks messages:
+// from= and subject= (as IMAP SEARCH matches them), since= (a time such as
+// 7d) and limit=, how many of the newest matching messages to read (20).
+type imapSource struct {
+	scheme   string
+	host     string // host:port
+	user     string
+	password string
+	mailbox  string
+	from     string
+	subject  string
+	since    string
+	limit    int
+	// plaintext allows imap:// to log in without STARTTLS
+	plaintext bool
+
+	mu          sync.Mutex
+	listed      bool
+	attachments map[string]mailAttachment
+}
+
+// mailAttachment is an attachment of a message, already decoded.
+type mailAttachment struct {
+	uid     uint32
+	modTime time.Time
+	data    []byte
+}
+
+func newIMAPSource(spec string) (source, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %q: %w", spec, err)
+	}
+	if u.Hostname() == "" || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid source %q: give it as %s://user@host/mailbox", spec, u.Scheme)
+	}
+	s := &imapSource{scheme: u.Scheme, user: u.User.Username(), mailbox: strings.Trim(u.Path, "/"), limit: 20}
+	port := u.Port()
+	if port == "" {
+		port = "993"
+		if s.scheme == "imap" {
+			port = "143"
+		}
+	}
+	s.host = net.JoinHostPort(u.Hostname(), port)
+	if s.mailbox == "" {
+		s.mailbox = "INBOX"
+	}
+	s.password, _ = u.User.Password()
+	if pw := os.Getenv("GETNEW_IMAP_PASSWORD"); pw != "" {
+		s.password = pw
+	}
+	s.plaintext = os.Getenv("GETNEW_IMAP_PLAINTEXT") == "1"
+	q := u.Query()
+	s.from, s.subject, s.since = q.Get("from"), q.Get("subject"), q.Get("since")
+	if v := q.Get("limit"); v != "" {
+		if s.limit, err = strconv.Atoi(v); err != nil || s.limit < 1 {
+			return nil, fmt.Errorf("invalid source %q: limit must be 1 or more", spec)
+		}
+	}
+	if s.since != "" {
+		if _, err := parseTimeSpec(s.since, clk.Now()); err != nil {
+			return nil, fmt.Errorf("invalid source %q: since: %w", spec, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *imapSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	attachments, err := s.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.Location(""), err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attachments, s.listed = attachments, true
+	var infos []fs.FileInfo
+	for name, a := range attachments {
+		infos = append(infos, remoteFileInfo{name: name, size: int64(len(a.data)), modTime: a.modTime})
+	}
+	return infos, nil
+}
+
+// attachment finds name among the attachments listed last, listing them
+// first if need be.
+func (s *imapSource) attachment(name string) (mailAttachment, error) {
+	s.mu.Lock()
+	listed := s.listed
+	s.mu.Unlock()
+	if !listed {
+		if _, err := s.List(context.Background()); err != nil {
+			return mailAttachment{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attachments[name]
+	if !ok {
+		return mailAttachment{}, fmt.Errorf("%s: %w", s.Location(name), fs.ErrNotExist)
+	}
+	return a, nil
+}
+
+func (s *imapSource) Stat(name string) (fs.FileInfo, error) {
+	a, err := s.attachment(name)
+	if err != nil {
+		return nil, err
+	}
+	return remoteFileInfo{name: name, size: int64(len(a.data)), modTime: a.modTime}, nil
+}
+
+func (s *imapSource) Open(name string) (io.ReadCloser, error) {
+	a, err := s.attachment(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(a.data)), nil
+}
+
+// Remove leaves the message alone: the attachment is copied, not moved.
+func (s *imapSource) Remove(name string) error {
+	return nil
+}
+
+// Location names the message an attachment came from as an IMAP URL does
+// (RFC 5092), with the attachment's name after it.
+func (s *imapSource) Location(name string) string {
+	loc := s.scheme + "://" + s.user + "@" + s.host + "/" + s.mailbox
+	if name == "" {
+		return loc
+	}
+	s.mu.Lock()
+	a, ok := s.attachments[name]
+	s.mu.Unlock()
+	if ok {
+		loc += fmt.Sprintf(";UID=%d", a.uid)
+	}
+	return loc + "/" + name
+}
+
+// criteria is the SEARCH for the messages the query asks for.
+func (s *imapSource) criteria() []any {
+	var c []any
+	if s.from != "" {
+		c = append(c, imapAtom("FROM"), s.from)
+	}
+	if s.subject != "" {
+		c = append(c, imapAtom("SUBJECT"), s.subject)
+	}
+	if s.since != "" {
+		since, _ := parseTimeSpec(s.since, clk.Now())
+		c = append(c, imapAtom("SINCE"), imapAtom(since.Format("2-Jan-2006")))
+	}
+	if len(c) == 0 {
+		c = append(c, imapAtom("ALL"))
+	}
+	return c
+}
+
+// fetch reads the newest matching messages and returns their attachments
+// by name. When names clash, the newer message keeps the plain name and
+// older ones are prefixed with their message's UID.
+func (s *imapSource) fetch(ctx context.Context) (map[string]mailAttachment, error) {
+	c, err := dialIMAP(ctx, s.scheme, s.host, s.plaintext)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	if err := s.login(c); err != nil {
+		return nil, err
+	}
+	if _, err := c.run(imapAtom("EXAMINE"), s.mailbox); err != nil {
+		return nil, err
+	}
+	resp, err := c.run(append([]any{imapAtom("UID SEARCH")}, s.criteria()...)...)
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, r := range resp {
+		if rest, ok := strings.CutPrefix(r.text, "* SEARCH"); ok {
+			for _, f := range strings.Fields(rest) {
+				if uid, err := strconv.ParseUint(f, 10, 32); err == nil {
+					uids = append(uids, uint32(uid))
+				}
+			}
+		}
+	}
+	attachments := make(map[string]mailAttachment)
+	if len(uids) == 0 {
+		return attachments, nil
+	}
+	// Higher UIDs arrived later
+	slices.Sort(uids)
+	if len(uids) > s.limit {
+		uids = uids[len(uids)-s.limit:]
+	}
+	set := make([]string, len(uids))
+	for i, uid := range uids {
+		set[i] = strconv.FormatUint(uint64(uid), 10)
+	}
+	resp, err = c.run(imapAtom("UID FETCH " + strings.Join(set, ",") + " (UID INTERNALDATE BODY.PEEK[])"))
+	if err != nil {
+		return nil, err
+	}
+	c.run(imapAtom("LOGOUT"))
+
+	var messages []fetchedMessage
+	for _, r := range resp {
+		if m, ok := parseFetch(r); ok {
+			messages = append(messages, m)
+		}
+	}
+	slices.SortFunc(messages, func(a, b fetchedMessage) int { return int(b.uid) - int(a.uid) })
+	for _, m := range messages {
+		err := mailAttachments(m.body, func(name string, data []byte) {
+			if _, clash := attachments[name]; clash {
+				name = fmt.Sprintf("%d-%s", m.uid, name)
+			}
+			attachments[name] = mailAttachment{uid: m.uid, modTime: m.date, data: data}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping message %d in %s: %v\n", m.uid, s.Location(""), err)
+		}
+	}
+	return attachments, nil
+}
+
+func (s *imapSource) login(c *imapConn) error {
+	if c.preauth {
+		return nil
+	}
+	password := s.password
+	if password == "" {
+		if !stdinIsTerminal() {
+			return fmt.Errorf("no password for %s (set GETNEW_IMAP_PASSWORD)", s.user)
+		}
+		passwordMu.Lock()
+		fmt.Fprintf(os.Stderr, "Password for %s@%s: ", s.user, s.host)
+		pw, err := readPassword()
+		fmt.Fprintln(os.Stderr)
+		passwordMu.Unlock()
+		if err != nil {
+			return err
+		}
+		password = pw
+	}
+	if _, err := c.run(imapAtom("LOGIN"), s.user, password); err != nil {
+		return fmt.Errorf("failed to log in as %s: %w", s.user, err)
+	}
+	return nil
+}
+
+// fetchedMessage is one message from a FETCH response.
+type fetchedMessage struct {
+	uid  uint32
+	date time.Time
+	body []byte
+}
+
+var (
+	fetchUID  = regexp.MustCompile(`\bUID (\d+)`)
+	fetchDate = regexp.MustCompile(`\bINTERNALDATE "([^"]+)"`)
+)
+
+func parseFetch(r imapResponse) (fetchedMessage, bool) {
+	if !strings.HasPrefix(r.text, "* ") || !strings.Contains(r.text, " FETCH (") || len(r.literals) == 0 {
+		return fetchedMessage{}, false
+	}
+	m := fetchedMessage{body: r.literals[len(r.literals)-1]}
+	match := fetchUID.FindStringSubmatch(r.text)
+	if match == nil {
+		return fetchedMessage{}, false
+	}
+	uid, _ := strconv.ParseUint(match[1], 10, 32)
+	m.uid = uint32(uid)
+	if match := fetchDate.FindStringSubmatch(r.text); match != nil {
+		m.date, _ = time.Parse("_2-Jan-2006 15:04:05 -0700", match[1])
+	}
+	if m.date.IsZero() {
+		// Fall back on the date the sender gave
+		if msg, err := mail.ReadMessage(bytes.NewReader(m.body)); err == nil {
+			m.date, _ = msg.Header.Date()
+		}
+	}
+	return m, true
+}
+
+// mailAttachments calls fn with the name and decoded contents of each part
+// of a message that has a file name.
+func mailAttachments(raw []byte, fn func(name string, data []byte)) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	return walkMailPart(mimeHeader(msg.Header), msg.Body, fn)
+}
+
+// mimeHeader is what walkMailPart needs of a header.
+type mimeHeader interface {
+	Get(key string) string
+}
+
+func walkMailPart(h mimeHeader, body io.Reader, fn func(name string, data []byte)) error {
+	mediaType, params, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			// NextRawPart leaves the decoding to decodePart
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkMailPart(part.Header, part, fn); err != nil {
+				return err
+			}
+		}
+	}
+	name := attachmentName(h, params)
+	if name == "" {
+		return nil
+	}
+	data, err := io.ReadAll(decodePart(h, body))
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	fn(name, data)
+	return nil
+}
+
+// attachmentName is the file name of a part from its Content-Disposition,
+// or failing that its Content-Type, made safe to use as a file name.
+func attachmentName(h mimeHeader, typeParams map[string]string) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = typeParams["name"]
+	}
+	// Old mailers encode the name as a header word rather than by RFC 2231
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+func decodePart(h mimeHeader, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &skipSpace{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// skipSpace drops the line breaks base64 bodies are wrapped with.
+type skipSpace struct {
+	r io.Reader
+}
+
+func (s *skipSpace) Read(p []byte) (int, error) {
+	for {
+		n, err := s.r.Read(p)
+		kept := 0
+		for _, b := range p[:n] {
+			if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// imapConn is a minimal IMAP4rev1 client (RFC 3501): enough to log in,
+// search a mailbox and fetch messages.
+type imapConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	tag     int
+	preauth bool
+}
+
+// imapAtom is sent as it is; other strings are quoted or sent as literals.
+type imapAtom string
+
+// imapResponse is one response line, with any literals it carried taken
+// out and left as {n} in the text.
+type imapResponse struct {
+	text     string
+	literals [][]byte
+}
+
+// dialIMAP connects and reads the greeting. imaps:// uses TLS throughout;
+// imap:// must switch to TLS with STARTTLS before the password is sent,
+// unless plaintext allows it to carry on unencrypted.
+func dialIMAP(ctx context.Context, scheme, host string, plaintext bool) (*imapConn, error) {
+	hostname, _, _ := net.SplitHostPort(host)
+	tlsConfig := &tls.Config{ServerName: hostname}
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if scheme == "imaps" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", host, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.read()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to talk to %s: %w", host, err)
+	}
+	switch {
+	case strings.HasPrefix(greeting.text, "* PREAUTH"):
+		c.preauth = true
+	case !strings.HasPrefix(greeting.text, "* OK"):
+		conn.Close()
+		return nil, fmt.Errorf("%s refused the connection: %s", host, greeting.text)
+	}
+	if scheme == "imap" && !c.preauth {
+		resp, err := c.run(imapAtom("CAPABILITY"))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		starttls := false
+		for _, r := range resp {
+			if strings.HasPrefix(r.text, "* CAPABILITY") && strings.Contains(r.text, " STARTTLS") {
+				starttls = true
+			}
+		}
+		switch {
+		case starttls:
+			if _, err := c.run(imapAtom("STARTTLS")); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			c.conn = tls.Client(conn, tlsConfig)
+			c.r = bufio.NewReader(c.conn)
+		case !plaintext:
+			conn.Close()
+			return nil, fmt.Errorf("%s does not offer STARTTLS; use imaps:// or set GETNEW_IMAP_PLAINTEXT=1 to send the password unencrypted", host)
+		}
+	}
+	return c, nil
+}
+
+func (c *imapConn) close() {
+	c.conn.Close()
+}
+
+// read reads one response line, and the literals within it.
+func (c *imapConn) read() (imapResponse, error) {
+	var resp imapResponse
+	var text strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		text.WriteString(line)
+		n, ok := literalSize(line)
+		if !ok {
+			break
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, literal)
+	}
+	resp.text = text.String()
+	return resp, nil
+}
+
+// literalSize reports the size of the literal announced at the end of a
+// line with {n}.
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndexByte(line, '{')
+	if open < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(line[open+1 : len(line)-1])
+	return n, err == nil && n >= 0
+}
+
+// run sends a command and returns its untagged responses, or an error if it
+// did not complete with OK.
+func (c *imapConn) run(args ...any) ([]imapResponse, error) {
+	c.tag++
+	tag := "g" + strconv.Itoa(c.tag)
+	w := bufio.NewWriter(c.conn)
+	w.WriteString(tag)
+	for _, arg := range args {
+		w.WriteByte(' ')
+		switch arg := arg.(type) {
+		case imapAtom:
+			w.WriteString(string(arg))
+		case string:
+			if imapQuotable(arg) {
+				w.WriteString(strconv.Quote(arg))
+				continue
+			}
+			// Anything else goes as a literal, once the server is ready for it
+			fmt.Fprintf(w, "{%d}\r\n", len(arg))
+			if err := w.Flush(); err != nil {
+				return nil, err
+			}
+			resp, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			if !strings.HasPrefix(resp.text, "+") {
+				return nil, fmt.Errorf("%s", resp.text)
+			}
+			w.WriteString(arg)
+		}
+	}
+	w.WriteString("\r\n")
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var untagged []imapResponse
+	for {
+		resp, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		status, ok := strings.CutPrefix(resp.text, tag+" ")
+		if !ok {
+			untagged = append(untagged, resp)
+			continue
+		}
+		if !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("%s", status)
+		}
+		return untagged, nil
+	}
+}
+
+// imapQuotable reports whether s can be sent as a quoted string, which
+// strconv.Quote writes as IMAP expects for plain ASCII.
+func imapQuotable(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e || r == '\\' {
+			return false
+		}
+	}
+	return true
+}
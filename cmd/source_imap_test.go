@@ -0,0 +1,166 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+const (
+	imapMessageOld = "From: alice@example.com\r\n" +
+		"Subject: Monthly report\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=b1\r\n\r\n" +
+		"--b1\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+		"--b1\r\nContent-Type: application/pdf; name=report.pdf\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n\r\nold=20report\r\n" +
+		"--b1--\r\n"
+	imapMessageNew = "From: alice@example.com\r\n" +
+		"Subject: Monthly report\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=b2\r\n\r\n" +
+		"--b2\r\nContent-Type: multipart/alternative; boundary=b3\r\n\r\n" +
+		"--b3\r\nContent-Type: text/plain\r\n\r\nHi\r\n--b3--\r\n" +
+		"--b2\r\nContent-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=\"report.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\nbmV3IHJl\r\ncG9ydA==\r\n" +
+		"--b2\r\nContent-Type: text/csv\r\n" +
+		"Content-Disposition: attachment; filename=\"=?UTF-8?Q?d=C3=A9penses.csv?=\"\r\n\r\na,b\r\n" +
+		"--b2--\r\n"
+)
+
+// fakeIMAPServer answers one session with two messages, UIDs 7 and 9.
+func fakeIMAPServer(t *testing.T) (string, *[]string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var commands []string
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "* OK fake IMAP ready\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			// Take in a literal, as the password is sent
+			if n, ok := literalSize(strings.TrimRight(line, "\r\n")); ok {
+				fmt.Fprint(conn, "+ go ahead\r\n")
+				literal := make([]byte, n)
+				io.ReadFull(r, literal)
+				rest, _ := r.ReadString('\n')
+				line = strings.TrimRight(line, "\r\n") + string(literal) + rest
+			}
+			tag, cmd, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+			commands = append(commands, cmd)
+			switch {
+			case strings.HasPrefix(cmd, "CAPABILITY"):
+				fmt.Fprint(conn, "* CAPABILITY IMAP4rev1\r\n")
+			case strings.HasPrefix(cmd, "UID SEARCH"):
+				fmt.Fprint(conn, "* SEARCH 7 9\r\n")
+			case strings.HasPrefix(cmd, "UID FETCH"):
+				fmt.Fprintf(conn, "* 1 FETCH (UID 7 INTERNALDATE \" 2-Oct-2026 09:00:00 +0000\" BODY[] {%d}\r\n%s)\r\n", len(imapMessageOld), imapMessageOld)
+				fmt.Fprintf(conn, "* 2 FETCH (UID 9 INTERNALDATE \"14-Oct-2026 09:00:00 +0000\" BODY[] {%d}\r\n%s)\r\n", len(imapMessageNew), imapMessageNew)
+			}
+			fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		}
+	}()
+	return ln.Addr().String(), &commands
+}
+
+func TestIMAPSource(t *testing.T) {
+	addr, commands := fakeIMAPServer(t)
+	t.Setenv("GETNEW_IMAP_PASSWORD", `pa"ss\word`)
+	t.Setenv("GETNEW_IMAP_PLAINTEXT", "1")
+	src, err := newIMAPSource("imap://me@" + addr + "/Reports?from=alice@example.com&subject=Monthly%20report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := src.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, info := range infos {
+		r, err := src.Open(info.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		got[info.Name()] = string(data)
+		if info.Size() != int64(len(data)) {
+			t.Errorf("%s: size %d, read %d bytes", info.Name(), info.Size(), len(data))
+		}
+	}
+	want := map[string]string{"report.pdf": "new report", "7-report.pdf": "old report", "dépenses.csv": "a,b"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("attachments = %v, want %v", got, want)
+	}
+	if info, _ := src.Stat("report.pdf"); info == nil || info.ModTime().Day() != 14 {
+		t.Errorf("report.pdf dated %v, want the newer message's date", info)
+	}
+	if loc := src.Location("7-report.pdf"); !strings.HasSuffix(loc, "/Reports;UID=7/7-report.pdf") {
+		t.Errorf("location = %s", loc)
+	}
+
+	wantCommands := []string{
+		"CAPABILITY",
+		`LOGIN "me" {10}` + `pa"ss\word`,
+		`EXAMINE "Reports"`,
+		`UID SEARCH FROM "alice@example.com" SUBJECT "Monthly report"`,
+		"UID FETCH 7,9 (UID INTERNALDATE BODY.PEEK[])",
+		"LOGOUT",
+	}
+	if strings.Join(*commands, "\n") != strings.Join(wantCommands, "\n") {
+		t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(*commands, "\n"), strings.Join(wantCommands, "\n"))
+	}
+}
+
+func TestIMAPRequiresSTARTTLS(t *testing.T) {
+	addr, commands := fakeIMAPServer(t)
+	t.Setenv("GETNEW_IMAP_PASSWORD", "secret")
+	src, err := newIMAPSource("imap://me@" + addr + "/INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.List(context.Background()); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("List without STARTTLS = %v, want a refusal", err)
+	}
+	for _, cmd := range *commands {
+		if strings.HasPrefix(cmd, "LOGIN") {
+			t.Errorf("password sent without TLS: %s", cmd)
+		}
+	}
+}
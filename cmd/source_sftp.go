@@ -0,0 +1,489 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+)
+
+// SFTP version 3 packet types and status codes (draft-ietf-secsh-filexfer-02).
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpStat     = 17
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+	sftpEOF      = 1
+	sftpNoSuch   = 2
+	sftpReadFlag = 1
+)
+
+// sftpChunk is how much each read asks for, and sftpWindow how many reads
+// are kept in flight, so a copy is not held up by the round trip.
+const (
+	sftpChunk  = 32 << 10
+	sftpWindow = 16
+)
+
+// sftpSource reaches a directory on another machine over SFTP, which ssh
+// runs as its sftp subsystem, so the user's agent, config and known_hosts
+// apply as they do for ssh:// and servers that allow nothing but SFTP work
+// too. Each operation has a session of its own.
+type sftpSource struct {
+	*sshSource
+	// dial starts a session; tests replace it
+	dial func(ctx context.Context) (*sftpConn, error)
+}
+
+func newSFTPSource(spec string) (source, error) {
+	s, err := newSSHSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	src := &sftpSource{sshSource: s.(*sshSource)}
+	src.dial = src.dialSSH
+	return src, nil
+}
+
+// dialSSH runs ssh's sftp subsystem and starts a session on it.
+func (s *sftpSource) dialSSH(ctx context.Context) (*sftpConn, error) {
+	args := []string{}
+	if s.port != "" {
+		args = append(args, "-p", s.port)
+	}
+	args = append(args, "-s", "--", s.target, "sftp")
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Stderr = os.Stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run ssh: %w", err)
+	}
+	return newSFTPConn(w, r, cmd.Wait)
+}
+
+func (s *sftpSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	infos, err := s.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.Location(""), err)
+	}
+	return infos, nil
+}
+
+func (s *sftpSource) list(ctx context.Context) ([]fs.FileInfo, error) {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	handle, err := c.open(sftpOpendir, sftpString(nil, s.dir))
+	if err != nil {
+		return nil, err
+	}
+	defer c.request(sftpClose, sftpString(nil, handle))
+	var infos []fs.FileInfo
+	for {
+		typ, data, err := c.request(sftpReaddir, sftpString(nil, handle))
+		if errors.Is(err, io.EOF) {
+			return infos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if typ != sftpName {
+			return nil, fmt.Errorf("unexpected SFTP reply %d to READDIR", typ)
+		}
+		d := sftpReader{data: data}
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			name := d.string()
+			d.string() // the long, ls -l style name
+			info := d.attrs(name)
+			if name != "." && name != ".." {
+				infos = append(infos, info)
+			}
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+}
+
+func (s *sftpSource) Stat(name string) (fs.FileInfo, error) {
+	c, err := s.dial(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", s.Location(name), err)
+	}
+	defer c.close()
+	typ, data, err := c.request(sftpStat, sftpString(nil, path.Join(s.dir, name)))
+	if err == nil && typ != sftpAttrs {
+		err = fmt.Errorf("unexpected SFTP reply %d to STAT", typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", s.Location(name), err)
+	}
+	d := sftpReader{data: data}
+	info := d.attrs(name)
+	return info, d.err
+}
+
+func (s *sftpSource) Open(name string) (io.ReadCloser, error) {
+	return s.OpenAt(name, 0)
+}
+
+// OpenAt opens name with its first offset bytes skipped, to resume a copy.
+func (s *sftpSource) OpenAt(name string, offset int64) (io.ReadCloser, error) {
+	c, err := s.dial(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", s.Location(name), err)
+	}
+	// OPEN takes the name, flags and attributes, here none
+	req := binary.BigEndian.AppendUint32(sftpString(nil, path.Join(s.dir, name)), sftpReadFlag)
+	handle, err := c.open(sftpOpen, binary.BigEndian.AppendUint32(req, 0))
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("failed to open %s: %w", s.Location(name), err)
+	}
+	return &sftpFile{c: c, handle: handle, next: offset}, nil
+}
+
+func (s *sftpSource) Remove(name string) error {
+	c, err := s.dial(context.Background())
+	if err == nil {
+		_, _, err = c.request(sftpRemove, sftpString(nil, path.Join(s.dir, name)))
+		c.close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", s.Location(name), err)
+	}
+	return nil
+}
+
+// sftpConn is a minimal SFTP version 3 client: enough to list a directory,
+// read files and remove them.
+type sftpConn struct {
+	w    io.WriteCloser
+	r    *bufio.Reader
+	wait func() error
+	id   uint32
+}
+
+// sftpStatusError is a failure the server reported.
+type sftpStatusError struct {
+	code uint32
+	msg  string
+}
+
+func (e *sftpStatusError) Error() string {
+	return fmt.Sprintf("SFTP error %d: %s", e.code, e.msg)
+}
+
+func (e *sftpStatusError) Is(target error) bool {
+	return e.code == sftpNoSuch && target == fs.ErrNotExist || e.code == sftpEOF && target == io.EOF
+}
+
+// newSFTPConn starts a session over w and r, agreeing on version 3. wait
+// is called once the session is closed.
+func newSFTPConn(w io.WriteCloser, r io.Reader, wait func() error) (*sftpConn, error) {
+	c := &sftpConn{w: w, r: bufio.NewReader(r), wait: wait}
+	// INIT carries a version where other packets carry an id
+	if _, err := c.w.Write([]byte{0, 0, 0, 5, sftpInit, 0, 0, 0, 3}); err != nil {
+		c.close()
+		return nil, fmt.Errorf("failed to start SFTP: %w", err)
+	}
+	typ, _, _, err := c.recv()
+	if err == nil && typ != sftpVersion {
+		err = fmt.Errorf("unexpected SFTP reply %d to INIT", typ)
+	}
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("failed to start SFTP: %w", err)
+	}
+	return c, nil
+}
+
+func (c *sftpConn) close() error {
+	c.w.Close()
+	return c.wait()
+}
+
+// send writes a request and returns its id.
+func (c *sftpConn) send(typ byte, body []byte) (uint32, error) {
+	c.id++
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(body)+5))
+	packet = binary.BigEndian.AppendUint32(append(packet, typ), c.id)
+	if _, err := c.w.Write(append(packet, body...)); err != nil {
+		return 0, fmt.Errorf("failed to send SFTP request: %w", err)
+	}
+	return c.id, nil
+}
+
+// recv reads a reply: its type, the id of the request it answers and the
+// rest of it.
+func (c *sftpConn) recv() (byte, uint32, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to read SFTP reply: %w", err)
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n < 1 || n > 1<<20 {
+		return 0, 0, nil, fmt.Errorf("SFTP reply of %d bytes", n)
+	}
+	data := make([]byte, n-1)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to read SFTP reply: %w", err)
+	}
+	if header[4] == sftpVersion || len(data) < 4 {
+		return header[4], 0, data, nil
+	}
+	return header[4], binary.BigEndian.Uint32(data), data[4:], nil
+}
+
+// request sends a request and reads its reply. A status other than OK is
+// returned as an error.
+func (c *sftpConn) request(typ byte, body []byte) (byte, []byte, error) {
+	id, err := c.send(typ, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	reply, got, data, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if got != id {
+		return 0, nil, fmt.Errorf("SFTP reply to request %d, not %d", got, id)
+	}
+	if reply == sftpStatus {
+		return reply, nil, statusError(data)
+	}
+	return reply, data, nil
+}
+
+// open sends OPEN or OPENDIR and returns the handle it gives back.
+func (c *sftpConn) open(typ byte, body []byte) (string, error) {
+	reply, data, err := c.request(typ, body)
+	if err != nil {
+		return "", err
+	}
+	if reply != sftpHandle {
+		return "", fmt.Errorf("unexpected SFTP reply %d to OPEN", reply)
+	}
+	d := sftpReader{data: data}
+	handle := d.string()
+	return handle, d.err
+}
+
+// statusError is the error for a STATUS reply, or nil if it says OK.
+func statusError(data []byte) error {
+	d := sftpReader{data: data}
+	code, msg := d.uint32(), d.string()
+	if d.err != nil {
+		return d.err
+	}
+	if code == 0 {
+		return nil
+	}
+	return &sftpStatusError{code: code, msg: msg}
+}
+
+func sftpString(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(s))), s...)
+}
+
+// sftpReader takes fields off the front of a reply, remembering the first
+// time it runs short.
+type sftpReader struct {
+	data []byte
+	err  error
+}
+
+func (d *sftpReader) take(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.data) {
+		d.err = errors.New("SFTP reply is cut short")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *sftpReader) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *sftpReader) uint64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *sftpReader) string() string {
+	return string(d.take(int(d.uint32())))
+}
+
+// attrs reads file attributes, keeping what a listing needs.
+func (d *sftpReader) attrs(name string) remoteFileInfo {
+	info := remoteFileInfo{name: name, size: -1}
+	flags := d.uint32()
+	if flags&0x01 != 0 {
+		info.size = int64(d.uint64())
+	}
+	if flags&0x02 != 0 {
+		d.take(8) // uid and gid
+	}
+	if flags&0x04 != 0 {
+		info.dir = d.uint32()&0o170000 == 0o040000
+	}
+	if flags&0x08 != 0 {
+		d.take(4) // access time
+		info.modTime = time.Unix(int64(d.uint32()), 0)
+	}
+	if flags&0x80000000 != 0 {
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			d.string()
+			d.string()
+		}
+	}
+	return info
+}
+
+// sftpRange is a read in flight: its request id and where it starts.
+type sftpRange struct {
+	id uint32
+	at int64
+}
+
+// sftpFile reads a file with several reads in flight. Replies about one
+// handle come back in the order they were asked for.
+type sftpFile struct {
+	c       *sftpConn
+	handle  string
+	next    int64       // where the next read asked for starts
+	pending []sftpRange // the reads in flight, in order
+	buf     []byte
+	eof     bool
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.eof && len(f.pending) == 0 {
+			return 0, io.EOF
+		}
+		for !f.eof && len(f.pending) < sftpWindow {
+			req := binary.BigEndian.AppendUint64(sftpString(nil, f.handle), uint64(f.next))
+			id, err := f.c.send(sftpRead, binary.BigEndian.AppendUint32(req, sftpChunk))
+			if err != nil {
+				return 0, err
+			}
+			f.pending = append(f.pending, sftpRange{id: id, at: f.next})
+			f.next += sftpChunk
+		}
+		typ, id, data, err := f.c.recv()
+		if err != nil {
+			return 0, err
+		}
+		read := f.pending[0]
+		if id != read.id {
+			return 0, fmt.Errorf("SFTP reply to request %d, not %d", id, read.id)
+		}
+		f.pending = f.pending[1:]
+		switch typ {
+		case sftpData:
+			d := sftpReader{data: data}
+			f.buf = []byte(d.string())
+			if d.err != nil {
+				return 0, d.err
+			}
+			// A short read leaves a gap before the reads after it, so
+			// their replies are dropped and reading goes on from here
+			if len(f.buf) < sftpChunk && len(f.pending) > 0 {
+				if err := f.drain(); err != nil {
+					return 0, err
+				}
+				f.next = read.at + int64(len(f.buf))
+			}
+		case sftpStatus:
+			if err := statusError(data); err != nil && !errors.Is(err, io.EOF) {
+				return 0, err
+			}
+			if err := f.drain(); err != nil {
+				return 0, err
+			}
+			f.eof = true
+		default:
+			return 0, fmt.Errorf("unexpected SFTP reply %d to READ", typ)
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// drain reads the replies to the reads still in flight.
+func (f *sftpFile) drain() error {
+	for len(f.pending) > 0 {
+		if _, _, _, err := f.c.recv(); err != nil {
+			return err
+		}
+		f.pending = f.pending[1:]
+	}
+	return nil
+}
+
+func (f *sftpFile) Close() error {
+	err := f.drain()
+	if err == nil {
+		_, _, err = f.c.request(sftpClose, sftpString(nil, f.handle))
+	}
+	if closeErr := f.c.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
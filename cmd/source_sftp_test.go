@@ -0,0 +1,215 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveFakeSFTP answers SFTP requests on r and w from the files under root,
+// sending back less than asked for by each READ.
+func serveFakeSFTP(root string, r io.Reader, w io.Writer) {
+	reply := func(typ byte, id []byte, body []byte) {
+		packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(id)+len(body)))
+		w.Write(append(append(append(packet, typ), id...), body...))
+	}
+	status := func(id []byte, code uint32) {
+		reply(sftpStatus, id, sftpString(sftpString(binary.BigEndian.AppendUint32(nil, code), "status"), ""))
+	}
+	attrs := func(info fs.FileInfo) []byte {
+		mode := uint32(0o100644)
+		if info.IsDir() {
+			mode = 0o040755
+		}
+		b := binary.BigEndian.AppendUint32(nil, 0x01|0x04|0x08)
+		b = binary.BigEndian.AppendUint64(b, uint64(info.Size()))
+		b = binary.BigEndian.AppendUint32(b, mode)
+		b = binary.BigEndian.AppendUint32(b, uint32(info.ModTime().Unix()))
+		return binary.BigEndian.AppendUint32(b, uint32(info.ModTime().Unix()))
+	}
+	files := map[string]*os.File{}
+	listings := map[string][]os.DirEntry{}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		if body[0] == sftpInit {
+			reply(sftpVersion, nil, binary.BigEndian.AppendUint32(nil, 3))
+			continue
+		}
+		id := body[1:5]
+		d := sftpReader{data: body[5:]}
+		switch body[0] {
+		case sftpOpendir:
+			entries, err := os.ReadDir(filepath.Join(root, d.string()))
+			if err != nil {
+				status(id, sftpNoSuch)
+				continue
+			}
+			listings["dir"] = entries
+			reply(sftpHandle, id, sftpString(nil, "dir"))
+		case sftpReaddir:
+			entries := listings[d.string()]
+			if len(entries) == 0 {
+				status(id, sftpEOF)
+				continue
+			}
+			b := binary.BigEndian.AppendUint32(nil, uint32(len(entries)))
+			for _, e := range entries {
+				info, _ := e.Info()
+				b = append(sftpString(sftpString(b, e.Name()), "-rw-r--r-- "+e.Name()), attrs(info)...)
+			}
+			listings["dir"] = nil
+			reply(sftpName, id, b)
+		case sftpOpen:
+			f, err := os.Open(filepath.Join(root, d.string()))
+			if err != nil {
+				status(id, sftpNoSuch)
+				continue
+			}
+			files["file"] = f
+			reply(sftpHandle, id, sftpString(nil, "file"))
+		case sftpRead:
+			f, offset, n := files[d.string()], d.uint64(), d.uint32()
+			buf := make([]byte, min(n, 20000))
+			got, _ := f.ReadAt(buf, int64(offset))
+			if got == 0 {
+				status(id, sftpEOF)
+				continue
+			}
+			reply(sftpData, id, sftpString(nil, string(buf[:got])))
+		case sftpClose:
+			if f := files[d.string()]; f != nil {
+				f.Close()
+			}
+			status(id, 0)
+		case sftpStat:
+			info, err := os.Stat(filepath.Join(root, d.string()))
+			if err != nil {
+				status(id, sftpNoSuch)
+				continue
+			}
+			reply(sftpAttrs, id, attrs(info))
+		case sftpRemove:
+			if err := os.Remove(filepath.Join(root, d.string())); err != nil {
+				status(id, sftpNoSuch)
+				continue
+			}
+			status(id, 0)
+		default:
+			status(id, 8)
+		}
+	}
+}
+
+func TestSFTPSource(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "out", "logs"), 0o755)
+	big := make([]byte, 700000)
+	rand.New(rand.NewSource(1)).Read(big)
+	os.WriteFile(filepath.Join(root, "out", "result.bin"), big, 0o644)
+	mtime := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(root, "out", "result.bin"), mtime, mtime)
+
+	s, err := newSFTPSource("sftp://me@cluster/~/out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := s.(*sftpSource)
+	src.dial = func(ctx context.Context) (*sftpConn, error) {
+		// OS pipes buffer, as ssh's do, so requests can be sent ahead
+		clientR, serverW, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		serverR, clientW, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			serveFakeSFTP(root, serverR, serverW)
+			serverR.Close()
+			serverW.Close()
+		}()
+		return newSFTPConn(clientW, clientR, func() error {
+			<-done
+			return clientR.Close()
+		})
+	}
+
+	infos, err := src.List(context.Background())
+	if err != nil || len(infos) != 2 {
+		t.Fatalf("List = %v, %v", infos, err)
+	}
+	for _, info := range infos {
+		if info.Name() == "logs" && !info.IsDir() || info.Name() == "result.bin" && (info.Size() != int64(len(big)) || !info.ModTime().Equal(mtime)) {
+			t.Errorf("listed %s: dir %v, size %d, time %v", info.Name(), info.IsDir(), info.Size(), info.ModTime())
+		}
+	}
+	if _, err := src.Stat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(missing) = %v, want not exist", err)
+	}
+
+	for _, offset := range []int64{0, 123457} {
+		r, err := src.OpenAt("result.bin", offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, big[offset:]) {
+			t.Errorf("read %d bytes from %d, want %d", len(data), offset, len(big)-int(offset))
+		}
+	}
+
+	if err := src.Remove("result.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "out", "result.bin")); !os.IsNotExist(err) {
+		t.Errorf("result.bin still there: %v", err)
+	}
+	if loc := src.Location("x"); loc != "sftp://me@cluster/~/out/x" {
+		t.Errorf("Location = %s", loc)
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+func init() {
+	getnew.RegisterBackend("slack", newSlackSource)
+}
+
+// slackAPI is where Slack's Web API is; tests point it elsewhere.
+var slackAPI = "https://slack.com/api/"
+
+// slackSource lists the files shared in a Slack channel, given as
+// slack://C0123ABCD or slack://#channel-name, using the token in
+// GETNEW_SLACK_TOKEN (which needs the files:read scope, and channels:read
+// to find a channel by name). Files are downloaded, not deleted from Slack.
+// The query can narrow them to one member's with user= and sets how many
+// of the newest are listed with limit= (100).
+type slackSource struct {
+	channel string // as given
+	token   string
+	user    string
+	limit   int
+
+	mu    sync.Mutex
+	id    string // the channel's ID, once known
+	files map[string]slackFile
+}
+
+// slackFile is a file as files.list describes it.
+type slackFile struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Created   int64  `json:"created"`
+	Download  string `json:"url_private_download"`
+	Permalink string `json:"permalink"`
+}
+
+func newSlackSource(spec string) (source, error) {
+	// Not url.Parse, which would take the # of slack://#general for a fragment
+	rest, _ := strings.CutPrefix(spec[strings.Index(spec, "://")+3:], "#")
+	channel, rawQuery, _ := strings.Cut(rest, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %q: %w", spec, err)
+	}
+	s := &slackSource{channel: strings.TrimSuffix(channel, "/"), token: os.Getenv("GETNEW_SLACK_TOKEN"), user: query.Get("user"), limit: 100}
+	if s.channel == "" {
+		return nil, fmt.Errorf("invalid source %q: give it as slack://channel", spec)
+	}
+	if s.token == "" {
+		return nil, fmt.Errorf("%s needs a Slack token in GETNEW_SLACK_TOKEN", spec)
+	}
+	if v := query.Get("limit"); v != "" {
+		if s.limit, err = strconv.Atoi(v); err != nil || s.limit < 1 || s.limit > 1000 {
+			return nil, fmt.Errorf("invalid source %q: limit must be from 1 to 1000", spec)
+		}
+	}
+	return s, nil
+}
+
+// call makes a Web API call and decodes its reply into v, turning Slack's
+// {"ok": false} into an error.
+func (s *slackSource) call(ctx context.Context, method string, params url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, slackAPI+method+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "getnew")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if !status.OK {
+		return fmt.Errorf("%s: %s", method, status.Error)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// channelID finds the ID of the channel, looking it up by name unless it
+// already looks like an ID.
+func (s *slackSource) channelID(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	id := s.id
+	s.mu.Unlock()
+	if id != "" {
+		return id, nil
+	}
+	if isSlackID(s.channel) {
+		id = s.channel
+	} else {
+		cursor := ""
+		for id == "" {
+			var page struct {
+				Channels []struct {
+					ID   string `json:"id"`
+					Name string `json:"name"`
+				} `json:"channels"`
+				Metadata struct {
+					NextCursor string `json:"next_cursor"`
+				} `json:"response_metadata"`
+			}
+			params := url.Values{"types": {"public_channel,private_channel"}, "limit": {"1000"}, "exclude_archived": {"true"}}
+			if cursor != "" {
+				params.Set("cursor", cursor)
+			}
+			if err := s.call(ctx, "conversations.list", params, &page); err != nil {
+				return "", err
+			}
+			for _, c := range page.Channels {
+				if c.Name == s.channel {
+					id = c.ID
+				}
+			}
+			if cursor = page.Metadata.NextCursor; cursor == "" && id == "" {
+				return "", fmt.Errorf("no channel named %s", s.channel)
+			}
+		}
+	}
+	s.mu.Lock()
+	s.id = id
+	s.mu.Unlock()
+	return id, nil
+}
+
+// isSlackID reports whether name is a channel ID such as C0123ABCD rather
+// than a channel's name, which is lower case.
+func isSlackID(name string) bool {
+	if len(name) < 9 || !strings.ContainsRune("CGD", rune(name[0])) {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *slackSource) List(ctx context.Context) ([]fs.FileInfo, error) {
+	id, err := s.channelID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.Location(""), err)
+	}
+	params := url.Values{"channel": {id}, "count": {strconv.Itoa(s.limit)}}
+	if s.user != "" {
+		params.Set("user", s.user)
+	}
+	var reply struct {
+		Files []slackFile `json:"files"`
+	}
+	if err := s.call(ctx, "files.list", params, &reply); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.Location(""), err)
+	}
+
+	// Newest first, so the newest file with a name keeps it and older ones
+	// are told apart by their IDs
+	files := reply.Files
+	slices.SortStableFunc(files, func(a, b slackFile) int { return cmp.Compare(b.Created, a.Created) })
+	byName := make(map[string]slackFile)
+	var infos []fs.FileInfo
+	for _, f := range files {
+		// Links to documents held elsewhere have nothing to download
+		if f.Download == "" || f.Name == "" {
+			continue
+		}
+		name := remoteFileName(f.Name)
+		if _, clash := byName[name]; clash {
+			name = f.ID + "-" + name
+		}
+		byName[name] = f
+		infos = append(infos, remoteFileInfo{name: name, size: f.Size, modTime: time.Unix(f.Created, 0)})
+	}
+	s.mu.Lock()
+	s.files = byName
+	s.mu.Unlock()
+	return infos, nil
+}
+
+// file finds name among the files listed last, listing them first if need be.
+func (s *slackSource) file(name string) (slackFile, error) {
+	s.mu.Lock()
+	listed := s.files != nil
+	s.mu.Unlock()
+	if !listed {
+		if _, err := s.List(context.Background()); err != nil {
+			return slackFile{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[name]
+	if !ok {
+		return slackFile{}, fmt.Errorf("%s: %w", s.Location(name), fs.ErrNotExist)
+	}
+	return f, nil
+}
+
+func (s *slackSource) Stat(name string) (fs.FileInfo, error) {
+	f, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+	return remoteFileInfo{name: name, size: f.Size, modTime: time.Unix(f.Created, 0)}, nil
+}
+
+func (s *slackSource) Open(name string) (io.ReadCloser, error) {
+	f, err := s.file(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, f.Download, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "getnew")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", f.Download, resp.Status)
+	}
+	// Without the files:read scope, Slack answers with its sign-in page
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") && !strings.HasSuffix(strings.ToLower(f.Name), ".html") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: Slack sent a web page rather than the file; does the token have the files:read scope?", f.Download)
+	}
+	return resp.Body, nil
+}
+
+// Remove leaves the file in Slack: it is downloaded, not moved.
+func (s *slackSource) Remove(name string) error {
+	return nil
+}
+
+func (s *slackSource) Location(name string) string {
+	loc := "slack://" + s.channel
+	if name == "" {
+		return loc
+	}
+	return loc + "/" + name
+}
+
+// Origin is the file's permalink in Slack.
+func (s *slackSource) Origin(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files[name].Permalink
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSlackSource(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			fmt.Fprint(w, `{"ok":false,"error":"invalid_auth"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/conversations.list":
+			fmt.Fprint(w, `{"ok":true,"channels":[{"id":"C0EXPORTS","name":"exports"}],"response_metadata":{"next_cursor":""}}`)
+		case "/api/files.list":
+			if r.URL.Query().Get("channel") != "C0EXPORTS" {
+				fmt.Fprint(w, `{"ok":false,"error":"channel_not_found"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"ok":true,"files":[
+				{"id":"F1","name":"data.csv","size":3,"created":1000,"url_private_download":"%[1]s/files/F1","permalink":"https://team.slack.com/files/F1"},
+				{"id":"F2","name":"data.csv","size":5,"created":2000,"url_private_download":"%[1]s/files/F2","permalink":"https://team.slack.com/files/F2"},
+				{"id":"F3","name":"Plan","created":3000,"permalink":"https://docs.example.com/plan"},
+				{"id":"F4","name":"..\\notes.txt","size":5,"created":500,"url_private_download":"%[1]s/files/F4"}]}`, ts.URL)
+		case "/files/F1":
+			fmt.Fprint(w, "old")
+		case "/files/F2":
+			fmt.Fprint(w, "newer")
+		case "/files/F4":
+			fmt.Fprint(w, "notes")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	defer func(api string) { slackAPI = api }(slackAPI)
+	slackAPI = ts.URL + "/api/"
+
+	t.Setenv("GETNEW_SLACK_TOKEN", "")
+	if _, err := newSlackSource("slack://#exports"); err == nil {
+		t.Error("opened without a token")
+	}
+	t.Setenv("GETNEW_SLACK_TOKEN", "xoxb-test")
+	src, err := newSlackSource("slack://#exports?limit=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := src.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, info := range infos {
+		r, err := src.Open(info.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		got[info.Name()] = string(data)
+	}
+	if want := map[string]string{"data.csv": "newer", "F1-data.csv": "old", ".._notes.txt": "notes"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if origin := src.(originSource).Origin("data.csv"); origin != "https://team.slack.com/files/F2" {
+		t.Errorf("origin = %q", origin)
+	}
+
+	t.Setenv("GETNEW_SLACK_TOKEN", "wrong")
+	bad, _ := newSlackSource("slack://C0EXPORTS")
+	if _, err := bad.List(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Errorf("wrong token: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsWeeks int
+	statsTop   int
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Summarise the move history: files per week, sizes, common types and places",
+	Long: `stats summarises getnew's history: how many files were moved each week and how
+much they weighed, the most common file extensions with the rule that catches
+each (handy for spotting what could use a rule), and the most common source
+and destination directories.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := showStats(); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().StringVar(&historySince, "since", "", "Only include moves after this date or age (e.g. 2024-01-01, 30d)")
+	statsCmd.Flags().IntVar(&statsWeeks, "weeks", 8, "Number of recent weeks to show")
+	statsCmd.Flags().IntVar(&statsTop, "top", 5, "Number of extensions and directories to show")
+}
+
+// tally counts files and bytes under a key.
+type tally struct {
+	key   string
+	files int
+	bytes int64
+}
+
+// topTallies returns the n keys with the most files.
+func topTallies(m map[string]*tally, n int) []*tally {
+	all := make([]*tally, 0, len(m))
+	for _, t := range m {
+		all = append(all, t)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].files != all[j].files {
+			return all[i].files > all[j].files
+		}
+		return all[i].key < all[j].key
+	})
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+func addTally(m map[string]*tally, key string, size int64) {
+	t := m[key]
+	if t == nil {
+		t = &tally{key: key}
+		m[key] = t
+	}
+	t.files++
+	if size > 0 {
+		t.bytes += size
+	}
+}
+
+// weekStart is the Monday starting t's week.
+func weekStart(t time.Time) time.Time {
+	t = t.Local()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+func showStats() error {
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	if entries, err = historySinceFilter(entries); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No moves recorded")
+		return nil
+	}
+
+	weeks := map[string]*tally{}
+	exts := map[string]*tally{}
+	srcs := map[string]*tally{}
+	dests := map[string]*tally{}
+	var total int64
+	for _, e := range entries {
+		addTally(weeks, weekStart(e.Time).Format("2006-01-02"), e.Size)
+		ext := strings.ToLower(filepath.Ext(e.Name))
+		if ext == "" {
+			ext = "(none)"
+		}
+		addTally(exts, ext, e.Size)
+		addTally(srcs, filepath.Dir(e.Source), e.Size)
+		addTally(dests, filepath.Dir(e.Dest), e.Size)
+		if e.Size > 0 {
+			total += e.Size
+		}
+	}
+
+	fmt.Printf("%d files moved, %s, since %s\n", len(entries), humanSize(total), entries[0].Time.Local().Format("2006-01-02"))
+
+	fmt.Println("\nWeek of")
+	week := weekStart(clk.Now())
+	for i := 0; i < statsWeeks; i++ {
+		key := week.Format("2006-01-02")
+		t := weeks[key]
+		if t == nil {
+			t = &tally{}
+		}
+		line := fmt.Sprintf("  %s  %5d  %8s  %s", key, t.files, humanSize(t.bytes), strings.Repeat("#", min(t.files, 50)))
+		fmt.Println(strings.TrimRight(line, " "))
+		week = week.AddDate(0, 0, -7)
+	}
+
+	fmt.Println("\nExtensions")
+	for _, t := range topTallies(exts, statsTop) {
+		caught := "no rule"
+		if i := firstRule(appConfig.Rules, "x"+t.key); i >= 0 && t.key != "(none)" {
+			caught = ruleLabel(appConfig.Rules[i], i)
+		}
+		fmt.Printf("  %-10s %5d  %8s  %s\n", t.key, t.files, humanSize(t.bytes), caught)
+	}
+
+	fmt.Println("\nFrom")
+	for _, t := range topTallies(srcs, statsTop) {
+		fmt.Printf("  %5d  %8s  %s\n", t.files, humanSize(t.bytes), t.key)
+	}
+	fmt.Println("\nTo")
+	for _, t := range topTallies(dests, statsTop) {
+		fmt.Printf("  %5d  %8s  %s\n", t.files, humanSize(t.bytes), t.key)
+	}
+	return nil
+}
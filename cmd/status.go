@@ -0,0 +1,235 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var statusRefresh bool
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print a one-line count of waiting files by type, for shell prompts",
+	Long: `status prints how many files are waiting in the source directories and of
+what type, such as "5 waiting: 3 pdf, 2 image". It reads a summary cached in the
+state directory, which every getnew run and watch keep up to date, so it is cheap
+enough to call from a prompt or status bar.
+
+A source directory that has changed since it was summarised is only noticed,
+not listed again: the line ends with "*" until the next run updates it, or
+--refresh updates it now. "?" means a source has no summary yet.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if statusRefresh {
+			if err := refreshStatus(cmd.Context()); err != nil {
+				exitWithError(cmd, err)
+			}
+		}
+		line, err := statusLine()
+		if err != nil {
+			exitWithError(cmd, err)
+		}
+		fmt.Println(line)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVar(&statusRefresh, "refresh", false, "List changed sources again before printing")
+}
+
+// sourceStatus summarises the files waiting in one source directory when its
+// modification time was DirModTime.
+type sourceStatus struct {
+	Dir        string         `json:"dir"`
+	DirModTime time.Time      `json:"dir_mod_time"`
+	Updated    time.Time      `json:"updated"`
+	Files      int            `json:"files"`
+	Bytes      int64          `json:"bytes"`
+	Types      map[string]int `json:"types"`
+}
+
+func statusPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "status.json"), nil
+}
+
+func loadStatus() (map[string]sourceStatus, error) {
+	cache := map[string]sourceStatus{}
+	path, err := statusPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status: %w", err)
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to parse status: %w", err)
+	}
+	return cache, nil
+}
+
+// statusDirs returns the local source directories, which are the only ones
+// summarised.
+func statusDirs() []string {
+	var dirs []string
+	for _, s := range sources {
+		if dir, ok := sourceDir(s); ok {
+			if abs, err := filepath.Abs(dir); err == nil {
+				dirs = append(dirs, abs)
+			}
+		}
+	}
+	return dirs
+}
+
+// refreshStatus summarises again the sources that changed since the cached
+// summary, leaving the rest alone.
+func refreshStatus(ctx context.Context) error {
+	cache, err := loadStatus()
+	if err != nil {
+		return err
+	}
+	for _, dir := range statusDirs() {
+		info, err := os.Stat(dir)
+		if err != nil {
+			delete(cache, dir)
+			continue
+		}
+		if cached, ok := cache[dir]; ok && cached.DirModTime.Equal(info.ModTime()) {
+			continue
+		}
+		st, err := summariseDir(ctx, dir)
+		if err != nil {
+			return err
+		}
+		st.DirModTime = info.ModTime()
+		cache[dir] = st
+	}
+	path, err := statusPath()
+	if err != nil {
+		return err
+	}
+	return writeJSONFile(path, cache)
+}
+
+func summariseDir(ctx context.Context, dir string) (sourceStatus, error) {
+	st := sourceStatus{Dir: dir, Updated: clk.Now(), Types: map[string]int{}}
+	src := localSource{Dir: dir}
+	files, err := src.List(ctx)
+	if err != nil {
+		return st, err
+	}
+	for _, file := range files {
+		if file.IsDir() || isIgnoredExt(filepath.Ext(file.Name())) || getnew.IsSystemFile(file.Name()) {
+			continue
+		}
+		kind := getnew.TypeOf(candidate{FileInfo: file, Source: src})
+		if kind == "" {
+			kind = "other"
+		}
+		st.Files++
+		st.Bytes += file.Size()
+		st.Types[kind]++
+	}
+	return st, nil
+}
+
+// updateStatus refreshes the status after a run, only warning on failure.
+func updateStatus(ctx context.Context) {
+	if err := refreshStatus(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// statusLine renders the cached summaries of the current sources, checking
+// only each directory's modification time.
+func statusLine() (string, error) {
+	cache, err := loadStatus()
+	if err != nil {
+		return "", err
+	}
+	total := 0
+	types := map[string]int{}
+	stale, missing := false, false
+	for _, dir := range statusDirs() {
+		st, ok := cache[dir]
+		if !ok {
+			missing = true
+			continue
+		}
+		if info, err := os.Stat(dir); err != nil || !info.ModTime().Equal(st.DirModTime) {
+			stale = true
+		}
+		total += st.Files
+		for kind, n := range st.Types {
+			types[kind] += n
+		}
+	}
+
+	line := "nothing waiting"
+	if total > 0 {
+		kinds := make([]string, 0, len(types))
+		for kind := range types {
+			kinds = append(kinds, kind)
+		}
+		sort.Slice(kinds, func(i, j int) bool {
+			if types[kinds[i]] != types[kinds[j]] {
+				return types[kinds[i]] > types[kinds[j]]
+			}
+			return kinds[i] < kinds[j]
+		})
+		var parts []string
+		for _, kind := range kinds {
+			parts = append(parts, fmt.Sprintf("%d %s", types[kind], kind))
+		}
+		line = fmt.Sprintf("%d waiting: %s", total, strings.Join(parts, ", "))
+	}
+	switch {
+	case missing && total == 0:
+		line = "?"
+	case missing:
+		line += " ?"
+	case stale:
+		line += " *"
+	}
+	return line, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+var (
+	toStdout      bool
+	stdoutConsume bool
+)
+
+func init() {
+	rootCmd.Flags().BoolVar(&toStdout, "stdout", false, "Write the file's contents to stdout instead of moving it, keeping the original")
+	rootCmd.Flags().BoolVar(&stdoutConsume, "consume", false, "With --stdout, remove the original once it has all been written")
+}
+
+// streamToStdout copies the nth newest file to stdout. The original is kept
+// unless --consume is given, and then only removed once it has all been
+// written, the removal being recorded in the history.
+func streamToStdout(ctx context.Context) error {
+	files, err := newestSettled(ctx, nthLimit())
+	if err != nil {
+		return err
+	}
+	file, err := selectNthNewest(files, nthNewest, fileFilter)
+	if err != nil {
+		return err
+	}
+	start := clk.Now()
+	r, err := file.Source.Open(file.Name())
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	_, err = getnew.CopyContext(ctx, os.Stdout, r)
+	if closeErr := r.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s to stdout: %w", file.Name(), err)
+	}
+	fmt.Fprintln(os.Stderr, file.Name())
+	if !stdoutConsume {
+		return nil
+	}
+
+	if err := getnew.WaitUntilClosed(ctx, file, findOptions()); err != nil {
+		return err
+	}
+	origin := fileOrigin(file)
+	remove := file.Source.Remove
+	if dir, ok := sourceDir(file.Source); ok {
+		remove = func(name string) error { return removeOriginal(filepath.Join(dir, name)) }
+	}
+	if err := remove(file.Name()); err != nil {
+		return fmt.Errorf("failed to remove original file: %w", err)
+	}
+	recordMove(historyEntry{
+		Name:     file.Name(),
+		Source:   file.Source.Location(file.Name()),
+		Dest:     "-",
+		Size:     file.Size(),
+		Duration: clk.Since(start),
+		Origin:   origin,
+	})
+	return nil
+}
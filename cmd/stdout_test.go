@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamToStdoutKeepsOriginal(t *testing.T) {
+	useFakeClock(t)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	fileFilter, nthNewest = "", 1
+	mem := &memSource{files: map[string]remoteFileInfo{
+		"data.csv": {name: "data.csv", size: 8, modTime: testEpoch},
+	}}
+	useSource(t, mem)
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	defer func(old *os.File) { os.Stdout = old }(os.Stdout)
+	os.Stdout = out
+
+	if err := streamToStdout(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mem.files["data.csv"]; !ok {
+		t.Fatal("--stdout removed the original without --consume")
+	}
+	if entries, _ := loadHistory(); len(entries) != 0 {
+		t.Errorf("history = %+v, want nothing recorded", entries)
+	}
+
+	stdoutConsume = true
+	defer func() { stdoutConsume = false }()
+	if err := streamToStdout(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mem.files["data.csv"]; ok {
+		t.Error("--consume kept the original")
+	}
+	entries, err := loadHistory()
+	if err != nil || len(entries) != 1 || entries[0].Name != "data.csv" || entries[0].Dest != "-" || entries[0].Source != "mem://data.csv" {
+		t.Errorf("history = %+v, %v", entries, err)
+	}
+	if data, _ := os.ReadFile(out.Name()); string(data) != "data.csvdata.csv" {
+		t.Errorf("stdout = %q", data)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var (
+	writeXattrTags bool
+	getTag         string
+	getList        bool
+)
+
+var tagCmd = &cobra.Command{
+	Use:   "tag <last|filter> <tag>...",
+	Short: "Tag a previously moved file so it can be found again later",
+	Long: `tag attaches labels to a file in getnew's history. Use "last" for the most
+recently moved file, or a filter to pick the most recent moved file matching it.
+
+With --xattr the tags are also written to the file itself: as Finder tags on
+macOS, and as the user.xdg.tags extended attribute on Linux.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := tagHistoryEntry(args[0], args[1:]); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+var getCmd = &cobra.Command{
+	Use:   "get --tag <tag>",
+	Short: "Bring back a previously moved file by its tag",
+	Long: `get looks up the nth most recently moved file carrying the given tag and moves
+it from wherever it was put to the destination directory. Use --list to just
+print where every file with the tag is.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := getTaggedFile(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+	rootCmd.AddCommand(getCmd)
+	tagCmd.Flags().BoolVar(&writeXattrTags, "xattr", false, "Also write the tags to the file's extended attributes (Finder tags on macOS)")
+	getCmd.Flags().StringVarP(&getTag, "tag", "t", "", "Tag to look for")
+	getCmd.Flags().IntVarP(&nthNewest, "nth", "n", 1, "Nth most recent tagged file to get (default is 1, the newest)")
+	getCmd.Flags().BoolVarP(&getList, "list", "l", false, "List tagged files without moving them")
+	getCmd.MarkFlagRequired("tag")
+}
+
+// findHistoryEntry returns the index of the most recent entry selected by "last",
+// by the path it was moved to, or matching filter, or -1.
+func findHistoryEntry(entries []historyEntry, selector string) int {
+	path, _ := filepath.Abs(selector)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if selector == "last" || entries[i].Dest == path || matchesFilter(entries[i].Name, selector) {
+			return i
+		}
+	}
+	return -1
+}
+
+func tagHistoryEntry(selector string, tags []string) error {
+	var tagged historyEntry
+	err := updateHistory(func(entries []historyEntry) error {
+		i := findHistoryEntry(entries, selector)
+		if i < 0 {
+			return fmt.Errorf("no moved file matching '%s' in history", selector)
+		}
+		for _, tag := range tags {
+			if !slices.Contains(entries[i].Tags, tag) {
+				entries[i].Tags = append(entries[i].Tags, tag)
+			}
+		}
+		tagged = entries[i]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if writeXattrTags {
+		if err := writeFileTags(tagged.Dest, tagged.Tags); err != nil {
+			return fmt.Errorf("failed to write tags to %s: %w", tagged.Dest, err)
+		}
+	}
+	fmt.Printf("%s: %v\n", tagged.Name, tagged.Tags)
+	return nil
+}
+
+func getTaggedFile(ctx context.Context) error {
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+
+	// Most recent first, skipping files that have since been moved or deleted
+	var tagged []historyEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !slices.Contains(entries[i].Tags, getTag) {
+			continue
+		}
+		if _, err := os.Stat(entries[i].Dest); err != nil {
+			continue
+		}
+		tagged = append(tagged, entries[i])
+	}
+
+	if getList {
+		for _, entry := range tagged {
+			fmt.Printf("%s\n", entry.Dest)
+		}
+		return nil
+	}
+	if len(tagged) == 0 {
+		return getnew.WithKind(fmt.Errorf("no files tagged '%s' found", getTag), getnew.ErrNoCandidates)
+	}
+	// -1 is the oldest, as with getnew --nth
+	nth := nthNewest
+	if nth < 0 {
+		nth += len(tagged) + 1
+	}
+	if nth < 1 || nth > len(tagged) {
+		return getnew.WithKind(fmt.Errorf("requested %dth tagged file, but only %d files available", nthNewest, len(tagged)), getnew.ErrNotEnoughFiles)
+	}
+
+	entry := tagged[nth-1]
+	destPath := filepath.Join(destDir, entry.Name)
+	if err := transferFile(ctx, entry.Dest, destPath); err != nil {
+		return err
+	}
+
+	// Carry the tags over to the history entry for the new location, which
+	// other runs may have recorded moves after
+	moved, err := filepath.Abs(destPath)
+	if err != nil {
+		return err
+	}
+	err = updateHistory(func(entries []historyEntry) error {
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].Dest == moved {
+				entries[i].Tags = entry.Tags
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", entry.Name)
+	return nil
+}
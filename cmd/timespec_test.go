@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeSpec(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"2h", now.Add(-2 * time.Hour)},
+		{"30m", now.Add(-30 * time.Minute)},
+		{"1d", now.Add(-24 * time.Hour)},
+		{"1.5d", now.Add(-36 * time.Hour)},
+		{"2w", now.Add(-14 * 24 * time.Hour)},
+		{"2025-01-01", time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"2025-01-01T08:30", time.Date(2025, 1, 1, 8, 30, 0, 0, time.Local)},
+		{"2025-01-01T08:30:00Z", time.Date(2025, 1, 1, 8, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeSpec(tt.spec, now)
+		if err != nil {
+			t.Errorf("parseTimeSpec(%q): %v", tt.spec, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseTimeSpec(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "yesterday", "2025-13-01", "5x"} {
+		if _, err := parseTimeSpec(spec, now); err == nil {
+			t.Errorf("parseTimeSpec(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coljac/getnew/pkg/getnew"
+)
+
+var (
+	colorMode string
+	verbose   bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&colorMode, "color", "auto", "Color file names by type: auto (on terminals, unless NO_COLOR is set), always or never")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Also list on stderr, dimmed, the files the filters left out")
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorOn reports whether output to f should be colored.
+func colorOn(f *os.File) bool {
+	switch colorMode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(f)
+}
+
+// typeColors are the SGR codes names of each kind of file are shown in,
+// close to those ls uses.
+var typeColors = map[string]string{
+	"image":   "35",
+	"video":   "95",
+	"audio":   "36",
+	"pdf":     "33",
+	"doc":     "94",
+	"archive": "31",
+	"dir":     "1;34",
+}
+
+func sgr(code, s string) string {
+	if s == "" {
+		return ""
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+// colorName colors name by its kind and underlines where the --filter
+// text, if it is not a pattern, matches.
+func colorName(name, kind string) string {
+	code := typeColors[kind]
+	start, end := filterMatch(name)
+	if start == end {
+		if code == "" {
+			return name
+		}
+		return sgr(code, name)
+	}
+	if code == "" {
+		code = "0"
+	}
+	return sgr(code, name[:start]) + sgr(code+";1;4", name[start:end]) + sgr(code, name[end:])
+}
+
+// filterMatch is where fileFilter first appears in name, ignoring case.
+func filterMatch(name string) (start, end int) {
+	if fileFilter == "" || strings.ContainsAny(fileFilter, "*?[") {
+		return 0, 0
+	}
+	i := strings.Index(strings.ToLower(name), strings.ToLower(fileFilter))
+	// Lowering can change the length of some characters; give up on those
+	if i < 0 || len(strings.ToLower(name)) != len(name) {
+		return 0, 0
+	}
+	return i, i + len(fileFilter)
+}
+
+// styledName is name as it should be shown on f.
+func styledName(f *os.File, name string) string {
+	if !colorOn(f) {
+		return name
+	}
+	return colorName(name, getnew.TypeByName(name))
+}
+
+// reportSkipped prints a file the filters left out, for --verbose.
+func reportSkipped(file candidate) {
+	line := "skipped " + file.Source.Location(file.Name())
+	if colorOn(os.Stderr) {
+		line = sgr("2", line)
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
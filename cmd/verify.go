@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/spf13/cobra"
+)
+
+var verifyAll bool
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <file|last|filter>...",
+	Short: "Check moved files against the checksums recorded when they were moved",
+	Long: `verify reads moved files again and compares them with the SHA-256 recorded in
+the history when they were moved, to confirm that copies on network or removable
+storage are still intact. Pick files by the path they were moved to, "last" for
+the most recent move, or a filter for the most recent move matching it; --all
+checks every moved file that is still where it was put.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 && !verifyAll {
+			fmt.Fprintln(os.Stderr, "Error: name the files to verify, or use --all")
+			os.Exit(1)
+		}
+		if err := verifyMoved(cmd.Context(), args); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().BoolVar(&verifyAll, "all", false, "Verify every moved file that is still where it was put")
+}
+
+func verifyMoved(ctx context.Context, selectors []string) error {
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	var picked []historyEntry
+	if verifyAll {
+		// The latest move to each path describes what is there now
+		seen := map[string]bool{}
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if seen[e.Dest] || isRemoteDest(e.Dest) {
+				continue
+			}
+			seen[e.Dest] = true
+			if _, err := os.Stat(e.Dest); err == nil {
+				picked = append(picked, e)
+			}
+		}
+	}
+	for _, selector := range selectors {
+		i := findHistoryEntry(entries, selector)
+		if i < 0 {
+			return fmt.Errorf("no moved file matching '%s' in history", selector)
+		}
+		picked = append(picked, entries[i])
+	}
+
+	bad := 0
+	for _, e := range picked {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		status, ok := verifyEntry(ctx, e)
+		if !ok {
+			bad++
+		}
+		fmt.Printf("%-10s %s\n", status, e.Dest)
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d of %d file(s) failed verification", bad, len(picked))
+	}
+	return nil
+}
+
+// verifyEntry checks one moved file, returning a status word and whether it
+// passed. Files moved without a checksum can only have their size checked.
+func verifyEntry(ctx context.Context, e historyEntry) (string, bool) {
+	if isRemoteDest(e.Dest) {
+		return "REMOTE", true
+	}
+	info, err := os.Stat(e.Dest)
+	if err != nil {
+		return "MISSING", false
+	}
+	size := info.Size()
+	if info.IsDir() {
+		if size, err = getnew.DirSize(ctx, e.Dest); err != nil {
+			return "UNREADABLE", false
+		}
+	}
+	if e.Size >= 0 && size != e.Size {
+		return "SIZE", false
+	}
+	if e.SHA256 == "" {
+		return "SIZE-OK", true
+	}
+	sum, err := hashPath(ctx, e.Dest)
+	if err != nil {
+		return "UNREADABLE", false
+	}
+	if sum != e.SHA256 {
+		return "CORRUPT", false
+	}
+	return "OK", true
+}
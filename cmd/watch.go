@@ -0,0 +1,269 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coljac/getnew/pkg/getnew"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [filter]",
+	Short: "Watch the source directory and move new files as they arrive",
+	Long: `watch keeps running and moves every new file that appears in the source
+directory to the destination directory, or to the destination of the first
+rule in the config file that matches it. A file is only moved once it has stopped
+changing for the settle period, so downloads still being written are left alone.
+
+Optionally, provide a filter argument to only move matching files.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeFilter,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			fileFilter = args[0]
+		}
+		if err := watchSourceDir(cmd.Context()); err != nil {
+			exitWithError(cmd, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+}
+
+type pendingFile struct {
+	size     int64
+	lastSeen time.Time
+}
+
+func watchSourceDir(ctx context.Context) error {
+	watcher, err := newSourceWatcher("watch")
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	watcher.pauseGone = true
+	fmt.Fprintf(os.Stderr, "Watching %s for new files...\n", strings.Join(sourceDirs, ", "))
+
+	err = watchArrivals(ctx, watcher, nil, func(file candidate) bool {
+		if err := moveArrivedFile(ctx, file); err != nil {
+			recordError("watch", file.Name(), err)
+		}
+		updateStatus(ctx)
+		return ctx.Err() == nil
+	})
+	// Stopping with Ctrl-C is the normal way out of watch mode
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// sourceWatcher watches the source directories and notices when one of them
+// disappears. With pauseGone it waits for the directory to return instead of
+// failing, as the watch daemon should outlive an unmounted share.
+type sourceWatcher struct {
+	*fsnotify.Watcher
+	dirs      []string
+	gone      map[string]bool
+	pauseGone bool
+}
+
+func newSourceWatcher(feature string) (*sourceWatcher, error) {
+	dirs, err := localDirs(feature)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start watcher: %w", err)
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch source directory %s: %w", dir, err)
+		}
+	}
+	return &sourceWatcher{Watcher: watcher, dirs: dirs, gone: map[string]bool{}}, nil
+}
+
+// checkSources pauses directories that have gone and resumes those that
+// have come back, or without pauseGone fails on the first one gone.
+func (w *sourceWatcher) checkSources() error {
+	for _, dir := range w.dirs {
+		src := localSource{Dir: dir}
+		switch {
+		case src.Gone() && !w.gone[dir]:
+			err := fmt.Errorf("%w: %s", getnew.ErrSourceGone, dir)
+			if !w.pauseGone {
+				return err
+			}
+			w.gone[dir] = true
+			recordError("watch", dir, err)
+			fmt.Fprintf(os.Stderr, "Pausing %s until it returns\n", dir)
+		case !src.Gone() && w.gone[dir]:
+			if err := w.Add(dir); err != nil {
+				continue
+			}
+			delete(w.gone, dir)
+			fmt.Fprintf(os.Stderr, "%s is back; watching it again\n", dir)
+		}
+	}
+	return nil
+}
+
+// watchArrivals calls handle for each new matching file once it has settled,
+// until handle returns false, the deadline passes or ctx is cancelled.
+func watchArrivals(ctx context.Context, watcher *sourceWatcher, deadline <-chan time.Time, handle func(candidate) bool) error {
+	interval := settlePeriod / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := make(map[string]pendingFile)
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			name := filepath.Base(event.Name)
+			if isIgnoredExt(filepath.Ext(name)) || !findOptions().Matches(name) {
+				continue
+			}
+			info, err := os.Stat(event.Name)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			pending[event.Name] = pendingFile{size: info.Size(), lastSeen: clk.Now()}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Watch error: %v\n", err)
+		case <-deadline:
+			return errWaitTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C():
+			if err := watcher.checkSources(); err != nil {
+				return err
+			}
+			for path, p := range pending {
+				if now.Sub(p.lastSeen) < settlePeriod {
+					continue
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					// Gone already, e.g. a partial download renamed to its final name
+					delete(pending, path)
+					continue
+				}
+				if info.Size() != p.size {
+					pending[path] = pendingFile{size: info.Size(), lastSeen: now}
+					continue
+				}
+				delete(pending, path)
+				file := candidate{FileInfo: info, Source: localSource{Dir: filepath.Dir(path)}}
+				// Filters on size, type, contents and the like are only
+				// checked once the file is whole
+				if !findOptions().Keeps(file) {
+					continue
+				}
+				if !handle(file) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func moveArrivedFile(ctx context.Context, file candidate) error {
+	info := file.FileInfo
+	dest := destDir
+	r := findRule(info.Name())
+	if r != nil {
+		dest = expandHome(r.Dest)
+	}
+	dest = fileDestDir(dest, info.ModTime())
+	if err := dedupe(ctx, file, dest); isDuplicate(err) {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	companions, err := checkSignature(ctx, file)
+	if err != nil {
+		return err
+	}
+	if unpackingFromSource(file) {
+		root, err := unpackFromSource(ctx, file, dest)
+		if err != nil {
+			return err
+		}
+		carryCompanions(ctx, companions, dest)
+		printResult(styledName(os.Stdout, info.Name())+" -> "+root, root)
+		if r != nil {
+			notifyRule(r, file, root)
+		}
+		_, err = finishRule(ctx, r, dest, root)
+		return err
+	}
+	if err := moveFromSource(ctx, file, filepath.Join(dest, info.Name())); err != nil {
+		return err
+	}
+	carryCompanions(ctx, companions, dest)
+	if r != nil {
+		notifyRule(r, file, filepath.Join(dest, info.Name()))
+	}
+	if dest != destDir {
+		printResult(styledName(os.Stdout, info.Name())+" -> "+dest, filepath.Join(dest, info.Name()))
+	} else {
+		printResult(styledName(os.Stdout, info.Name()), filepath.Join(dest, info.Name()))
+	}
+
+	if err := testMovedArchive(ctx, dest, info); err != nil {
+		return err
+	}
+	moved := filepath.Join(dest, info.Name())
+	if unarchive {
+		if moved, err = unarchiveFetchedFile(ctx, dest, info); err != nil {
+			return fmt.Errorf("failed to unarchive: %w", err)
+		}
+	}
+	_, err = finishRule(ctx, r, dest, moved)
+	return err
+}
@@ -0,0 +1,53 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchArrivalsAppliesFilters(t *testing.T) {
+	dir := t.TempDir()
+	useSource(t, localSource{Dir: dir})
+	defer func(settle time.Duration, min int64) { settlePeriod, minSize = settle, min }(settlePeriod, minSize)
+	settlePeriod, minSize, fileFilter = 50*time.Millisecond, 1000, ""
+	watcher, err := newSourceWatcher("watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "data.bin"), make([]byte, 2000), 0o644)
+	var handled []string
+	err = watchArrivals(context.Background(), watcher, time.After(time.Second), func(file candidate) bool {
+		handled = append(handled, file.Name())
+		return true
+	})
+	if err != errWaitTimeout || len(handled) != 1 || handled[0] != "data.bin" {
+		t.Errorf("handled %v, %v; want only data.bin, which passes --min-size", handled, err)
+	}
+}
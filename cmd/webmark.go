@@ -0,0 +1,115 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// webMarkMode is --web-mark: "keep" carries the mark-of-the-web a browser
+// left on a download over to the moved file, "strip" drops it, and
+// "unquarantine" drops only the part that makes the system warn before
+// opening it, keeping where it came from.
+var webMarkMode string
+
+// fromDomain is --from: only files downloaded from this domain are candidates.
+var fromDomain string
+
+// webMark is the record a browser leaves on a downloaded file: the
+// Zone.Identifier stream on Windows, the com.apple.quarantine attribute on
+// macOS, or the user.xdg.origin.url attribute on Linux.
+type webMark struct {
+	raw []byte
+	// from is the kMDItemWhereFroms attribute on macOS, which holds the
+	// download URL and the page it was linked from.
+	from []byte
+	// url is where the file was downloaded from, when the mark says.
+	url string
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&webMarkMode, "web-mark", "keep", "What to do with the downloaded-from-the-internet mark on moved files: keep, strip, or unquarantine to keep only where it came from")
+	rootCmd.PersistentFlags().StringVar(&fromDomain, "from", "", "Only consider files downloaded from this domain or its subdomains, as recorded by the browser")
+}
+
+// carryWebMark applies --web-mark to a file moved from sourcePath to destPath,
+// given the mark read from the source before the move. Failing to carry the
+// mark over is only a warning; the move itself has succeeded.
+func carryWebMark(destPath string, mark webMark, found bool) {
+	var err error
+	switch {
+	case webMarkMode == "strip":
+		err = removeWebMark(destPath)
+	case webMarkMode == "unquarantine":
+		if mark, found = withoutQuarantine(mark); found {
+			err = writeWebMark(destPath, mark)
+		} else {
+			err = removeWebMark(destPath)
+		}
+	case found:
+		err = writeWebMark(destPath, mark)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update the web mark on %s: %v\n", filepath.Base(destPath), err)
+	}
+}
+
+// originSource is implemented by sources that know where their files were
+// downloaded from, such as a browser's download history.
+type originSource interface {
+	Origin(name string) string
+}
+
+// fileOrigin is the URL file was downloaded from, as its web mark or its
+// source says, or "" if neither does.
+func fileOrigin(file candidate) string {
+	if dir, ok := sourceDir(file.Source); ok {
+		mark, _ := readWebMark(filepath.Join(dir, file.Name()))
+		return mark.url
+	}
+	if s, ok := file.Source.(originSource); ok {
+		return s.Origin(file.Name())
+	}
+	return ""
+}
+
+// downloadedFrom reports whether file was downloaded from domain or one of
+// its subdomains.
+func downloadedFrom(file candidate, domain string) bool {
+	origin := fileOrigin(file)
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if d, err := url.Parse(domain); err == nil && d.Host != "" {
+		domain = d.Hostname()
+	}
+	host, domain := strings.ToLower(u.Hostname()), strings.ToLower(strings.Trim(domain, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// macOS records no URL in the quarantine attribute, only the time and the
+// application that downloaded the file. The URL is kept separately, for
+// Spotlight, in kMDItemWhereFroms.
+const (
+	quarantineAttr = "com.apple.quarantine"
+	whereFromsAttr = "com.apple.metadata:kMDItemWhereFroms"
+)
+
+func readWebMark(path string) (webMark, bool) {
+	raw, qErr := getxattr(path, quarantineAttr)
+	from, fErr := getxattr(path, whereFromsAttr)
+	if qErr != nil && fErr != nil {
+		return webMark{}, false
+	}
+	mark := webMark{raw: raw, from: from}
+	if urls := plistStrings(from); len(urls) > 0 {
+		mark.url = urls[0]
+	}
+	return mark, true
+}
+
+func writeWebMark(path string, mark webMark) error {
+	if len(mark.raw) > 0 {
+		if err := unix.Setxattr(path, quarantineAttr, mark.raw, 0); err != nil {
+			return err
+		}
+	} else if err := removeAttr(path, quarantineAttr); err != nil {
+		return err
+	}
+	if len(mark.from) > 0 {
+		return unix.Setxattr(path, whereFromsAttr, mark.from, 0)
+	}
+	return nil
+}
+
+// withoutQuarantine keeps where the file came from, but not the quarantine
+// that has Gatekeeper ask before opening it.
+func withoutQuarantine(mark webMark) (webMark, bool) {
+	mark.raw = nil
+	return mark, len(mark.from) > 0
+}
+
+func removeWebMark(path string) error {
+	if err := removeAttr(path, quarantineAttr); err != nil {
+		return err
+	}
+	return removeAttr(path, whereFromsAttr)
+}
+
+func removeAttr(path, attr string) error {
+	err := unix.Removexattr(path, attr)
+	if errors.Is(err, unix.ENOATTR) {
+		return nil
+	}
+	return err
+}
+
+func getxattr(path, attr string) ([]byte, error) {
+	size, err := unix.Getxattr(path, attr, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.Getxattr(path, attr, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// Browsers and wget --xattr record where a download came from in the
+// freedesktop user.xdg.origin.url attribute.
+const originAttr = "user.xdg.origin.url"
+
+func readWebMark(path string) (webMark, bool) {
+	raw, err := getxattr(path, originAttr)
+	if err != nil {
+		return webMark{}, false
+	}
+	return webMark{raw: raw, url: string(raw)}, true
+}
+
+func writeWebMark(path string, mark webMark) error {
+	return unix.Setxattr(path, originAttr, mark.raw, 0)
+}
+
+// withoutQuarantine keeps the origin, which Linux does not warn about.
+func withoutQuarantine(mark webMark) (webMark, bool) { return mark, true }
+
+func removeWebMark(path string) error {
+	err := unix.Removexattr(path, originAttr)
+	if errors.Is(err, unix.ENODATA) {
+		return nil
+	}
+	return err
+}
+
+func getxattr(path, attr string) ([]byte, error) {
+	size, err := unix.Getxattr(path, attr, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.Getxattr(path, attr, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+)
+
+// The mark of the web is an alternate data stream, which NTFS lets us read
+// and write as if it were a file called name:Zone.Identifier.
+const zoneStream = ":Zone.Identifier"
+
+func readWebMark(path string) (webMark, bool) {
+	raw, err := os.ReadFile(path + zoneStream)
+	if err != nil {
+		return webMark{}, false
+	}
+	mark := webMark{raw: raw}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		if url, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "HostUrl="); ok {
+			mark.url = url
+		}
+	}
+	return mark, true
+}
+
+func writeWebMark(path string, mark webMark) error {
+	return os.WriteFile(path+zoneStream, mark.raw, 0o644)
+}
+
+// withoutQuarantine drops the whole mark, as Windows warns about any file
+// that has one.
+func withoutQuarantine(mark webMark) (webMark, bool) { return webMark{}, false }
+
+func removeWebMark(path string) error {
+	return os.Remove(path + zoneStream)
+}
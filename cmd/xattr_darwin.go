@@ -0,0 +1,162 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+
+	"golang.org/x/sys/unix"
+)
+
+// writeFileTags sets the Finder tags, which macOS keeps as a binary plist
+// array of strings in the _kMDItemUserTags attribute.
+func writeFileTags(path string, tags []string) error {
+	return unix.Setxattr(path, "com.apple.metadata:_kMDItemUserTags", finderTagsPlist(tags), 0)
+}
+
+func finderTagsPlist(tags []string) []byte {
+	if len(tags) > 255 {
+		tags = tags[:255]
+	}
+	var buf bytes.Buffer
+	buf.WriteString("bplist00")
+
+	// Object 0 is the array, objects 1..n are the strings it references
+	offsets := []uint32{uint32(buf.Len())}
+	writePlistMarker(&buf, 0xA0, len(tags))
+	for i := range tags {
+		buf.WriteByte(byte(i + 1))
+	}
+	for _, tag := range tags {
+		offsets = append(offsets, uint32(buf.Len()))
+		if isASCII(tag) {
+			writePlistMarker(&buf, 0x50, len(tag))
+			buf.WriteString(tag)
+			continue
+		}
+		units := utf16.Encode([]rune(tag))
+		writePlistMarker(&buf, 0x60, len(units))
+		binary.Write(&buf, binary.BigEndian, units)
+	}
+
+	tableOffset := uint64(buf.Len())
+	binary.Write(&buf, binary.BigEndian, offsets)
+
+	// Trailer: offset size, object ref size, object count, top object, table offset
+	buf.Write(make([]byte, 6))
+	buf.WriteByte(4)
+	buf.WriteByte(1)
+	binary.Write(&buf, binary.BigEndian, uint64(len(offsets)))
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	binary.Write(&buf, binary.BigEndian, tableOffset)
+	return buf.Bytes()
+}
+
+func writePlistMarker(buf *bytes.Buffer, kind byte, n int) {
+	if n < 15 {
+		buf.WriteByte(kind | byte(n))
+		return
+	}
+	buf.WriteByte(kind | 0x0F)
+	buf.WriteByte(0x11)
+	binary.Write(buf, binary.BigEndian, uint16(n))
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// plistStrings reads the strings from a binary plist holding an array of
+// them, as kMDItemWhereFroms and _kMDItemUserTags do. Anything else gives
+// nil.
+func plistStrings(data []byte) []string {
+	if len(data) < 40 || string(data[:8]) != "bplist00" {
+		return nil
+	}
+	trailer := data[len(data)-32:]
+	offsetSize, refSize := int(trailer[6]), int(trailer[7])
+	count := bigEndian(trailer[8:16])
+	table := int(bigEndian(trailer[24:32]))
+
+	// object finds object i, returning its kind, length and contents' start
+	object := func(i uint64) (kind byte, n, at int, ok bool) {
+		start := table + int(i)*offsetSize
+		if i >= count || start < 0 || start+offsetSize > len(data) {
+			return 0, 0, 0, false
+		}
+		at = int(bigEndian(data[start : start+offsetSize]))
+		if at < 0 || at >= len(data) {
+			return 0, 0, 0, false
+		}
+		kind, n, at = data[at]>>4, int(data[at]&0x0F), at+1
+		if n == 0x0F {
+			// The length follows as an integer object
+			if at >= len(data) || data[at]>>4 != 1 {
+				return 0, 0, 0, false
+			}
+			size := 1 << (data[at] & 0x0F)
+			if at+1+size > len(data) {
+				return 0, 0, 0, false
+			}
+			n, at = int(bigEndian(data[at+1:at+1+size])), at+1+size
+		}
+		return kind, n, at, n >= 0
+	}
+
+	kind, n, at, ok := object(bigEndian(trailer[16:24]))
+	if !ok || kind != 0x0A || at+n*refSize > len(data) {
+		return nil
+	}
+	var strs []string
+	for i := 0; i < n; i++ {
+		ref := data[at+i*refSize : at+(i+1)*refSize]
+		kind, size, start, ok := object(bigEndian(ref))
+		switch {
+		case !ok:
+		case kind == 0x05 && start+size <= len(data):
+			strs = append(strs, string(data[start:start+size]))
+		case kind == 0x06 && start+2*size <= len(data):
+			units := make([]uint16, size)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(data[start+2*j:])
+			}
+			strs = append(strs, string(utf16.Decode(units)))
+		}
+	}
+	return strs
+}
+
+// bigEndian reads an unsigned integer of any width up to 8 bytes.
+func bigEndian(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFakeSleepAdvances(t *testing.T) {
+	c := NewFake(epoch)
+	c.Sleep(90 * time.Second)
+	if got := c.Since(epoch); got != 90*time.Second {
+		t.Fatalf("Since = %v, want 90s", got)
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	c := NewFake(epoch)
+	ch := c.After(time.Minute)
+
+	c.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("After fired early")
+	default:
+	}
+
+	c.Advance(time.Second)
+	select {
+	case at := <-ch:
+		if !at.Equal(epoch.Add(time.Minute)) {
+			t.Fatalf("After fired at %v, want %v", at, epoch.Add(time.Minute))
+		}
+	default:
+		t.Fatal("After did not fire")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	c := NewFake(epoch)
+	ticker := c.NewTicker(10 * time.Second)
+
+	var ticks []time.Time
+	for i := 0; i < 3; i++ {
+		c.Advance(10 * time.Second)
+		select {
+		case at := <-ticker.C():
+			ticks = append(ticks, at)
+		default:
+			t.Fatalf("tick %d missing", i)
+		}
+	}
+	for i, at := range ticks {
+		if want := epoch.Add(time.Duration(i+1) * 10 * time.Second); !at.Equal(want) {
+			t.Errorf("tick %d at %v, want %v", i, at, want)
+		}
+	}
+
+	ticker.Stop()
+	c.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("stopped ticker fired")
+	default:
+	}
+}
+
+func TestFakeSetBackwards(t *testing.T) {
+	c := NewFake(epoch)
+	ch := c.After(time.Second)
+	c.Set(epoch.Add(-time.Hour))
+	if !c.Now().Equal(epoch.Add(-time.Hour)) {
+		t.Fatalf("Now = %v after Set", c.Now())
+	}
+	select {
+	case <-ch:
+		t.Fatal("timer fired when moving backwards")
+	default:
+	}
+}
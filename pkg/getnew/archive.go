@@ -0,0 +1,426 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The built-in extractors need no external tools, so they work the same on
+// every platform, Windows included.
+
+func extractZip(ctx context.Context, path, dir string, filter *entryFilter) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	// archive/zip cannot decrypt, so leave encrypted files to a tool
+	for _, f := range zr.File {
+		if f.Flags&0x1 != 0 && (filter == nil || MatchesEntry(f.Name, filter.patterns)) {
+			return ErrEncrypted
+		}
+	}
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		target, err := entryPath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			// Directories holding chosen files are made as they are written
+			if filter != nil {
+				continue
+			}
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !filter.take(f.Name) {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		err = writeEntry(ctx, target, r, f.Mode(), f.Modified)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar unpacks a tar file, compressed with "gzip" or "bzip2" or not at
+// all.
+func extractTar(ctx context.Context, path, dir, compression string, filter *entryFilter) error {
+	tr, closer, err := openTar(path, compression)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	return unpackTar(ctx, tr, dir, filter)
+}
+
+// unpackTar writes the entries of a tar stream into dir.
+func unpackTar(ctx context.Context, tr *tar.Reader, dir string, filter *entryFilter) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Some tools, dpkg-deb among them, name every entry from ./
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if name == "" {
+			continue
+		}
+		target, err := entryPath(dir, name)
+		if err != nil {
+			return err
+		}
+		if filter != nil && (hdr.Typeflag != tar.TypeReg || !filter.take(name)) {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeEntry(ctx, target, tr, hdr.FileInfo().Mode(), hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := writeSymlink(dir, name, hdr.Linkname); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeSymlink makes the link an archive entry called name holds in dir,
+// leaving out links that point outside dir, which could be written through
+// later, and all links on Windows, which needs extra privileges for them.
+// Where a link points is worked out from where it lands on disk, following
+// the links already there, not from the entry's name.
+func writeSymlink(dir, name, linkname string) error {
+	if runtime.GOOS == "windows" || filepath.IsAbs(linkname) {
+		return nil
+	}
+	target, err := entryPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if !staysInside(dir, filepath.Dir(target), linkname) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	return os.Symlink(linkname, target)
+}
+
+// staysInside reports whether linkname, read from the directory from
+// inside dir, leads somewhere inside dir once the links on the way are
+// followed as they are on disk. Parts that do not exist yet are taken as
+// plain directories.
+func staysInside(dir, from, linkname string) bool {
+	rel, err := filepath.Rel(dir, from)
+	if err != nil {
+		return false
+	}
+	todo := append(strings.Split(filepath.ToSlash(rel), "/"), strings.Split(filepath.ToSlash(linkname), "/")...)
+	var at []string // the parts below dir reached so far
+	for hops := 0; len(todo) > 0; {
+		part := todo[0]
+		todo = todo[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(at) == 0 {
+				return false
+			}
+			at = at[:len(at)-1]
+			continue
+		}
+		next := filepath.Join(append([]string{dir}, append(at, part)...)...)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			at = append(at, part)
+			continue
+		}
+		link, err := os.Readlink(next)
+		if hops++; err != nil || filepath.IsAbs(link) || hops > 40 {
+			return false
+		}
+		todo = append(strings.Split(filepath.ToSlash(link), "/"), todo...)
+	}
+	return true
+}
+
+// openTar opens a tar file, compressed with "gzip" or "bzip2" or not at all.
+// Closing the closer closes the file.
+func openTar(path, compression string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := decompressor(f, compression)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return tar.NewReader(r), f, nil
+}
+
+// decompressor reads r decompressed with "gzip" or "bzip2", or as it is.
+func decompressor(r io.Reader, compression string) (io.Reader, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return bzip2.NewReader(r), nil
+	}
+	return r, nil
+}
+
+func listZip(path string) ([]ArchiveEntry, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	entries := make([]ArchiveEntry, 0, len(zr.File))
+	for _, f := range zr.File {
+		entries = append(entries, ArchiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64), Dir: f.FileInfo().IsDir()})
+	}
+	return entries, nil
+}
+
+func listTar(ctx context.Context, path, compression string) ([]ArchiveEntry, error) {
+	tr, closer, err := openTar(path, compression)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return tarEntries(ctx, tr)
+}
+
+func tarEntries(ctx context.Context, tr *tar.Reader) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if name := strings.TrimPrefix(hdr.Name, "./"); name != "" {
+			entries = append(entries, ArchiveEntry{Name: name, Size: hdr.Size, Dir: hdr.Typeflag == tar.TypeDir})
+		}
+	}
+}
+
+// testZip reads every file in a zip archive, which checks each against its
+// CRC.
+func testZip(ctx context.Context, path string, opts Options) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		// archive/zip cannot decrypt, so leave encrypted files to a tool
+		if f.Flags&0x1 != 0 {
+			return testWithTool(ctx, path, opts)
+		}
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		_, err = CopyContext(ctx, io.Discard, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// testTar reads a tar file to the end, compressed with "gzip" or "bzip2" or
+// not at all, so that all of it is decoded and its checksum checked.
+func testTar(ctx context.Context, path, compression string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompressor(f, compression)
+	if err != nil {
+		return err
+	}
+	if err := readTar(ctx, tar.NewReader(r)); err != nil {
+		return err
+	}
+	// The checksum of the compressed data comes after the end of the tar
+	_, err = CopyContext(ctx, io.Discard, r)
+	return err
+}
+
+// readTar reads every entry of a tar stream, checking only that it can.
+func readTar(ctx context.Context, tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := CopyContext(ctx, io.Discard, tr); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// testCompressed decompresses a compressed file in full.
+func testCompressed(ctx context.Context, path, compression string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompressor(f, compression)
+	if err != nil {
+		return err
+	}
+	_, err = CopyContext(ctx, io.Discard, r)
+	return err
+}
+
+// gunzipFile decompresses a plain .gz file into dir, without the .gz.
+func gunzipFile(ctx context.Context, path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	target := filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name)))
+	return writeEntry(ctx, target, gz, info.Mode(), info.ModTime())
+}
+
+// entryPath resolves an archive entry name inside dir, refusing names that
+// would land outside it, whether by .. or through a link an earlier entry
+// made.
+func entryPath(dir, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("archive entry %q is outside the destination", name)
+	}
+	// No entry may be written through a link, wherever it points now
+	parent := dir
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			break
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if err != nil {
+			break // not made yet, so neither is anything below it
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry %q is written through the symbolic link %s", name, filepath.Base(parent))
+		}
+	}
+	return target, nil
+}
+
+func writeEntry(ctx context.Context, target string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	// A later entry replaces a link of the same name rather than writing
+	// to wherever it points
+	if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0o200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, contextReader{ctx, r}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		os.Chtimes(target, modTime, modTime)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errCannotClone means the filesystem cannot clone or copy the file itself,
+// and it should be copied the usual way.
+var errCannotClone = errors.New("cannot clone")
+
+// cloneLocal copies c to dest's partial file by cloning it where the
+// filesystem can (Btrfs, XFS, APFS), which is instant and shares the data
+// until either file changes, or by having the kernel copy it. It reports
+// false, having done nothing, if c is not a local file or neither is
+// possible. The checksum is still taken from the original, which only
+// reads it.
+func cloneLocal(ctx context.Context, c Candidate, dest string) (string, bool, error) {
+	src, ok := c.Source.(LocalSource)
+	if !ok || c.Size() <= 0 {
+		return "", false, nil
+	}
+	partial := dest + PartialSuffix
+	// Carry on from a partial copy rather than start again
+	if _, err := os.Lstat(partial); err == nil {
+		return "", false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", false, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	path := filepath.Join(src.Dir, c.Name())
+	err := cloneFile(ctx, path, partial)
+	if err != nil {
+		os.Remove(partial)
+		if errors.Is(err, errCannotClone) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to copy file: %w", err)
+	}
+	checksum, err := fileChecksum(ctx, path)
+	if err != nil {
+		os.Remove(partial)
+		return "", false, fmt.Errorf("failed to read source file: %w", err)
+	}
+	return checksum, true, nil
+}
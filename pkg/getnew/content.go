@@ -0,0 +1,58 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// ContentReadLimit is how much of a file MatchesContent searches.
+const ContentReadLimit = 64 << 20
+
+// binarySniffSize is how much of the start of a file is checked for NUL
+// bytes, which text files do not have, as grep and git do.
+const binarySniffSize = 8000
+
+// MatchesContent reports whether re matches the contents of c. Binary files
+// never match, and only the first ContentReadLimit bytes are searched.
+func MatchesContent(c Candidate, re *regexp.Regexp) bool {
+	if c.IsDir() {
+		return false
+	}
+	r, err := c.Source.Open(c.Name())
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	head := make([]byte, binarySniffSize)
+	n, _ := io.ReadFull(r, head)
+	head = head[:n]
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	rest := io.LimitReader(r, ContentReadLimit-int64(n))
+	return re.MatchReader(bufio.NewReader(io.MultiReader(bytes.NewReader(head), rest)))
+}
@@ -0,0 +1,268 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// moveDir moves the directory c to dest with everything in it, renaming it
+// where it can and copying it where it cannot, or with opts.TarDirs packs
+// it into a gzipped tarball at dest. Only local directories can be moved.
+func moveDir(ctx context.Context, c Candidate, dest string, opts Options) (Result, error) {
+	clk := opts.clock()
+	start := clk.Now()
+	src, ok := c.Source.(LocalSource)
+	if !ok {
+		return Result{}, fmt.Errorf("cannot move directory %s: only directories on this machine can be moved", c.Source.Location(c.Name()))
+	}
+	// A followed link is copied from where it points, and only the link
+	// itself removed
+	link := filepath.Join(src.Dir, c.Name())
+	root, isLink := link, false
+	if info, err := os.Lstat(link); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if root, err = filepath.EvalSymlinks(link); err != nil {
+			return Result{}, fmt.Errorf("failed to read directory %s: %w", c.Name(), err)
+		}
+		isLink = true
+	}
+	size, err := DirSize(ctx, root)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := checkSpace(filepath.Dir(dest), size); err != nil {
+		return Result{}, err
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		if !opts.Trash {
+			return Result{}, WithKind(fmt.Errorf("%s already exists", dest), ErrConflict)
+		}
+		if err := Trash(dest); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return Result{}, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	result := Result{Source: c.Source.Location(c.Name()), Dest: dest, Size: size}
+	renamed := false
+	switch {
+	case opts.TarDirs:
+		result.Size, result.SHA256, err = tarDir(ctx, root, c.Name(), dest)
+	case !opts.KeepSource && !isLink && os.Rename(root, dest) == nil:
+		renamed = true
+	default:
+		err = copyTree(ctx, root, dest)
+		if err == nil {
+			err = verifyTree(ctx, dest, size)
+		}
+		if err != nil {
+			os.RemoveAll(dest)
+		}
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	if !opts.KeepSource && !renamed {
+		if t, ok := c.Source.(trasher); ok && opts.Trash {
+			err = t.Trash(c.Name())
+		} else {
+			err = os.RemoveAll(link)
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to remove original directory: %w", err)
+		}
+	}
+	result.Duration = clk.Since(start)
+	return result, nil
+}
+
+// DirSize is the total size of the files in the directory at path.
+func DirSize(ctx context.Context, path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory %s: %w", filepath.Base(path), err)
+	}
+	return size, nil
+}
+
+// copyTree copies the directory src to dest, which must not exist. Files
+// keep their permissions and symbolic links are copied as links.
+func copyTree(ctx context.Context, src, dest string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			r, err := os.Open(p)
+			if err != nil {
+				return fmt.Errorf("failed to open source file: %w", err)
+			}
+			_, err = WriteFile(ctx, target, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		}
+		// Sockets, devices and the like are left behind
+		return nil
+	})
+}
+
+// verifyTree checks the copy at dest holds size bytes of files.
+func verifyTree(ctx context.Context, dest string, size int64) error {
+	got, err := DirSize(ctx, dest)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy: %w", err)
+	}
+	if got != size {
+		return fmt.Errorf("copy of %s holds %d bytes, expected %d", filepath.Base(dest), got, size)
+	}
+	return nil
+}
+
+// tarDir packs the directory src into a gzipped tarball at dest, under
+// name, returning the tarball's size and SHA-256.
+func tarDir(ctx context.Context, src, name, dest string) (int64, string, error) {
+	partial := dest + PartialSuffix
+	f, err := os.Create(partial)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create destination file: %w", err)
+	}
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(f, hash))
+	tw := tar.NewWriter(gz)
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if d.Type()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		r, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = CopyContext(ctx, tw, r)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, dest)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return 0, "", fmt.Errorf("failed to pack %s: %w", filepath.Base(src), err)
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to verify copy: %w", err)
+	}
+	return info.Size(), hex.EncodeToString(hash.Sum(nil)), nil
+}
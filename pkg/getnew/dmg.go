@@ -0,0 +1,173 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// dmgSystemDirs are made by macOS on volumes and are not part of an image's
+// contents.
+var dmgSystemDirs = map[string]bool{".Trashes": true, ".fseventsd": true, ".Spotlight-V100": true, ".DS_Store": true}
+
+// canAttachDMG reports whether disk images can be attached here, which
+// only macOS can do.
+func canAttachDMG() bool {
+	if runtime.GOOS != "darwin" {
+		return false
+	}
+	_, err := exec.LookPath("hdiutil")
+	return err == nil
+}
+
+// extractDMG unpacks a macOS disk image: on macOS by attaching it read-only
+// with hdiutil and copying out what is on it, elsewhere with 7-Zip.
+func extractDMG(ctx context.Context, src, dir string, opts Options, filter *entryFilter) error {
+	if !canAttachDMG() {
+		return extractWithTool(ctx, src, dir, opts, filter)
+	}
+	return withAttachedDMG(ctx, src, func(mount string) error {
+		return walkDMG(mount, func(rel string, d fs.DirEntry) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			target, err := entryPath(dir, rel)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			switch {
+			case d.IsDir():
+				if filter == nil {
+					return os.MkdirAll(target, 0o755)
+				}
+				return nil
+			case !filter.take(rel):
+				return nil
+			case d.Type()&fs.ModeSymlink != 0:
+				// Such as the link to /Applications to drag apps onto
+				link, err := os.Readlink(filepath.Join(mount, rel))
+				if err != nil {
+					return err
+				}
+				if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+					return err
+				}
+				return os.Symlink(link, target)
+			case d.Type().IsRegular():
+				f, err := os.Open(filepath.Join(mount, rel))
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				return writeEntry(ctx, target, f, info.Mode(), info.ModTime())
+			}
+			return nil
+		})
+	})
+}
+
+func listDMG(ctx context.Context, src string, opts Options) ([]ArchiveEntry, error) {
+	if !canAttachDMG() {
+		return listWithTool(ctx, src, opts)
+	}
+	var entries []ArchiveEntry
+	err := withAttachedDMG(ctx, src, func(mount string) error {
+		return walkDMG(mount, func(rel string, d fs.DirEntry) error {
+			size := int64(-1)
+			if info, err := d.Info(); err == nil && d.Type().IsRegular() {
+				size = info.Size()
+			}
+			entries = append(entries, ArchiveEntry{Name: rel, Size: size, Dir: d.IsDir()})
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// testDMG checks the checksums in a macOS disk image: with hdiutil on macOS,
+// elsewhere with 7-Zip.
+func testDMG(ctx context.Context, src string, opts Options) error {
+	if !canAttachDMG() {
+		return testWithTool(ctx, src, opts)
+	}
+	out, err := exec.CommandContext(ctx, "hdiutil", "verify", "-quiet", src).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// withAttachedDMG attaches the disk image at src read-only at a temporary
+// mount point, runs fn with it, and detaches the image again.
+func withAttachedDMG(ctx context.Context, src string, fn func(mount string) error) error {
+	mount, err := os.MkdirTemp("", "getnew-dmg-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mount)
+	cmd := exec.CommandContext(ctx, "hdiutil", "attach", "-nobrowse", "-noautoopen", "-readonly", "-mountpoint", mount, src)
+	// Images with a licence show it in a pager, then wait for Y to agree
+	cmd.Stdin = strings.NewReader("qY\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if passwordProblem(string(out)) {
+			return fmt.Errorf("%w: %v", ErrEncrypted, err)
+		}
+		return fmt.Errorf("failed to attach %s: %w: %s", filepath.Base(src), err, bytes.TrimSpace(out))
+	}
+	// Detach even if ctx was cancelled while copying
+	defer exec.Command("hdiutil", "detach", "-quiet", "-force", mount).Run()
+	return fn(mount)
+}
+
+// walkDMG calls fn with each entry on a mounted image, by its path with
+// slashes relative to mount, leaving out what macOS keeps on volumes.
+func walkDMG(mount string, fn func(rel string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(mount, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == mount {
+			return err
+		}
+		rel, err := filepath.Rel(mount, p)
+		if err != nil {
+			return err
+		}
+		if dmgSystemDirs[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(filepath.ToSlash(rel), d)
+	})
+}
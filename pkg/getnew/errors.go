@@ -0,0 +1,57 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import "errors"
+
+// Kinds of error that callers can tell apart with errors.Is, such as to
+// choose an exit status. The errors returned carry their own messages.
+var (
+	// ErrNoCandidates means no file matched, so there was nothing to do.
+	ErrNoCandidates = errors.New("no matching files")
+	// ErrNotEnoughFiles means fewer files matched than the one asked for.
+	ErrNotEnoughFiles = errors.New("not enough matching files")
+	// ErrConflict means another file or another run is in the way.
+	ErrConflict = errors.New("conflict")
+	// ErrExtractFailed means an archive could not be unpacked.
+	ErrExtractFailed = errors.New("extraction failed")
+	// ErrCorruptArchive means an archive failed TestArchive: it is damaged
+	// or was cut short.
+	ErrCorruptArchive = errors.New("corrupt archive")
+)
+
+// WithKind marks err as being of the given kind, keeping its message, so
+// that errors.Is(err, kind) holds. It returns nil for a nil err.
+func WithKind(err, kind error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{err: err, kind: kind}
+}
+
+type kindError struct {
+	err, kind error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
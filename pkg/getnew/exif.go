@@ -0,0 +1,245 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+// CaptureTime returns when the photo or video c was taken: the EXIF
+// DateTimeOriginal of a JPEG or a TIFF-based raw file (CR2, NEF, ARW, DNG
+// and so on), or the creation time in the movie header of an MP4 or MOV.
+// ok is false for other formats and files that do not record it.
+func CaptureTime(c Candidate) (t time.Time, ok bool) {
+	r, closer, err := openReaderAt(c)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer closer.Close()
+	head := make([]byte, 12)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return time.Time{}, false
+	}
+	if string(head[4:8]) == "ftyp" {
+		return movieCreationTime(r)
+	}
+	tags := exifTags(r)
+	for _, tag := range []uint16{exifDateTimeOriginal, exifDateTime} {
+		if t, err := time.ParseInLocation("2006:01:02 15:04:05", tags[tag], time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// metadataReadLimit is how much of a file is read for its metadata when its
+// source cannot read from anywhere in it.
+const metadataReadLimit = 1 << 20
+
+// openReaderAt opens c for reading anywhere in it, reading the start of it
+// into memory when its source can only read it from the beginning.
+func openReaderAt(c Candidate) (io.ReaderAt, io.Closer, error) {
+	rc, err := c.Source.Open(c.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	if r, ok := rc.(io.ReaderAt); ok {
+		return r, rc, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, metadataReadLimit))
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	return bytes.NewReader(data), rc, nil
+}
+
+// EXIF tags that are read.
+const (
+	exifMake             = 0x010F
+	exifModel            = 0x0110
+	exifDateTime         = 0x0132
+	exifIFDPointer       = 0x8769
+	exifDateTimeOriginal = 0x9003
+	exifLensModel        = 0xA434
+)
+
+// exifTags reads the text tags of the main and EXIF directories from a
+// JPEG or TIFF file. Anything it cannot make sense of is left out.
+func exifTags(r io.ReaderAt) map[uint16]string {
+	tags := map[uint16]string{}
+	base, ok := tiffStart(r)
+	if !ok {
+		return tags
+	}
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, base); err != nil {
+		return tags
+	}
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return tags
+	}
+	exifIFD := readIFD(r, base, int64(order.Uint32(header[4:])), order, tags)
+	if exifIFD > 0 {
+		readIFD(r, base, exifIFD, order, tags)
+	}
+	return tags
+}
+
+// tiffStart finds where the TIFF structure holding the EXIF data starts: the
+// beginning of a TIFF file, or inside the APP1 segment of a JPEG.
+func tiffStart(r io.ReaderAt) (int64, bool) {
+	marker := make([]byte, 10)
+	if _, err := r.ReadAt(marker[:2], 0); err != nil {
+		return 0, false
+	}
+	if marker[0] != 0xFF || marker[1] != 0xD8 {
+		return 0, true
+	}
+	for at := int64(2); ; {
+		if _, err := r.ReadAt(marker, at); err != nil || marker[0] != 0xFF {
+			return 0, false
+		}
+		kind, length := marker[1], int64(binary.BigEndian.Uint16(marker[2:4]))
+		if kind == 0xE1 && string(marker[4:10]) == "Exif\x00\x00" {
+			return at + 10, true
+		}
+		// The image data starts at the start of scan; nothing comes after
+		if kind == 0xDA || length < 2 {
+			return 0, false
+		}
+		at += 2 + length
+	}
+}
+
+// readIFD adds the text entries of the image file directory at offset to
+// tags, returning the offset of the EXIF directory if this one points to it.
+func readIFD(r io.ReaderAt, base, offset int64, order binary.ByteOrder, tags map[uint16]string) int64 {
+	count := make([]byte, 2)
+	if _, err := r.ReadAt(count, base+offset); err != nil {
+		return 0
+	}
+	n := int(order.Uint16(count))
+	if n > 512 {
+		return 0
+	}
+	entries := make([]byte, 12*n)
+	if _, err := r.ReadAt(entries, base+offset+2); err != nil {
+		return 0
+	}
+	var exifIFD int64
+	for i := 0; i < n; i++ {
+		e := entries[12*i : 12*(i+1)]
+		tag, kind, size := order.Uint16(e), order.Uint16(e[2:]), order.Uint32(e[4:])
+		switch {
+		case tag == exifIFDPointer && kind == 4:
+			exifIFD = int64(order.Uint32(e[8:]))
+		case kind == 2 && size <= 4:
+			tags[tag] = exifString(e[8 : 8+size])
+		case kind == 2 && size <= 1024:
+			value := make([]byte, size)
+			if _, err := r.ReadAt(value, base+int64(order.Uint32(e[8:]))); err == nil {
+				tags[tag] = exifString(value)
+			}
+		}
+	}
+	return exifIFD
+}
+
+func exifString(b []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// movieCreationTime reads the creation time from the mvhd box of an MP4 or
+// QuickTime file, which counts seconds from 1904.
+func movieCreationTime(r io.ReaderAt) (time.Time, bool) {
+	moov, moovEnd, ok := findBox(r, 0, -1, "moov")
+	if !ok {
+		return time.Time{}, false
+	}
+	mvhd, _, ok := findBox(r, moov, moovEnd, "mvhd")
+	if !ok {
+		return time.Time{}, false
+	}
+	// Version 1 has 64-bit times after the version and flags, version 0 32-bit
+	b := make([]byte, 12)
+	if _, err := r.ReadAt(b[:1], mvhd); err != nil {
+		return time.Time{}, false
+	}
+	if b[0] != 1 {
+		b = b[:8]
+	}
+	if _, err := r.ReadAt(b, mvhd); err != nil {
+		return time.Time{}, false
+	}
+	var secs uint64
+	if b[0] == 1 {
+		secs = binary.BigEndian.Uint64(b[4:12])
+	} else {
+		secs = uint64(binary.BigEndian.Uint32(b[4:8]))
+	}
+	const epochOffset = 2082844800 // 1904-01-01 to 1970-01-01
+	if secs <= epochOffset {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(secs-epochOffset), 0), true
+}
+
+// findBox finds the box of the given type among those from start to end (-1
+// for the end of the file), returning where its contents start and end.
+func findBox(r io.ReaderAt, start, end int64, kind string) (int64, int64, bool) {
+	header := make([]byte, 16)
+	for at := start; end < 0 || at+8 <= end; {
+		if _, err := r.ReadAt(header[:8], at); err != nil {
+			return 0, 0, false
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0:
+			size = end - at // to the end of the enclosing box
+		case 1:
+			if _, err := r.ReadAt(header[8:], at+8); err != nil {
+				return 0, 0, false
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize {
+			return 0, 0, false
+		}
+		if string(header[4:8]) == kind {
+			return at + headerSize, at + size, true
+		}
+		at += size
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,594 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrEncrypted is returned for an encrypted archive when no password, or
+// the wrong one, was given.
+var ErrEncrypted = errors.New("archive is encrypted")
+
+// ArchiveEntry is a file or directory inside an archive.
+type ArchiveEntry struct {
+	Name string
+	// Size is -1 where the listing does not give it.
+	Size int64
+	Dir  bool
+}
+
+// Extract unpacks the archive at path into the directory it is in and removes
+// the archive afterwards. Zip, tar, gzip and bzip2 archives are unpacked
+// directly; others, and encrypted zip files, need an external tool, the best
+// installed one being used. RegisterExtractor adds other formats. If
+// extraction fails or ctx is cancelled, anything it added to the directory is
+// removed. With opts.ExtractOnly, only matching entries are unpacked, and
+// with it or opts.KeepArchive the archive is kept. With opts.Depth, archives
+// found inside are unpacked in turn and removed. Failures are marked as
+// ErrExtractFailed.
+func Extract(ctx context.Context, path string, opts Options) error {
+	_, err := Unpack(ctx, path, opts)
+	return err
+}
+
+// Unpack is Extract, also returning where the archive's contents went: the
+// one directory it unpacked to, if it held everything in a single top-level
+// directory, or else the directory the archive is in.
+func Unpack(ctx context.Context, path string, opts Options) (string, error) {
+	root, err := extract(ctx, path, filepath.Dir(path), opts)
+	if err == nil && len(opts.ExtractOnly) == 0 && !opts.KeepArchive {
+		if err = os.Remove(path); err != nil {
+			err = fmt.Errorf("failed to remove original archive file: %w", err)
+		}
+	}
+	if err == nil || ctx.Err() != nil {
+		return root, err
+	}
+	return "", WithKind(err, ErrExtractFailed)
+}
+
+// UnpackInto is Unpack into dir rather than the directory the archive is in,
+// so an archive can be unpacked where it is wanted without first being
+// copied there. The archive is always left in place.
+func UnpackInto(ctx context.Context, path, dir string, opts Options) (string, error) {
+	root, err := extract(ctx, path, dir, opts)
+	if err == nil || ctx.Err() != nil {
+		return root, err
+	}
+	return "", WithKind(err, ErrExtractFailed)
+}
+
+// extract unpacks the archive at path into dir, leaving the archive there.
+// It unpacks into a scratch directory of its own and only then moves what it
+// unpacked into dir, so that a failure removes nothing others have put there.
+func extract(ctx context.Context, path, dir string, opts Options) (string, error) {
+	name := filepath.Base(path)
+	filter := newEntryFilter(opts.ExtractOnly)
+	if filter != nil {
+		filter.nested = opts.Depth > 1
+	}
+
+	scratch, err := os.MkdirTemp(dir, ".getnew-unpack-")
+	if err != nil {
+		return "", fmt.Errorf("failed to unarchive %s: %w", name, err)
+	}
+	defer os.RemoveAll(scratch)
+
+	added, err := unpack(ctx, path, scratch, opts, filter)
+	if err != nil {
+		return "", err
+	}
+	for depth := 1; depth < opts.Depth && len(added) > 0; depth++ {
+		var next []string
+		for _, archive := range nestedArchives(scratch, added, filter) {
+			more, err := unpack(ctx, archive, filepath.Dir(archive), opts, filter)
+			if err == nil {
+				err = os.Remove(archive)
+			}
+			if err != nil {
+				return "", fmt.Errorf("in %s: %w", name, err)
+			}
+			next = append(next, more...)
+		}
+		added = next
+	}
+	if filter != nil && filter.matched == 0 {
+		return "", fmt.Errorf("failed to unarchive %s: nothing in it matches %s", name, strings.Join(opts.ExtractOnly, ", "))
+	}
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("unarchiving %s: %w", name, err)
+	}
+	root, err := placeUnpacked(scratch, dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to unarchive %s: %w", name, err)
+	}
+	return root, nil
+}
+
+// placeUnpacked moves everything in scratch into dir, merging directories
+// that are already there, and returns the one directory it added to dir, if
+// it added only that, or else dir itself. If it fails, the files and
+// directories it added are removed again.
+func placeUnpacked(scratch, dir string) (string, error) {
+	entries, err := os.ReadDir(scratch)
+	if err != nil {
+		return "", err
+	}
+	var placed, top []string
+	for _, entry := range entries {
+		target := filepath.Join(dir, entry.Name())
+		n := len(placed)
+		if err := mergeInto(filepath.Join(scratch, entry.Name()), target, &placed); err != nil {
+			for i := len(placed) - 1; i >= 0; i-- {
+				os.RemoveAll(placed[i])
+			}
+			return "", err
+		}
+		if len(placed) > n && placed[n] == target {
+			top = append(top, target)
+		}
+	}
+	if len(top) == 1 {
+		if info, err := os.Stat(top[0]); err == nil && info.IsDir() {
+			return top[0], nil
+		}
+	}
+	return dir, nil
+}
+
+// mergeInto moves from to to, adding the paths it creates to placed. A
+// directory moved onto one that already exists is merged into it, and a
+// file moved onto another replaces it, as unpacking in place would.
+func mergeInto(from, to string, placed *[]string) error {
+	existing, err := os.Lstat(to)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		*placed = append(*placed, to)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(from)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return os.Rename(from, to)
+	}
+	if !existing.IsDir() {
+		return fmt.Errorf("%s is in the way of a directory in the archive", to)
+	}
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := mergeInto(filepath.Join(from, entry.Name()), filepath.Join(to, entry.Name()), placed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpack extracts one archive into dir, returning the paths of the files and
+// directories it added there. If it fails, they are removed.
+func unpack(ctx context.Context, path, dir string, opts Options, filter *entryFilter) ([]string, error) {
+	name := filepath.Base(path)
+	e := extractorFor(path)
+	if e == nil {
+		return nil, fmt.Errorf("not a recognized archive format: %s", name)
+	}
+	format := archiveFormat(name)
+	if singleFile(format) && !filter.take(strings.TrimSuffix(name, filepath.Ext(name))) {
+		return nil, nil
+	}
+
+	if err := checkSpace(dir, declaredSize(ctx, path, format, opts)); err != nil {
+		return nil, fmt.Errorf("cannot unarchive %s: %w", name, err)
+	}
+
+	existed, err := dirNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	added := func() []string {
+		var paths []string
+		if after, err := dirNames(dir); err == nil {
+			for entry := range after {
+				if !existed[entry] {
+					paths = append(paths, filepath.Join(dir, entry))
+				}
+			}
+		}
+		return paths
+	}
+	undo := func() {
+		for _, path := range added() {
+			os.RemoveAll(path)
+		}
+	}
+
+	err = extractWith(ctx, e, path, dir, opts, filter)
+	if _, builtin := e.(formatExtractor); builtin && errors.Is(err, ErrEncrypted) {
+		// Try again with a password, asking for one only now it is needed
+		undo()
+		err = extractEncrypted(ctx, path, dir, format, opts, filter)
+	}
+	if err != nil {
+		undo()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("unarchiving %s: %w", name, ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to unarchive %s: %w", name, err)
+	}
+	return added(), nil
+}
+
+// extractWith unpacks the archive at src into dir with e. Only the built-in
+// extractors take a filter; others unpack into a scratch directory, from
+// which only the entries the filter takes are moved out.
+func extractWith(ctx context.Context, e Extractor, src, dir string, opts Options, filter *entryFilter) error {
+	if b, ok := e.(formatExtractor); ok {
+		return b.extract(ctx, src, dir, opts, filter)
+	}
+	if filter == nil {
+		return e.Extract(ctx, src, dir, opts)
+	}
+	return throughScratch(dir, filter, func(scratch string) error {
+		return e.Extract(ctx, src, scratch, opts)
+	})
+}
+
+func dirNames(dir string) (map[string]bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	return names, nil
+}
+
+// nestedArchives finds the archives among the paths just unpacked into dir,
+// leaving out those a filter took because they matched its patterns.
+func nestedArchives(dir string, added []string, filter *entryFilter) []string {
+	var archives []string
+	for _, root := range added {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() || !IsArchive(d.Name()) {
+				return nil
+			}
+			if rel, err := filepath.Rel(dir, p); err == nil && filter != nil && MatchesEntry(filepath.ToSlash(rel), filter.patterns) {
+				return nil
+			}
+			archives = append(archives, p)
+			return nil
+		})
+	}
+	return archives
+}
+
+// extractEncrypted unpacks an encrypted archive with an external tool, using
+// the password from opts.Password.
+func extractEncrypted(ctx context.Context, path, dir, format string, opts Options, filter *entryFilter) error {
+	if opts.Password == nil {
+		return fmt.Errorf("%w and no password was given", ErrEncrypted)
+	}
+	pw, err := opts.Password(path)
+	if err != nil {
+		return err
+	}
+	if pw == "" {
+		return fmt.Errorf("%w and no password was given", ErrEncrypted)
+	}
+	tool, toolPath, err := findPasswordExtractor(ctx, format)
+	if err != nil {
+		return err
+	}
+	if err := unpackWith(ctx, tool, toolPath, path, dir, pw, opts, filter); err != nil {
+		if errors.Is(err, ErrEncrypted) {
+			return fmt.Errorf("wrong password: %w", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// unpackWith unpacks the archive at path into dir with an external tool.
+// With a filter, it is unpacked into a scratch directory and only the
+// entries the filter takes are moved out of it.
+func unpackWith(ctx context.Context, tool extractorTool, toolPath, path, dir, pw string, opts Options, filter *entryFilter) error {
+	if filter == nil || singleFile(archiveFormat(path)) {
+		return runExtractor(ctx, tool, toolPath, path, dir, pw, opts)
+	}
+	return throughScratch(dir, filter, func(scratch string) error {
+		return runExtractor(ctx, tool, toolPath, path, scratch, pw, opts)
+	})
+}
+
+// throughScratch runs extract on a scratch directory in dir, then moves the
+// files in it that filter takes into dir.
+func throughScratch(dir string, filter *entryFilter, extract func(scratch string) error) error {
+	scratch, err := os.MkdirTemp(dir, ".getnew-extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+	if err := extract(scratch); err != nil {
+		return err
+	}
+	return filepath.WalkDir(scratch, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(scratch, p)
+		if err != nil || !filter.take(filepath.ToSlash(rel)) {
+			return err
+		}
+		target := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.Rename(p, target)
+	})
+}
+
+// runExtractor unpacks path into dir with an external tool, using password
+// pw if the tool takes one. ErrEncrypted is returned if the tool's output
+// shows it failed for want of the right password.
+func runExtractor(ctx context.Context, tool extractorTool, toolPath, path, dir, pw string, opts Options) error {
+	archive, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, toolPath, tool.withPassword(tool.args(archive), pw)...)
+	cmd.Dir = dir
+	cmd.Stdout = io.MultiWriter(orDiscard(opts.Stdout), &out)
+	cmd.Stderr = io.MultiWriter(orDiscard(opts.Stderr), &out)
+	err = cmd.Run()
+	if err != nil && tool.password != nil && passwordProblem(out.String()) {
+		return fmt.Errorf("%w: %v", ErrEncrypted, err)
+	}
+	return err
+}
+
+// passwordProblem tells from a tool's output whether it stopped for a
+// missing or wrong password.
+func passwordProblem(output string) bool {
+	output = strings.ToLower(output)
+	for _, word := range []string{"password", "passphrase", "encrypted"} {
+		if strings.Contains(output, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// singleFile reports whether format compresses a single file rather than
+// holding many.
+func singleFile(format string) bool {
+	return format == ".gz" || format == ".xz"
+}
+
+// entryFilter picks the archive entries to unpack with Options.ExtractOnly,
+// counting those it takes. A nil filter takes everything. With nested, it
+// also takes archives, to look inside them.
+type entryFilter struct {
+	patterns []string
+	matched  int
+	nested   bool
+}
+
+func newEntryFilter(patterns []string) *entryFilter {
+	if len(patterns) == 0 {
+		return nil
+	}
+	return &entryFilter{patterns: patterns}
+}
+
+func (f *entryFilter) take(name string) bool {
+	if f == nil {
+		return true
+	}
+	if MatchesEntry(name, f.patterns) {
+		f.matched++
+		return true
+	}
+	return f.nested && IsArchive(name)
+}
+
+// MatchesEntry reports whether an archive entry, named with slashes, matches
+// any of patterns. Patterns are case-insensitive globs, matched against the
+// whole name if they contain a slash and otherwise against its last part.
+func MatchesEntry(name string, patterns []string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "/"))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		subject := name
+		if !strings.Contains(pattern, "/") {
+			subject = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ListArchive lists the entries of the archive at path, or with
+// opts.ExtractOnly, the files Extract would unpack.
+func ListArchive(ctx context.Context, archivePath string, opts Options) ([]ArchiveEntry, error) {
+	name := filepath.Base(archivePath)
+	e := extractorFor(archivePath)
+	if e == nil {
+		return nil, fmt.Errorf("not a recognized archive format: %s", name)
+	}
+	lister, ok := e.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("failed to list %s: its extractor cannot list archives", name)
+	}
+	entries, err := lister.List(ctx, archivePath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", name, err)
+	}
+	if len(opts.ExtractOnly) == 0 {
+		return entries, nil
+	}
+	var matched []ArchiveEntry
+	for _, e := range entries {
+		if !e.Dir && MatchesEntry(e.Name, opts.ExtractOnly) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+// TestArchive checks that the archive at path is whole and undamaged, without
+// unpacking it: zip files against their CRCs, compressed archives by
+// decoding them in full, and 7z and RAR archives with an external tool.
+// Encrypted archives are tested with the password from opts.Password, where
+// the tool can take one. Damaged archives are marked as ErrCorruptArchive;
+// archives that could not be tested at all are not.
+func TestArchive(ctx context.Context, path string, opts Options) error {
+	name := filepath.Base(path)
+	e := extractorFor(path)
+	if e == nil {
+		return fmt.Errorf("not a recognized archive format: %s", name)
+	}
+	var err error
+	if t, ok := e.(Tester); ok {
+		err = t.Test(ctx, path, opts)
+	} else {
+		err = testByExtracting(ctx, e, path, opts)
+	}
+	switch {
+	case err == nil || ctx.Err() != nil:
+		return err
+	case errors.Is(err, ErrEncrypted) || errors.Is(err, errUntestable):
+		return fmt.Errorf("cannot test %s: %w", name, err)
+	}
+	return WithKind(fmt.Errorf("%s is damaged: %w", name, err), ErrCorruptArchive)
+}
+
+// errUntestable marks errors that stopped an archive being tested at all,
+// rather than showing it is damaged.
+var errUntestable = errors.New("cannot test archive")
+
+// testByExtracting tests an archive whose extractor cannot by unpacking it
+// into a scratch directory beside it.
+func testByExtracting(ctx context.Context, e Extractor, path string, opts Options) error {
+	scratch, err := os.MkdirTemp(filepath.Dir(path), ".getnew-test-")
+	if err != nil {
+		return WithKind(err, errUntestable)
+	}
+	defer os.RemoveAll(scratch)
+	return e.Extract(ctx, path, scratch, opts)
+}
+
+// testWithTool tests an archive with the best installed external tool for
+// its format that can.
+func testWithTool(ctx context.Context, path string, opts Options) error {
+	tool, toolPath, err := findTester(ctx, archiveFormat(path))
+	if err != nil {
+		return WithKind(err, errUntestable)
+	}
+	err = runTester(ctx, tool, toolPath, path, "")
+	if errors.Is(err, ErrEncrypted) && opts.Password != nil {
+		pw, perr := opts.Password(path)
+		if perr != nil {
+			return perr
+		}
+		if pw != "" {
+			if err = runTester(ctx, tool, toolPath, path, pw); errors.Is(err, ErrEncrypted) {
+				return fmt.Errorf("wrong password: %w", err)
+			}
+		}
+	}
+	return err
+}
+
+// runTester tests path with an external tool, using password pw if the
+// tool takes one.
+func runTester(ctx context.Context, tool extractorTool, toolPath, path, pw string) error {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, toolPath, tool.withPassword(tool.test(path), pw)...)
+	// Some tools test by unpacking everything to stdout
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if tool.password != nil && passwordProblem(out.String()) {
+		return fmt.Errorf("%w: %v", ErrEncrypted, err)
+	}
+	if msg := bytes.TrimSpace(out.Bytes()); len(msg) > 0 {
+		return fmt.Errorf("%s: %w: %s", tool.name, err, msg)
+	}
+	return fmt.Errorf("%s: %w", tool.name, err)
+}
+
+func listWithTool(ctx context.Context, path string, _ Options) ([]ArchiveEntry, error) {
+	tool, toolPath, err := findLister(ctx, archiveFormat(path))
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.CommandContext(ctx, toolPath, tool.withPassword(tool.list(path), "")...).Output()
+	if err != nil {
+		return nil, err
+	}
+	if tool.parse != nil {
+		return tool.parse(string(out)), nil
+	}
+	var entries []ArchiveEntry
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			entries = append(entries, ArchiveEntry{Name: line, Size: -1, Dir: strings.HasSuffix(line, "/")})
+		}
+	}
+	return entries, nil
+}
+
+func orDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
@@ -0,0 +1,201 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Extractor unpacks one kind of archive. Those built in unpack zip, tar,
+// gzip and bzip2 archives, ISO 9660 disc images and Debian and RPM packages
+// themselves, and 7z, RAR, xz and macOS disk images with external tools;
+// RegisterExtractor adds more.
+type Extractor interface {
+	// Match reports whether an archive is one the extractor unpacks, from
+	// the first HeaderSize bytes of the file (or fewer if it is shorter)
+	// and its name. header is nil when only the name is known, as when
+	// telling whether a file is an archive at all.
+	Match(header []byte, name string) bool
+	// Extract unpacks everything in the archive at src into destDir,
+	// leaving src in place. Encrypted archives are opened with
+	// opts.Password, and ErrEncrypted returned without the right one.
+	// Entries are picked for opts.ExtractOnly by the caller.
+	Extract(ctx context.Context, src, destDir string, opts Options) error
+}
+
+// Lister is an Extractor that can also list what is in an archive without
+// unpacking it, for ListArchive.
+type Lister interface {
+	List(ctx context.Context, src string, opts Options) ([]ArchiveEntry, error)
+}
+
+// Tester is an Extractor that can also check an archive is whole and
+// undamaged without unpacking it, for TestArchive. Archives whose extractor
+// cannot are checked by unpacking them into a scratch directory.
+type Tester interface {
+	Test(ctx context.Context, src string, opts Options) error
+}
+
+// HeaderSize is how much of the start of an archive Match is given, enough
+// to reach the signatures of disk images.
+const HeaderSize = 64 << 10
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   []Extractor
+)
+
+// RegisterExtractor adds e to the extractors tried for each archive. It is
+// tried before those built in and any registered earlier, so it can also take
+// over a format getnew already knows.
+func RegisterExtractor(e Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors = append([]Extractor{e}, extractors...)
+}
+
+// extractorFor picks the extractor for the archive at path, or nil.
+func extractorFor(path string) Extractor {
+	var header []byte
+	if f, err := os.Open(path); err == nil {
+		header = make([]byte, HeaderSize)
+		n, _ := io.ReadFull(f, header)
+		header = header[:n]
+		f.Close()
+	}
+	return matchExtractor(header, filepath.Base(path))
+}
+
+func matchExtractor(header []byte, name string) Extractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	for _, e := range extractors {
+		if e.Match(header, name) {
+			return e
+		}
+	}
+	for _, e := range builtinExtractors {
+		if e.Match(header, name) {
+			return e
+		}
+	}
+	return nil
+}
+
+// formatExtractor is a built-in extractor for archives with one of formats
+// as their extension, as archiveFormat tells it. It picks entries for an
+// entry filter itself.
+type formatExtractor struct {
+	formats []string
+	extract func(ctx context.Context, src, dir string, opts Options, filter *entryFilter) error
+	list    func(ctx context.Context, src string, opts Options) ([]ArchiveEntry, error)
+	test    func(ctx context.Context, src string, opts Options) error
+}
+
+func (e formatExtractor) Match(_ []byte, name string) bool {
+	return contains(e.formats, archiveFormat(name))
+}
+
+func (e formatExtractor) Extract(ctx context.Context, src, destDir string, opts Options) error {
+	return e.extract(ctx, src, destDir, opts, newEntryFilter(opts.ExtractOnly))
+}
+
+func (e formatExtractor) List(ctx context.Context, src string, opts Options) ([]ArchiveEntry, error) {
+	return e.list(ctx, src, opts)
+}
+
+func (e formatExtractor) Test(ctx context.Context, src string, opts Options) error {
+	return e.test(ctx, src, opts)
+}
+
+// builtinExtractors are set in init, as they refer back to matchExtractor.
+var builtinExtractors []formatExtractor
+
+func init() {
+	builtinExtractors = []formatExtractor{
+		{
+			formats: []string{".zip"},
+			extract: func(ctx context.Context, src, dir string, _ Options, filter *entryFilter) error {
+				return extractZip(ctx, src, dir, filter)
+			},
+			list: func(_ context.Context, src string, _ Options) ([]ArchiveEntry, error) { return listZip(src) },
+			test: testZip,
+		},
+		tarExtractor("", ".tar"),
+		tarExtractor("gzip", ".tar.gz", ".tgz"),
+		tarExtractor("bzip2", ".tar.bz2", ".tbz2"),
+		{
+			formats: []string{".gz"},
+			extract: func(ctx context.Context, src, dir string, _ Options, _ *entryFilter) error {
+				return gunzipFile(ctx, src, dir)
+			},
+			list: listCompressedFile,
+			test: func(ctx context.Context, src string, _ Options) error {
+				return testCompressed(ctx, src, "gzip")
+			},
+		},
+		{formats: []string{".iso"}, extract: extractISO, list: listISO, test: testISO},
+		{formats: []string{".dmg"}, extract: extractDMG, list: listDMG, test: testDMG},
+		{formats: []string{".deb"}, extract: extractDeb, list: listDeb, test: testDeb},
+		{formats: []string{".rpm"}, extract: extractRPM, list: listRPM, test: testRPM},
+		{formats: []string{".xz"}, extract: extractWithTool, list: listCompressedFile, test: testWithTool},
+		{formats: []string{".7z", ".rar", ".tar.xz", ".txz"}, extract: extractWithTool, list: listWithTool, test: testWithTool},
+	}
+}
+
+func tarExtractor(compression string, formats ...string) formatExtractor {
+	return formatExtractor{
+		formats: formats,
+		extract: func(ctx context.Context, src, dir string, _ Options, filter *entryFilter) error {
+			return extractTar(ctx, src, dir, compression, filter)
+		},
+		list: func(ctx context.Context, src string, _ Options) ([]ArchiveEntry, error) {
+			return listTar(ctx, src, compression)
+		},
+		test: func(ctx context.Context, src string, _ Options) error {
+			return testTar(ctx, src, compression)
+		},
+	}
+}
+
+// extractWithTool unpacks an archive with the best installed external tool
+// for its format.
+func extractWithTool(ctx context.Context, src, dir string, opts Options, filter *entryFilter) error {
+	tool, toolPath, err := findExtractor(ctx, archiveFormat(src))
+	if err != nil {
+		return err
+	}
+	return unpackWith(ctx, tool, toolPath, src, dir, "", opts, filter)
+}
+
+// listCompressedFile lists the one file in a compressed file, whose size is
+// not known without decompressing it.
+func listCompressedFile(_ context.Context, src string, _ Options) ([]ArchiveEntry, error) {
+	name := filepath.Base(src)
+	return []ArchiveEntry{{Name: strings.TrimSuffix(name, filepath.Ext(name)), Size: -1}}, nil
+}
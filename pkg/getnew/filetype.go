@@ -0,0 +1,114 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// FileTypes are the broad kinds of file Options.Type can select.
+var FileTypes = []string{"image", "video", "audio", "pdf", "doc", "archive", "text"}
+
+var typeByExt = map[string]string{}
+
+func init() {
+	for kind, exts := range map[string]string{
+		"image":   ".jpg .jpeg .png .gif .webp .bmp .tif .tiff .heic .heif .avif .svg .ico .raw .cr2 .cr3 .nef .arw .dng",
+		"video":   ".mp4 .m4v .mkv .webm .mov .avi .wmv .flv .mpg .mpeg .3gp",
+		"audio":   ".mp3 .m4a .aac .flac .wav .ogg .oga .opus .wma .aiff",
+		"pdf":     ".pdf",
+		"doc":     ".doc .docx .odt .rtf .pages .xls .xlsx .ods .numbers .ppt .pptx .odp .key .epub",
+		"archive": ".zip .tar .gz .tgz .bz2 .tbz2 .xz .txz .7z .rar .zst .iso .dmg .deb .rpm",
+		"text":    ".txt .md .csv .tsv .json .yaml .yml .xml .html .htm .log .ini .toml",
+	} {
+		for _, ext := range strings.Fields(exts) {
+			typeByExt[ext] = kind
+		}
+	}
+}
+
+// TypeByName classifies a file by its extension alone, without reading it,
+// returning "" for extensions that are not known.
+func TypeByName(name string) string {
+	return typeByExt[strings.ToLower(filepath.Ext(name))]
+}
+
+// TypeOf classifies c as one of FileTypes, or "" if it is none of them. The
+// extension decides when it is a known one; otherwise the first bytes of the
+// file are sniffed.
+func TypeOf(c Candidate) string {
+	if kind, ok := typeByExt[strings.ToLower(filepath.Ext(c.Name()))]; ok {
+		return kind
+	}
+	r, err := c.Source.Open(c.Name())
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	return typeOfContent(head[:n])
+}
+
+func typeOfContent(head []byte) string {
+	mime := http.DetectContentType(head)
+	switch {
+	case strings.HasPrefix(mime, "image/"):
+		return "image"
+	case strings.HasPrefix(mime, "video/"):
+		return "video"
+	case strings.HasPrefix(mime, "audio/"), mime == "application/ogg":
+		return "audio"
+	case mime == "application/pdf":
+		return "pdf"
+	case mime == "application/zip", mime == "application/x-gzip", mime == "application/x-rar-compressed":
+		return "archive"
+	case strings.HasPrefix(mime, "text/"):
+		return "text"
+	}
+	// Formats the standard sniffer does not know
+	switch {
+	case strings.HasPrefix(string(head), "7z\xbc\xaf\x27\x1c"), strings.HasPrefix(string(head), "\xfd7zXZ\x00"), strings.HasPrefix(string(head), "BZh"):
+		return "archive"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		// ISO media: HEIC images and MP4/MOV video share the container
+		if brand := string(head[8:12]); brand == "heic" || brand == "heix" || brand == "mif1" || brand == "avif" {
+			return "image"
+		}
+		return "video"
+	}
+	return ""
+}
+
+// filterType keeps the files of the given type.
+func filterType(files []Candidate, kind string) []Candidate {
+	var kept []Candidate
+	for _, file := range files {
+		if TypeOf(file) == kind {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,429 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coljac/getnew/pkg/clock"
+)
+
+// PartialDownloadExts are the extensions browsers give downloads in progress.
+// getnew's own copies in progress are included.
+var PartialDownloadExts = []string{".crdownload", ".part", ".partial", ".download", ".tmp", PartialSuffix}
+
+// Options control how candidates are found and moved.
+type Options struct {
+	Sources []SourceBackend
+	// Filter is a case-insensitive substring, or a glob if it contains
+	// any of *?[. Empty matches everything.
+	Filter string
+	// Fuzzy matches a Filter without glob characters as a subsequence, as
+	// FuzzyScore does, and orders the files by score before age.
+	Fuzzy bool
+	// NewerThan and OlderThan, unless zero, limit candidates to files
+	// modified after and before those times.
+	NewerThan, OlderThan time.Time
+	// MinSize and MaxSize, unless zero, limit candidates by size in bytes.
+	// Files whose size the source cannot tell are kept.
+	MinSize, MaxSize int64
+	// Type, if set, keeps only files of that kind, one of FileTypes.
+	Type string
+	// Metadata keeps only files whose metadata, as Metadata reads it, has
+	// each field matching its pattern, as in MatchesMetadata.
+	Metadata map[string]string
+	// Content, if set, keeps only files whose contents it matches, as
+	// MatchesContent does.
+	Content *regexp.Regexp
+	// Exclude, if set, leaves out the files it reports true for.
+	Exclude func(Candidate) bool
+	// Skipped, if set, is called with each file the filters above leave
+	// out, as when showing why a file was not chosen.
+	Skipped func(Candidate)
+	// Dirs makes directories candidates as well as files. TarDirs packs
+	// them into tarballs when they are moved.
+	Dirs, TarDirs bool
+	// Symlinks says how symbolic links in a source are treated.
+	Symlinks SymlinkMode
+	// Limit, if positive, is how many of the first files Find returns.
+	// Find then keeps only that many while listing, in a heap, rather
+	// than sorting every file in the sources. It is ignored with Platform.
+	Limit int
+	// IgnoreExts are extensions of files that are never candidates,
+	// usually PartialDownloadExts.
+	IgnoreExts []string
+	// Settle leaves out files modified this recently until they have
+	// been seen not to change for that long.
+	Settle time.Duration
+	// ByVersion orders files by the version number in their names,
+	// highest first, rather than by modification time.
+	ByVersion bool
+	// Platform, if set, picks the best fit among files that differ only
+	// by a platform suffix such as linux-amd64 or .deb.
+	Platform *Platform
+	// Trash sends removed originals, and destination files that would be
+	// overwritten, to the system trash instead of deleting them. Sources
+	// that have no trash, such as remote ones, still delete.
+	Trash bool
+	// CheckOpen, if positive, is how long to wait for other processes to
+	// close a local file before its original is removed. If it is still
+	// open after that, the copy is discarded and the original left alone.
+	CheckOpen time.Duration
+	// KeepSource copies files without removing the originals, for sources
+	// that must not be written to, such as a camera card.
+	KeepSource bool
+	// SkipSystemFiles leaves out the files operating systems scatter over
+	// removable media, such as .DS_Store and Thumbs.db.
+	SkipSystemFiles bool
+	// Verify reads each copy back before the original is removed; see
+	// VerifyContents. SampleAbove and Coverage (a fraction of the file)
+	// control when and how much of a large file is only sampled.
+	Verify      bool
+	SampleAbove int64
+	Coverage    float64
+	// ExtractOnly, if set, limits Extract to the archive entries matching
+	// these patterns; see MatchesEntry.
+	ExtractOnly []string
+	// Depth is how many levels of archives within archives Extract
+	// unpacks; 0 and 1 unpack only the archive itself.
+	Depth int
+	// KeepArchive keeps an archive once Extract has unpacked it.
+	KeepArchive bool
+	// Password is asked for the password of an encrypted archive, given
+	// its path. If it is nil or gives "", encrypted archives are left
+	// packed.
+	Password func(archive string) (string, error)
+	// Clock defaults to the wall clock.
+	Clock clock.Clock
+	// Stdout and Stderr receive the output of external archive tools.
+	// Nil discards it.
+	Stdout, Stderr io.Writer
+}
+
+func (o Options) clock() clock.Clock {
+	if o.Clock == nil {
+		return clock.Real
+	}
+	return o.Clock
+}
+
+// Candidate is a file that could be fetched, with the source it is in.
+type Candidate struct {
+	fs.FileInfo
+	Source SourceBackend
+}
+
+// Find lists the matching files in all sources, newest first (or highest
+// version first with opts.ByVersion, best match first with opts.Fuzzy).
+// Files that are still being written are left out if opts.Settle is set.
+func Find(ctx context.Context, opts Options) ([]Candidate, error) {
+	files, partial, err := collect(ctx, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	if partial != nil {
+		// Files found to be next to partial downloads only at the end of
+		// the listing took the places of others, so list again knowing them
+		if files, _, err = collect(ctx, opts, partial); err != nil {
+			return nil, err
+		}
+	}
+	files, err = settle(ctx, files, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Platform != nil {
+		files = opts.Platform.choose(files)
+	}
+	if opts.ByVersion {
+		SortByVersion(files)
+	} else {
+		SortNewest(files)
+	}
+	if opts.fuzzy() {
+		SortByScore(files, opts.Filter)
+	}
+	if opts.Limit > 0 && len(files) > opts.Limit {
+		files = files[:opts.Limit]
+	}
+	return files, nil
+}
+
+// collect lists the files in all sources that could be fetched, keeping
+// only the best opts.Limit of them as it goes. A finished-looking file next
+// to its partial download (as Firefox leaves it) is still being written;
+// known gives the names of those in each source if an earlier listing
+// found them. If such files had to be dropped after others were let go,
+// the result is short and collect returns the names to list again with.
+func collect(ctx context.Context, opts Options, known []map[string]bool) ([]Candidate, []map[string]bool, error) {
+	top := newTopN(opts)
+	partials := make([]map[string]bool, len(opts.Sources))
+	for i, src := range opts.Sources {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		partial := map[string]bool{}
+		if known != nil {
+			partial = known[i]
+		}
+		partials[i] = partial
+		err := eachEntry(ctx, src, func(info Entry) error {
+			name := info.Name()
+			if ext := filepath.Ext(name); IsIgnoredExt(ext, opts.IgnoreExts) {
+				partial[strings.TrimSuffix(name, ext)] = true
+				return nil
+			}
+			if isSymlink(info) && opts.Symlinks == SymlinksFollow {
+				// Take the link as what it points to, leaving out broken ones
+				target, err := src.Stat(name)
+				if err != nil {
+					return nil
+				}
+				info = target
+			}
+			if (info.IsDir() && !opts.Dirs) || partial[name] || (opts.SkipSystemFiles && IsSystemFile(name)) {
+				return nil
+			}
+			c := Candidate{FileInfo: info, Source: src}
+			if !opts.keeps(c) {
+				if opts.Skipped != nil {
+					opts.Skipped(c)
+				}
+				return nil
+			}
+			top.add(c, i)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, sourceGone(src, err)
+		}
+	}
+	dropped := top.remove(func(c Candidate, src int) bool { return partials[src][c.Name()] })
+	files := top.files()
+	if dropped && top.evicted && known == nil {
+		return files, partials, nil
+	}
+	return files, nil, nil
+}
+
+// Keeps reports whether Find would take c, a file found some other way,
+// such as by watching a directory: whether it passes every filter in opts
+// and, with opts.SkipSystemFiles, is not operating system metadata.
+func (o Options) Keeps(c Candidate) bool {
+	if o.SkipSystemFiles && IsSystemFile(c.Name()) {
+		return false
+	}
+	return o.keeps(c)
+}
+
+// keeps reports whether c passes the filters in opts.
+func (o Options) keeps(c Candidate) bool {
+	if !o.Matches(c.Name()) || !inTimeWindow(c, o) || !inSizeRange(c, o) {
+		return false
+	}
+	if o.Exclude != nil && o.Exclude(c) {
+		return false
+	}
+	if o.Type != "" && TypeOf(c) != o.Type {
+		return false
+	}
+	if len(o.Metadata) > 0 && !MatchesMetadata(c, o.Metadata) {
+		return false
+	}
+	return o.Content == nil || MatchesContent(c, o.Content)
+}
+
+// eachEntry calls fn with each entry of src, streaming them if it can.
+func eachEntry(ctx context.Context, src SourceBackend, fn func(Entry) error) error {
+	if s, ok := src.(Streamer); ok {
+		return s.Stream(ctx, fn)
+	}
+	infos, err := src.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// systemFiles are metadata files that operating systems leave on volumes
+// they have mounted.
+var systemFiles = map[string]bool{
+	".ds_store": true, "thumbs.db": true, "desktop.ini": true, ".volumeicon.icns": true,
+	".com.apple.timemachine.donotpresent": true, "indexervolumeguid": true,
+}
+
+// IsSystemFile reports whether name is operating system metadata rather than
+// a user's file, including macOS "._" resource fork files.
+func IsSystemFile(name string) bool {
+	return systemFiles[strings.ToLower(name)] || strings.HasPrefix(name, "._")
+}
+
+func inTimeWindow(file fs.FileInfo, opts Options) bool {
+	if !opts.NewerThan.IsZero() && !file.ModTime().After(opts.NewerThan) {
+		return false
+	}
+	if !opts.OlderThan.IsZero() && !file.ModTime().Before(opts.OlderThan) {
+		return false
+	}
+	return true
+}
+
+func inSizeRange(file fs.FileInfo, opts Options) bool {
+	size := file.Size()
+	if size < 0 {
+		return true
+	}
+	return (opts.MinSize <= 0 || size >= opts.MinSize) && (opts.MaxSize <= 0 || size <= opts.MaxSize)
+}
+
+// settle drops files that are still growing. Files modified within the settle
+// period are checked again once it has passed.
+func settle(ctx context.Context, files []Candidate, opts Options) ([]Candidate, error) {
+	if opts.Settle <= 0 {
+		return files, nil
+	}
+	clk := opts.clock()
+
+	var wait time.Duration
+	for _, file := range files {
+		if age := clk.Since(file.ModTime()); age < opts.Settle && opts.Settle-age > wait {
+			wait = opts.Settle - age
+		}
+	}
+	if wait == 0 {
+		return files, nil
+	}
+	slept := make(chan struct{})
+	go func() {
+		clk.Sleep(wait)
+		close(slept)
+	}()
+	select {
+	case <-slept:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	settled := files[:0]
+	for _, file := range files {
+		info, err := file.Source.Stat(file.Name())
+		if err != nil || info.Size() != file.Size() || !info.ModTime().Equal(file.ModTime()) {
+			continue
+		}
+		settled = append(settled, Candidate{FileInfo: info, Source: file.Source})
+	}
+	return settled, nil
+}
+
+// SortNewest orders files by modification time, newest first.
+func SortNewest(files []Candidate) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+}
+
+// Nth returns the nth of files in the order Find returned them, counting from
+// 1, or back from the end if nth is negative, so that -1 is the oldest.
+func Nth(files []Candidate, nth int) (Candidate, error) {
+	picked, err := NthRange(files, nth, nth)
+	if err != nil {
+		return Candidate{}, err
+	}
+	return picked[0], nil
+}
+
+// NthRange returns the files from the nth to the last, inclusive, each
+// counted as Nth counts them.
+func NthRange(files []Candidate, nth, last int) ([]Candidate, error) {
+	if len(files) == 0 {
+		return nil, WithKind(fmt.Errorf("no files found in the source directory"), ErrNoCandidates)
+	}
+	from, to := nth, last
+	for _, n := range []*int{&from, &to} {
+		switch {
+		case *n > len(files):
+			return nil, WithKind(fmt.Errorf("requested %dth newest file, but only %d files available", *n, len(files)), ErrNotEnoughFiles)
+		case *n < -len(files):
+			return nil, WithKind(fmt.Errorf("requested %dth oldest file, but only %d files available", -*n, len(files)), ErrNotEnoughFiles)
+		case *n < 0:
+			*n += len(files) + 1
+		case *n == 0:
+			return nil, fmt.Errorf("there is no 0th file; the newest is 1 and the oldest -1")
+		}
+	}
+	if from > to {
+		return nil, WithKind(fmt.Errorf("%d..%d selects no files of the %d available", nth, last, len(files)), ErrNotEnoughFiles)
+	}
+	return files[from-1 : to], nil
+}
+
+// Matches reports whether name passes o.Filter, fuzzily with o.Fuzzy.
+func (o Options) Matches(name string) bool {
+	if o.fuzzy() {
+		_, ok := FuzzyScore(name, o.Filter)
+		return ok
+	}
+	return MatchesFilter(name, o.Filter)
+}
+
+func (o Options) fuzzy() bool {
+	return o.Fuzzy && o.Filter != "" && !strings.ContainsAny(o.Filter, "*?[")
+}
+
+// MatchesFilter reports whether name matches filter as described for
+// Options.Filter.
+func MatchesFilter(name, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	name, filter = strings.ToLower(name), strings.ToLower(filter)
+	if strings.ContainsAny(filter, "*?[") {
+		matched, _ := filepath.Match(filter, name)
+		return matched
+	}
+	return strings.Contains(name, filter)
+}
+
+// IsIgnoredExt reports whether ext, with its leading dot, is one of ignored,
+// which may be given with or without dots.
+func IsIgnoredExt(ext string, ignored []string) bool {
+	for _, ig := range ignored {
+		if ig != "" && strings.EqualFold(ext, "."+strings.TrimPrefix(ig, ".")) {
+			return true
+		}
+	}
+	return false
+}
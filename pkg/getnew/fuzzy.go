@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Scores in the spirit of fzf: each matched character earns scoreMatch, more
+// at the start of a word or a run of matches, and gaps between matched
+// characters cost a little.
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+	bonusBoundary     = 8
+	bonusCamel        = 7
+	bonusConsecutive  = 4
+	bonusFirstChar    = 2
+)
+
+// FuzzyScore reports whether the characters of pattern appear in name in
+// order, ignoring case, and how well: higher scores for matches at word
+// boundaries and in unbroken runs, so "rprt25" ranks
+// "Quarterly-Report-2025.pdf" above "our-paper-draft-2-of-5.txt".
+func FuzzyScore(name, pattern string) (int, bool) {
+	n, p := []rune(name), []rune(strings.ToLower(pattern))
+	if len(p) == 0 {
+		return 0, true
+	}
+	if len(p) > len(n) {
+		return 0, false
+	}
+	lower := []rune(strings.ToLower(name))
+	if len(lower) != len(n) {
+		lower = n
+	}
+
+	// best[j] is the best score with the current pattern character at j
+	const none = -1 << 30
+	best := make([]int, len(n))
+	prev := make([]int, len(n))
+	for i, pc := range p {
+		for j := range n {
+			best[j] = none
+			if lower[j] != pc {
+				continue
+			}
+			bonus := boundaryBonus(n, j)
+			if i == 0 {
+				best[j] = scoreMatch + bonus*bonusFirstChar
+				continue
+			}
+			for k := j - 1; k >= 0; k-- {
+				if prev[k] == none {
+					continue
+				}
+				s := prev[k] + scoreMatch + bonus
+				if gap := j - k - 1; gap == 0 {
+					s += bonusConsecutive
+				} else {
+					s += scoreGapStart + scoreGapExtension*(gap-1)
+				}
+				best[j] = max(best[j], s)
+			}
+		}
+		prev, best = best, prev
+	}
+
+	score := none
+	for _, s := range prev {
+		score = max(score, s)
+	}
+	return score, score != none
+}
+
+// boundaryBonus rewards a match at the start of a word or number.
+func boundaryBonus(name []rune, j int) int {
+	if j == 0 {
+		return bonusBoundary
+	}
+	before, c := name[j-1], name[j]
+	switch {
+	case !unicode.IsLetter(before) && !unicode.IsDigit(before):
+		return bonusBoundary
+	case unicode.IsLower(before) && unicode.IsUpper(c):
+		return bonusCamel
+	case unicode.IsLetter(before) && unicode.IsDigit(c):
+		return bonusCamel
+	}
+	return 0
+}
+
+// SortByScore orders files by how well they fuzzily match pattern, best
+// first, keeping the existing order among equal scores.
+func SortByScore(files []Candidate, pattern string) {
+	scores := make(map[string]int, len(files))
+	for _, f := range files {
+		scores[f.Name()], _ = FuzzyScore(f.Name(), pattern)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return scores[files[i].Name()] > scores[files[j].Name()]
+	})
+}
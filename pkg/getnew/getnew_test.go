@@ -0,0 +1,1178 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeAged(t *testing.T, path, content string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindMergesSourcesNewestFirst(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeAged(t, filepath.Join(a, "old.pdf"), "old", time.Hour)
+	writeAged(t, filepath.Join(b, "new.pdf"), "new", time.Minute)
+	writeAged(t, filepath.Join(b, "notes.txt"), "txt", time.Second)
+	writeAged(t, filepath.Join(a, "big.iso.part"), "partial", time.Second)
+
+	opts := Options{
+		Sources:    []SourceBackend{LocalSource{Dir: a}, LocalSource{Dir: b}},
+		Filter:     "*.pdf",
+		IgnoreExts: PartialDownloadExts,
+	}
+	files, err := Find(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name() != "new.pdf" || files[1].Name() != "old.pdf" {
+		t.Fatalf("Find = %v, want new.pdf then old.pdf", files)
+	}
+	if files[1].Source.Location("") != a {
+		t.Errorf("old.pdf came from %s, want %s", files[1].Source.Location(""), a)
+	}
+
+	second, err := Nth(files, 2)
+	if err != nil || second.Name() != "old.pdf" {
+		t.Errorf("Nth(2) = %v, %v, want old.pdf", second.Name(), err)
+	}
+	if _, err := Nth(files, 3); !errors.Is(err, ErrNotEnoughFiles) {
+		t.Errorf("Nth(3) of 2 files = %v, want ErrNotEnoughFiles", err)
+	}
+	if _, err := Nth(nil, 1); !errors.Is(err, ErrNoCandidates) {
+		t.Errorf("Nth(1) of none = %v, want ErrNoCandidates", err)
+	}
+	if oldest, err := Nth(files, -1); err != nil || oldest.Name() != "old.pdf" {
+		t.Errorf("Nth(-1) = %v, %v, want old.pdf", oldest.Name(), err)
+	}
+	if both, err := NthRange(files, 1, -1); err != nil || len(both) != 2 {
+		t.Errorf("NthRange(1, -1) = %v, %v, want both files", both, err)
+	}
+	if _, err := NthRange(files, 2, 1); !errors.Is(err, ErrNotEnoughFiles) {
+		t.Errorf("NthRange(2, 1) = %v, want ErrNotEnoughFiles", err)
+	}
+}
+
+func TestFindLimit(t *testing.T) {
+	src := t.TempDir()
+	for i, name := range []string{"b.txt", "c.txt", "d.txt", "e.txt"} {
+		writeAged(t, filepath.Join(src, name), name, time.Duration(i+1)*time.Minute)
+	}
+	// The newest file is still downloading, which is only seen after it
+	writeAged(t, filepath.Join(src, "z"), "z", time.Second)
+	writeAged(t, filepath.Join(src, "z.part"), "", time.Second)
+
+	opts := Options{Sources: []SourceBackend{LocalSource{Dir: src}}, IgnoreExts: PartialDownloadExts}
+	all, err := Find(context.Background(), opts)
+	if err != nil || len(all) != 4 {
+		t.Fatalf("Find = %v, %v", all, err)
+	}
+	opts.Limit = 2
+	top, err := Find(context.Background(), opts)
+	if err != nil || len(top) != 2 || top[0].Name() != all[0].Name() || top[1].Name() != all[1].Name() {
+		t.Errorf("Find with Limit 2 = %v, %v; want %s, %s", top, err, all[0].Name(), all[1].Name())
+	}
+	if top[0].Name() != "b.txt" {
+		t.Errorf("newest = %s, want b.txt", top[0].Name())
+	}
+}
+
+func TestMove(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	writeAged(t, filepath.Join(src, "report.pdf"), "contents", time.Minute)
+
+	files, err := Find(context.Background(), Options{Sources: []SourceBackend{LocalSource{Dir: src}}})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Find = %v, %v", files, err)
+	}
+	target := filepath.Join(dest, "sub", "report.pdf")
+	result, err := Move(context.Background(), files[0], target, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != 8 || result.SHA256 == "" || result.Dest != target {
+		t.Errorf("Move result = %+v", result)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "contents" {
+		t.Errorf("destination = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "report.pdf")); !os.IsNotExist(err) {
+		t.Errorf("original still present: %v", err)
+	}
+}
+
+func TestMoveOntoItself(t *testing.T) {
+	src := t.TempDir()
+	writeAged(t, filepath.Join(src, "report.pdf"), "contents", time.Minute)
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(src, link); err != nil {
+		t.Skip("symbolic links cannot be made here")
+	}
+	files, err := Find(context.Background(), Options{Sources: []SourceBackend{LocalSource{Dir: src}}})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Find = %v, %v", files, err)
+	}
+	for _, target := range []string{filepath.Join(src, "report.pdf"), filepath.Join(link, "report.pdf")} {
+		if _, err := Move(context.Background(), files[0], target, Options{}); !errors.Is(err, ErrConflict) {
+			t.Errorf("Move to %s = %v, want a conflict", target, err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(src, "report.pdf")); err != nil || string(data) != "contents" {
+		t.Errorf("original = %q, %v", data, err)
+	}
+}
+
+func TestMoveDir(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	for _, name := range []string{"photos/a.jpg", "photos/more/b.jpg"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(src, name)), 0o755)
+		os.WriteFile(filepath.Join(src, name), []byte(name), 0o644)
+	}
+	opts := Options{Sources: []SourceBackend{LocalSource{Dir: src}}}
+	if files, _ := Find(context.Background(), opts); len(files) != 0 {
+		t.Fatalf("Find without Dirs = %v", files)
+	}
+	opts.Dirs, opts.KeepSource = true, true
+	files, err := Find(context.Background(), opts)
+	if err != nil || len(files) != 1 || !files[0].IsDir() {
+		t.Fatalf("Find with Dirs = %v, %v", files, err)
+	}
+
+	result, err := Move(context.Background(), files[0], filepath.Join(dest, "photos"), opts)
+	if err != nil || result.Size != int64(len("photos/a.jpg")+len("photos/more/b.jpg")) {
+		t.Fatalf("Move = %+v, %v", result, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "photos", "more", "b.jpg")); err != nil || string(data) != "photos/more/b.jpg" {
+		t.Errorf("copied file = %q, %v", data, err)
+	}
+
+	opts.TarDirs, opts.KeepSource = true, false
+	target := filepath.Join(dest, "photos.tar.gz")
+	if _, err := Move(context.Background(), files[0], target, opts); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ListArchive(context.Background(), target, opts)
+	if err != nil || len(entries) != 4 || entries[0].Name != "photos/" {
+		t.Errorf("tarball holds %+v, %v", entries, err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "photos")); !os.IsNotExist(err) {
+		t.Errorf("original directory still present: %v", err)
+	}
+}
+
+func TestMoveSymlinks(t *testing.T) {
+	src, dest, elsewhere := t.TempDir(), t.TempDir(), t.TempDir()
+	target := filepath.Join(elsewhere, "big.iso")
+	os.WriteFile(target, []byte("the real contents"), 0o644)
+	if err := os.Symlink(target, filepath.Join(src, "link.iso")); err != nil {
+		t.Skip("cannot create links here:", err)
+	}
+	os.Symlink(filepath.Join(elsewhere, "missing"), filepath.Join(src, "broken"))
+
+	opts := Options{Sources: []SourceBackend{LocalSource{Dir: src}}}
+	files, err := Find(context.Background(), opts)
+	if err != nil || len(files) != 1 || files[0].Size() != int64(len("the real contents")) {
+		t.Fatalf("Find following links = %v, %v", files, err)
+	}
+	if _, err := Move(context.Background(), files[0], filepath.Join(dest, "copy.iso"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "copy.iso")); err != nil || string(data) != "the real contents" {
+		t.Errorf("copy = %q, %v", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(src, "link.iso")); !os.IsNotExist(err) {
+		t.Errorf("link still present: %v", err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("link target removed: %v", err)
+	}
+
+	os.Symlink(target, filepath.Join(src, "link.iso"))
+	opts.Symlinks = SymlinksPreserve
+	files, err = Find(context.Background(), opts)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Find keeping links = %v, %v", files, err)
+	}
+	for _, file := range files {
+		if _, err := Move(context.Background(), file, filepath.Join(dest, file.Name()), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := os.Readlink(filepath.Join(dest, "link.iso")); err != nil || got != target {
+		t.Errorf("recreated link points to %q, %v", got, err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("link target removed: %v", err)
+	}
+}
+
+func TestMoveCancelled(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	writeAged(t, filepath.Join(src, "report.pdf"), "contents", time.Minute)
+	files, _ := Find(context.Background(), Options{Sources: []SourceBackend{LocalSource{Dir: src}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Move(ctx, files[0], filepath.Join(dest, "report.pdf"), Options{}); err == nil {
+		t.Fatal("cancelled Move succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "report.pdf")); !os.IsNotExist(err) {
+		t.Errorf("partial destination left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "report.pdf")); err != nil {
+		t.Errorf("original removed after a cancelled move: %v", err)
+	}
+}
+
+func TestMoveResumes(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	writeAged(t, filepath.Join(src, "data.bin"), "first half, second half", time.Minute)
+	target := filepath.Join(dest, "data.bin")
+	os.WriteFile(target+PartialSuffix, []byte("first half, "), 0o644)
+	files, _ := Find(context.Background(), Options{Sources: []SourceBackend{LocalSource{Dir: src}}})
+
+	var stderr bytes.Buffer
+	result, err := Move(context.Background(), files[0], target, Options{Stderr: &stderr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "first half, second half" {
+		t.Errorf("destination = %q, %v", data, err)
+	}
+	if !strings.Contains(stderr.String(), "Resuming") {
+		t.Errorf("copy started over; stderr = %q", stderr.String())
+	}
+	sum := sha256.Sum256([]byte("first half, second half"))
+	if result.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("SHA256 = %s, want the whole file's", result.SHA256)
+	}
+	if _, err := os.Stat(target + PartialSuffix); !os.IsNotExist(err) {
+		t.Errorf("partial copy left behind: %v", err)
+	}
+}
+
+func TestOpenSourceByScheme(t *testing.T) {
+	dir := t.TempDir()
+	var opened string
+	RegisterBackend("test", func(spec string) (SourceBackend, error) {
+		opened = spec
+		return LocalSource{Dir: dir}, nil
+	})
+
+	if _, err := OpenSource("TEST://somewhere/out"); err != nil || opened != "TEST://somewhere/out" {
+		t.Errorf("OpenSource with a registered scheme: opened %q, %v", opened, err)
+	}
+	if s, err := OpenSource("file://" + dir); err != nil || s.Location("") != dir {
+		t.Errorf("OpenSource(file://) = %v, %v", s, err)
+	}
+	if s, err := OpenSource(dir); err != nil || s.(LocalSource).Dir != dir {
+		t.Errorf("OpenSource(%s) = %v, %v", dir, s, err)
+	}
+	if _, err := OpenSource("nope://host/dir"); err == nil {
+		t.Error("OpenSource with an unknown scheme succeeded")
+	}
+}
+
+func TestSortByVersion(t *testing.T) {
+	names := []string{"tool-1.9.2.tar.gz", "notes.txt", "tool-1.10.0.tar.gz", "tool-1.10.0-rc2.tar.gz", "tool-v1.2-x86_64.zip", "report-v3.pdf"}
+	var files []Candidate
+	for i, name := range names {
+		// Later names are newer, so mtime order would differ
+		files = append(files, Candidate{FileInfo: agedFile{name, time.Duration(len(names)-i) * time.Hour}})
+	}
+	SortByVersion(files)
+
+	var got []string
+	for _, file := range files {
+		got = append(got, file.Name())
+	}
+	want := []string{"report-v3.pdf", "tool-1.10.0.tar.gz", "tool-1.10.0-rc2.tar.gz", "tool-1.9.2.tar.gz", "tool-v1.2-x86_64.zip", "notes.txt"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("SortByVersion = %v, want %v", got, want)
+	}
+}
+
+type agedFile struct {
+	name string
+	age  time.Duration
+}
+
+func (f agedFile) Name() string       { return f.name }
+func (f agedFile) Size() int64        { return 0 }
+func (f agedFile) Mode() fs.FileMode  { return 0 }
+func (f agedFile) ModTime() time.Time { return time.Unix(1e9, 0).Add(-f.age) }
+func (f agedFile) IsDir() bool        { return false }
+func (f agedFile) Sys() any           { return nil }
+
+func TestVerifyContentsSampled(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	data := make([]byte, 10*sampleChunk)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	os.WriteFile(filepath.Join(src, "big.img"), data, 0o644)
+	info, _ := os.Stat(filepath.Join(src, "big.img"))
+	c := Candidate{FileInfo: info, Source: LocalSource{Dir: src}}
+	opts := Options{SampleAbove: sampleChunk, Coverage: 0.2}
+
+	target := filepath.Join(dest, "big.img")
+	os.WriteFile(target, data, 0o644)
+	if err := VerifyContents(context.Background(), c, target, "", opts); err != nil {
+		t.Errorf("identical copy: %v", err)
+	}
+	// The last chunk is always sampled
+	data[len(data)-1]++
+	os.WriteFile(target, data, 0o644)
+	if err := VerifyContents(context.Background(), c, target, "", opts); err == nil {
+		t.Error("corrupt copy passed verification")
+	}
+}
+
+func TestSampleOffsets(t *testing.T) {
+	tests := []struct {
+		size     int64
+		coverage float64
+		want     int
+	}{
+		{sampleChunk / 2, 0.05, 1},
+		{100 * sampleChunk, 0.05, 5},
+		{100 * sampleChunk, 0, 2},
+		{10 * sampleChunk, 2, 10},
+	}
+	for _, tt := range tests {
+		offsets := sampleOffsets(tt.size, tt.coverage)
+		if len(offsets) != tt.want {
+			t.Errorf("sampleOffsets(%d, %v) gave %d chunks, want %d", tt.size, tt.coverage, len(offsets), tt.want)
+		}
+		if last := offsets[len(offsets)-1]; last != (tt.size-1)/sampleChunk*sampleChunk {
+			t.Errorf("sampleOffsets(%d, %v) ends at %d, not the last chunk", tt.size, tt.coverage, last)
+		}
+	}
+}
+
+func TestExtractBuiltIn(t *testing.T) {
+	dir := t.TempDir()
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	w, _ := zw.Create("pkg/readme.txt")
+	w.Write([]byte("zip contents"))
+	zw.Close()
+	os.WriteFile(filepath.Join(dir, "pkg.zip"), zbuf.Bytes(), 0o644)
+
+	var tbuf bytes.Buffer
+	gz := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "tool/bin/tool", Mode: 0o755, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("tool"))
+	tw.Close()
+	gz.Close()
+	os.WriteFile(filepath.Join(dir, "tool.tar.gz"), tbuf.Bytes(), 0o644)
+
+	for _, name := range []string{"pkg.zip", "tool.tar.gz"} {
+		if err := Extract(context.Background(), filepath.Join(dir, name), Options{}); err != nil {
+			t.Fatalf("Extract(%s): %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed after extraction", name)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "pkg", "readme.txt")); string(data) != "zip contents" {
+		t.Errorf("zip entry = %q, %v", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "tool", "bin", "tool")); string(data) != "tool" {
+		t.Errorf("tar entry = %q, %v", data, err)
+	}
+}
+
+func TestUnpackKeepArchive(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, entries ...string) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, e := range entries {
+			w, _ := zw.Create(e)
+			w.Write([]byte(e))
+		}
+		zw.Close()
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, buf.Bytes(), 0o644)
+		return path
+	}
+
+	// One top-level directory is the root; loose files leave the root at dir
+	for archive, want := range map[string]string{
+		write("nested.zip", "pkg/a.txt", "pkg/docs/b.txt"): filepath.Join(dir, "pkg"),
+		write("flat.zip", "c.txt", "d.txt"):                dir,
+	} {
+		root, err := Unpack(context.Background(), archive, Options{KeepArchive: true})
+		if err != nil || root != want {
+			t.Errorf("Unpack(%s) = %q, %v, want %q", filepath.Base(archive), root, err, want)
+		}
+		if _, err := os.Stat(archive); err != nil {
+			t.Errorf("%s removed despite KeepArchive", filepath.Base(archive))
+		}
+	}
+}
+
+func TestTestArchive(t *testing.T) {
+	dir := t.TempDir()
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "readme.txt", Method: zip.Store})
+	w.Write([]byte("zip contents"))
+	zw.Close()
+	os.WriteFile(filepath.Join(dir, "good.zip"), zbuf.Bytes(), 0o644)
+	bad := bytes.Replace(zbuf.Bytes(), []byte("zip contents"), []byte("zip c0ntents"), 1)
+	os.WriteFile(filepath.Join(dir, "bad.zip"), bad, 0o644)
+
+	var tbuf bytes.Buffer
+	gz := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "tool", Mode: 0o755, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("tool"))
+	tw.Close()
+	gz.Close()
+	os.WriteFile(filepath.Join(dir, "good.tar.gz"), tbuf.Bytes(), 0o644)
+	// Cut short in the gzip trailer, after the end of the tar
+	os.WriteFile(filepath.Join(dir, "short.tar.gz"), tbuf.Bytes()[:tbuf.Len()-4], 0o644)
+
+	for name, damaged := range map[string]bool{"good.zip": false, "bad.zip": true, "good.tar.gz": false, "short.tar.gz": true} {
+		err := TestArchive(context.Background(), filepath.Join(dir, name), Options{})
+		if damaged != errors.Is(err, ErrCorruptArchive) || !damaged && err != nil {
+			t.Errorf("TestArchive(%s) = %v, want damaged %v", name, err, damaged)
+		}
+	}
+	// Nothing is unpacked or removed
+	if entries, _ := os.ReadDir(dir); len(entries) != 4 {
+		t.Errorf("TestArchive left %d files, want 4", len(entries))
+	}
+}
+
+func TestExtractRejectsEscapingEntries(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("../escaped.txt")
+	w.Write([]byte("x"))
+	zw.Close()
+	archive := filepath.Join(dir, "sub", "bad.zip")
+	os.MkdirAll(filepath.Dir(archive), 0o755)
+	os.WriteFile(archive, buf.Bytes(), 0o644)
+
+	if err := Extract(context.Background(), archive, Options{}); err == nil {
+		t.Fatal("Extract accepted an entry outside the directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("entry written outside the directory: %v", err)
+	}
+	if _, err := os.Stat(archive); err != nil {
+		t.Errorf("archive removed after a failed extraction: %v", err)
+	}
+}
+
+func TestExtractRejectsWritesThroughLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("links are not unpacked on Windows")
+	}
+	out := t.TempDir()
+	dest := filepath.Join(out, "dest")
+	os.MkdirAll(dest, 0o755)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	// Each link looks harmless from its own name, but the second is made
+	// through the first and so lands as dest/c -> ..
+	tw.WriteHeader(&tar.Header{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: ".."})
+	tw.WriteHeader(&tar.Header{Name: "a/b/c", Typeflag: tar.TypeSymlink, Linkname: ".."})
+	tw.WriteHeader(&tar.Header{Name: "c/pwned", Typeflag: tar.TypeReg, Mode: 0o644, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+	archive := filepath.Join(dest, "evil.tar")
+	os.WriteFile(archive, buf.Bytes(), 0o644)
+
+	if err := Extract(context.Background(), archive, Options{}); err == nil {
+		t.Error("Extract accepted an entry written through a link")
+	}
+	if _, err := os.Lstat(filepath.Join(out, "pwned")); !os.IsNotExist(err) {
+		t.Errorf("entry written outside the destination: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "c")); !os.IsNotExist(err) {
+		t.Errorf("link made through another link: %v", err)
+	}
+
+	// A link whose target only escapes through a link already on disk
+	linked := t.TempDir()
+	os.MkdirAll(filepath.Join(linked, "a"), 0o755)
+	os.Symlink("..", filepath.Join(linked, "a", "b"))
+	if staysInside(linked, filepath.Join(linked, "a"), "b/../x") {
+		t.Error("staysInside followed a/b lexically")
+	}
+	if !staysInside(linked, filepath.Join(linked, "a"), "../x") {
+		t.Error("staysInside refused a link inside the destination")
+	}
+}
+
+func TestExtractOnly(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"data/a.csv", "data/b.txt", "c.CSV"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(name))
+	}
+	zw.Close()
+	archive := filepath.Join(dir, "data.zip")
+	os.WriteFile(archive, buf.Bytes(), 0o644)
+
+	if err := Extract(context.Background(), archive, Options{ExtractOnly: []string{"*.csv"}}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	for name, want := range map[string]bool{"data/a.csv": true, "c.CSV": true, "data/b.txt": false, "data.zip": true} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", name, err == nil, want)
+		}
+	}
+	if err := Extract(context.Background(), archive, Options{ExtractOnly: []string{"*.pdf"}}); err == nil {
+		t.Error("Extract with no matching entries succeeded")
+	}
+
+	entries, err := ListArchive(context.Background(), archive, Options{ExtractOnly: []string{"data/*"}})
+	if err != nil || len(entries) != 2 || entries[0].Name != "data/a.csv" || entries[0].Size != int64(len("data/a.csv")) {
+		t.Errorf("ListArchive = %+v, %v", entries, err)
+	}
+}
+
+// lineArchive is a made-up format for TestRegisterExtractor: "LINES" then
+// one file name to a line, each file holding its own name.
+type lineArchive struct{}
+
+func (lineArchive) Match(header []byte, name string) bool {
+	if header == nil {
+		return strings.HasSuffix(name, ".lines")
+	}
+	return bytes.HasPrefix(header, []byte("LINES\n"))
+}
+
+func (lineArchive) Extract(ctx context.Context, src, destDir string, opts Options) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Fields(strings.TrimPrefix(string(data), "LINES\n")) {
+		if err := os.WriteFile(filepath.Join(destDir, name), []byte(name), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRegisterExtractor(t *testing.T) {
+	RegisterExtractor(lineArchive{})
+	dir := t.TempDir()
+	// Matched by its contents, whatever its name
+	archive := filepath.Join(dir, "bundle.bin")
+	os.WriteFile(archive, []byte("LINES\na.csv\nb.txt\n"), 0o644)
+
+	if !IsArchive("more.lines") || IsArchive("more.bin") {
+		t.Error("IsArchive should ask registered extractors about names")
+	}
+	if err := Extract(context.Background(), archive, Options{ExtractOnly: []string{"*.csv"}}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	for name, want := range map[string]bool{"a.csv": true, "b.txt": false, "bundle.bin": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", name, err == nil, want)
+		}
+	}
+	if _, err := ListArchive(context.Background(), archive, Options{}); err == nil {
+		t.Error("ListArchive should fail for an extractor that cannot list")
+	}
+	if err := Extract(context.Background(), archive, Options{}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if _, err := os.Stat(archive); err == nil {
+		t.Error("Extract kept the archive")
+	}
+}
+
+// failingArchive is a made-up format for TestExtractKeepsOthersFiles that
+// unpacks one file, while another file arrives beside the archive, and fails.
+type failingArchive struct{}
+
+func (failingArchive) Match(header []byte, name string) bool {
+	return strings.HasSuffix(name, ".fails")
+}
+
+func (failingArchive) Extract(ctx context.Context, src, destDir string, opts Options) error {
+	os.WriteFile(filepath.Join(destDir, "partial.txt"), nil, 0o644)
+	os.WriteFile(filepath.Join(filepath.Dir(src), "moved.txt"), nil, 0o644)
+	return errors.New("archive is damaged")
+}
+
+func TestExtractKeepsOthersFiles(t *testing.T) {
+	RegisterExtractor(failingArchive{})
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "bundle.fails")
+	os.WriteFile(archive, []byte("x"), 0o644)
+
+	if err := Extract(context.Background(), archive, Options{}); err == nil {
+		t.Fatal("Extract of a damaged archive succeeded")
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != "bundle.fails moved.txt" {
+		t.Errorf("left %v, want the archive and the file that arrived meanwhile", names)
+	}
+}
+
+func TestExtractNested(t *testing.T) {
+	dir := t.TempDir()
+	var tbuf bytes.Buffer
+	gz := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "table.csv", Mode: 0o644, Size: 3, Typeflag: tar.TypeReg})
+	tw.Write([]byte("a,b"))
+	tw.Close()
+	gz.Close()
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	w, _ := zw.Create("export/data.tar.gz")
+	w.Write(tbuf.Bytes())
+	zw.Close()
+	archive := filepath.Join(dir, "export.zip")
+	os.WriteFile(archive, zbuf.Bytes(), 0o644)
+
+	if err := Extract(context.Background(), archive, Options{Depth: 2}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "export", "table.csv")); string(data) != "a,b" {
+		t.Errorf("nested entry = %q, %v", data, err)
+	}
+	for _, name := range []string{"export.zip", "export/data.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s not removed after extraction", name)
+		}
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	dir := t.TempDir()
+	if free, err := freeSpace(dir); err != nil || free < 0 {
+		t.Skip("free space cannot be told here")
+	}
+	if err := checkSpace(filepath.Join(dir, "not", "made"), 1); err != nil {
+		t.Errorf("checkSpace(1 byte) = %v", err)
+	}
+	var spaceErr *SpaceError
+	if err := checkSpace(dir, 1<<62); !errors.As(err, &spaceErr) {
+		t.Errorf("checkSpace(4EiB) = %v, want a SpaceError", err)
+	}
+}
+
+// isoRecord is an ISO 9660 directory record, with extra system use entries.
+func isoRecord(name string, lba, size uint32, dir bool, extra []byte) []byte {
+	rec := make([]byte, 33, 64)
+	binary.LittleEndian.PutUint32(rec[2:], lba)
+	binary.BigEndian.PutUint32(rec[6:], lba)
+	binary.LittleEndian.PutUint32(rec[10:], size)
+	binary.BigEndian.PutUint32(rec[14:], size)
+	copy(rec[18:], []byte{124, 1, 2, 3, 4, 5, 0})
+	if dir {
+		rec[25] = 2
+	}
+	rec[32] = byte(len(name))
+	rec = append(rec, name...)
+	if len(name)%2 == 0 {
+		rec = append(rec, 0)
+	}
+	rec = append(rec, extra...)
+	rec[0] = byte(len(rec))
+	return rec
+}
+
+func TestExtractISO(t *testing.T) {
+	image := make([]byte, 20*isoSector)
+	pvd := image[16*isoSector:]
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	copy(pvd[156:], isoRecord("\x00", 18, isoSector, true, nil))
+	copy(image[17*isoSector:], "\xffCD001")
+	// The root holds . and .., a file with a Rock Ridge name and mode, and
+	// one with only its ISO name
+	nm := append([]byte{'N', 'M', 5 + 10, 1, 0}, "read me.md"...)
+	px := []byte{'P', 'X', 44, 1, 0o755 & 0xff, 0o755 >> 8, 0, 0}
+	px = append(px, make([]byte, 36)...)
+	root := append(isoRecord("\x00", 18, isoSector, true, []byte{'S', 'P', 7, 1, 0xBE, 0xEF, 0}), isoRecord("\x01", 18, isoSector, true, nil)...)
+	root = append(root, isoRecord("README.MD;1", 19, 5, false, append(nm, px...))...)
+	root = append(root, isoRecord("DATA.;1", 19, 3, false, nil)...)
+	copy(image[18*isoSector:], root)
+	copy(image[19*isoSector:], "hello")
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "disc.iso")
+	os.WriteFile(archive, image, 0o644)
+	entries, err := ListArchive(context.Background(), archive, Options{})
+	if err != nil || len(entries) != 2 || entries[0].Name != "read me.md" || entries[0].Size != 5 || entries[1].Name != "DATA" {
+		t.Fatalf("ListArchive = %+v, %v", entries, err)
+	}
+	if err := Extract(context.Background(), archive, Options{}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "read me.md"))
+	if err != nil || info.Mode().Perm() != 0o755 || info.ModTime().Year() != 2024 {
+		t.Errorf("read me.md = %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "DATA")); string(data) != "hel" {
+		t.Errorf("DATA holds %q, want %q", data, "hel")
+	}
+
+	// A root directory claiming 4 GB is refused rather than allocated
+	copy(pvd[156:], isoRecord("\x00", 18, 0xffffffff, true, nil))
+	os.WriteFile(archive, image, 0o644)
+	if _, err := ListArchive(context.Background(), archive, Options{}); err == nil {
+		t.Error("ListArchive of an image with an oversized directory succeeded")
+	}
+}
+
+// udfTag fills in the descriptor tag at the start of d.
+func udfTag(d []byte, id uint16, location uint32) {
+	binary.LittleEndian.PutUint16(d, id)
+	binary.LittleEndian.PutUint16(d[2:], 2)
+	binary.LittleEndian.PutUint32(d[12:], location)
+	d[4] = 0
+	var sum byte
+	for i := 0; i < 16; i++ {
+		sum += d[i]
+	}
+	d[4] = sum
+}
+
+// udfFID is a UDF file identifier for the entry in block lbn.
+func udfFID(name []byte, lbn uint32, flags byte) []byte {
+	fid := make([]byte, (38+len(name)+3)&^3)
+	binary.LittleEndian.PutUint16(fid[16:], 1)
+	fid[18], fid[19] = flags, byte(len(name))
+	binary.LittleEndian.PutUint32(fid[24:], lbn)
+	copy(fid[38:], name)
+	udfTag(fid, 257, 0)
+	return fid
+}
+
+func TestExtractUDF(t *testing.T) {
+	const part = 300
+	image := make([]byte, (part+8)*isoSector)
+	sector := func(n int) []byte { return image[n*isoSector : (n+1)*isoSector] }
+	block := func(n int) []byte { return sector(part + n) }
+
+	anchor := sector(256)
+	binary.LittleEndian.PutUint32(anchor[16:], 3*isoSector)
+	binary.LittleEndian.PutUint32(anchor[20:], 257)
+	udfTag(anchor, 2, 256)
+	binary.LittleEndian.PutUint32(sector(257)[188:], part)
+	udfTag(sector(257), 5, 257)
+	lvd := sector(258)
+	binary.LittleEndian.PutUint32(lvd[212:], isoSector)
+	binary.LittleEndian.PutUint32(lvd[268:], 1)
+	copy(lvd[440:], []byte{1, 6, 1, 0, 0, 0})
+	udfTag(lvd, 6, 258)
+	udfTag(sector(259), 8, 259)
+
+	binary.LittleEndian.PutUint32(block(0)[404:], 1)
+	udfTag(block(0), 256, 0)
+	// entry writes a file entry in block n holding allocation descriptors
+	// ads of kind, for size bytes of data
+	entry := func(n int, id uint16, fileType byte, kind uint16, size int, ads []byte) []byte {
+		fe := block(n)
+		fe[27] = fileType
+		binary.LittleEndian.PutUint16(fe[34:], kind)
+		binary.LittleEndian.PutUint64(fe[56:], uint64(size))
+		lengthsAt := 168
+		if id == 266 {
+			lengthsAt = 208
+		}
+		binary.LittleEndian.PutUint32(fe[lengthsAt+4:], uint32(len(ads)))
+		copy(fe[lengthsAt+8:], ads)
+		udfTag(fe, id, uint32(n))
+		return fe
+	}
+	shortAD := func(length, pos uint32) []byte {
+		return binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, length), pos)
+	}
+
+	// The root holds a file with its data in its entry, and a directory
+	root := append(udfFID(nil, 0, 0x0A), udfFID(append([]byte{8}, "read me.md"...), 3, 0)...)
+	root = append(root, udfFID(append([]byte{8}, "docs"...), 4, 0x02)...)
+	entry(1, 261, 4, 0, len(root), shortAD(uint32(len(root)), 2))
+	copy(block(2), root)
+	readme := entry(3, 261, 5, 3, 5, []byte("hello"))
+	binary.LittleEndian.PutUint32(readme[44:], 1<<12|1<<11|1<<10|1<<7|1<<5|1<<2|1<<0)
+	copy(readme[84:], []byte{0, 0x10, 0xE9, 0x07, 3, 4, 5, 6, 7})
+	udfTag(readme, 261, 3)
+
+	// It holds a file with a 16 bit name, in two extents of which the
+	// second is not recorded
+	docs := append(udfFID(nil, 0, 0x0A), udfFID([]byte{16, 0, 0xFC, 0, 'n', 0, 0xEF, 0, '.', 0, 't', 0, 'x', 0, 't'}, 6, 0)...)
+	entry(4, 261, 4, 0, len(docs), shortAD(uint32(len(docs)), 5))
+	copy(block(5), docs)
+	entry(6, 266, 5, 0, 8, append(shortAD(5, 7), shortAD(1<<30|3, 0)...))
+	copy(block(7), "world")
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "dvd.iso")
+	os.WriteFile(archive, image, 0o644)
+	entries, err := ListArchive(context.Background(), archive, Options{})
+	if err != nil || len(entries) != 3 || entries[0].Name != "read me.md" || entries[0].Size != 5 ||
+		!entries[1].Dir || entries[2].Name != "docs/ünï.txt" || entries[2].Size != 8 {
+		t.Fatalf("ListArchive = %+v, %v", entries, err)
+	}
+	if err := Extract(context.Background(), archive, Options{}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "read me.md"))
+	if err != nil || info.Mode().Perm() != 0o755 || info.ModTime().Year() != 2025 {
+		t.Errorf("read me.md = %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "docs", "ünï.txt")); string(data) != "world\x00\x00\x00" {
+		t.Errorf("docs/ünï.txt holds %q", data)
+	}
+}
+
+func TestExtractPackages(t *testing.T) {
+	dir := t.TempDir()
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(data)
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	// A Debian package is an ar archive holding the control and data tars
+	var data bytes.Buffer
+	tw := tar.NewWriter(&data)
+	tw.WriteHeader(&tar.Header{Name: "./", Mode: 0o755, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "./usr/bin/tool", Mode: 0o755, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hi"))
+	tw.Close()
+	deb := []byte("!<arch>\n")
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{{"debian-binary", []byte("2.0\n")}, {"data.tar.gz", gzipped(data.Bytes())}} {
+		deb = append(deb, fmt.Sprintf("%-16s%-12d%-6d%-6d%-8s%-10d`\n", m.name, 0, 0, 0, "100644", len(m.data))...)
+		deb = append(deb, m.data...)
+		if len(m.data)%2 == 1 {
+			deb = append(deb, '\n')
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "tool_1.0_amd64.deb"), deb, 0o644)
+
+	// An RPM is a lead, two headers (here empty) and a cpio payload
+	var cpio bytes.Buffer
+	for _, e := range []struct {
+		name string
+		mode int
+		data string
+	}{{"./etc/tool.conf", 0o100640, "x=1\n"}, {"./usr/bin/tl", 0o120777, "tool"}, {"TRAILER!!!", 0, ""}} {
+		fmt.Fprintf(&cpio, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x", 0, e.mode, 0, 0, 1, 0, len(e.data), 0, 0, 0, 0, len(e.name)+1, 0)
+		cpio.WriteString(e.name + "\x00")
+		cpio.Write(make([]byte, (4-(110+len(e.name)+1)%4)%4))
+		cpio.WriteString(e.data)
+		cpio.Write(make([]byte, (4-len(e.data)%4)%4))
+	}
+	rpm := append([]byte{0xed, 0xab, 0xee, 0xdb}, make([]byte, 92)...)
+	header := []byte{0x8e, 0xad, 0xe8, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	rpm = append(append(append(rpm, header...), header...), gzipped(cpio.Bytes())...)
+	os.WriteFile(filepath.Join(dir, "tool-1.0.x86_64.rpm"), rpm, 0o644)
+
+	entries, err := ListArchive(context.Background(), filepath.Join(dir, "tool-1.0.x86_64.rpm"), Options{})
+	if err != nil || len(entries) != 2 || entries[0].Name != "etc/tool.conf" || entries[0].Size != 4 {
+		t.Errorf("ListArchive = %+v, %v", entries, err)
+	}
+	for _, name := range []string{"tool_1.0_amd64.deb", "tool-1.0.x86_64.rpm"} {
+		if err := Extract(context.Background(), filepath.Join(dir, name), Options{}); err != nil {
+			t.Fatalf("Extract(%s): %v", name, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "tool_1.0_amd64", "usr", "bin", "tool")); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("deb payload: %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "tool-1.0.x86_64", "etc", "tool.conf")); string(data) != "x=1\n" {
+		t.Errorf("rpm payload holds %q", data)
+	}
+	if runtime.GOOS != "windows" {
+		if link, err := os.Readlink(filepath.Join(dir, "tool-1.0.x86_64", "usr", "bin", "tl")); err != nil || link != "tool" {
+			t.Errorf("rpm link = %q, %v", link, err)
+		}
+	}
+}
+
+func TestArchiveFormat(t *testing.T) {
+	tests := map[string]string{
+		"tool-1.2.tar.gz":  ".tar.gz",
+		"tool.TGZ":         ".tgz",
+		"data.tar.xz":      ".tar.xz",
+		"backup.7z":        ".7z",
+		"notes.gz":         ".gz",
+		"photos.zip":       ".zip",
+		"Installer.DMG":    ".dmg",
+		"report.pdf":       "",
+		"archive.tar.gz.1": "",
+	}
+	for name, want := range tests {
+		if got := archiveFormat(name); got != want {
+			t.Errorf("archiveFormat(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Screenshot.PNG": "not really a png",
+		"download":       "%PDF-1.7\n",
+		"clip.bin":       "\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00",
+		"photo":          "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00",
+		"notes":          "just some words\n",
+		"blob.dat":       "\x00\x01\x02\x03",
+	}
+	want := map[string]string{
+		"Screenshot.PNG": "image",
+		"download":       "pdf",
+		"clip.bin":       "video",
+		"photo":          "image",
+		"notes":          "text",
+		"blob.dat":       "",
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		info, _ := os.Stat(filepath.Join(dir, name))
+		if got := TypeOf(Candidate{FileInfo: info, Source: LocalSource{Dir: dir}}); got != want[name] {
+			t.Errorf("TypeOf(%s) = %q, want %q", name, got, want[name])
+		}
+	}
+}
+
+func TestCaptureTime(t *testing.T) {
+	dir := t.TempDir()
+
+	// A JPEG whose EXIF directory holds DateTimeOriginal
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8)
+	tiff = le.AppendUint16(tiff, 1) // IFD0: a pointer to the EXIF IFD at 26
+	tiff = le.AppendUint16(tiff, exifIFDPointer)
+	tiff = le.AppendUint16(tiff, 4)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, 26)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = le.AppendUint16(tiff, 1) // EXIF IFD: the date at 44
+	tiff = le.AppendUint16(tiff, exifDateTimeOriginal)
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = le.AppendUint32(tiff, 20)
+	tiff = le.AppendUint32(tiff, 44)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, "2024:07:14 09:30:05\x00"...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(app1)+2))
+	jpeg = append(append(jpeg, app1...), 0xFF, 0xDA, 0, 2)
+
+	// An MP4 with its movie header after the media data, as cameras write it
+	mvhd := binary.BigEndian.AppendUint32(make([]byte, 4), 2082844800+1720949405)
+	mp4 := []byte("\x00\x00\x00\x10ftypisom\x00\x00\x00\x00\x00\x00\x00\x0cmdat\x01\x02\x03\x04")
+	mp4 = binary.BigEndian.AppendUint32(mp4, uint32(16+len(mvhd)))
+	mp4 = append(mp4, "moov"...)
+	mp4 = binary.BigEndian.AppendUint32(mp4, uint32(8+len(mvhd)))
+	mp4 = append(append(mp4, "mvhd"...), mvhd...)
+
+	files := map[string][]byte{"photo.jpg": jpeg, "clip.mp4": mp4, "plain.png": []byte("\x89PNG\r\n\x1a\n0000")}
+	want := map[string]time.Time{
+		"photo.jpg": time.Date(2024, 7, 14, 9, 30, 5, 0, time.Local),
+		"clip.mp4":  time.Unix(1720949405, 0),
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), content, 0o644)
+		info, _ := os.Stat(filepath.Join(dir, name))
+		got, ok := CaptureTime(Candidate{FileInfo: info, Source: LocalSource{Dir: dir}})
+		if w, known := want[name]; ok != known || !got.Equal(w) {
+			t.Errorf("CaptureTime(%s) = %v, %v, want %v", name, got, ok, w)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	dir := t.TempDir()
+
+	frame := func(id, text string) []byte {
+		f := append([]byte(id), 0, 0, 0, byte(len(text)+1), 0, 0, 3)
+		return append(f, text...)
+	}
+	tag := append(frame("TIT2", "Blue in Green"), frame("TPE1", "Miles Davis")...)
+	mp3 := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(tag))}, tag...)
+
+	v1 := make([]byte, 128)
+	copy(v1, "TAG")
+	copy(v1[3:], "So What")
+	copy(v1[93:], "1959")
+
+	pdf := "%PDF-1.4\n1 0 obj << /Type /Outlines /Title (Chapter 1) >> endobj\n" +
+		"2 0 obj << /Title (Quarterly \\(draft\\) report) /Author <FEFF004100640061> >> endobj\n" +
+		"trailer << /Root 3 0 R /Info 2 0 R >>\n%%EOF\n"
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    map[string]string
+	}{
+		{"track.mp3", mp3, map[string]string{"id3.title": "Blue in Green", "id3.artist": "Miles Davis"}},
+		{"old.mp3", append([]byte("audio"), v1...), map[string]string{"id3.title": "So What", "id3.year": "1959"}},
+		{"report.pdf", []byte(pdf), map[string]string{"pdf.title": "Quarterly (draft) report", "pdf.author": "Ada"}},
+	}
+	for _, tt := range tests {
+		os.WriteFile(filepath.Join(dir, tt.name), tt.content, 0o644)
+		info, _ := os.Stat(filepath.Join(dir, tt.name))
+		c := Candidate{FileInfo: info, Source: LocalSource{Dir: dir}}
+		got := Metadata(c)
+		if len(got) != len(tt.want) {
+			t.Errorf("Metadata(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+		for field, want := range tt.want {
+			if got[field] != want {
+				t.Errorf("Metadata(%s)[%s] = %q, want %q", tt.name, field, got[field], want)
+			}
+		}
+	}
+
+	info, _ := os.Stat(filepath.Join(dir, "track.mp3"))
+	c := Candidate{FileInfo: info, Source: LocalSource{Dir: dir}}
+	if !MatchesMetadata(c, map[string]string{"id3.artist": "miles*"}) || MatchesMetadata(c, map[string]string{"id3.album": "*"}) {
+		t.Error("MatchesMetadata should match a present field by pattern and fail a missing one")
+	}
+}
+
+func TestMatchesContent(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"3f9a2c": "Invoice #1234\nTotal due: 40.00\n",
+		"other":  "Invoice #9999\n",
+		"binary": "\x00\x01Invoice #1234",
+	}
+	re := regexp.MustCompile(`(?i)invoice #1234`)
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		info, _ := os.Stat(filepath.Join(dir, name))
+		if got := MatchesContent(Candidate{FileInfo: info, Source: LocalSource{Dir: dir}}, re); got != (name == "3f9a2c") {
+			t.Errorf("MatchesContent(%s) = %v", name, got)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "shot.png"), buf.Bytes(), 0o644)
+	pdf := "%PDF-1.4\n1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n" +
+		"2 0 obj << /Kids [3 0 R 4 0 R 5 0 R] /Count 3 /Type /Pages >> endobj\n" +
+		"3 0 obj << /Type /Page /Parent 2 0 R >> endobj\n"
+	os.WriteFile(filepath.Join(dir, "tree.pdf"), []byte(pdf), 0o644)
+	os.WriteFile(filepath.Join(dir, "flat.pdf"), []byte("%PDF-1.4\n<< /Type /Page >>\n<< /Type /Page >>\n"), 0o644)
+
+	candidate := func(name string) Candidate {
+		info, _ := os.Stat(filepath.Join(dir, name))
+		return Candidate{FileInfo: info, Source: LocalSource{Dir: dir}}
+	}
+	if w, h, ok := ImageSize(candidate("shot.png")); !ok || w != 40 || h != 30 {
+		t.Errorf("ImageSize = %d, %d, %v, want 40, 30, true", w, h, ok)
+	}
+	if _, _, ok := ImageSize(candidate("tree.pdf")); ok {
+		t.Error("ImageSize of a PDF should fail")
+	}
+	for name, want := range map[string]int{"tree.pdf": 3, "flat.pdf": 2} {
+		if got, ok := PDFPages(candidate(name)); !ok || got != want {
+			t.Errorf("PDFPages(%s) = %d, %v, want %d", name, got, ok, want)
+		}
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	if _, ok := FuzzyScore("Quarterly-Report-2025.pdf", "rprt25"); !ok {
+		t.Fatal("rprt25 should match Quarterly-Report-2025.pdf")
+	}
+	if _, ok := FuzzyScore("report.pdf", "rprt25"); ok {
+		t.Error("rprt25 should not match report.pdf")
+	}
+	if _, ok := FuzzyScore("anything", ""); !ok {
+		t.Error("an empty pattern should match")
+	}
+
+	// Better matches come first, whatever their age
+	names := []string{"our-paper-draft-2-of-5.txt", "Quarterly-Report-2025.pdf", "rprt25.txt"}
+	files := make([]Candidate, len(names))
+	for i, name := range names {
+		files[i] = Candidate{FileInfo: namedFile(name)}
+	}
+	SortByScore(files, "rprt25")
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Name())
+	}
+	want := "rprt25.txt Quarterly-Report-2025.pdf our-paper-draft-2-of-5.txt"
+	if strings.Join(got, " ") != want {
+		t.Errorf("order = %v, want %s", got, want)
+	}
+}
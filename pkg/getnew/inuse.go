@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// opener is implemented by sources that can tell whether another process
+// still has one of their files open.
+type opener interface {
+	OpenBy(name string) ([]string, error)
+}
+
+// OpenBy returns the names of other processes that have name open. An empty
+// result means none do, or that this cannot be checked here.
+func (s LocalSource) OpenBy(name string) ([]string, error) {
+	return OpenBy(filepath.Join(s.Dir, name))
+}
+
+// WaitUntilClosed waits up to opts.CheckOpen for other processes to close c,
+// checking twice a second. It returns an error naming them if they do not.
+// Sources that cannot tell are not waited on.
+func WaitUntilClosed(ctx context.Context, c Candidate, opts Options) error {
+	o, ok := c.Source.(opener)
+	if !ok || opts.CheckOpen <= 0 {
+		return nil
+	}
+	clk := opts.clock()
+	deadline := clk.Now().Add(opts.CheckOpen)
+	for {
+		procs, err := o.OpenBy(c.Name())
+		if err != nil {
+			return fmt.Errorf("failed to check whether %s is in use: %w", c.Name(), err)
+		}
+		if len(procs) == 0 {
+			return nil
+		}
+		if !clk.Now().Before(deadline) {
+			return fmt.Errorf("%s is still open in %s", c.Name(), strings.Join(procs, ", "))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clk.After(500 * time.Millisecond):
+		}
+	}
+}
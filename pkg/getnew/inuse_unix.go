@@ -0,0 +1,57 @@
+//go:build !linux && !windows
+
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// OpenBy returns the names of other processes with path open, as reported
+// by lsof. Without lsof it cannot tell and reports none.
+func OpenBy(path string) ([]string, error) {
+	if _, err := exec.LookPath("lsof"); err != nil {
+		return nil, nil
+	}
+	// lsof exits 1 when nothing has the file open
+	out, _ := exec.Command("lsof", "-F", "pc", "--", path).Output()
+	self := strconv.Itoa(os.Getpid())
+	var names []string
+	pid := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "p"):
+			pid = line[1:]
+		case strings.HasPrefix(line, "c") && pid != self:
+			names = append(names, line[1:]+" ("+pid+")")
+		}
+	}
+	return names, nil
+}
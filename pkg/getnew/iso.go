@@ -0,0 +1,347 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// isoSector is the size of a sector of a CD or DVD image.
+const isoSector = 2048
+
+// isoMaxDir caps the size of a directory read from an image, which is
+// whatever the image claims.
+const isoMaxDir = 16 << 20
+
+// errNoFileSystem is returned for disc images with no file system getnew
+// reads itself, such as UDF 2.50, which are left to an external tool.
+var errNoFileSystem = errors.New("no file system getnew can read")
+
+// isoFile is a file or directory in an ISO 9660 image.
+type isoFile struct {
+	name    string
+	dir     bool
+	mode    fs.FileMode
+	modTime time.Time
+	// extents are the offsets and lengths of the file's data; files over
+	// 4 GB are recorded in several.
+	extents [][2]int64
+}
+
+func (f isoFile) size() int64 {
+	var n int64
+	for _, e := range f.extents {
+		n += e[1]
+	}
+	return n
+}
+
+// open reads the file's data from the image r.
+func (f isoFile) open(r io.ReaderAt) io.Reader {
+	parts := make([]io.Reader, len(f.extents))
+	for i, e := range f.extents {
+		if e[0] < 0 {
+			parts[i] = io.LimitReader(zeros{}, e[1])
+		} else {
+			parts[i] = io.NewSectionReader(r, e[0], e[1])
+		}
+	}
+	return io.MultiReader(parts...)
+}
+
+// zeros reads as endless zero bytes, for the parts of a file that a UDF
+// image does not record.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// readISO lists the files in an ISO 9660 image by their Rock Ridge names,
+// which come with Unix permissions, or else their Joliet names if the image
+// has them, or else their plain ISO names. Images with only UDF are read by
+// readUDF. size is the size of the image.
+func readISO(r io.ReaderAt, size int64) ([]isoFile, error) {
+	var primary, joliet []byte
+	// Volume descriptors follow the 32 KB system area, ended by a terminator
+	for sector := int64(16); sector < 16+64; sector++ {
+		vd := make([]byte, isoSector)
+		if _, err := r.ReadAt(vd, sector*isoSector); err != nil || string(vd[1:6]) != "CD001" || vd[0] == 255 {
+			break
+		}
+		// A supplementary descriptor with these escape sequences is Joliet
+		switch esc := string(vd[88:91]); {
+		case vd[0] == 1 && primary == nil:
+			primary = vd[156:190]
+		case vd[0] == 2 && (esc == "%/@" || esc == "%/C" || esc == "%/E"):
+			joliet = vd[156:190]
+		}
+	}
+	root := primary
+	if joliet != nil && (primary == nil || !hasRockRidge(r, primary)) {
+		root = joliet
+	}
+	if root == nil {
+		return readUDF(r, size)
+	}
+	var files []isoFile
+	err := walkISO(r, size, root, "", joliet != nil && bytes.Equal(root, joliet), map[uint32]bool{}, &files)
+	return files, err
+}
+
+// hasRockRidge reports whether the directory of record root uses Rock
+// Ridge, which its first entry announces with an SP entry.
+func hasRockRidge(r io.ReaderAt, root []byte) bool {
+	first := make([]byte, 255)
+	if _, err := r.ReadAt(first, int64(binary.LittleEndian.Uint32(root[2:]))*isoSector); err != nil {
+		return false
+	}
+	n := int(first[0])
+	return n >= 34+7 && bytes.Contains(first[34:n], []byte{'S', 'P', 7, 1, 0xBE, 0xEF})
+}
+
+// walkISO adds the files in the directory of record dir to files, in an
+// image of imageSize bytes. seen guards against directories that contain
+// themselves.
+func walkISO(r io.ReaderAt, imageSize int64, dir []byte, prefix string, joliet bool, seen map[uint32]bool, files *[]isoFile) error {
+	lba, size := binary.LittleEndian.Uint32(dir[2:]), binary.LittleEndian.Uint32(dir[10:])
+	if seen[lba] || len(seen) > 1<<16 {
+		return nil
+	}
+	seen[lba] = true
+	if size > isoMaxDir || int64(lba)*isoSector+int64(size) > imageSize {
+		return fmt.Errorf("directory %s has an impossible size of %d bytes", prefix, size)
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, int64(lba)*isoSector); err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", prefix, err)
+	}
+	continued := false
+	for off := 0; off < len(data); {
+		n := int(data[off])
+		if n == 0 {
+			// Records do not cross sectors; the rest of this one is padding
+			off = (off/isoSector + 1) * isoSector
+			continue
+		}
+		if n < 34 || off+n > len(data) {
+			break
+		}
+		rec := data[off : off+n]
+		off += n
+		nameLen := int(rec[32])
+		if 33+nameLen > n || nameLen == 1 && rec[33] <= 1 {
+			// Too short, or the . and .. entries
+			continue
+		}
+		name, mode := isoName(rec, joliet)
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			continue
+		}
+		name = path.Join(prefix, name)
+		extent := [2]int64{int64(binary.LittleEndian.Uint32(rec[2:])) * isoSector, int64(binary.LittleEndian.Uint32(rec[10:]))}
+		flags := rec[25]
+		if last := len(*files) - 1; continued && last >= 0 && (*files)[last].name == name {
+			(*files)[last].extents = append((*files)[last].extents, extent)
+			continued = flags&0x80 != 0
+			continue
+		}
+		continued = flags&0x80 != 0
+		f := isoFile{name: name, dir: flags&0x02 != 0, mode: mode, modTime: isoTime(rec[18:25])}
+		if f.dir {
+			*files = append(*files, f)
+			if err := walkISO(r, imageSize, rec, name, joliet, seen, files); err != nil {
+				return err
+			}
+			continue
+		}
+		f.extents = [][2]int64{extent}
+		*files = append(*files, f)
+	}
+	return nil
+}
+
+// isoName is the name in a directory record, and the permissions Rock Ridge
+// gives it, if any.
+func isoName(rec []byte, joliet bool) (string, fs.FileMode) {
+	nameLen := int(rec[32])
+	raw := rec[33 : 33+nameLen]
+	var name string
+	if joliet {
+		name = utf16String(raw, true)
+	} else {
+		name = string(raw)
+	}
+	var mode fs.FileMode = 0o644
+	// Rock Ridge entries are in the system use area after the name
+	if start := 33 + nameLen + (1 - nameLen%2); !joliet && start < len(rec) {
+		su := rec[start:]
+		var long strings.Builder
+		for i := 0; i+4 <= len(su); {
+			n := int(su[i+2])
+			if n < 4 || i+n > len(su) {
+				break
+			}
+			switch string(su[i : i+2]) {
+			case "NM":
+				if n > 5 && su[i+4]&0x06 == 0 {
+					long.Write(su[i+5 : i+n])
+				}
+			case "PX":
+				if n >= 12 {
+					mode = fs.FileMode(binary.LittleEndian.Uint32(su[i+4:]) & 0o777)
+				}
+			}
+			i += n
+		}
+		if long.Len() > 0 {
+			return long.String(), mode
+		}
+	}
+	// Plain names end in a version number and a dot if there is no extension
+	if i := strings.LastIndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, "."), mode
+}
+
+// isoTime reads a directory record's date: years since 1900, month, day,
+// hour, minute, second and the offset from GMT in 15 minute steps.
+func isoTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// extractISO unpacks an ISO 9660 or UDF disc image, leaving those it cannot
+// read to an external tool.
+func extractISO(ctx context.Context, src, dir string, opts Options, filter *entryFilter) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	files, err := readISO(f, info.Size())
+	if errors.Is(err, errNoFileSystem) {
+		return extractWithTool(ctx, src, dir, opts, filter)
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		target, err := entryPath(dir, file.name)
+		if err != nil {
+			return err
+		}
+		if file.dir {
+			// Directories holding chosen files are made as they are written
+			if filter == nil {
+				if err := os.MkdirAll(target, 0o755); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !filter.take(file.name) {
+			continue
+		}
+		if err := writeEntry(ctx, target, file.open(f), file.mode, file.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listISO(ctx context.Context, src string, opts Options) ([]ArchiveEntry, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	files, err := readISO(f, info.Size())
+	if errors.Is(err, errNoFileSystem) {
+		return listWithTool(ctx, src, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ArchiveEntry, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, ArchiveEntry{Name: file.name, Size: file.size(), Dir: file.dir})
+	}
+	return entries, nil
+}
+
+// testISO checks that every file in a disc image lies within it, as they do
+// not once a download is cut short. Disc images keep no checksums of their
+// data to check.
+func testISO(ctx context.Context, src string, opts Options) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	files, err := readISO(f, info.Size())
+	if errors.Is(err, errNoFileSystem) {
+		return testWithTool(ctx, src, opts)
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		for _, e := range file.extents {
+			if e[0] >= 0 && e[0]+e[1] > info.Size() {
+				return fmt.Errorf("%s: image is cut short", file.name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// MetadataFields are the fields Metadata can read: the EXIF data of photos,
+// the ID3 tags of MP3s and the document information of PDFs.
+var MetadataFields = []string{
+	"exif.date", "exif.time", "exif.make", "exif.model", "exif.lens",
+	"id3.title", "id3.artist", "id3.album", "id3.year",
+	"pdf.title", "pdf.author", "pdf.subject",
+}
+
+// Metadata reads what c records about itself, keyed by MetadataFields.
+// Fields the file does not record are missing.
+func Metadata(c Candidate) map[string]string {
+	meta := map[string]string{}
+	r, closer, err := openReaderAt(c)
+	if err != nil {
+		return meta
+	}
+	defer closer.Close()
+	switch ext := strings.ToLower(filepath.Ext(c.Name())); {
+	case ext == ".mp3":
+		id3Tags(r, c.Size(), meta)
+	case ext == ".pdf":
+		pdfInfo(r, c.Size(), meta)
+	default:
+		tags := exifTags(r)
+		if t, err := time.ParseInLocation("2006:01:02 15:04:05", tags[exifDateTimeOriginal], time.Local); err == nil {
+			meta["exif.date"] = t.Format("2006-01-02")
+			meta["exif.time"] = t.Format("15-04-05")
+		}
+		for field, tag := range map[string]uint16{"exif.make": exifMake, "exif.model": exifModel, "exif.lens": exifLensModel} {
+			if tags[tag] != "" {
+				meta[field] = tags[tag]
+			}
+		}
+	}
+	return meta
+}
+
+// MatchesMetadata reports whether each field of c's metadata matches its
+// pattern in patterns, as MatchesFilter matches names.
+func MatchesMetadata(c Candidate, patterns map[string]string) bool {
+	meta := Metadata(c)
+	for field, pattern := range patterns {
+		value, ok := meta[field]
+		if !ok || !MatchesFilter(value, pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// id3Tags reads an MP3's ID3v2 tag at its start, or failing that the ID3v1
+// tag in its last 128 bytes.
+func id3Tags(r io.ReaderAt, size int64, meta map[string]string) {
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err == nil && string(header[:3]) == "ID3" {
+		version := header[3]
+		tagSize := syncsafe(header[6:10])
+		tag := make([]byte, tagSize)
+		if n, _ := r.ReadAt(tag, 10); n > 0 {
+			readID3v2Frames(tag[:n], version, meta)
+		}
+		if len(meta) > 0 {
+			return
+		}
+	}
+	if size < 128 {
+		return
+	}
+	v1 := make([]byte, 128)
+	if _, err := r.ReadAt(v1, size-128); err != nil || string(v1[:3]) != "TAG" {
+		return
+	}
+	for field, at := range map[string][2]int{"id3.title": {3, 33}, "id3.artist": {33, 63}, "id3.album": {63, 93}, "id3.year": {93, 97}} {
+		if value := exifString(v1[at[0]:at[1]]); value != "" {
+			meta[field] = value
+		}
+	}
+}
+
+// readID3v2Frames reads the text frames of an ID3v2.2, 2.3 or 2.4 tag.
+func readID3v2Frames(tag []byte, version byte, meta map[string]string) {
+	fields := map[string]string{
+		"TIT2": "id3.title", "TPE1": "id3.artist", "TALB": "id3.album", "TYER": "id3.year", "TDRC": "id3.year",
+		"TT2": "id3.title", "TP1": "id3.artist", "TAL": "id3.album", "TYE": "id3.year",
+	}
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	for at := 0; at+headerSize <= len(tag); {
+		id := string(tag[at : at+idSize])
+		if id[0] == 0 {
+			break // padding
+		}
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[at+3])<<16 | int(tag[at+4])<<8 | int(tag[at+5])
+		case 4:
+			size = syncsafe(tag[at+4 : at+8])
+		default:
+			size = int(binary.BigEndian.Uint32(tag[at+4 : at+8]))
+		}
+		body := at + headerSize
+		if size <= 0 || body+size > len(tag) {
+			break
+		}
+		if field, ok := fields[id]; ok {
+			if value := id3Text(tag[body : body+size]); value != "" {
+				if field == "id3.year" && len(value) > 4 {
+					value = value[:4] // TDRC is a full timestamp
+				}
+				meta[field] = value
+			}
+		}
+		at = body + size
+	}
+}
+
+// id3Text decodes a text frame, whose first byte gives its encoding.
+func id3Text(frame []byte) string {
+	if len(frame) < 2 {
+		return ""
+	}
+	text := frame[1:]
+	switch frame[0] {
+	case 1, 2:
+		return exifString([]byte(utf16String(text, frame[0] == 2)))
+	case 3:
+		return exifString(text)
+	}
+	return exifString([]byte(latin1(text)))
+}
+
+// utf16String decodes UTF-16 text, big-endian if bigEndian is set or its
+// byte order mark says so.
+func utf16String(b []byte, bigEndian bool) string {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		order, b = binary.BigEndian, b[2:]
+	} else if len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE {
+		order, b = binary.LittleEndian, b[2:]
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// syncsafe reads an ID3 size, which uses seven bits of each byte.
+func syncsafe(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<7 | int(c&0x7F)
+	}
+	return n
+}
+
+var (
+	pdfInfoRef   = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+	pdfInfoEntry = regexp.MustCompile(`/(Title|Author|Subject)\s*(\(|<)`)
+)
+
+// pdfInfo reads the title, author and subject from a PDF's document
+// information dictionary, which the trailer at the end of the file points
+// to. It is not found when the PDF keeps it in a compressed object stream.
+func pdfInfo(r io.ReaderAt, size int64, meta map[string]string) {
+	data := pdfData(r, size)
+	refs := pdfInfoRef.FindAllSubmatch(data, -1)
+	if len(refs) == 0 {
+		return
+	}
+	// Later updates to the file come after earlier ones
+	ref := refs[len(refs)-1]
+	objects := regexp.MustCompile(`(?:^|\s)`+string(ref[1])+`\s+`+string(ref[2])+`\s+obj\b`).FindAllIndex(data, -1)
+	if len(objects) == 0 {
+		return
+	}
+	dict := data[objects[len(objects)-1][1]:]
+	if end := bytes.Index(dict, []byte("endobj")); end >= 0 {
+		dict = dict[:end]
+	}
+	for _, m := range pdfInfoEntry.FindAllSubmatchIndex(dict, -1) {
+		field := "pdf." + strings.ToLower(string(dict[m[2]:m[3]]))
+		var value string
+		if dict[m[4]] == '(' {
+			value = pdfLiteral(dict[m[5]:])
+		} else if end := bytes.IndexByte(dict[m[5]:], '>'); end >= 0 {
+			raw, _ := hex.DecodeString(string(bytes.Join(bytes.Fields(dict[m[5]:m[5]+end]), nil)))
+			value = pdfText(raw)
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			meta[field] = value
+		}
+	}
+}
+
+// pdfData is the start and the end of a PDF, where the objects of interest
+// usually are, or all of it if it is small.
+func pdfData(r io.ReaderAt, size int64) []byte {
+	data := make([]byte, min(size, 2*metadataReadLimit))
+	if size > int64(len(data)) {
+		r.ReadAt(data[:metadataReadLimit], 0)
+		r.ReadAt(data[metadataReadLimit:], size-metadataReadLimit)
+	} else if n, _ := r.ReadAt(data, 0); n < len(data) {
+		data = data[:n]
+	}
+	return data
+}
+
+// pdfLiteral decodes a literal string, from just after its opening bracket.
+func pdfLiteral(data []byte) string {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch e := data[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case '\n', '\r':
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for j := 0; j < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; j++ {
+						v = v*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		case c == '(':
+			depth++
+			out = append(out, c)
+		case c == ')':
+			if depth == 0 {
+				return pdfText(out)
+			}
+			depth--
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return ""
+}
+
+// pdfText decodes a PDF text string: UTF-16 with a byte order mark, or
+// otherwise PDFDocEncoding, which is close enough to Latin-1.
+func pdfText(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		return utf16String(b, true)
+	}
+	return latin1(b)
+}
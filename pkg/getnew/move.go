@@ -0,0 +1,339 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Result describes a completed move.
+type Result struct {
+	Source   string
+	Dest     string
+	Size     int64
+	SHA256   string
+	Duration time.Duration
+}
+
+// Move copies c to dest, checks the copy and only then removes the original,
+// unless opts.KeepSource is set. dest is the full path of the new file. A
+// directory is moved with everything in it, or with opts.TarDirs packed
+// into a gzipped tarball at dest. A symbolic link that Find did not follow
+// is recreated at dest.
+func Move(ctx context.Context, c Candidate, dest string, opts Options) (Result, error) {
+	result, err := move(ctx, c, dest, opts)
+	return result, sourceGone(c.Source, err)
+}
+
+func move(ctx context.Context, c Candidate, dest string, opts Options) (Result, error) {
+	if sameFile(c, dest) {
+		return Result{}, WithKind(fmt.Errorf("%s is already at %s", c.Name(), dest), ErrConflict)
+	}
+	if c.Mode()&fs.ModeSymlink != 0 {
+		return moveLink(ctx, c, dest, opts)
+	}
+	if c.IsDir() {
+		return moveDir(ctx, c, dest, opts)
+	}
+	clk := opts.clock()
+	start := clk.Now()
+
+	// Fail now rather than part way through with a truncated copy
+	if err := checkSpace(filepath.Dir(dest), c.Size()); err != nil {
+		return Result{}, err
+	}
+
+	// The copy is made and checked under the partial name, so a file already
+	// at dest is only replaced by a good copy
+	partial := dest + PartialSuffix
+	checksum, cloned, err := cloneLocal(ctx, c, dest)
+	if err != nil {
+		return Result{}, err
+	}
+	if !cloned {
+		if checksum, err = copyResuming(ctx, c, dest, opts); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := VerifyCopy(partial, c.Size()); err != nil {
+		os.Remove(partial)
+		return Result{}, err
+	}
+	if opts.Verify {
+		if err := VerifyContents(ctx, c, partial, checksum, opts); err != nil {
+			os.Remove(partial)
+			return Result{}, err
+		}
+	}
+	if !opts.KeepSource {
+		if err := WaitUntilClosed(ctx, c, opts); err != nil {
+			os.Remove(partial)
+			return Result{}, err
+		}
+	}
+
+	if opts.Trash {
+		if _, err := os.Lstat(dest); err == nil {
+			if err := Trash(dest); err != nil {
+				os.Remove(partial)
+				return Result{}, err
+			}
+		}
+	}
+	if err := renamePartial(partial, dest); err != nil {
+		os.Remove(partial)
+		return Result{}, err
+	}
+	if !opts.KeepSource {
+		if err := removeSource(c, opts); err != nil {
+			return Result{}, fmt.Errorf("failed to remove original file: %w", err)
+		}
+	}
+	return Result{
+		Source:   c.Source.Location(c.Name()),
+		Dest:     dest,
+		Size:     c.Size(),
+		SHA256:   checksum,
+		Duration: clk.Since(start),
+	}, nil
+}
+
+// sameFile reports whether dest is where c already is, perhaps by another
+// path, as moving it there would replace it with itself and then remove it.
+func sameFile(c Candidate, dest string) bool {
+	src := c.Source.Location(c.Name())
+	if !strings.EqualFold(filepath.Base(src), filepath.Base(dest)) {
+		return false
+	}
+	from, err := os.Stat(filepath.Dir(src))
+	if err != nil {
+		return false
+	}
+	to, err := os.Stat(filepath.Dir(dest))
+	if err != nil || !os.SameFile(from, to) {
+		return false
+	}
+	if filepath.Base(src) == filepath.Base(dest) {
+		return true
+	}
+	// Names that differ only in case are one file on some file systems
+	a, err := os.Lstat(src)
+	if err != nil {
+		return false
+	}
+	b, err := os.Lstat(dest)
+	return err == nil && os.SameFile(a, b)
+}
+
+// WriteFile writes r to destPath, creating its directory if needed, and
+// returns the SHA-256 of the contents. The contents go to a partial file
+// that is renamed into place once complete; if the copy fails or ctx is
+// cancelled part way, the partial file is removed.
+func WriteFile(ctx context.Context, destPath string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	partial := destPath + PartialSuffix
+	destFile, err := os.Create(partial)
+	if err != nil {
+		return "", fmt.Errorf("failed to create destination file: %w", err)
+	}
+	hash := sha256.New()
+	err = writePartial(ctx, destFile, io.MultiWriter(destFile, hash), r)
+	if err == nil {
+		err = renamePartial(partial, destPath)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// PartialSuffix is added to the name of a copy in progress. A copy that is
+// interrupted leaves it behind when the source can be read from part way
+// through, and copying the same file again carries on from it.
+const PartialSuffix = ".getnew-partial"
+
+// RangeOpener is implemented by sources that can open a file part way
+// through, which lets an interrupted copy resume.
+type RangeOpener interface {
+	OpenAt(name string, offset int64) (io.ReadCloser, error)
+}
+
+// copyResuming copies c to dest's partial file, leaving the caller to
+// rename it into place. Wherever the source is a RangeOpener, it resumes
+// from a partial copy left by an earlier attempt, and leaves its own
+// partial copy if interrupted.
+func copyResuming(ctx context.Context, c Candidate, dest string, opts Options) (string, error) {
+	ranged, canResume := c.Source.(RangeOpener)
+	canResume = canResume && c.Size() >= 0
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	partial := dest + PartialSuffix
+	hash := sha256.New()
+	var f *os.File
+	var r io.ReadCloser
+	var offset int64
+	if canResume {
+		offset = resumeOffset(c, ranged, partial)
+	}
+	if offset > 0 {
+		var err error
+		if f, err = os.OpenFile(partial, os.O_RDWR, 0); err == nil {
+			// The checksum covers what was copied before, too
+			if _, err = CopyContext(ctx, hash, io.NewSectionReader(f, 0, offset)); err == nil {
+				_, err = f.Seek(offset, io.SeekStart)
+			}
+			if err == nil {
+				r, err = ranged.OpenAt(c.Name(), offset)
+			}
+			if err != nil {
+				f.Close()
+				f = nil
+				hash.Reset()
+			}
+		}
+		if f != nil && opts.Stderr != nil {
+			fmt.Fprintf(opts.Stderr, "Resuming %s after %s\n", c.Name(), byteSize(offset))
+		}
+	}
+	if f == nil {
+		var err error
+		if r, err = c.Source.Open(c.Name()); err != nil {
+			return "", fmt.Errorf("failed to open source file: %w", err)
+		}
+		if f, err = os.Create(partial); err != nil {
+			r.Close()
+			return "", fmt.Errorf("failed to create destination file: %w", err)
+		}
+	}
+
+	err := writePartial(ctx, f, io.MultiWriter(f, hash), r)
+	if closeErr := r.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to read source file: %w", closeErr)
+	}
+	if err != nil {
+		if info, statErr := os.Stat(partial); canResume && statErr == nil && info.Size() > 0 {
+			return "", fmt.Errorf("%w (%s copied is kept in %s, and the next attempt will resume from it)", err, byteSize(info.Size()), filepath.Base(partial))
+		}
+		os.Remove(partial)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writePartial copies r to w, which writes to f, then closes f.
+func writePartial(ctx context.Context, f *os.File, w io.Writer, r io.Reader) error {
+	if _, err := CopyContext(ctx, w, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close destination file: %w", err)
+	}
+	return nil
+}
+
+// renamePartial puts a finished partial copy in place at dest.
+func renamePartial(partial, dest string) error {
+	if err := os.Rename(partial, dest); err != nil {
+		return fmt.Errorf("failed to rename destination file: %w", err)
+	}
+	return nil
+}
+
+// resumeOffset is how much of c an earlier copy left in partial, or 0 if
+// there is none or it cannot be trusted: if the source has changed since,
+// or the end of the partial copy differs from the source there.
+func resumeOffset(c Candidate, ranged RangeOpener, partial string) int64 {
+	info, err := os.Stat(partial)
+	if err != nil || info.Size() == 0 || info.Size() >= c.Size() || c.ModTime().After(info.ModTime()) {
+		return 0
+	}
+	f, err := os.Open(partial)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	n := min(info.Size(), 64<<10)
+	have := make([]byte, n)
+	if _, err := f.ReadAt(have, info.Size()-n); err != nil {
+		return 0
+	}
+	r, err := ranged.OpenAt(c.Name(), info.Size()-n)
+	if err != nil {
+		return 0
+	}
+	defer r.Close()
+	want := make([]byte, n)
+	if _, err := io.ReadFull(r, want); err != nil || !bytes.Equal(have, want) {
+		return 0
+	}
+	return info.Size()
+}
+
+// VerifyCopy checks destPath has the expected size. Sources that cannot tell
+// the size up front report it as -1, which skips the check.
+func VerifyCopy(destPath string, size int64) error {
+	if size < 0 {
+		return nil
+	}
+	info, err := os.Stat(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy: %w", err)
+	}
+	if info.Size() != size {
+		return fmt.Errorf("copy of %s is %d bytes, expected %d", destPath, info.Size(), size)
+	}
+	return nil
+}
+
+// contextReader stops a copy once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// CopyContext is io.Copy that stops once ctx is done.
+func CopyContext(ctx context.Context, w io.Writer, r io.Reader) (int64, error) {
+	return io.Copy(w, contextReader{ctx, r})
+}
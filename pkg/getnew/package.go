@@ -0,0 +1,346 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Debian and RPM packages are unpacked for a look inside, into a directory
+// named after the package, without dpkg or rpm2cpio. Their payloads are
+// compressed with gzip or bzip2, read directly, or xz or zstd, which need
+// those tools.
+
+// packageDir is the directory a package is unpacked into: its name without
+// the extension, beside it in dir.
+func packageDir(src, dir string) string {
+	name := filepath.Base(src)
+	return filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// decompress reads r decompressed as its first bytes say it is compressed,
+// or as it is if they do not. Closing it waits for any tool it runs.
+func decompress(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+	var tool string
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return gz, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0}):
+		tool = "xz"
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		tool = "zstd"
+	default:
+		return io.NopCloser(br), nil
+	}
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return nil, fmt.Errorf("no tool to decompress %s data: install %s", tool, tool)
+	}
+	cmd := exec.CommandContext(ctx, path, "-dc")
+	cmd.Stdin = br
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return toolReader{out, cmd}, nil
+}
+
+// toolReader is the output of a decompressing tool.
+type toolReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r toolReader) Close() error {
+	// Stopping reading early makes the tool fail writing, which is fine
+	r.ReadCloser.Close()
+	r.cmd.Wait()
+	return nil
+}
+
+// readAr calls fn with each member of an ar archive, the container of a
+// Debian package.
+func readAr(r io.Reader, fn func(name string, r io.Reader) error) error {
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "!<arch>\n" {
+		return errors.New("not a Debian package")
+	}
+	hdr := make([]byte, 60)
+	for {
+		if _, err := io.ReadFull(r, hdr); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(hdr[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad size for %s in package", name)
+		}
+		member := io.LimitReader(r, size)
+		if err := fn(name, member); err != nil {
+			return err
+		}
+		// Members are padded to an even length
+		if _, err := io.CopyN(io.Discard, member, size); err != nil && err != io.EOF {
+			return err
+		}
+		if size%2 == 1 {
+			io.CopyN(io.Discard, r, 1)
+		}
+	}
+}
+
+// debTar calls fn with the tar stream of each member of the Debian package
+// at src that is wanted: data.tar, and control.tar if control is set.
+func debTar(ctx context.Context, src string, control bool, fn func(member string, tr *tar.Reader) error) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readAr(bufio.NewReader(f), func(name string, r io.Reader) error {
+		member, _, _ := strings.Cut(name, ".")
+		if name != "data.tar" && !strings.HasPrefix(name, "data.tar.") && (!control || !strings.HasPrefix(name, "control.tar")) {
+			return nil
+		}
+		dr, err := decompress(ctx, r)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+		return fn(member, tar.NewReader(dr))
+	})
+}
+
+// extractDeb unpacks the files a Debian package installs, and when all of it
+// is wanted its control files into DEBIAN, as dpkg-deb --raw-extract does.
+func extractDeb(ctx context.Context, src, dir string, _ Options, filter *entryFilter) error {
+	root := packageDir(src, dir)
+	return debTar(ctx, src, filter == nil, func(member string, tr *tar.Reader) error {
+		if member == "control" {
+			return unpackTar(ctx, tr, filepath.Join(root, "DEBIAN"), nil)
+		}
+		return unpackTar(ctx, tr, root, filter)
+	})
+}
+
+func testDeb(ctx context.Context, src string, _ Options) error {
+	return debTar(ctx, src, true, func(_ string, tr *tar.Reader) error {
+		return readTar(ctx, tr)
+	})
+}
+
+func listDeb(ctx context.Context, src string, _ Options) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	err := debTar(ctx, src, false, func(_ string, tr *tar.Reader) error {
+		var err error
+		entries, err = tarEntries(ctx, tr)
+		return err
+	})
+	return entries, err
+}
+
+// rpmPayload skips the lead and headers of an RPM package, leaving r at its
+// payload, a compressed cpio archive.
+func rpmPayload(r io.Reader) error {
+	lead := make([]byte, 96)
+	if _, err := io.ReadFull(r, lead); err != nil || !bytes.HasPrefix(lead, []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return errors.New("not an RPM package")
+	}
+	// The signature header, padded to eight bytes, then the header proper
+	for _, pad := range []bool{true, false} {
+		intro := make([]byte, 16)
+		if _, err := io.ReadFull(r, intro); err != nil || !bytes.HasPrefix(intro, []byte{0x8e, 0xad, 0xe8}) {
+			return errors.New("bad RPM header")
+		}
+		size := 16*int64(binary.BigEndian.Uint32(intro[8:])) + int64(binary.BigEndian.Uint32(intro[12:]))
+		if pad {
+			size += (8 - (16+size)%8) % 8
+		}
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return fmt.Errorf("bad RPM header: %w", err)
+		}
+	}
+	return nil
+}
+
+// cpioEntry is a file in a cpio archive in the "new ASCII" format RPM uses.
+type cpioEntry struct {
+	name    string
+	mode    uint32
+	size    int64
+	modTime time.Time
+}
+
+// readCpio calls fn with each entry of a cpio archive and a reader of its
+// contents.
+func readCpio(r io.Reader, fn func(e cpioEntry, r io.Reader) error) error {
+	hdr := make([]byte, 110)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return fmt.Errorf("bad cpio archive: %w", err)
+		}
+		if magic := string(hdr[:6]); magic != "070701" && magic != "070702" {
+			return errors.New("bad cpio archive: not in the new ASCII format")
+		}
+		field := func(i int) int64 {
+			n, _ := strconv.ParseInt(string(hdr[6+8*i:14+8*i]), 16, 64)
+			return n
+		}
+		nameSize, size := field(11), field(6)
+		name := make([]byte, nameSize+(4-(110+nameSize)%4)%4)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return fmt.Errorf("bad cpio archive: %w", err)
+		}
+		e := cpioEntry{
+			name:    strings.TrimPrefix(string(bytes.TrimRight(name[:nameSize], "\x00")), "./"),
+			mode:    uint32(field(1)),
+			size:    size,
+			modTime: time.Unix(field(5), 0),
+		}
+		if e.name == "TRAILER!!!" {
+			return nil
+		}
+		data := io.LimitReader(r, size)
+		if err := fn(e, data); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(io.Discard, data, size); err != nil && err != io.EOF {
+			return err
+		}
+		if _, err := io.CopyN(io.Discard, r, (4-size%4)%4); err != nil {
+			return fmt.Errorf("bad cpio archive: %w", err)
+		}
+	}
+}
+
+// rpmCpio calls fn with each entry of the payload of the RPM package at src.
+func rpmCpio(ctx context.Context, src string, fn func(e cpioEntry, r io.Reader) error) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if err := rpmPayload(r); err != nil {
+		return err
+	}
+	payload, err := decompress(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer payload.Close()
+	return readCpio(payload, func(e cpioEntry, r io.Reader) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(e, r)
+	})
+}
+
+// Unix file types in a cpio mode.
+const (
+	cpioTypeMask = 0o170000
+	cpioDir      = 0o040000
+	cpioFile     = 0o100000
+	cpioSymlink  = 0o120000
+)
+
+// extractRPM unpacks the files an RPM package installs.
+func extractRPM(ctx context.Context, src, dir string, _ Options, filter *entryFilter) error {
+	root := packageDir(src, dir)
+	return rpmCpio(ctx, src, func(e cpioEntry, r io.Reader) error {
+		if e.name == "" || e.name == "." {
+			return nil
+		}
+		target, err := entryPath(root, e.name)
+		if err != nil {
+			return err
+		}
+		switch e.mode & cpioTypeMask {
+		case cpioDir:
+			if filter == nil {
+				return os.MkdirAll(target, 0o755)
+			}
+		case cpioFile:
+			if filter.take(e.name) {
+				return writeEntry(ctx, target, r, fs.FileMode(e.mode&0o777), e.modTime)
+			}
+		case cpioSymlink:
+			if filter.take(e.name) {
+				link, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				return writeSymlink(root, e.name, string(link))
+			}
+		}
+		return nil
+	})
+}
+
+func listRPM(ctx context.Context, src string, _ Options) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	err := rpmCpio(ctx, src, func(e cpioEntry, _ io.Reader) error {
+		if e.name != "" && e.name != "." {
+			entries = append(entries, ArchiveEntry{Name: e.name, Size: e.size, Dir: e.mode&cpioTypeMask == cpioDir})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func testRPM(ctx context.Context, src string, _ Options) error {
+	return rpmCpio(ctx, src, func(_ cpioEntry, r io.Reader) error {
+		_, err := CopyContext(ctx, io.Discard, r)
+		return err
+	})
+}
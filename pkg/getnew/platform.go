@@ -0,0 +1,220 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Platform describes the machine a release asset should be chosen for.
+type Platform struct {
+	OS   string // as runtime.GOOS
+	Arch string // as runtime.GOARCH
+	// Package is the native package format, "deb" or "rpm", if known.
+	Package string
+}
+
+// CurrentPlatform describes the machine getnew is running on.
+func CurrentPlatform() Platform {
+	p := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if p.OS == "linux" {
+		p.Package = linuxPackageFormat("/etc/os-release")
+	}
+	return p
+}
+
+func linuxPackageFormat(osRelease string) string {
+	f, err := os.Open(osRelease)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && (key == "ID" || key == "ID_LIKE") {
+			ids = append(ids, strings.Fields(strings.Trim(value, `"'`))...)
+		}
+	}
+	for _, id := range ids {
+		switch id {
+		case "debian", "ubuntu":
+			return "deb"
+		case "fedora", "rhel", "centos", "suse", "opensuse":
+			return "rpm"
+		}
+	}
+	return ""
+}
+
+var platformOS = map[string]string{
+	"linux": "linux", "darwin": "darwin", "macos": "darwin", "mac": "darwin", "osx": "darwin",
+	"apple": "darwin", "windows": "windows", "win": "windows", "win64": "windows", "win32": "windows",
+	"freebsd": "freebsd", "openbsd": "openbsd", "netbsd": "netbsd",
+}
+
+var platformArch = map[string]string{
+	"amd64": "amd64", "x64": "amd64", "arm64": "arm64", "aarch64": "arm64",
+	"386": "386", "i386": "386", "i686": "386", "x86": "386", "win32": "386",
+	"arm": "arm", "armv6": "arm", "armv7": "arm", "armhf": "arm",
+	"universal": "*",
+}
+
+// Extensions that say which platform a file is for, and those that don't.
+var platformExts = map[string]string{
+	".deb": "deb", ".rpm": "rpm", ".exe": "windows", ".msi": "windows",
+	".dmg": "darwin", ".pkg": "darwin", ".appimage": "linux",
+}
+
+var archiveExts = []string{".tar.gz", ".tar.xz", ".tar.bz2", ".tgz", ".zip", ".7z", ".tar"}
+
+var (
+	x8664       = regexp.MustCompile(`x86[_-]64`)
+	nameTokenRe = regexp.MustCompile(`[-_.\s]+`)
+)
+
+// platformInfo is what a file name says about its platform.
+type platformInfo struct {
+	key    string // the name with every platform marker removed
+	os     string
+	arch   string
+	pkg    string
+	tagged bool
+	extOS  string
+}
+
+func parsePlatform(name string) platformInfo {
+	var info platformInfo
+	stem := strings.ToLower(name)
+	for ext, kind := range platformExts {
+		if strings.HasSuffix(stem, ext) {
+			stem = strings.TrimSuffix(stem, ext)
+			info.tagged = true
+			if kind == "deb" || kind == "rpm" {
+				info.pkg, info.extOS = kind, "linux"
+			} else {
+				info.extOS = kind
+			}
+		}
+	}
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(stem, ext) {
+			stem = strings.TrimSuffix(stem, ext)
+			break
+		}
+	}
+
+	var rest []string
+	for _, token := range nameTokenRe.Split(x8664.ReplaceAllString(stem, "amd64"), -1) {
+		goos, isOS := platformOS[token]
+		goarch, isArch := platformArch[token]
+		switch {
+		case isOS && isArch: // win32
+			info.os, info.arch, info.tagged = goos, goarch, true
+		case isOS:
+			info.os, info.tagged = goos, true
+		case isArch:
+			info.arch, info.tagged = goarch, true
+		case token != "":
+			rest = append(rest, token)
+		}
+	}
+	if info.os == "" {
+		info.os = info.extOS
+	}
+	info.key = strings.Join(rest, "-")
+	return info
+}
+
+// score rates how well a file fits p: negative means it cannot run there.
+func (p Platform) score(info platformInfo) int {
+	score := 0
+	if info.os != "" {
+		if info.os != p.OS {
+			return -1
+		}
+		score += 4
+	}
+	if info.arch != "" {
+		if info.arch != "*" && info.arch != p.Arch {
+			return -1
+		}
+		score += 2
+		if info.arch == p.Arch {
+			score++
+		}
+	}
+	if info.pkg != "" {
+		if p.Package != "" && info.pkg != p.Package {
+			return -1
+		}
+		if info.pkg == p.Package {
+			score++
+		}
+	}
+	return score
+}
+
+// choose keeps, out of each set of files that differ only by platform, the
+// ones that best fit p. Files built for other platforms are dropped.
+func (p Platform) choose(files []Candidate) []Candidate {
+	infos := make([]platformInfo, len(files))
+	groups := map[string][]int{}
+	for i, file := range files {
+		infos[i] = parsePlatform(file.Name())
+		if infos[i].tagged {
+			groups[infos[i].key] = append(groups[infos[i].key], i)
+		}
+	}
+
+	drop := make([]bool, len(files))
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		best := -1
+		for _, i := range group {
+			if s := p.score(infos[i]); s > best {
+				best = s
+			}
+		}
+		for _, i := range group {
+			if s := p.score(infos[i]); s < 0 || s < best {
+				drop[i] = true
+			}
+		}
+	}
+
+	kept := files[:0]
+	for i, file := range files {
+		if !drop[i] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
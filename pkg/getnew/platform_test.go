@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type namedFile string
+
+func (n namedFile) Name() string       { return string(n) }
+func (n namedFile) Size() int64        { return 0 }
+func (n namedFile) Mode() fs.FileMode  { return 0 }
+func (n namedFile) ModTime() time.Time { return time.Time{} }
+func (n namedFile) IsDir() bool        { return false }
+func (n namedFile) Sys() any           { return nil }
+
+func TestPlatformChoose(t *testing.T) {
+	linux := Platform{OS: "linux", Arch: "amd64", Package: "deb"}
+	mac := Platform{OS: "darwin", Arch: "arm64"}
+
+	tests := []struct {
+		platform Platform
+		files    []string
+		want     []string
+	}{
+		{linux, []string{"tool-1.2-linux-amd64.tar.gz", "tool-1.2-linux-arm64.tar.gz", "tool-1.2-darwin-arm64.tar.gz", "tool-1.2-windows-amd64.zip"},
+			[]string{"tool-1.2-linux-amd64.tar.gz"}},
+		{mac, []string{"tool-1.2-linux-amd64.tar.gz", "tool-1.2-darwin-arm64.tar.gz", "tool-1.2-darwin-amd64.tar.gz"},
+			[]string{"tool-1.2-darwin-arm64.tar.gz"}},
+		{mac, []string{"app-3.0-universal.dmg", "app-3.0-x86_64.AppImage", "app-3.0-win64.exe"},
+			[]string{"app-3.0-universal.dmg"}},
+		{linux, []string{"app_2.1_amd64.deb", "app-2.1.x86_64.rpm", "app_2.1_arm64.deb"},
+			[]string{"app_2.1_amd64.deb"}},
+		// Unrelated files and lone platform builds are left alone
+		{linux, []string{"report.pdf", "tool-darwin-arm64.zip", "other-linux-amd64.zip"},
+			[]string{"other-linux-amd64.zip", "report.pdf", "tool-darwin-arm64.zip"}},
+	}
+	for _, tt := range tests {
+		var files []Candidate
+		for _, name := range tt.files {
+			files = append(files, Candidate{FileInfo: namedFile(name)})
+		}
+		var got []string
+		for _, file := range tt.platform.choose(files) {
+			got = append(got, file.Name())
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s/%s choose(%v) = %v, want %v", tt.platform.OS, tt.platform.Arch, tt.files, got, tt.want)
+		}
+	}
+}
+
+func TestLinuxPackageFormat(t *testing.T) {
+	dir := t.TempDir()
+	for content, want := range map[string]string{
+		"ID=ubuntu\nID_LIKE=debian\n":                    "deb",
+		"ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n": "rpm",
+		"ID=arch\n": "",
+	} {
+		path := filepath.Join(dir, "os-release")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got := linuxPackageFormat(path); got != want {
+			t.Errorf("linuxPackageFormat(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"regexp"
+	"strconv"
+)
+
+// ImageSize is the width and height in pixels of a PNG, JPEG or GIF, read
+// from its header.
+func ImageSize(c Candidate) (width, height int, ok bool) {
+	r, err := c.Source.Open(c.Name())
+	if err != nil {
+		return 0, 0, false
+	}
+	defer r.Close()
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, false
+	}
+	return cfg.Width, cfg.Height, true
+}
+
+// Thumbnail decodes a PNG, JPEG or GIF and scales it down to fit within
+// width by height pixels, keeping its proportions. Each pixel is the average
+// of those it covers, so text in screenshots stays legible.
+func Thumbnail(c Candidate, width, height int) (image.Image, error) {
+	r, err := c.Source.Open(c.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	scale := min(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()), 1)
+	w, h := max(int(float64(b.Dx())*scale), 1), max(int(float64(b.Dy())*scale), 1)
+	thumb := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			var sr, sg, sb, sa, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					r, g, b, a := img.At(sx, sy).RGBA()
+					sr, sg, sb, sa, n = sr+uint64(r), sg+uint64(g), sb+uint64(b), sa+uint64(a), n+1
+				}
+			}
+			i := thumb.PixOffset(x, y)
+			thumb.Pix[i], thumb.Pix[i+1], thumb.Pix[i+2], thumb.Pix[i+3] = uint8(sr/n>>8), uint8(sg/n>>8), uint8(sb/n>>8), uint8(sa/n>>8)
+		}
+	}
+	return thumb, nil
+}
+
+var (
+	pdfPagesCount = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+	pdfPage       = regexp.MustCompile(`/Type\s*/Page\b`)
+)
+
+// PDFPages is the number of pages in a PDF: the count in its page tree, or
+// failing that the pages found. Neither is found when the PDF keeps them in
+// a compressed object stream.
+func PDFPages(c Candidate) (int, bool) {
+	r, closer, err := openReaderAt(c)
+	if err != nil {
+		return 0, false
+	}
+	defer closer.Close()
+	data := pdfData(r, c.Size())
+	// The root of the page tree counts every page, so has the largest count
+	pages := 0
+	for _, m := range pdfPagesCount.FindAllSubmatch(data, -1) {
+		count := m[1]
+		if count == nil {
+			count = m[2]
+		}
+		if n, err := strconv.Atoi(string(count)); err == nil {
+			pages = max(pages, n)
+		}
+	}
+	if pages == 0 {
+		pages = len(pdfPage.FindAllIndex(data, -1))
+	}
+	return pages, pages > 0
+}
@@ -0,0 +1,211 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package getnew finds the newest files in one or more source directories and
+// moves them elsewhere. It is the library behind the getnew command.
+package getnew
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Entry describes one file or directory in a source. Backends that cannot
+// tell a file's size up front report it as -1.
+type Entry = fs.FileInfo
+
+// SourceBackend is a place candidate files are listed, read and removed from.
+type SourceBackend interface {
+	// List returns the entries of the source directory.
+	List(ctx context.Context) ([]Entry, error)
+	Stat(name string) (Entry, error)
+	Open(name string) (io.ReadCloser, error)
+	Remove(name string) error
+	// Location describes name within the source for messages and history;
+	// an empty name describes the source itself.
+	Location(name string) string
+}
+
+// Streamer is implemented by sources that can list their entries as they
+// read them, which large directories need. Stream stops at the first error
+// fn returns.
+type Streamer interface {
+	Stream(ctx context.Context, fn func(Entry) error) error
+}
+
+// ErrSourceGone is returned when a source directory disappears, as when a
+// network share is unmounted or a drive unplugged part way through.
+var ErrSourceGone = errors.New("source directory is gone")
+
+// goneChecker is implemented by sources that can tell they have disappeared.
+type goneChecker interface {
+	Gone() bool
+}
+
+// sourceGone marks err as ErrSourceGone when src has disappeared.
+func sourceGone(src SourceBackend, err error) error {
+	if err == nil {
+		return nil
+	}
+	if g, ok := src.(goneChecker); ok && g.Gone() {
+		return fmt.Errorf("%w (%s): %w", ErrSourceGone, src.Location(""), err)
+	}
+	return err
+}
+
+// BackendFunc opens a source from its full spec, such as sftp://host/dir.
+type BackendFunc func(spec string) (SourceBackend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFunc{
+		"file": func(spec string) (SourceBackend, error) {
+			u, err := url.Parse(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid source %q: %w", spec, err)
+			}
+			return LocalSource{Dir: u.Path}, nil
+		},
+	}
+)
+
+// RegisterBackend makes OpenSource use open for specs with the given URL
+// scheme, replacing any backend already registered for it.
+func RegisterBackend(scheme string, open BackendFunc) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[strings.ToLower(scheme)] = open
+}
+
+// OpenSource opens spec with the backend registered for its URL scheme. A
+// spec without a scheme is a local directory.
+func OpenSource(spec string) (SourceBackend, error) {
+	scheme, _, ok := strings.Cut(spec, "://")
+	if !ok {
+		return LocalSource{Dir: spec}, nil
+	}
+	backendsMu.RLock()
+	open := backends[strings.ToLower(scheme)]
+	backendsMu.RUnlock()
+	if open == nil {
+		return nil, fmt.Errorf("unsupported source %q: no backend for %s://", spec, scheme)
+	}
+	return open(spec)
+}
+
+// LocalSource is a directory on this machine.
+type LocalSource struct {
+	Dir string
+}
+
+// Gone reports whether the directory can no longer be reached.
+func (s LocalSource) Gone() bool {
+	info, err := os.Stat(s.Dir)
+	return err != nil || !info.IsDir()
+}
+
+func (s LocalSource) List(ctx context.Context) ([]Entry, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source directory: %w", err)
+	}
+	infos := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file info: %w", err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Stream calls fn with each entry as the directory is read. Unlike List,
+// it does not stat an entry until its size or time is asked for.
+func (s LocalSource) Stream(ctx context.Context, fn func(Entry) error) error {
+	dir, err := os.Open(s.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read source directory: %w", err)
+	}
+	defer dir.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := dir.ReadDir(1024)
+		for _, entry := range entries {
+			if err := fn(&lazyEntry{DirEntry: entry}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read source directory: %w", err)
+		}
+	}
+}
+
+func (s LocalSource) Stat(name string) (Entry, error) {
+	return os.Stat(filepath.Join(s.Dir, name))
+}
+
+func (s LocalSource) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Dir, name))
+}
+
+// OpenAt opens name with its first offset bytes skipped.
+func (s LocalSource) OpenAt(name string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s LocalSource) Remove(name string) error {
+	return os.Remove(filepath.Join(s.Dir, name))
+}
+
+func (s LocalSource) Location(name string) string {
+	path, err := filepath.Abs(filepath.Join(s.Dir, name))
+	if err != nil {
+		return filepath.Join(s.Dir, name)
+	}
+	return path
+}
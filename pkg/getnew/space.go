@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SpaceError reports a destination without room for what is to be written.
+type SpaceError struct {
+	Dir        string
+	Need, Free int64
+}
+
+func (e *SpaceError) Error() string {
+	return fmt.Sprintf("not enough space in %s: %s needed, %s free", e.Dir, byteSize(e.Need), byteSize(e.Free))
+}
+
+// checkSpace returns a *SpaceError if the filesystem holding dir, or the
+// nearest parent that exists yet, has fewer than need bytes free. A need
+// of -1, or free space that cannot be told, passes.
+func checkSpace(dir string, need int64) error {
+	if need <= 0 {
+		return nil
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	free, err := freeSpace(dir)
+	if err != nil || free < 0 || free >= need {
+		return nil
+	}
+	return &SpaceError{Dir: dir, Need: need, Free: free}
+}
+
+// declaredSize is what an archive says it will unpack to, or -1 where that
+// cannot be told without unpacking it, as for compressed tar files.
+func declaredSize(ctx context.Context, path, format string, opts Options) int64 {
+	switch format {
+	case ".gz", ".tar.gz", ".tgz":
+		// The size is recorded, modulo 4GiB, in the last four bytes
+		f, err := os.Open(path)
+		if err != nil {
+			return -1
+		}
+		defer f.Close()
+		var trailer [4]byte
+		info, err := f.Stat()
+		if err != nil || info.Size() < 4 {
+			return -1
+		}
+		if _, err := f.ReadAt(trailer[:], info.Size()-4); err != nil {
+			return -1
+		}
+		return int64(binary.LittleEndian.Uint32(trailer[:]))
+	case ".zip", ".7z", ".rar", ".iso":
+		entries, err := ListArchive(ctx, path, opts)
+		if err != nil {
+			return -1
+		}
+		var total int64
+		for _, e := range entries {
+			if e.Size < 0 {
+				return -1
+			}
+			total += e.Size
+		}
+		return total
+	}
+	return -1
+}
+
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
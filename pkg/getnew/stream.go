@@ -0,0 +1,203 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"container/heap"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lazyEntry is a directory entry that is only statted once its size, mode
+// or time is asked for. If the file has gone by then, it reports a size of
+// -1 and the zero time, and Find leaves it out.
+type lazyEntry struct {
+	fs.DirEntry
+	once sync.Once
+	info fs.FileInfo
+	err  error
+}
+
+func (e *lazyEntry) stat() fs.FileInfo {
+	e.once.Do(func() { e.info, e.err = e.DirEntry.Info() })
+	return e.info
+}
+
+func (e *lazyEntry) Size() int64 {
+	if info := e.stat(); info != nil {
+		return info.Size()
+	}
+	return -1
+}
+
+func (e *lazyEntry) Mode() fs.FileMode {
+	if info := e.stat(); info != nil {
+		return info.Mode()
+	}
+	return e.Type()
+}
+
+func (e *lazyEntry) ModTime() time.Time {
+	if info := e.stat(); info != nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+func (e *lazyEntry) Sys() any {
+	if info := e.stat(); info != nil {
+		return info.Sys()
+	}
+	return nil
+}
+
+// ranked is a candidate with what it is ordered by worked out once.
+type ranked struct {
+	Candidate
+	src, seq  int
+	score     int
+	ver       version
+	versioned bool
+	unsettled bool
+}
+
+// topN keeps the best limit candidates in the order Find returns them, or
+// every candidate if limit is 0. Its heap has the worst at the root.
+type topN struct {
+	opts      Options
+	limit     int
+	items     []ranked
+	seq       int
+	unsettled int
+	// evicted is set once a candidate has been let go.
+	evicted bool
+}
+
+func newTopN(opts Options) *topN {
+	t := &topN{opts: opts, limit: opts.Limit}
+	if opts.Platform != nil {
+		// Choosing between platforms needs every file
+		t.limit = 0
+	}
+	return t
+}
+
+func (t *topN) add(c Candidate, src int) {
+	t.seq++
+	r := ranked{Candidate: c, src: src, seq: t.seq}
+	if t.limit <= 0 {
+		t.items = append(t.items, r)
+		return
+	}
+	if t.opts.fuzzy() {
+		r.score, _ = FuzzyScore(c.Name(), t.opts.Filter)
+	}
+	if t.opts.ByVersion {
+		r.ver, r.versioned = parseVersion(c.Name())
+	}
+	// Files that may yet be left out as unsettled do not take up a place
+	if t.opts.Settle > 0 && t.opts.clock().Since(c.ModTime()) < t.opts.Settle {
+		r.unsettled = true
+		t.unsettled++
+	}
+	heap.Push(t, r)
+	for len(t.items)-t.unsettled > t.limit {
+		if heap.Pop(t).(ranked).unsettled {
+			t.unsettled--
+		}
+		t.evicted = true
+	}
+}
+
+// remove drops the candidates drop reports true for, and reports whether
+// there were any.
+func (t *topN) remove(drop func(c Candidate, src int) bool) bool {
+	kept := t.items[:0]
+	for _, r := range t.items {
+		if !drop(r.Candidate, r.src) {
+			kept = append(kept, r)
+		}
+	}
+	dropped := len(kept) < len(t.items)
+	t.items = kept
+	return dropped
+}
+
+// files returns the candidates in listing order, statting any listed lazily
+// and leaving out those that have gone since.
+func (t *topN) files() []Candidate {
+	sort.Slice(t.items, func(i, j int) bool { return listedBefore(t.items[i], t.items[j]) })
+	files := make([]Candidate, 0, len(t.items))
+	for _, r := range t.items {
+		if e, ok := r.FileInfo.(*lazyEntry); ok {
+			if e.stat(); e.err != nil {
+				continue
+			}
+			r.FileInfo = e.info
+		}
+		files = append(files, r.Candidate)
+	}
+	return files
+}
+
+// better reports whether a comes before b, as Find sorts them.
+func (t *topN) better(a, b ranked) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	if a.versioned != b.versioned {
+		return a.versioned
+	}
+	if c := a.ver.compare(b.ver); c != 0 {
+		return c > 0
+	}
+	if !a.ModTime().Equal(b.ModTime()) {
+		return a.ModTime().After(b.ModTime())
+	}
+	return listedBefore(a, b)
+}
+
+// listedBefore reports whether a was listed before b, taking a directory
+// read a piece at a time as listed by name, as List does.
+func listedBefore(a, b ranked) bool {
+	if a.src != b.src {
+		return a.src < b.src
+	}
+	if _, lazy := a.FileInfo.(*lazyEntry); lazy {
+		return a.Name() < b.Name()
+	}
+	return a.seq < b.seq
+}
+
+func (t *topN) Len() int           { return len(t.items) }
+func (t *topN) Less(i, j int) bool { return t.better(t.items[j], t.items[i]) }
+func (t *topN) Swap(i, j int)      { t.items[i], t.items[j] = t.items[j], t.items[i] }
+func (t *topN) Push(x any)         { t.items = append(t.items, x.(ranked)) }
+
+func (t *topN) Pop() any {
+	last := t.items[len(t.items)-1]
+	t.items = t.items[:len(t.items)-1]
+	return last
+}
@@ -0,0 +1,97 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// SymlinkMode says how Find and Move treat symbolic links in a source.
+// Either way, removing the original removes only the link, never what it
+// points to.
+type SymlinkMode int
+
+const (
+	// SymlinksFollow treats a link as what it points to, so the file or
+	// directory it names is copied. Broken links are left out, as are links
+	// to directories without Options.Dirs. This is the default.
+	SymlinksFollow SymlinkMode = iota
+	// SymlinksPreserve treats a link as a link, recreating it at the
+	// destination pointing at the same place.
+	SymlinksPreserve
+)
+
+// isSymlink reports whether info is a symbolic link, without statting a
+// lazily listed entry.
+func isSymlink(info fs.FileInfo) bool {
+	if e, ok := info.(*lazyEntry); ok {
+		return e.Type()&fs.ModeSymlink != 0
+	}
+	return info.Mode()&fs.ModeSymlink != 0
+}
+
+// moveLink recreates the link c at dest, pointing where c does; a relative
+// target is made absolute so that it still does from dest.
+func moveLink(ctx context.Context, c Candidate, dest string, opts Options) (Result, error) {
+	clk := opts.clock()
+	start := clk.Now()
+	src, ok := c.Source.(LocalSource)
+	if !ok {
+		return Result{}, fmt.Errorf("cannot move link %s: only links on this machine can be moved", c.Source.Location(c.Name()))
+	}
+	path := filepath.Join(src.Dir, c.Name())
+	target, err := os.Readlink(path)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read link: %w", err)
+	}
+	if !filepath.IsAbs(target) {
+		if target, err = filepath.Abs(filepath.Join(src.Dir, target)); err != nil {
+			return Result{}, fmt.Errorf("failed to read link: %w", err)
+		}
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		if !opts.Trash {
+			return Result{}, WithKind(fmt.Errorf("%s already exists", dest), ErrConflict)
+		}
+		if err := Trash(dest); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return Result{}, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	if err := os.Symlink(target, dest); err != nil {
+		return Result{}, fmt.Errorf("failed to create link: %w", err)
+	}
+	if !opts.KeepSource {
+		if err := removeSource(c, opts); err != nil {
+			return Result{}, fmt.Errorf("failed to remove original link: %w", err)
+		}
+	}
+	// A link has no contents of its own to check later
+	return Result{Source: c.Source.Location(c.Name()), Dest: dest, Size: -1, Duration: clk.Since(start)}, nil
+}
@@ -0,0 +1,349 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// builtinFormats are unpacked without any external tool.
+var builtinFormats = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".gz", ".iso", ".deb", ".rpm"}
+
+// extractorTool is an external program that can unpack some formats.
+type extractorTool struct {
+	name    string
+	formats []string
+	// versionArgs print the version; some tools only do so in their usage.
+	versionArgs []string
+	// minVersion, if set, is the oldest version that handles formats.
+	minVersion string
+	// requires are other programs the tool runs for these formats.
+	requires []string
+	args     func(archive string) []string
+	// password gives the arguments for an archive password, or for none
+	// so that the tool fails rather than prompting. Nil if the tool
+	// cannot take one.
+	password func(pw string) []string
+	// list gives the arguments to list the archive's contents, which parse
+	// reads; if parse is nil, one name to a line. Nil if the tool cannot.
+	list  func(archive string) []string
+	parse func(out string) []ArchiveEntry
+	// test gives the arguments to check the archive without unpacking it,
+	// writing anything unpacked to stdout. Nil if the tool cannot.
+	test func(archive string) []string
+}
+
+// extractorTools are in order of preference for each format.
+var extractorTools = []extractorTool{
+	{name: "7zz", formats: []string{".7z", ".rar", ".zip", ".iso", ".dmg"}, args: sevenZipArgs, password: sevenZipPassword, list: sevenZipList, parse: parseSevenZipList, test: sevenZipTest},
+	{name: "7z", formats: []string{".7z", ".rar", ".zip", ".iso", ".dmg"}, args: sevenZipArgs, password: sevenZipPassword, list: sevenZipList, parse: parseSevenZipList, test: sevenZipTest},
+	{name: "7za", formats: []string{".7z", ".zip"}, args: sevenZipArgs, password: sevenZipPassword, list: sevenZipList, parse: parseSevenZipList, test: sevenZipTest},
+	{
+		name: "unrar", formats: []string{".rar"}, args: func(a string) []string { return []string{"x", "-o+", a} },
+		list: func(a string) []string { return []string{"lb", a} },
+		test: func(a string) []string { return []string{"t", a} },
+		password: func(pw string) []string {
+			if pw == "" {
+				return []string{"-p-"}
+			}
+			return []string{"-p" + pw}
+		},
+	},
+	// Only needed for encrypted zip files, which are not unpacked directly
+	{
+		name: "unzip", formats: []string{".zip"}, versionArgs: []string{"-v"},
+		args:     func(a string) []string { return []string{"-o", a} },
+		test:     func(a string) []string { return []string{"-tq", a} },
+		password: func(pw string) []string { return []string{"-P", pw} },
+	},
+	{
+		name: "bsdtar", formats: []string{".7z", ".rar", ".zip", ".tar.xz", ".txz"}, versionArgs: []string{"--version"},
+		// Older libarchive cannot read 7z or RAR
+		minVersion: "3.0", args: func(a string) []string { return []string{"-xf", a} },
+		list: func(a string) []string { return []string{"-tf", a} },
+		test: func(a string) []string { return []string{"-xOf", a} },
+		password: func(pw string) []string {
+			// An empty passphrase is refused outright, even for archives
+			// that need none
+			if pw == "" {
+				return nil
+			}
+			return []string{"--passphrase", pw}
+		},
+	},
+	{
+		name: "tar", formats: []string{".tar.xz", ".txz"}, versionArgs: []string{"--version"}, requires: []string{"xz"},
+		args: func(a string) []string { return []string{"-xJf", a} },
+		list: func(a string) []string { return []string{"-tJf", a} },
+		test: func(a string) []string { return []string{"-xOJf", a} },
+	},
+	{
+		name: "unar", formats: []string{".7z", ".rar"}, versionArgs: []string{"--version"}, args: func(a string) []string { return []string{"-f", a} },
+		password: func(pw string) []string { return []string{"-p", pw} },
+	},
+	{name: "xz", formats: []string{".xz"}, versionArgs: []string{"--version"}, args: func(a string) []string { return []string{"-dk", a} },
+		test: func(a string) []string { return []string{"-t", a} },
+	},
+}
+
+func sevenZipArgs(archive string) []string { return []string{"x", "-y", archive} }
+
+func sevenZipPassword(pw string) []string { return []string{"-p" + pw} }
+
+func sevenZipTest(archive string) []string { return []string{"t", archive} }
+
+func sevenZipList(archive string) []string { return []string{"l", "-slt", archive} }
+
+// parseSevenZipList reads the technical listing of 7z l -slt, in which each
+// entry is a block of "Key = value" lines following a line of dashes.
+func parseSevenZipList(out string) []ArchiveEntry {
+	if _, after, ok := strings.Cut(out, "\n----------"); ok {
+		out = after
+	}
+	var entries []ArchiveEntry
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimRight(line, "\r"), " = ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			entries = append(entries, ArchiveEntry{Name: value, Size: -1})
+		case "Size":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && len(entries) > 0 {
+				entries[len(entries)-1].Size = n
+			}
+		case "Folder":
+			if value == "+" && len(entries) > 0 {
+				entries[len(entries)-1].Dir = true
+			}
+		}
+	}
+	return entries
+}
+
+// withPassword adds the arguments for password pw, if t takes one, to args,
+// which end with the archive. The tools take it as an argument, so pw is
+// visible in the process list while the tool runs.
+func (t extractorTool) withPassword(args []string, pw string) []string {
+	if t.password == nil {
+		return args
+	}
+	last := len(args) - 1
+	return append(append(args[:last:last], t.password(pw)...), args[last])
+}
+
+// archiveFormat returns the archive extension of name, such as ".tar.gz", or
+// "" if it is not an archive getnew knows.
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tar.xz"} {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	for _, ext := range []string{".zip", ".tar", ".tgz", ".tbz2", ".txz", ".gz", ".xz", ".7z", ".rar", ".iso", ".dmg", ".deb", ".rpm"} {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// IsArchive reports whether name is that of an archive getnew can unpack,
+// by its extension or, for a registered Extractor, whatever its Match tells
+// from a name alone.
+func IsArchive(name string) bool {
+	return matchExtractor(nil, name) != nil
+}
+
+// Tool is an external extractor as found on this machine.
+type Tool struct {
+	Name    string
+	Path    string // empty if not installed
+	Version string // empty if it could not be told
+	Formats []string
+	// Problem says why an installed tool will not be used.
+	Problem string
+}
+
+// Tools reports on every external extractor getnew knows about.
+func Tools(ctx context.Context) []Tool {
+	tools := make([]Tool, 0, len(extractorTools))
+	for _, t := range extractorTools {
+		tools = append(tools, t.check(ctx))
+	}
+	return tools
+}
+
+// FormatSupport says how a format will be unpacked: "built in", the tool
+// that will be used, or "" with Missing saying what to install.
+type FormatSupport struct {
+	Format  string
+	Using   string
+	Missing string
+}
+
+// Formats lists every archive format getnew knows and how it would be
+// unpacked on this machine.
+func Formats(ctx context.Context) []FormatSupport {
+	var support []FormatSupport
+	for _, f := range builtinFormats {
+		support = append(support, FormatSupport{Format: f, Using: "built in"})
+	}
+	for _, f := range []string{".7z", ".rar", ".tar.xz", ".txz", ".xz", ".dmg"} {
+		s := FormatSupport{Format: f}
+		if f == ".dmg" && canAttachDMG() {
+			s.Using = "hdiutil"
+		} else if t, path, err := findExtractor(ctx, f); err == nil {
+			s.Using = t.name + " (" + path + ")"
+		} else {
+			s.Missing = err.Error()
+		}
+		support = append(support, s)
+	}
+	return support
+}
+
+// findExtractor picks the most preferred usable tool for format, or explains
+// what to install.
+func findExtractor(ctx context.Context, format string) (extractorTool, string, error) {
+	var candidates []string
+	for _, t := range extractorTools {
+		if !contains(t.formats, format) {
+			continue
+		}
+		candidates = append(candidates, strings.Join(append([]string{t.name}, t.requires...), " with "))
+		if status := t.check(ctx); status.Path != "" && status.Problem == "" {
+			return t, status.Path, nil
+		}
+	}
+	return extractorTool{}, "", fmt.Errorf("no tool to unpack %s files: install %s", format, orList(candidates))
+}
+
+// findPasswordExtractor is findExtractor for tools that take a password.
+func findPasswordExtractor(ctx context.Context, format string) (extractorTool, string, error) {
+	t, path, candidates := findToolThat(ctx, format, func(t extractorTool) bool { return t.password != nil })
+	if path == "" {
+		return t, "", fmt.Errorf("no tool to unpack encrypted %s files: install %s", format, orList(candidates))
+	}
+	return t, path, nil
+}
+
+// findLister is findExtractor for tools that can list an archive.
+func findLister(ctx context.Context, format string) (extractorTool, string, error) {
+	t, path, candidates := findToolThat(ctx, format, func(t extractorTool) bool { return t.list != nil })
+	if path == "" {
+		return t, "", fmt.Errorf("no tool to list %s files: install %s", format, orList(candidates))
+	}
+	return t, path, nil
+}
+
+// findTester is findExtractor for tools that can test an archive.
+func findTester(ctx context.Context, format string) (extractorTool, string, error) {
+	t, path, candidates := findToolThat(ctx, format, func(t extractorTool) bool { return t.test != nil })
+	if path == "" {
+		return t, "", fmt.Errorf("no tool to test %s files: install %s", format, orList(candidates))
+	}
+	return t, path, nil
+}
+
+// findToolThat picks the most preferred usable tool for format that can do
+// what is needed, returning the names of all such tools if none is usable.
+func findToolThat(ctx context.Context, format string, can func(extractorTool) bool) (extractorTool, string, []string) {
+	var candidates []string
+	for _, t := range extractorTools {
+		if !contains(t.formats, format) || !can(t) {
+			continue
+		}
+		candidates = append(candidates, t.name)
+		if status := t.check(ctx); status.Path != "" && status.Problem == "" {
+			return t, status.Path, nil
+		}
+	}
+	return extractorTool{}, "", candidates
+}
+
+func (t extractorTool) check(ctx context.Context) Tool {
+	status := Tool{Name: t.name, Formats: t.formats}
+	path, err := exec.LookPath(t.name)
+	if err != nil {
+		return status
+	}
+	status.Path = path
+	for _, req := range t.requires {
+		if _, err := exec.LookPath(req); err != nil {
+			status.Problem = "needs " + req
+			return status
+		}
+	}
+	status.Version = toolVersion(ctx, path, t.versionArgs)
+	if t.minVersion != "" {
+		have, ok := parseVersion(status.Version)
+		want, _ := parseVersion(t.minVersion)
+		if ok && have.compare(want) < 0 {
+			status.Problem = "version " + t.minVersion + " or later needed"
+		}
+	}
+	return status
+}
+
+// toolVersion returns the first version number the tool prints, or "".
+func toolVersion(ctx context.Context, path string, args []string) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	// Tools that print their version in their usage exit non-zero
+	out, _ := exec.CommandContext(ctx, path, args...).CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if m := dottedVersionRe.FindStringSubmatch(scanner.Text()); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func orList(items []string) string {
+	switch len(items) {
+	case 0:
+		return "a suitable tool"
+	case 1:
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
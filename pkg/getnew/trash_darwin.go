@@ -0,0 +1,45 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Trash moves path to the user's Trash folder.
+func Trash(path string) error {
+	trash := filepath.Join(os.Getenv("HOME"), ".Trash")
+	if err := os.MkdirAll(trash, 0o700); err != nil {
+		return fmt.Errorf("failed to create trash: %w", err)
+	}
+	name := freeName(filepath.Base(path), func(n string) bool {
+		_, err := os.Lstat(filepath.Join(trash, n))
+		return err == nil
+	})
+	if err := renameOrCopy(path, filepath.Join(trash, name)); err != nil {
+		return fmt.Errorf("failed to move %s to the trash: %w", path, err)
+	}
+	return nil
+}
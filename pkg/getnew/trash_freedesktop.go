@@ -0,0 +1,81 @@
+//go:build !darwin && !windows
+
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Trash moves path to the home trash described by the freedesktop.org Trash
+// specification, where desktop file managers can restore it from.
+func Trash(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	trash := filepath.Join(dataHome, "Trash")
+	for _, dir := range []string{"files", "info"} {
+		if err := os.MkdirAll(filepath.Join(trash, dir), 0o700); err != nil {
+			return fmt.Errorf("failed to create trash: %w", err)
+		}
+	}
+
+	// Reserve a name by creating its .trashinfo exclusively
+	var name string
+	var info *os.File
+	for {
+		name = freeName(filepath.Base(abs), func(n string) bool {
+			_, errFiles := os.Lstat(filepath.Join(trash, "files", n))
+			_, errInfo := os.Lstat(filepath.Join(trash, "info", n+".trashinfo"))
+			return errFiles == nil || errInfo == nil
+		})
+		info, err = os.OpenFile(filepath.Join(trash, "info", name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to write trash info: %w", err)
+		}
+	}
+	u := url.URL{Path: abs}
+	fmt.Fprintf(info, "[Trash Info]\nPath=%s\nDeletionDate=%s\n", u.EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+	if err := info.Close(); err != nil {
+		return fmt.Errorf("failed to write trash info: %w", err)
+	}
+
+	if err := renameOrCopy(abs, filepath.Join(trash, "files", name)); err != nil {
+		os.Remove(filepath.Join(trash, "info", name+".trashinfo"))
+		return fmt.Errorf("failed to move %s to the trash: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build !darwin && !windows
+
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoveWithTrash(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	src, dest := t.TempDir(), t.TempDir()
+	writeAged(t, filepath.Join(src, "report.pdf"), "new", time.Minute)
+	writeAged(t, filepath.Join(dest, "report.pdf"), "old", time.Hour)
+
+	files, err := Find(context.Background(), Options{Sources: []SourceBackend{LocalSource{Dir: src}}})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Find = %v, %v", files, err)
+	}
+	if _, err := Move(context.Background(), files[0], filepath.Join(dest, "report.pdf"), Options{Trash: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both the overwritten destination and the original are in the trash
+	trash := filepath.Join(data, "Trash")
+	for name, want := range map[string]string{"report.pdf": "old", "report.2.pdf": "new"} {
+		if got, err := os.ReadFile(filepath.Join(trash, "files", name)); err != nil || string(got) != want {
+			t.Errorf("trashed %s = %q, %v, want %q", name, got, err, want)
+		}
+		info, err := os.ReadFile(filepath.Join(trash, "info", name+".trashinfo"))
+		if err != nil || !strings.Contains(string(info), "Path=") {
+			t.Errorf("trash info for %s = %q, %v", name, info, err)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "report.pdf")); err != nil || string(got) != "new" {
+		t.Errorf("destination = %q, %v, want new", got, err)
+	}
+}
+
+func TestMoveWithTrashKeepsDestOnFailure(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	src, dest := t.TempDir(), t.TempDir()
+	writeAged(t, filepath.Join(src, "report.pdf"), "new", time.Minute)
+	writeAged(t, filepath.Join(dest, "report.pdf"), "old", time.Hour)
+	files, _ := Find(context.Background(), Options{Sources: []SourceBackend{LocalSource{Dir: src}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Move(ctx, files[0], filepath.Join(dest, "report.pdf"), Options{Trash: true}); err == nil {
+		t.Fatal("cancelled Move succeeded")
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "report.pdf")); err != nil || string(got) != "old" {
+		t.Errorf("destination = %q, %v, want the old file left in place", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(data, "Trash", "files", "report.pdf")); !os.IsNotExist(err) {
+		t.Errorf("destination trashed by a failed move: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// UDF descriptor tag identifiers (ECMA-167).
+const (
+	udfAnchor            = 2
+	udfPartition         = 5
+	udfLogicalVolume     = 6
+	udfTerminator        = 8
+	udfFileSet           = 256
+	udfFileIdentifier    = 257
+	udfFileEntry         = 261
+	udfExtendedFileEntry = 266
+)
+
+// udfVolume locates blocks in the one partition of a UDF image.
+type udfVolume struct {
+	r     io.ReaderAt
+	size  int64
+	start int64 // byte offset of the partition
+}
+
+// readUDF lists the files in a UDF image, as DVD and Blu-ray images often
+// have no ISO 9660 file system. Only the plain layout of UDF up to 2.01, one
+// physical partition in 2048 byte blocks, is read; for anything else
+// errNoFileSystem is returned so an external tool can try.
+func readUDF(r io.ReaderAt, size int64) ([]isoFile, error) {
+	v := &udfVolume{r: r, size: size}
+	anchor, err := v.descriptor(256*isoSector, udfAnchor)
+	if err != nil {
+		return nil, errNoFileSystem
+	}
+
+	// The volume descriptor sequence names the partition and the file set
+	vdsLen, vdsLoc := binary.LittleEndian.Uint32(anchor[16:]), binary.LittleEndian.Uint32(anchor[20:])
+	var partition, fileSet uint32
+	var partitions, maps int
+	for i := int64(0); i < int64(vdsLen/isoSector) && i < 64; i++ {
+		d, err := v.descriptor((int64(vdsLoc)+i)*isoSector, 0)
+		if err != nil {
+			break
+		}
+		switch binary.LittleEndian.Uint16(d) {
+		case udfPartition:
+			partitions++
+			partition = binary.LittleEndian.Uint32(d[188:])
+		case udfLogicalVolume:
+			if binary.LittleEndian.Uint32(d[212:]) != isoSector {
+				return nil, fmt.Errorf("%w: UDF blocks are not %d bytes", errNoFileSystem, isoSector)
+			}
+			fileSet = binary.LittleEndian.Uint32(d[252:])
+			// Only type 1 maps point straight at a partition; the others are
+			// for sparing, virtual and metadata partitions
+			for i, off := 0, 440; i < int(binary.LittleEndian.Uint32(d[268:])) && off+2 <= len(d); i++ {
+				if d[off] != 1 || d[off+1] < 2 {
+					return nil, fmt.Errorf("%w: UDF partition map of type %d", errNoFileSystem, d[off])
+				}
+				maps++
+				off += int(d[off+1])
+			}
+		}
+		if binary.LittleEndian.Uint16(d) == udfTerminator {
+			break
+		}
+	}
+	if partitions != 1 || maps != 1 {
+		return nil, fmt.Errorf("%w: UDF volume with %d partitions", errNoFileSystem, partitions)
+	}
+	v.start = int64(partition) * isoSector
+
+	fsd, err := v.descriptor(v.block(fileSet), udfFileSet)
+	if err != nil {
+		return nil, err
+	}
+	var files []isoFile
+	err = v.walk(binary.LittleEndian.Uint32(fsd[404:]), "", map[uint32]bool{}, &files)
+	return files, err
+}
+
+// block is the offset in the image of logical block lbn.
+func (v *udfVolume) block(lbn uint32) int64 {
+	return v.start + int64(lbn)*isoSector
+}
+
+// descriptor reads the block at off and checks that it starts with a valid
+// tag, with identifier id unless that is 0.
+func (v *udfVolume) descriptor(off int64, id uint16) ([]byte, error) {
+	d := make([]byte, isoSector)
+	if off+isoSector > v.size {
+		return nil, fmt.Errorf("UDF descriptor at %d lies outside the image", off)
+	}
+	if _, err := v.r.ReadAt(d, off); err != nil {
+		return nil, fmt.Errorf("failed to read UDF descriptor: %w", err)
+	}
+	var sum byte
+	for i := 0; i < 16; i++ {
+		if i != 4 {
+			sum += d[i]
+		}
+	}
+	if sum != d[4] || id != 0 && binary.LittleEndian.Uint16(d) != id {
+		return nil, fmt.Errorf("no UDF descriptor %d at %d", id, off)
+	}
+	return d, nil
+}
+
+// walk adds the files in the directory whose entry is in block lbn to
+// files. seen guards against directories that contain themselves.
+func (v *udfVolume) walk(lbn uint32, prefix string, seen map[uint32]bool, files *[]isoFile) error {
+	if seen[lbn] || len(seen) > 1<<16 {
+		return nil
+	}
+	seen[lbn] = true
+	dir, err := v.entry(lbn)
+	if err != nil {
+		return err
+	}
+	if dir.size() > isoMaxDir {
+		return fmt.Errorf("directory %s has an impossible size of %d bytes", prefix, dir.size())
+	}
+	data := make([]byte, 0, dir.size())
+	for _, e := range dir.extents {
+		part := make([]byte, e[1])
+		if e[0] >= 0 {
+			if _, err := v.r.ReadAt(part, e[0]); err != nil {
+				return fmt.Errorf("failed to read directory %s: %w", prefix, err)
+			}
+		}
+		data = append(data, part...)
+	}
+
+	// The directory is a list of file identifiers, each padded to 4 bytes
+	for off := 0; off+38 <= len(data); {
+		if binary.LittleEndian.Uint16(data[off:]) != udfFileIdentifier {
+			break
+		}
+		flags, nameLen := data[off+18], int(data[off+19])
+		child := binary.LittleEndian.Uint32(data[off+24:])
+		nameStart := off + 38 + int(binary.LittleEndian.Uint16(data[off+36:]))
+		next := (nameStart + nameLen + 3) &^ 3
+		if nameStart+nameLen > len(data) {
+			break
+		}
+		raw := data[nameStart : nameStart+nameLen]
+		off = next
+		// Skip deleted entries and the parent
+		if flags&0x0C != 0 {
+			continue
+		}
+		name := udfName(raw)
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			continue
+		}
+		f, err := v.entry(child)
+		if err != nil {
+			return err
+		}
+		f.name = path.Join(prefix, name)
+		switch {
+		case f.dir:
+			f.extents = nil
+			*files = append(*files, f)
+			if err := v.walk(child, f.name, seen, files); err != nil {
+				return err
+			}
+		case f.mode.IsRegular():
+			*files = append(*files, f)
+		}
+	}
+	return nil
+}
+
+// entry reads the file entry in block lbn: what the file is, its
+// permissions and time, and where its data lies. Extents not recorded in
+// the image, which read as zeros, have an offset of -1.
+func (v *udfVolume) entry(lbn uint32) (isoFile, error) {
+	off := v.block(lbn)
+	d, err := v.descriptor(off, 0)
+	if err != nil {
+		return isoFile{}, err
+	}
+	// An extended file entry has the same fields, some further along
+	timeAt, lengthsAt := 84, 168
+	switch binary.LittleEndian.Uint16(d) {
+	case udfFileEntry:
+	case udfExtendedFileEntry:
+		timeAt, lengthsAt = 92, 208
+	default:
+		return isoFile{}, fmt.Errorf("no UDF file entry at block %d", lbn)
+	}
+	f := isoFile{modTime: udfTime(d[timeAt:]), mode: udfMode(binary.LittleEndian.Uint32(d[44:]))}
+	switch d[27] {
+	case 4:
+		f.dir = true
+	case 5:
+	default:
+		// Symbolic links, devices and the like are left out
+		f.mode |= fs.ModeIrregular
+		return f, nil
+	}
+
+	remaining := int64(binary.LittleEndian.Uint64(d[56:]))
+	eaLen, adLen := binary.LittleEndian.Uint32(d[lengthsAt:]), binary.LittleEndian.Uint32(d[lengthsAt+4:])
+	start := int64(lengthsAt) + 8 + int64(eaLen)
+	if start+int64(adLen) > isoSector {
+		return isoFile{}, fmt.Errorf("UDF file entry at block %d is corrupt", lbn)
+	}
+	ads := d[start : start+int64(adLen)]
+	add := func(at, n int64) {
+		n = min(n, remaining)
+		if n > 0 {
+			f.extents = append(f.extents, [2]int64{at, n})
+			remaining -= n
+		}
+	}
+	switch kind := binary.LittleEndian.Uint16(d[34:]) & 7; kind {
+	case 3:
+		// The data is small enough to live in the entry itself
+		add(off+start, int64(adLen))
+	case 0, 1:
+		step := 8
+		if kind == 1 {
+			step = 16
+		}
+		for i := 0; i+step <= len(ads); i += step {
+			length := binary.LittleEndian.Uint32(ads[i:])
+			at := v.block(binary.LittleEndian.Uint32(ads[i+4:]))
+			switch length >> 30 {
+			case 1, 2:
+				at = -1
+			case 3:
+				return isoFile{}, fmt.Errorf("%w: UDF allocation continued elsewhere", errNoFileSystem)
+			}
+			if kind == 1 && binary.LittleEndian.Uint16(ads[i+8:]) != 0 {
+				return isoFile{}, fmt.Errorf("%w: UDF extent in another partition", errNoFileSystem)
+			}
+			if length&0x3FFFFFFF == 0 {
+				break
+			}
+			add(at, int64(length&0x3FFFFFFF))
+		}
+	default:
+		return isoFile{}, fmt.Errorf("%w: UDF extended allocation descriptors", errNoFileSystem)
+	}
+	return f, nil
+}
+
+// udfName decodes a file identifier, which starts with a byte saying
+// whether it holds 8 or 16 bit characters.
+func udfName(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	switch b[0] {
+	case 8, 254:
+		runes := make([]rune, len(b)-1)
+		for i, c := range b[1:] {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	case 16, 255:
+		return utf16String(b[1:], true)
+	}
+	return ""
+}
+
+// udfMode turns UDF permissions, five bits each for others, group and
+// owner of which the low three are execute, write and read, into Unix ones.
+func udfMode(p uint32) fs.FileMode {
+	mode := fs.FileMode(p&7 | (p>>5&7)<<3 | (p>>10&7)<<6)
+	if mode == 0 {
+		return 0o644
+	}
+	return mode
+}
+
+// udfTime reads a UDF timestamp: a type and a 12 bit offset from UTC in
+// minutes, then year, month, day, hour, minute and second.
+func udfTime(b []byte) time.Time {
+	year := int(int16(binary.LittleEndian.Uint16(b[2:])))
+	if year == 0 {
+		return time.Time{}
+	}
+	offset := int(int16(binary.LittleEndian.Uint16(b)<<4) >> 4)
+	if offset == -2047 {
+		offset = 0
+	}
+	zone := time.FixedZone("", offset*60)
+	return time.Date(year, time.Month(b[4]), int(b[5]), int(b[6]), int(b[7]), int(b[8]), 0, zone)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package getnew
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sampleChunk is the size of each piece compared by sampled verification.
+const sampleChunk = 1 << 20
+
+// VerifyContents reads a finished copy back to check it against the original.
+// Copies up to opts.SampleAbove bytes are hashed in full and compared with
+// checksum, the SHA-256 taken while copying. Larger ones are compared with the
+// original chunk by chunk at evenly spread offsets, reading opts.Coverage of
+// the file, so a huge file on a slow disk is not read a second time in full.
+// Sources that cannot be read at an offset are always checked in full.
+func VerifyContents(ctx context.Context, c Candidate, dest, checksum string, opts Options) error {
+	if opts.SampleAbove <= 0 || c.Size() <= opts.SampleAbove {
+		return verifyChecksum(ctx, dest, checksum)
+	}
+	r, err := c.Source.Open(c.Name())
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer r.Close()
+	src, ok := r.(io.ReaderAt)
+	if !ok {
+		return verifyChecksum(ctx, dest, checksum)
+	}
+	f, err := os.Open(dest)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy: %w", err)
+	}
+	defer f.Close()
+
+	a, b := make([]byte, sampleChunk), make([]byte, sampleChunk)
+	for _, off := range sampleOffsets(c.Size(), opts.Coverage) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := src.ReadAt(a, off)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read source file: %w", err)
+		}
+		m, err := f.ReadAt(b, off)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to verify copy: %w", err)
+		}
+		if !bytes.Equal(a[:n], b[:m]) {
+			return fmt.Errorf("copy of %s differs from the original near byte %d", dest, off)
+		}
+	}
+	return nil
+}
+
+// sampleOffsets spreads enough chunks over a file of size bytes to read
+// coverage of it, always including the first and last chunk.
+func sampleOffsets(size int64, coverage float64) []int64 {
+	chunks := (size + sampleChunk - 1) / sampleChunk
+	n := int64(coverage*float64(chunks) + 0.5)
+	if n < 2 {
+		n = 2
+	}
+	if n >= chunks {
+		n = chunks
+	}
+	offsets := make([]int64, 0, n)
+	for i := int64(0); i < n; i++ {
+		chunk := int64(0)
+		if n > 1 {
+			chunk = i * (chunks - 1) / (n - 1)
+		}
+		offsets = append(offsets, chunk*sampleChunk)
+	}
+	return offsets
+}
+
+func verifyChecksum(ctx context.Context, dest, checksum string) error {
+	got, err := fileChecksum(ctx, dest)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy: %w", err)
+	}
+	if got != checksum {
+		return fmt.Errorf("copy of %s does not match the original: checksum %s, expected %s", dest, got, checksum)
+	}
+	return nil
+}
+
+// fileChecksum is the SHA-256 of the file at path.
+func fileChecksum(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, contextReader{ctx, f}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
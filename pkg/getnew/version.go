@@ -0,0 +1,109 @@
+/*
+Copyright © 2024 Colin Jacobs <colin@coljac.space>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package getnew
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// version is a version number found in a file name.
+type version struct {
+	parts      []int
+	prerelease bool
+}
+
+var (
+	dottedVersionRe = regexp.MustCompile(`(?i)(?:^|[^0-9a-z])v?(\d+(?:\.\d+)+)(-?(?:rc|beta|alpha|pre|dev)\d*)?`)
+	plainVersionRe  = regexp.MustCompile(`(?i)(?:^|[^0-9a-z])v?(\d+)(-?(?:rc|beta|alpha|pre|dev)\d*)?(?:[^0-9a-z]|$)`)
+)
+
+// parseVersion finds the version in name: the first dotted number such as
+// 1.10.0, or failing that the first standalone number, as in report-v3.pdf.
+func parseVersion(name string) (version, bool) {
+	m := dottedVersionRe.FindStringSubmatch(name)
+	if m == nil {
+		m = plainVersionRe.FindStringSubmatch(name)
+	}
+	if m == nil {
+		return version{}, false
+	}
+	var v version
+	for _, part := range strings.Split(m[1], ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return version{}, false
+		}
+		v.parts = append(v.parts, n)
+	}
+	v.prerelease = m[2] != ""
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v is lower than, equal to or higher than w.
+// Missing parts count as zero, and a prerelease is lower than its release.
+func (v version) compare(w version) int {
+	for i := 0; i < len(v.parts) || i < len(w.parts); i++ {
+		a, b := 0, 0
+		if i < len(v.parts) {
+			a = v.parts[i]
+		}
+		if i < len(w.parts) {
+			b = w.parts[i]
+		}
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.prerelease == w.prerelease:
+		return 0
+	case v.prerelease:
+		return -1
+	}
+	return 1
+}
+
+// SortByVersion orders files by the version in their names, highest first.
+// Files with the same version, or none, are ordered newest first, after all
+// the versioned ones.
+func SortByVersion(files []Candidate) {
+	SortNewest(files)
+	versions := make(map[string]version, len(files))
+	found := make(map[string]bool, len(files))
+	for _, file := range files {
+		versions[file.Name()], found[file.Name()] = parseVersion(file.Name())
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i].Name(), files[j].Name()
+		if found[a] != found[b] {
+			return found[a]
+		}
+		return versions[a].compare(versions[b]) > 0
+	})
+}